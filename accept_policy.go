@@ -0,0 +1,167 @@
+package ngrok
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// ConnMetadata describes a connection being considered by an [AcceptPolicy].
+type ConnMetadata struct {
+	// The tunnel that the connection arrived on.
+	Tunnel Tunnel
+	// The address of the client that initiated the connection.
+	RemoteAddr net.Addr
+	// The tunnel protocol (http, https, tls, or tcp) of the connection.
+	Proto string
+	// The type of the edge that matched the tunnel.
+	EdgeType EdgeType
+	// Whether the connection contains an end-to-end tls connection.
+	PassthroughTLS bool
+}
+
+// Decision is the result of evaluating an [AcceptPolicy] for a connection.
+type Decision struct {
+	reject bool
+	delay  time.Duration
+}
+
+var (
+	// DecisionAccept hands the connection to the application immediately.
+	DecisionAccept = Decision{}
+	// DecisionReject closes the connection without handing it to the
+	// application.
+	DecisionReject = Decision{reject: true}
+)
+
+// DecisionDelay hands the connection to the application after the given
+// delay. Other connections are not held up in the meantime.
+func DecisionDelay(d time.Duration) Decision {
+	return Decision{delay: d}
+}
+
+// AcceptPolicy decides what to do with each connection before it is returned
+// from [Tunnel.Accept], and so before it is forwarded or served. It must be
+// safe for concurrent use by multiple tunnels.
+type AcceptPolicy func(meta ConnMetadata) Decision
+
+// WithAcceptPolicy configures a policy that is evaluated for each connection
+// arriving on the session's tunnels before the application sees it. Use it to
+// implement custom allowlists, geographic rules with data you provide, or
+// quotas, in one place.
+//
+// Rejected connections are counted in [TunnelStats].
+func WithAcceptPolicy(policy AcceptPolicy) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.AcceptPolicy = policy
+	}
+}
+
+// Applies an accept policy to the connections of a tunnel, and answers
+// TLS-ALPN-01 challenges. Connections are accepted from the underlying tunnel
+// by a dedicated goroutine so that delayed connections don't block the ones
+// behind them.
+type policyPump struct {
+	startOnce sync.Once
+	ready     chan *tunnel_client.ProxyConn
+	done      chan struct{}
+	err       error
+}
+
+// Get the next connection that the policy allows.
+func (t *tunnelImpl) acceptAllowed() (*tunnel_client.ProxyConn, error) {
+	if t.policy == nil && t.challenges == nil {
+		return t.acceptLimited()
+	}
+
+	p := &t.pump
+	p.startOnce.Do(func() {
+		p.ready = make(chan *tunnel_client.ProxyConn)
+		p.done = make(chan struct{})
+		t.goroutines.spawn(t.runPolicy)
+	})
+
+	select {
+	case conn := <-p.ready:
+		if t.closed.Load() {
+			conn.Conn.Close()
+			return nil, net.ErrClosed
+		}
+		return conn, nil
+	case <-p.done:
+		return nil, p.err
+	case <-t.stopping():
+		return nil, net.ErrClosed
+	}
+}
+
+func (t *tunnelImpl) runPolicy() {
+	p := &t.pump
+	deliver := func(conn *tunnel_client.ProxyConn) {
+		select {
+		case p.ready <- conn:
+		case <-p.done:
+			conn.Conn.Close()
+		case <-t.stopping():
+			conn.Conn.Close()
+		}
+	}
+
+	for {
+		conn, err := t.acceptLimited()
+		if err != nil {
+			p.err = err
+			close(p.done)
+			return
+		}
+
+		if t.challenges != nil {
+			// Reading the ClientHello waits on the client.
+			t.goroutines.spawn(func() {
+				if conn, answered := t.answerChallenge(conn); !answered {
+					t.applyPolicy(conn, deliver)
+				}
+			})
+			continue
+		}
+		t.applyPolicy(conn, deliver)
+	}
+}
+
+// Hand a connection to deliver, or not, as the accept policy decides.
+func (t *tunnelImpl) applyPolicy(conn *tunnel_client.ProxyConn, deliver func(*tunnel_client.ProxyConn)) {
+	if t.policy == nil {
+		deliver(conn)
+		return
+	}
+	p := &t.pump
+	decision := t.policy(ConnMetadata{
+		Tunnel:         t,
+		RemoteAddr:     conn.Conn.RemoteAddr(),
+		Proto:          conn.Header.Proto,
+		EdgeType:       edgeType(conn.Header.EdgeType),
+		PassthroughTLS: conn.Header.PassthroughTLS,
+	})
+	switch {
+	case decision.reject:
+		t.stats.rejected.Add(1)
+		conn.Conn.Close()
+	case decision.delay > 0:
+		t.goroutines.spawn(func() {
+			timer := time.NewTimer(decision.delay)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+				deliver(conn)
+			case <-p.done:
+				conn.Conn.Close()
+			case <-t.stopping():
+				conn.Conn.Close()
+			}
+		})
+	default:
+		deliver(conn)
+	}
+}
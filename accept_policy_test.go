@@ -0,0 +1,112 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+type addrConn struct {
+	net.Conn
+	addr string
+}
+
+func (c *addrConn) RemoteAddr() net.Addr {
+	addr, _ := net.ResolveTCPAddr("tcp", c.addr)
+	return addr
+}
+
+func TestAcceptPolicy(t *testing.T) {
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 3)}
+	tun := &tunnelImpl{
+		Tunnel: q,
+		policy: func(meta ConnMetadata) Decision {
+			switch meta.RemoteAddr.String() {
+			case "192.0.2.1:1000":
+				return DecisionReject
+			case "192.0.2.2:1000":
+				return DecisionDelay(50 * time.Millisecond)
+			}
+			require.Equal(t, "https", meta.Proto)
+			return DecisionAccept
+		},
+	}
+
+	send := func(addr string) net.Conn {
+		local, remote := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{
+			Conn:   &addrConn{local, addr},
+			Header: proto.ProxyHeader{Proto: "https"},
+		}
+		return remote
+	}
+
+	rejected := send("192.0.2.1:1000")
+	send("192.0.2.2:1000")
+	send("192.0.2.3:1000")
+
+	// The delayed connection doesn't hold up the one behind it.
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.3:1000", conn.RemoteAddr().String())
+
+	conn, err = tun.Accept()
+	require.NoError(t, err)
+	require.Equal(t, "192.0.2.2:1000", conn.RemoteAddr().String())
+
+	// The rejected connection was closed.
+	_, err = rejected.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, uint64(1), tun.Stats().Rejected)
+
+	close(q.conns)
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, errAcceptFailed{})
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestAcceptPolicyClose(t *testing.T) {
+	// The client tunnel never stops handing out connections, as when it's
+	// still waiting for the unbind request.
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 3)}
+	tun := &tunnelImpl{
+		Tunnel: q,
+		policy: func(meta ConnMetadata) Decision {
+			if meta.RemoteAddr.String() == "192.0.2.2:1000" {
+				return DecisionDelay(time.Hour)
+			}
+			return DecisionAccept
+		},
+	}
+	send := func(addr string) net.Conn {
+		local, remote := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: &addrConn{local, addr}}
+		return remote
+	}
+
+	send("192.0.2.1:1000")
+	_, err := tun.Accept()
+	require.NoError(t, err)
+
+	// Connections the application hasn't accepted yet, whether waiting to
+	// be accepted or delayed, are closed along with the tunnel.
+	delayed := send("192.0.2.2:1000")
+	pending := send("192.0.2.1:1000")
+	require.Eventually(t, func() bool { return len(q.conns) == 0 }, time.Second, time.Millisecond)
+	require.NoError(t, tun.Close())
+	for _, conn := range []net.Conn{pending, delayed} {
+		// Pipes that are already closed refuse deadlines.
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	}
+
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
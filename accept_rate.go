@@ -0,0 +1,107 @@
+package ngrok
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok/clock"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// EventAcceptThrottled is emitted when a [Tunnel] starts closing connections
+// because they arrive faster than the limit configured with
+// config.WithAcceptRateLimit. It isn't emitted again until a connection has
+// been accepted in the meantime.
+type EventAcceptThrottled struct {
+	baseEvent
+	Tunnel    Tunnel
+	PerSecond float64
+	Burst     int
+}
+
+// A token bucket limiting the rate at which a tunnel accepts connections.
+type acceptLimiter struct {
+	clock     clock.Clock
+	perSecond float64
+	burst     float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	// Whether the most recent connection was throttled.
+	throttling bool
+}
+
+// Create a limiter, or return nil if perSecond doesn't limit anything.
+func newAcceptLimiter(clk clock.Clock, perSecond float64, burst int) *acceptLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	burst = max(burst, 1)
+	return &acceptLimiter{
+		clock:     clk,
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      clk.Now(),
+	}
+}
+
+// Report whether a connection may be accepted now, and if not, whether it's
+// the first to be throttled since one was accepted.
+func (l *acceptLimiter) allow() (ok, started bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.clock.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.perSecond)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		l.throttling = false
+		return true, false
+	}
+	started = !l.throttling
+	l.throttling = true
+	return false, started
+}
+
+// Accept the next connection from the underlying tunnel that's allowed by
+// its local CIDR restrictions and within its rate limit, closing those that
+// aren't.
+func (t *tunnelImpl) acceptLimited() (*tunnel_client.ProxyConn, error) {
+	for {
+		conn, err := t.Tunnel.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !t.cidrs.allows(conn.Conn.RemoteAddr()) {
+			t.stats.rejected.Add(1)
+			conn.Conn.Close()
+			t.log(func(l *slog.Logger) {
+				l.Debug("connection rejected by CIDR restrictions", "clientid", t.Tunnel.ID(), "remote_addr", conn.Conn.RemoteAddr())
+			})
+			continue
+		}
+		if t.limiter == nil {
+			return conn, nil
+		}
+		ok, started := t.limiter.allow()
+		if ok {
+			return conn, nil
+		}
+		t.stats.throttled.Add(1)
+		conn.Conn.Close()
+		if started {
+			t.log(func(l *slog.Logger) {
+				l.Warn("throttling connections over the accept rate limit", "clientid", t.Tunnel.ID(), "per_second", t.limiter.perSecond)
+			})
+			t.events.emit(&EventAcceptThrottled{
+				baseEvent: newBaseEvent(EventTypeAcceptThrottled),
+				Tunnel:    t,
+				PerSecond: t.limiter.perSecond,
+				Burst:     int(t.limiter.burst),
+			})
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/clock"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func TestAcceptRateLimit(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	rec := &eventRecorder{}
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 5)}
+	tun := &tunnelImpl{
+		Tunnel:  q,
+		limiter: newAcceptLimiter(clk, 10, 2),
+		events:  newEventDispatcher([]EventHandler{rec.handle}),
+	}
+
+	send := func() net.Conn {
+		local, remote := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+		return remote
+	}
+
+	// The burst is accepted at once.
+	for i := 0; i < 2; i++ {
+		send()
+		_, err := tun.Accept()
+		require.NoError(t, err)
+	}
+
+	// Connections beyond it are closed until the allowance refills.
+	throttled := []net.Conn{send(), send()}
+	accepted := make(chan net.Conn)
+	go func() {
+		conn, err := tun.Accept()
+		require.NoError(t, err)
+		accepted <- conn
+	}()
+	for _, conn := range throttled {
+		_, err := conn.Read(make([]byte, 1))
+		require.ErrorIs(t, err, io.EOF)
+	}
+
+	clk.Advance(100 * time.Millisecond)
+	send()
+	<-accepted
+	require.Equal(t, uint64(2), tun.Stats().Throttled)
+
+	require.Eventually(t, func() bool { return len(rec.types()) == 4 }, time.Second, time.Millisecond)
+	var throttles []*EventAcceptThrottled
+	for _, ev := range rec.events {
+		if ev, ok := ev.(*EventAcceptThrottled); ok {
+			throttles = append(throttles, ev)
+		}
+	}
+	require.Len(t, throttles, 1)
+	require.Equal(t, 10.0, throttles[0].PerSecond)
+	require.Equal(t, 2, throttles[0].Burst)
+}
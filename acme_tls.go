@@ -0,0 +1,64 @@
+package ngrok
+
+import (
+	"bytes"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"slices"
+	"time"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// The ALPN protocol offered by the connections validating TLS-ALPN-01
+// challenges.
+const acmeTLSProto = "acme-tls/1"
+
+// Answer the connection with the challenge certificate if it's validating a
+// TLS-ALPN-01 challenge. Otherwise, returns the connection to hand to the
+// application, which replays the ClientHello that was read from it.
+func (t *tunnelImpl) answerChallenge(conn *tunnel_client.ProxyConn) (*tunnel_client.ProxyConn, bool) {
+	rec := &recordingReader{r: conn.Conn}
+	_ = conn.Conn.SetReadDeadline(time.Now().Add(sniTimeout))
+	var protos []string
+	_ = tls.Server(&readOnlyConn{Conn: conn.Conn, r: rec}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			protos = info.SupportedProtos
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	_ = conn.Conn.SetReadDeadline(time.Time{})
+
+	replayed := *conn
+	replayed.Conn = &prefixedConn{Conn: conn.Conn, r: io.MultiReader(bytes.NewReader(rec.buf), conn.Conn)}
+	if !slices.Contains(protos, acmeTLSProto) {
+		return &replayed, false
+	}
+
+	defer replayed.Conn.Close()
+	_ = replayed.Conn.SetDeadline(time.Now().Add(sniTimeout))
+	err := tls.Server(replayed.Conn, &tls.Config{
+		NextProtos:     []string{acmeTLSProto},
+		GetCertificate: t.challenges,
+		MinVersion:     tls.VersionTLS12,
+	}).Handshake()
+	if err != nil {
+		t.log(func(l *slog.Logger) {
+			l.Warn("failed to answer TLS-ALPN-01 challenge", "clientid", t.Tunnel.ID(), "err", err)
+		})
+	}
+	return nil, true
+}
+
+// A connection whose reads come from r, which starts with bytes that were
+// already read from it.
+type prefixedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
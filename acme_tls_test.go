@@ -0,0 +1,73 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestTLSALPNChallenge(t *testing.T) {
+	serverCert := testCert(t, "app.ngrok.test")
+	challengeCert := testCert(t, "challenge")
+	serverCAs := x509.NewCertPool()
+	serverCAs.AddCert(serverCert.Leaf)
+
+	var challenged string
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 2)}
+	tun := &tunnelImpl{
+		Tunnel:   q,
+		agentTLS: &tls.Config{Certificates: []tls.Certificate{serverCert}},
+		challenges: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			challenged = hello.ServerName
+			return &challengeCert, nil
+		},
+	}
+	queue := func() net.Conn {
+		local, remote := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{
+			Header: proto.ProxyHeader{Proto: "tls", PassthroughTLS: true},
+			Conn:   local,
+		}
+		return remote
+	}
+
+	// Challenges are answered while the application waits in Accept.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := tun.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	validator := tls.Client(queue(), &tls.Config{
+		ServerName:         "app.ngrok.test",
+		NextProtos:         []string{acmeTLSProto},
+		InsecureSkipVerify: true,
+	})
+	require.NoError(t, validator.Handshake())
+	state := validator.ConnectionState()
+	require.Equal(t, acmeTLSProto, state.NegotiatedProtocol)
+	require.Equal(t, "challenge", state.PeerCertificates[0].Subject.CommonName)
+	require.Equal(t, "app.ngrok.test", challenged)
+	validator.Close()
+
+	// Other connections are accepted, with their handshakes intact.
+	client := tls.Client(queue(), &tls.Config{ServerName: "app.ngrok.test", RootCAs: serverCAs})
+	go func() {
+		defer client.Close()
+		_, _ = client.Write([]byte("hello"))
+	}()
+	conn := <-accepted
+	defer conn.Close()
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
@@ -0,0 +1,14 @@
+package ngrok
+
+import "crypto/tls"
+
+func (c *connImpl) TLSConnectionState() (*tls.ConnectionState, error) {
+	if c.tlsConn == nil {
+		return nil, nil
+	}
+	if err := c.tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	state := c.tlsConn.ConnectionState()
+	return &state, nil
+}
@@ -0,0 +1,95 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestAgentTLSTermination(t *testing.T) {
+	serverCert := testCert(t, "app.ngrok.test")
+	clientCert := testCert(t, "client")
+	serverCAs, clientCAs := x509.NewCertPool(), x509.NewCertPool()
+	serverCAs.AddCert(serverCert.Leaf)
+	clientCAs.AddCert(clientCert.Leaf)
+
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{Tunnel: q, agentTLS: &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}}
+
+	local, remote := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{
+		Header: proto.ProxyHeader{Proto: "tls", PassthroughTLS: true},
+		Conn:   local,
+	}
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	ngrokConn := conn.(Conn)
+	require.False(t, ngrokConn.PassthroughTLS())
+
+	client := tls.Client(remote, &tls.Config{
+		ServerName:   "app.ngrok.test",
+		RootCAs:      serverCAs,
+		Certificates: []tls.Certificate{clientCert},
+	})
+	go func() {
+		defer client.Close()
+		_, _ = client.Write([]byte("hello"))
+	}()
+
+	state, err := ngrokConn.TLSConnectionState()
+	require.NoError(t, err)
+	require.Len(t, state.PeerCertificates, 1)
+	require.Equal(t, "client", state.PeerCertificates[0].Subject.CommonName)
+
+	// The application reads plaintext.
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+}
+
+func TestAgentTLSClientCertRequired(t *testing.T) {
+	serverCert := testCert(t, "app.ngrok.test")
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(testCert(t, "client").Leaf)
+
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{Tunnel: q, agentTLS: &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	}}
+
+	local, remote := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	go func() {
+		client := tls.Client(remote, &tls.Config{InsecureSkipVerify: true})
+		_ = client.Handshake()
+		_, _ = io.Copy(io.Discard, client)
+	}()
+
+	_, err = conn.(Conn).TLSConnectionState()
+	require.Error(t, err)
+}
+
+func TestNoAgentTLSTermination(t *testing.T) {
+	conn, _ := testTunnelConn(t, "tls")
+	state, err := conn.TLSConnectionState()
+	require.NoError(t, err)
+	require.Nil(t, state)
+}
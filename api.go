@@ -0,0 +1,98 @@
+package ngrok
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	neturl "net/url"
+)
+
+const defaultAPIURL = "https://api.ngrok.com"
+
+// A client for the ngrok API, authenticated with an API key.
+type apiClient struct {
+	apiKey  string
+	baseURL string
+	client  *http.Client
+}
+
+func newAPIClient(apiKey string) *apiClient {
+	return &apiClient{
+		apiKey:  apiKey,
+		baseURL: defaultAPIURL,
+		client:  http.DefaultClient,
+	}
+}
+
+// Make a request to the ngrok API, sending body and decoding the response
+// into out if they aren't nil. The path may also be a URL returned by the
+// API, such as the next page of a list, as long as it's on the same host, so
+// that the API key isn't sent elsewhere.
+func (c *apiClient) do(ctx context.Context, method, path string, body, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(buf)
+	}
+
+	url, err := c.resolve(path)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	req.Header.Set("Ngrok-Version", "2")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		if out == nil || resp.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+
+	var apiErr struct {
+		ErrorCode string `json:"error_code"`
+		Msg       string `json:"msg"`
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	if err := json.Unmarshal(respBody, &apiErr); err != nil || apiErr.Msg == "" {
+		return fmt.Errorf("unexpected response from ngrok API: %s", resp.Status)
+	}
+	return &ngrokError{Message: apiErr.Msg, ErrCode: apiErr.ErrorCode}
+}
+
+// Resolve a path or URL against the base URL, refusing those that name
+// another scheme or host.
+func (c *apiClient) resolve(path string) (string, error) {
+	base, err := neturl.Parse(c.baseURL)
+	if err != nil {
+		return "", err
+	}
+	ref, err := neturl.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	u := base.ResolveReference(ref)
+	if u.Scheme != base.Scheme || u.Host != base.Host {
+		return "", fmt.Errorf("refusing to send the API key to %s://%s, which isn't the ngrok API", u.Scheme, u.Host)
+	}
+	return u.String(), nil
+}
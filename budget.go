@@ -0,0 +1,134 @@
+package ngrok
+
+import (
+	"sync/atomic"
+)
+
+// Budget configures soft limits on the traffic carried by a [Session].
+// Exceeding a limit does not interrupt any traffic; instead, an
+// [EventBudgetExceeded] is emitted so that the application can decide what to
+// do, for example by closing the session.
+//
+// Zero values mean unlimited.
+type Budget struct {
+	// The total number of bytes read from and written to connections
+	// accepted from the session's tunnels.
+	MaxBytes int64
+	// The total number of connections accepted from the session's tunnels.
+	MaxConnections uint64
+}
+
+// BudgetResource identifies the limit of a [Budget] that was exceeded.
+type BudgetResource string
+
+// The resources tracked by a [Budget].
+const (
+	BudgetBytes       BudgetResource = "bytes"
+	BudgetConnections BudgetResource = "connections"
+)
+
+// WithBudget configures soft limits on the total bytes and connections
+// carried by the [Session]. Usage is always tracked and is available via
+// [Session.Stats].
+func WithBudget(budget Budget) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.Budget = budget
+	}
+}
+
+// EventBudgetExceeded is emitted the first time the [Session]'s usage of a
+// resource exceeds the limit configured with [WithBudget].
+type EventBudgetExceeded struct {
+	baseEvent
+	Session  Session
+	Resource BudgetResource
+	Limit    int64
+	Used     int64
+}
+
+// Tracks the usage of a session across all of its tunnels. A nil accounting
+// discards usage.
+type sessionAccounting struct {
+	budget  Budget
+	session Session
+	events  *eventDispatcher
+
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	connections  atomic.Uint64
+	open         atomic.Int64
+
+	maxOpen       atomic.Int64
+	maxGoroutines atomic.Int64
+
+	bytesExceeded atomic.Bool
+	connsExceeded atomic.Bool
+}
+
+func (a *sessionAccounting) addRead(n int) {
+	if a == nil || n == 0 {
+		return
+	}
+	a.bytesRead.Add(int64(n))
+	a.checkBytes()
+}
+
+func (a *sessionAccounting) addWritten(n int) {
+	if a == nil || n == 0 {
+		return
+	}
+	a.bytesWritten.Add(int64(n))
+	a.checkBytes()
+}
+
+func (a *sessionAccounting) addConn() {
+	if a == nil {
+		return
+	}
+	used := a.connections.Add(1)
+	storeMax(&a.maxOpen, a.open.Add(1))
+	sampleGoroutines(&a.maxGoroutines)
+	if a.budget.MaxConnections > 0 && used > a.budget.MaxConnections && a.connsExceeded.CompareAndSwap(false, true) {
+		a.exceeded(BudgetConnections, int64(a.budget.MaxConnections), int64(used))
+	}
+}
+
+func (a *sessionAccounting) closeConn() {
+	if a == nil {
+		return
+	}
+	a.open.Add(-1)
+}
+
+func (a *sessionAccounting) checkBytes() {
+	if a.budget.MaxBytes <= 0 || a.bytesExceeded.Load() {
+		return
+	}
+	used := a.bytesRead.Load() + a.bytesWritten.Load()
+	if used > a.budget.MaxBytes && a.bytesExceeded.CompareAndSwap(false, true) {
+		a.exceeded(BudgetBytes, a.budget.MaxBytes, used)
+	}
+}
+
+func (a *sessionAccounting) snapshot() SessionStats {
+	sampleGoroutines(&a.maxGoroutines)
+	return SessionStats{
+		Connections:        a.connections.Load(),
+		BytesRead:          a.bytesRead.Load(),
+		BytesWritten:       a.bytesWritten.Load(),
+		OpenConnections:    a.open.Load(),
+		MaxOpenConnections: a.maxOpen.Load(),
+		MaxGoroutines:      a.maxGoroutines.Load(),
+		MaxQueuedEvents:    a.events.maxQueued(),
+	}
+}
+
+func (a *sessionAccounting) exceeded(resource BudgetResource, limit, used int64) {
+	a.events.emit(&EventBudgetExceeded{
+		baseEvent: newBaseEvent(EventTypeBudgetExceeded),
+		Session:   a.session,
+		Resource:  resource,
+		Limit:     limit,
+		Used:      used,
+	})
+}
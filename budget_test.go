@@ -0,0 +1,60 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func TestBudgetExceeded(t *testing.T) {
+	rec := &eventRecorder{}
+	acct := &sessionAccounting{
+		budget: Budget{MaxBytes: 8, MaxConnections: 1},
+		events: newEventDispatcher([]EventHandler{rec.handle}),
+	}
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 2)}
+	tun := &tunnelImpl{Tunnel: q, acct: acct}
+
+	var remotes []net.Conn
+	for i := 0; i < 2; i++ {
+		local, remote := net.Pipe()
+		remotes = append(remotes, remote)
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	}
+
+	first, err := tun.Accept()
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), acct.snapshot().Connections)
+
+	go func() { _, _ = io.Copy(io.Discard, remotes[0]) }()
+	_, err = first.Write([]byte("12345"))
+	require.NoError(t, err)
+	_, err = first.Write([]byte("67890"))
+	require.NoError(t, err)
+	_, err = first.Write([]byte("more"))
+	require.NoError(t, err)
+
+	_, err = tun.Accept()
+	require.NoError(t, err)
+
+	require.Equal(t, []EventType{EventTypeBudgetExceeded, EventTypeBudgetExceeded}, rec.waitFor(t, 2))
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	bytesEv := rec.events[0].(*EventBudgetExceeded)
+	require.Equal(t, BudgetBytes, bytesEv.Resource)
+	require.Equal(t, int64(8), bytesEv.Limit)
+	require.Equal(t, int64(10), bytesEv.Used)
+	connsEv := rec.events[1].(*EventBudgetExceeded)
+	require.Equal(t, BudgetConnections, connsEv.Resource)
+	require.Equal(t, int64(2), connsEv.Used)
+
+	stats := acct.snapshot()
+	require.Equal(t, uint64(2), stats.Connections)
+	require.Equal(t, int64(0), stats.BytesRead)
+	require.Equal(t, int64(14), stats.BytesWritten)
+}
@@ -0,0 +1,256 @@
+package ngrok
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"time"
+)
+
+// WriteSupportBundle writes a zip archive describing a session returned by
+// [Connect] to w, to attach to tickets with ngrok support. It contains:
+//
+//   - status.json: the session's [StatusReport].
+//   - stats.json: the session's [SessionStats] and [Resources].
+//   - config.json: the options the session was connected with, with its
+//     authtoken, API key and proxy credentials redacted.
+//   - logs.txt: the records the session kept with [WithLogBuffer], if it was
+//     connected with it.
+//   - goroutines.txt: the stacks of all of the process's goroutines.
+//   - diagnostics.json: the [ServerInfo] of the session, and a
+//     [ConnectProbe] of the address it connects to.
+//
+// The diagnostics are gathered within ctx; those that fail or time out are
+// recorded as errors in the bundle rather than failing it.
+func WriteSupportBundle(ctx context.Context, sess Session, w io.Writer) error {
+	s, err := unwrapSession(sess, "WriteSupportBundle")
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	files := []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{"status.json", writeJSON(s.StatusReport())},
+		{"stats.json", writeJSON(struct {
+			Stats     SessionStats `json:"stats"`
+			Resources Resources    `json:"resources"`
+		}{s.Stats(), s.ActiveResources()})},
+		{"config.json", writeJSON(s.bundleConfig)},
+		{"logs.txt", s.logBuffer.writeTo},
+		{"goroutines.txt", func(w io.Writer) error {
+			return pprof.Lookup("goroutine").WriteTo(w, 2)
+		}},
+		{"diagnostics.json", writeJSON(s.diagnostics(ctx))},
+	}
+	for _, f := range files {
+		fw, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     f.name,
+			Method:   zip.Deflate,
+			Modified: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if err := f.write(fw); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	return zw.Close()
+}
+
+func writeJSON(v any) func(io.Writer) error {
+	return func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+}
+
+// The options a session was connected with, safe to share with support.
+type bundleConfig struct {
+	LibraryVersion        string   `json:"library_version"`
+	GoVersion             string   `json:"go_version"`
+	OS                    string   `json:"os"`
+	Arch                  string   `json:"arch"`
+	Authtoken             string   `json:"authtoken"`
+	APIKey                string   `json:"api_key,omitempty"`
+	ServerAddr            string   `json:"server_addr,omitempty"`
+	AdditionalServerAddrs []string `json:"additional_server_addrs,omitempty"`
+	Region                string   `json:"region,omitempty"`
+	MultiLeg              bool     `json:"multi_leg"`
+	ConnectFailover       bool     `json:"connect_failover"`
+	AutoRegion            bool     `json:"auto_region"`
+	OfflineFallback       string   `json:"offline_fallback,omitempty"`
+	ProxyURL              string   `json:"proxy_url,omitempty"`
+	CustomDialer          bool     `json:"custom_dialer"`
+	CustomCA              bool     `json:"custom_ca"`
+	ClientCertificates    int      `json:"client_certificates"`
+	Metadata              string   `json:"metadata,omitempty"`
+	HeartbeatInterval     string   `json:"heartbeat_interval,omitempty"`
+	HeartbeatTolerance    string   `json:"heartbeat_tolerance,omitempty"`
+}
+
+func newBundleConfig(cfg *connectConfig) bundleConfig {
+	bc := bundleConfig{
+		LibraryVersion:        strings.TrimSpace(libraryAgentVersion),
+		GoVersion:             runtime.Version(),
+		OS:                    runtime.GOOS,
+		Arch:                  runtime.GOARCH,
+		Authtoken:             redact(string(cfg.Authtoken)),
+		APIKey:                redact(cfg.APIKey),
+		ServerAddr:            cfg.ServerAddr,
+		AdditionalServerAddrs: cfg.AdditionalServerAddrs,
+		Region:                cfg.Region,
+		MultiLeg:              cfg.EnableMultiLeg,
+		ConnectFailover:       cfg.ConnectFailover,
+		AutoRegion:            cfg.AutoRegion,
+		OfflineFallback:       cfg.OfflineFallback,
+		CustomDialer:          cfg.Dialer != nil || cfg.ProxyDialer != nil,
+		CustomCA:              cfg.CAPool != nil,
+		ClientCertificates:    len(cfg.ClientCertificates),
+		Metadata:              cfg.Metadata,
+	}
+	if cfg.AuthtokenProvider != nil {
+		bc.Authtoken = "PROVIDED"
+	}
+	if cfg.ProxyURL != nil {
+		bc.ProxyURL = cfg.ProxyURL.Redacted()
+	}
+	if cfg.HeartbeatInterval > 0 {
+		bc.HeartbeatInterval = cfg.HeartbeatInterval.String()
+	}
+	if cfg.HeartbeatTolerance > 0 {
+		bc.HeartbeatTolerance = cfg.HeartbeatTolerance.String()
+	}
+	return bc
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "REDACTED"
+}
+
+// The results of checking the session's connection to the ngrok service.
+type bundleDiagnostics struct {
+	ServerInfo      *ServerInfo    `json:"server_info,omitempty"`
+	ServerInfoError string         `json:"server_info_error,omitempty"`
+	Probes          []bundleProbe  `json:"probes,omitempty"`
+	Warnings        []string       `json:"warnings,omitempty"`
+	Health          SessionHealth  `json:"health"`
+	Legs            []LegStatus    `json:"legs,omitempty"`
+	Deprecation     *bundleWarning `json:"deprecation,omitempty"`
+}
+
+type bundleProbe struct {
+	Addr    string `json:"addr"`
+	Latency string `json:"latency,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+type bundleWarning struct {
+	Message string `json:"message"`
+}
+
+func (s *sessionImpl) diagnostics(ctx context.Context) bundleDiagnostics {
+	status := s.status()
+	diag := bundleDiagnostics{Health: status.Health, Legs: status.Legs}
+	for _, w := range s.Warnings() {
+		diag.Warnings = append(diag.Warnings, w.Error())
+	}
+	if dep := s.Deprecation(); dep != nil {
+		diag.Deprecation = &bundleWarning{dep.Error()}
+	}
+
+	addr := s.bundleConfig.ServerAddr
+	if !s.isOffline() {
+		info, err := s.ServerInfo(ctx)
+		if err != nil {
+			diag.ServerInfoError = err.Error()
+		} else {
+			diag.ServerInfo = &info
+			if info.ConnectAddress != "" {
+				addr = info.ConnectAddress
+			}
+		}
+	}
+	if addr == "" {
+		addr = defaultServer
+	}
+	for _, probe := range ProbeConnectURLs(ctx, addr) {
+		bp := bundleProbe{Addr: probe.Addr}
+		if probe.Err != nil {
+			bp.Error = probe.Err.Error()
+		} else {
+			bp.Latency = probe.Latency.String()
+		}
+		diag.Probes = append(diag.Probes, bp)
+	}
+	return diag
+}
+
+// Write the records out as text, oldest first.
+func (b *logBuffer) writeTo(w io.Writer) error {
+	if b == nil {
+		_, err := io.WriteString(w, "No logs were kept. Connect with WithLogBuffer to include them.\n")
+		return err
+	}
+	h := slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	for _, rec := range b.recent() {
+		r := slog.NewRecord(rec.Time, rec.Level, rec.Message, 0)
+		r.AddAttrs(rec.Attrs...)
+		if err := h.Handle(context.Background(), r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sends log records to every handler that's enabled for them.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
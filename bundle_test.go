@@ -0,0 +1,106 @@
+package ngrok
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestWriteSupportBundle(t *testing.T) {
+	// Grab a free port and close it so that connecting fails.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	ctx := context.Background()
+	sess, err := Connect(ctx,
+		WithAuthtoken("secret-authtoken"),
+		WithServer(addr),
+		WithMaxConnectAttempts(1),
+		WithProxyURL(&url.URL{Scheme: "http", User: url.UserPassword("user", "secret-password"), Host: addr}),
+		WithOfflineFallback("127.0.0.1:0"),
+		WithLogBuffer(100),
+	)
+	require.NoError(t, err)
+	defer sess.Close()
+	tun, err := sess.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, WriteSupportBundle(ctx, sess, &buf))
+	require.NotContains(t, buf.String(), "secret")
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		require.NoError(t, err)
+		files[f.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.NotContains(t, string(files[f.Name]), "secret", f.Name)
+	}
+	require.ElementsMatch(t, []string{
+		"status.json", "stats.json", "config.json", "logs.txt", "goroutines.txt", "diagnostics.json",
+	}, keys(files))
+
+	require.Contains(t, string(files["status.json"]), tun.ID())
+
+	var cfg bundleConfig
+	require.NoError(t, json.Unmarshal(files["config.json"], &cfg))
+	require.Equal(t, "REDACTED", cfg.Authtoken)
+	require.Equal(t, addr, cfg.ServerAddr)
+	require.Contains(t, cfg.ProxyURL, "user:xxxxx@")
+
+	require.Contains(t, string(files["logs.txt"]), "listening locally instead")
+	require.Contains(t, string(files["goroutines.txt"]), "TestWriteSupportBundle")
+
+	var diag struct {
+		Probes   []bundleProbe `json:"probes"`
+		Warnings []string      `json:"warnings"`
+	}
+	require.NoError(t, json.Unmarshal(files["diagnostics.json"], &diag))
+	require.Len(t, diag.Probes, 1)
+	require.Equal(t, addr, diag.Probes[0].Addr)
+	require.NotEmpty(t, diag.Probes[0].Error)
+}
+
+func TestLogBufferWriteTo(t *testing.T) {
+	var none *logBuffer
+	var buf bytes.Buffer
+	require.NoError(t, none.writeTo(&buf))
+	require.Contains(t, buf.String(), "WithLogBuffer")
+
+	cfg := connectConfig{}
+	WithLogBuffer(2)(&cfg)
+	logger := cfg.logger().With("tunnel", "tn_1")
+	for _, msg := range []string{"a", "b", "c"} {
+		logger.Debug(msg)
+	}
+	buf.Reset()
+	require.NoError(t, cfg.logBuffer.writeTo(&buf))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], "level=DEBUG msg=b tunnel=tn_1")
+	require.Contains(t, lines[1], "msg=c")
+}
+
+func keys[V any](m map[string]V) []string {
+	out := make([]string, 0, len(m))
+	for k := range m {
+		out = append(out, k)
+	}
+	return out
+}
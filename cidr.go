@@ -0,0 +1,70 @@
+package ngrok
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// CIDR restrictions enforced by the agent on the client addresses of
+// connections, for tunnels configured with config.WithCIDRsEnforcedLocally.
+type cidrFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// Parse the allowed and denied CIDRs, or return nil if there are none.
+func newCIDRFilter(allow, deny []string) (*cidrFilter, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	f := &cidrFilter{}
+	for _, list := range []struct {
+		cidrs    []string
+		prefixes *[]netip.Prefix
+	}{{allow, &f.allow}, {deny, &f.deny}} {
+		for _, cidr := range list.cidrs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+			}
+			*list.prefixes = append(*list.prefixes, prefix.Masked())
+		}
+	}
+	return f, nil
+}
+
+// Report whether a connection from addr is allowed. Addresses matching a
+// denied CIDR aren't, nor are those that match none of the allowed CIDRs if
+// there are any. A nil filter allows everything.
+func (f *cidrFilter) allows(addr net.Addr) bool {
+	if f == nil {
+		return true
+	}
+	var ip netip.Addr
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		ip, _ = netip.AddrFromSlice(tcp.IP)
+	} else if addr != nil {
+		if ap, err := netip.ParseAddrPort(addr.String()); err == nil {
+			ip = ap.Addr()
+		}
+	}
+	if !ip.IsValid() {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range f.deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	for _, p := range f.allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func TestCIDRFilter(t *testing.T) {
+	f, err := newCIDRFilter([]string{"192.0.2.0/24", "2001:db8::/32"}, []string{"192.0.2.128/25"})
+	require.NoError(t, err)
+
+	for addr, allowed := range map[string]bool{
+		"192.0.2.1:1000":       true,
+		"192.0.2.200:1000":     false,
+		"198.51.100.1:1000":    false,
+		"[2001:db8::1]:1000":   true,
+		"[::ffff:192.0.2.1]:1": true,
+	} {
+		require.Equal(t, allowed, f.allows((&addrConn{addr: addr}).RemoteAddr()), addr)
+	}
+
+	f, err = newCIDRFilter(nil, nil)
+	require.NoError(t, err)
+	require.Nil(t, f)
+	require.True(t, f.allows(nil))
+
+	_, err = newCIDRFilter([]string{"192.0.2.0"}, nil)
+	require.Error(t, err)
+}
+
+func TestCIDRRejects(t *testing.T) {
+	f, err := newCIDRFilter(nil, []string{"192.0.2.0/24"})
+	require.NoError(t, err)
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 2)}
+	tun := &tunnelImpl{Tunnel: q, cidrs: f}
+
+	send := func(addr string) net.Conn {
+		local, remote := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: &addrConn{local, addr}}
+		return remote
+	}
+
+	denied := send("192.0.2.1:1000")
+	send("198.51.100.1:1000")
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	require.Equal(t, "198.51.100.1:1000", conn.RemoteAddr().String())
+
+	_, err = denied.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	require.Equal(t, uint64(1), tun.Stats().Rejected)
+}
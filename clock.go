@@ -0,0 +1,27 @@
+package ngrok
+
+import (
+	"golang.ngrok.com/ngrok/clock"
+)
+
+// WithClock configures the clock that times the session's waits between
+// reconnect attempts, its checks for missed heartbeats, and the idle timeouts
+// of forwarded connections. It defaults to [clock.System].
+//
+// It's intended for tests, which can pass a [clock.Fake] to drive backoffs and
+// timeouts without sleeping. The heartbeats themselves are still sent on the
+// system clock. Pair it with [WithDialer] to connect the session over an
+// in-memory network, such as the one provided by the ngroktest package.
+func WithClock(clk clock.Clock) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ReconnectOptions.Clock = clk
+	}
+}
+
+// The clock that times the session.
+func (s *sessionImpl) clock() clock.Clock {
+	if s.clk == nil {
+		return clock.System
+	}
+	return s.clk
+}
@@ -0,0 +1,74 @@
+// Package clock abstracts the passage of time for sessions, so that their
+// reconnect backoffs, heartbeat checks and idle timeouts can be tested quickly
+// and deterministically. Sessions use the [System] clock unless they're
+// connected with [golang.ngrok.com/ngrok.WithClock], which tests can pass a
+// [Fake] clock to advance by hand:
+//
+//	clk := clock.NewFake(time.Now())
+//	sess, err := ngrok.Connect(ctx, ngrok.WithClock(clk), ...)
+//	...
+//	clk.BlockUntil(1)
+//	clk.Advance(time.Minute)
+package clock
+
+import (
+	"time"
+)
+
+// Clock tells the time and creates timers.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// NewTimer creates a timer that sends the time on its channel once d
+	// has passed.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc creates a timer that calls f in its own goroutine once d
+	// has passed.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a single event created by a [Clock], like a [time.Timer].
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires. It's
+	// nil for timers created by AfterFunc.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. It returns false if the timer
+	// already fired or was stopped.
+	Stop() bool
+	// Reset changes the timer to fire once d has passed. It returns whether
+	// the timer was active.
+	Reset(d time.Duration) bool
+}
+
+// System is the clock backed by the time package.
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	t *time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t systemTimer) Stop() bool {
+	return t.t.Stop()
+}
+
+func (t systemTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
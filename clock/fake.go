@@ -0,0 +1,133 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a [Clock] whose time only moves when it's advanced. Timers fire
+// during [Fake.Advance] in the order they're due, with the clock set to
+// their deadline.
+type Fake struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	now  time.Time
+	// The timers that have yet to fire.
+	pending []*fakeTimer
+}
+
+// NewFake creates a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, c: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward by d, firing the timers that come due.
+// The functions of AfterFunc timers run in their own goroutines, so they may
+// still be running when Advance returns.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	for {
+		next := -1
+		for i, t := range f.pending {
+			if !t.when.After(end) && (next < 0 || t.when.Before(f.pending[next].when)) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+		t := f.pending[next]
+		f.remove(t)
+		f.now = t.when
+		t.fire()
+	}
+	f.now = end
+	f.mu.Unlock()
+}
+
+// BlockUntil waits until at least n timers are waiting to fire. Tests call
+// it before Advance to be sure that the code under test has set the timers
+// it's meant to.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.pending) < n {
+		f.cond.Wait()
+	}
+}
+
+// Remove a pending timer. The lock must be held.
+func (f *Fake) remove(t *fakeTimer) bool {
+	for i, p := range f.pending {
+		if p == t {
+			f.pending = append(f.pending[:i], f.pending[i+1:]...)
+			f.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *Fake
+	c     chan time.Time
+	fn    func()
+	when  time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	active := f.remove(t)
+	t.when = f.now.Add(d)
+	if d <= 0 {
+		t.fire()
+		return active
+	}
+	f.pending = append(f.pending, t)
+	f.cond.Broadcast()
+	return active
+}
+
+func (t *fakeTimer) fire() {
+	if t.fn != nil {
+		go t.fn()
+		return
+	}
+	select {
+	case t.c <- t.when:
+	default:
+	}
+}
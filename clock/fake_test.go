@@ -0,0 +1,51 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeTimers(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk := NewFake(start)
+
+	late := clk.NewTimer(2 * time.Second)
+	early := clk.NewTimer(time.Second)
+	stopped := clk.NewTimer(time.Second)
+	require.True(t, stopped.Stop())
+	require.False(t, stopped.Stop())
+
+	fired := make(chan time.Time, 1)
+	clk.AfterFunc(1500*time.Millisecond, func() { fired <- clk.Now() })
+
+	clk.Advance(999 * time.Millisecond)
+	require.Empty(t, early.C())
+
+	clk.Advance(time.Second)
+	require.Equal(t, start.Add(time.Second), <-early.C())
+	require.Equal(t, start.Add(1999*time.Millisecond), <-fired)
+	require.Empty(t, late.C())
+	require.Empty(t, stopped.C())
+
+	require.False(t, early.Reset(time.Second))
+	require.True(t, late.Reset(time.Second))
+	clk.Advance(time.Second)
+	require.Equal(t, start.Add(2999*time.Millisecond), <-early.C())
+	require.Equal(t, start.Add(2999*time.Millisecond), <-late.C())
+	require.Equal(t, start.Add(2999*time.Millisecond), clk.Now())
+}
+
+func TestFakeBlockUntil(t *testing.T) {
+	clk := NewFake(time.Now())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		<-clk.NewTimer(time.Minute).C()
+	}()
+
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	<-done
+}
@@ -0,0 +1,107 @@
+package ngrok
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// The default most bytes buffered before coalesced writes are flushed, which
+// is the largest TLS record.
+const defaultCoalesceSize = 16 << 10
+
+// WithWriteCoalescing batches the frames written to the ngrok service into
+// fewer, larger writes. Frames are held for up to window, or until size bytes
+// are waiting, before they're written together. With many concurrent
+// connections sending small packets, this cuts the number of TLS records and
+// syscalls the session makes, at the cost of up to window of added latency.
+//
+// A size of zero or less uses the size of the largest TLS record. A window of
+// zero, the default, writes each frame as soon as it's sent.
+func WithWriteCoalescing(window time.Duration, size int) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.CoalesceWindow = window
+		cfg.CoalesceSize = size
+	}
+}
+
+// A connection that buffers small writes, flushing them together once the
+// window passes or the buffer fills up. The muxado writer writes each frame's
+// header and payload separately, so this also joins them into one TLS record.
+type coalescingConn struct {
+	net.Conn
+	window time.Duration
+	size   int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+	// The error from a flush that happened after its writes had returned,
+	// reported by the next write.
+	err error
+}
+
+func newCoalescingConn(conn net.Conn, window time.Duration, size int) *coalescingConn {
+	if size <= 0 {
+		size = defaultCoalesceSize
+	}
+	return &coalescingConn{Conn: conn, window: window, size: size}
+}
+
+func (c *coalescingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	// Large writes have nothing to gain from waiting.
+	if len(c.buf) == 0 && len(p) >= c.size {
+		return c.Conn.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.size {
+		if err := c.flushLocked(); err != nil {
+			return 0, err
+		}
+	} else if c.timer == nil {
+		c.timer = time.AfterFunc(c.window, c.flush)
+	}
+	return len(p), nil
+}
+
+// Flush the buffered writes once the window has passed. Since their writes
+// have already returned, a failure closes the connection so that the session
+// notices it.
+func (c *coalescingConn) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.flushLocked(); err != nil {
+		_ = c.Conn.Close()
+	}
+}
+
+func (c *coalescingConn) flushLocked() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if len(c.buf) == 0 {
+		return c.err
+	}
+	_, err := c.Conn.Write(c.buf)
+	c.buf = c.buf[:0]
+	if err != nil && c.err == nil {
+		c.err = err
+	}
+	return c.err
+}
+
+// Close writes out anything that's buffered before closing the connection.
+func (c *coalescingConn) Close() error {
+	c.mu.Lock()
+	_ = c.flushLocked()
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,151 @@
+package ngrok
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/muxado/v2"
+)
+
+// A connection that counts the writes made to it, and records them if it
+// isn't passing them on.
+type writeCountingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes [][]byte
+	count  atomic.Int64
+	err    error
+}
+
+func (c *writeCountingConn) Write(p []byte) (int, error) {
+	c.count.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.Conn != nil {
+		return c.Conn.Write(p)
+	}
+	c.writes = append(c.writes, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func (c *writeCountingConn) Close() error {
+	if c.Conn != nil {
+		return c.Conn.Close()
+	}
+	return nil
+}
+
+func (c *writeCountingConn) written() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.writes
+}
+
+func TestCoalescingConn(t *testing.T) {
+	under := &writeCountingConn{}
+	conn := newCoalescingConn(under, 20*time.Millisecond, 8)
+
+	for _, p := range []string{"a", "b", "c"} {
+		n, err := conn.Write([]byte(p))
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+	}
+	require.Empty(t, under.written(), "writes should wait for the window")
+	require.Eventually(t, func() bool { return len(under.written()) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, "abc", string(under.written()[0]))
+
+	// Filling the buffer flushes it right away.
+	_, err := conn.Write([]byte("defghijk"))
+	require.NoError(t, err)
+	require.Len(t, under.written(), 2)
+	require.Equal(t, "defghijk", string(under.written()[1]))
+
+	// So does closing.
+	_, err = conn.Write([]byte("l"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+	require.Len(t, under.written(), 3)
+	require.Equal(t, "l", string(under.written()[2]))
+}
+
+func TestCoalescingConnFlushError(t *testing.T) {
+	under := &writeCountingConn{err: errors.New("broken pipe")}
+	conn := newCoalescingConn(under, time.Millisecond, 0)
+
+	_, err := conn.Write([]byte("a"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, err = conn.Write([]byte("b"))
+		return err != nil
+	}, time.Second, time.Millisecond)
+	require.EqualError(t, err, "broken pipe")
+}
+
+// Writes a small packet on each of many concurrent streams, reporting the
+// writes that reach the transport.
+func BenchmarkCoalescing(b *testing.B) {
+	for _, bench := range []struct {
+		name   string
+		window time.Duration
+	}{
+		{"off", 0},
+		{"100us", 100 * time.Microsecond},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			agentSide, edgeSide := net.Pipe()
+			under := &writeCountingConn{Conn: agentSide}
+			var transport net.Conn = under
+			if bench.window > 0 {
+				transport = newCoalescingConn(under, bench.window, 0)
+			}
+			agent := muxado.Client(transport, nil)
+			edge := muxado.Server(edgeSide, nil)
+			defer agent.Close()
+			defer edge.Close()
+
+			go func() {
+				for {
+					stream, err := edge.AcceptStream()
+					if err != nil {
+						return
+					}
+					go func() { _, _ = io.Copy(io.Discard, stream) }()
+				}
+			}()
+
+			const streams = 32
+			packet := make([]byte, 64)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var wg sync.WaitGroup
+				for j := 0; j < streams; j++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						stream, err := agent.OpenStream()
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						defer stream.Close()
+						_, _ = stream.Write(packet)
+					}()
+				}
+				wg.Wait()
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(under.count.Load())/float64(b.N), "writes/op")
+		})
+	}
+}
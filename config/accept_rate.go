@@ -0,0 +1,32 @@
+package config
+
+type acceptRateOption struct {
+	perSecond float64
+	burst     int
+}
+
+// WithAcceptRateLimit limits how quickly connections to the endpoint are
+// accepted, to protect the application from connection floods. Up to burst
+// connections are accepted at once, and the allowance refills at perSecond.
+// Connections beyond the limit are closed as they arrive, counted in the
+// tunnel's stats as Throttled, and reported by an EventAcceptThrottled each
+// time throttling starts.
+func WithAcceptRateLimit(perSecond float64, burst int) interface {
+	HTTPEndpointOption
+	TLSEndpointOption
+	TCPEndpointOption
+} {
+	return acceptRateOption{perSecond: perSecond, burst: burst}
+}
+
+func (opt acceptRateOption) ApplyHTTP(opts *httpOptions) {
+	opts.AcceptRate, opts.AcceptBurst = opt.perSecond, opt.burst
+}
+
+func (opt acceptRateOption) ApplyTLS(opts *tlsOptions) {
+	opts.AcceptRate, opts.AcceptBurst = opt.perSecond, opt.burst
+}
+
+func (opt acceptRateOption) ApplyTCP(opts *tcpOptions) {
+	opts.AcceptRate, opts.AcceptBurst = opt.perSecond, opt.burst
+}
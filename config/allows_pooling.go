@@ -1,5 +1,12 @@
 package config
 
+import (
+	"context"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
 type allowsPoolingOption bool
 
 func WithAllowsPooling(allowsPooling bool) interface {
@@ -21,3 +28,44 @@ func (opt allowsPoolingOption) ApplyTLS(opts *tlsOptions) {
 func (opt allowsPoolingOption) ApplyTCP(opts *tcpOptions) {
 	opts.AllowsPooling = bool(opt)
 }
+
+// WithPoolHealthCheck takes a pooled endpoint out of its pool while the
+// provided health check fails, so that the ngrok edge sends its traffic to
+// the other members instead. The endpoint rejoins the pool as soon as the
+// check passes again. Connections that are already open are unaffected.
+//
+// The check is called every interval, defaulting to 10 seconds, with a
+// context that expires after the interval. It only runs for endpoints started
+// with [WithAllowsPooling](true).
+func WithPoolHealthCheck(interval time.Duration, check func(ctx context.Context) error) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+} {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.HealthCheck = check
+		opts.HealthInterval = interval
+	})
+}
+
+// WithPoolReadinessCheck is like [WithPoolHealthCheck], except that the
+// endpoint also waits for the check to pass before joining its pool at all:
+// Listen calls the check every interval and only binds the endpoint once it
+// succeeds. Combined with [WithAllowsPooling](true), this lets a new
+// deployment publish the same URL as the one it replaces without receiving
+// traffic before it is ready, and drop out of the pool when it stops being
+// healthy.
+//
+// Listen fails with an error matching ngrok.ErrNotHealthy if its context
+// expires before the check passes.
+func WithPoolReadinessCheck(interval time.Duration, check func(ctx context.Context) error) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+} {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.HealthCheck = check
+		opts.HealthInterval = interval
+		opts.HealthGate = true
+	})
+}
@@ -15,7 +15,13 @@ func (ap appProtocol) ApplyLabeled(cfg *labeledOptions) {
 // upgrades or downgrades.
 //
 // Currently, `http2` is the only valid string, and will cause connections
-// received from HTTP endpoints to always use HTTP/2.
+// received from HTTP endpoints to always use HTTP/2, without TLS. Servers
+// passed to [golang.ngrok.com/ngrok.Session].ListenAndServeHTTP or
+// [WithHTTPServer] are set up to serve them. When serving the connections
+// returned by [golang.ngrok.com/ngrok.Session].Listen yourself, use
+// [golang.ngrok.com/ngrok.Tunnel].ServeHTTP, or wrap the handler with
+// h2c.NewHandler from golang.org/x/net/http2/h2c. The protocol
+// is also shown for the tunnel in the API and dashboard.
 func WithAppProtocol(proto string) interface {
 	HTTPEndpointOption
 	LabeledTunnelOption
@@ -66,6 +66,35 @@ func WithDenyCIDR(net ...*net.IPNet) interface {
 	return cidrRestrictions{Denied: cidrStrings}
 }
 
+type localCIDROption bool
+
+// WithCIDRsEnforcedLocally enforces the CIDRs added with WithAllowCIDR and
+// WithDenyCIDR in the agent, rather than at the ngrok edge, for accounts whose
+// plans don't include IP restrictions. Each connection is checked against the
+// client address reported by the ngrok edge as it's accepted, and closed if it
+// isn't allowed. The CIDRs are then not sent to the ngrok service, so denied
+// clients still reach the endpoint, and HTTP requests on an allowed
+// connection aren't checked individually.
+func WithCIDRsEnforcedLocally() interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+} {
+	return localCIDROption(true)
+}
+
+func (opt localCIDROption) ApplyHTTP(opts *httpOptions) {
+	opts.LocalCIDRs = bool(opt)
+}
+
+func (opt localCIDROption) ApplyTCP(opts *tcpOptions) {
+	opts.LocalCIDRs = bool(opt)
+}
+
+func (opt localCIDROption) ApplyTLS(opts *tlsOptions) {
+	opts.LocalCIDRs = bool(opt)
+}
+
 func (base *cidrRestrictions) merge(set cidrRestrictions) *cidrRestrictions {
 	if base == nil {
 		base = &cidrRestrictions{}
@@ -99,3 +128,11 @@ func (opt cidrRestrictions) ApplyTCP(opts *tcpOptions) {
 func (opt cidrRestrictions) ApplyTLS(opts *tlsOptions) {
 	opts.CIDRRestrictions = opts.CIDRRestrictions.merge(opt)
 }
+
+// The CIDR restrictions to send to the ngrok edge, if it enforces them.
+func (cfg *commonOpts) ipRestriction() *pb.MiddlewareConfiguration_IPRestriction {
+	if cfg.LocalCIDRs {
+		return nil
+	}
+	return cfg.CIDRRestrictions.toProtoConfig()
+}
@@ -116,9 +116,32 @@ func testCIDRRestrictions[T tunnelConfigPrivate, O any, OT any](t *testing.T,
 				require.ElementsMatch(t, []string{"127.0.0.0/8", "10.0.0.0/8"}, actual.AllowCidrs)
 			},
 		},
+		{
+			name: "enforced locally",
+			opts: optsFunc(
+				WithAllowCIDRString("127.0.0.0/8"),
+				WithDenyCIDRString("127.0.0.1/32"),
+				WithCIDRsEnforcedLocally(),
+			),
+			expectOpts: func(t *testing.T, opts *O) {
+				require.Nil(t, getRestrictions(opts))
+			},
+		},
 	}
 
 	cases.runAll(t)
+
+	allow, deny := optsFunc(
+		WithAllowCIDRString("127.0.0.0/8"),
+		WithDenyCIDRString("127.0.0.1/32"),
+		WithCIDRsEnforcedLocally(),
+	).(tunnelConfigPrivate).LocalCIDRRestrictions()
+	require.Equal(t, []string{"127.0.0.0/8"}, allow)
+	require.Equal(t, []string{"127.0.0.1/32"}, deny)
+
+	allow, deny = optsFunc(WithAllowCIDRString("127.0.0.0/8")).(tunnelConfigPrivate).LocalCIDRRestrictions()
+	require.Nil(t, allow)
+	require.Nil(t, deny)
 }
 
 func TestCIDRRestrictions(t *testing.T) {
@@ -1,8 +1,17 @@
 package config
 
+import (
+	"crypto/tls"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
 type commonOpts struct {
 	// Restrictions placed on the origin of incoming connections to the edge.
 	CIDRRestrictions *cidrRestrictions
+	// Whether the CIDR restrictions are enforced by the agent rather than
+	// sent to the ngrok edge.
+	LocalCIDRs bool
 	// The version of PROXY protocol to use with this tunnel, zero if not
 	// using.
 	ProxyProto ProxyProtoVersion
@@ -39,6 +48,30 @@ type commonOpts struct {
 
 	// Allows the endpoint to pool with other endpoints with the same host/port/binding
 	AllowsPooling bool
+
+	// URLs to request in order if the ngrok service refuses to bind the
+	// endpoint. The empty string requests a random URL.
+	FallbackURLs []string
+	// Whether to close the tunnel rather than let its URL change when it's
+	// bound again after a reconnect.
+	StableURLRequired bool
+	// Whether to return the session's tunnel already bound to the same URL,
+	// rather than fail.
+	ReuseExistingTunnel bool
+
+	// The rate at which connections are accepted from the tunnel, and how
+	// many may be accepted at once. Connections beyond it are closed.
+	AcceptRate  float64
+	AcceptBurst int
+
+	// The ID and token of an endpoint from a previous session to start the
+	// endpoint as.
+	BindID    string
+	BindToken string
+
+	// Options for connecting to the upstream service when forwarding. These
+	// are used locally and never sent to the ngrok service.
+	Upstream upstream.Options
 }
 
 type CommonOptionsFunc func(cfg *commonOpts)
@@ -58,4 +91,43 @@ func (cfg *commonOpts) getForwardsTo() string {
 	return cfg.ForwardsTo
 }
 
+func (cfg *commonOpts) UpstreamOptions() upstream.Options {
+	return cfg.Upstream
+}
+
+func (cfg *commonOpts) Identity() string {
+	return cfg.BindID
+}
+
+func (cfg *commonOpts) URLFallbacks() []string {
+	return cfg.FallbackURLs
+}
+
+func (cfg *commonOpts) StableURL() bool {
+	return cfg.StableURLRequired
+}
+
+func (cfg *commonOpts) ReuseExisting() bool {
+	return cfg.ReuseExistingTunnel
+}
+
+func (cfg *commonOpts) AcceptRateLimit() (float64, int) {
+	return cfg.AcceptRate, cfg.AcceptBurst
+}
+
+func (cfg *commonOpts) LocalCIDRRestrictions() (allow, deny []string) {
+	if !cfg.LocalCIDRs || cfg.CIDRRestrictions == nil {
+		return nil, nil
+	}
+	return cfg.CIDRRestrictions.Allowed, cfg.CIDRRestrictions.Denied
+}
+
+func (cfg *commonOpts) AgentTLSConfig() *tls.Config {
+	return nil
+}
+
+func (cfg *commonOpts) TLSALPNChallenges() func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return nil
+}
+
 func (cfg *commonOpts) tunnelOptions() {}
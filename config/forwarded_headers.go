@@ -0,0 +1,37 @@
+package config
+
+import "golang.ngrok.com/ngrok/internal/upstream"
+
+// ForwardedHeadersMode is how the Via and X-Forwarded-* headers of the
+// requests proxied to the upstream service are set.
+type ForwardedHeadersMode int
+
+const (
+	// Forwarded headers are not added.
+	ForwardedHeadersNone = ForwardedHeadersMode(upstream.ForwardedHeadersNone)
+	// The client's IP address is appended to any X-Forwarded-For header
+	// received with the request, and an entry for the agent to any Via
+	// header. X-Forwarded-Proto and X-Forwarded-Host are only set if they
+	// weren't received. Use this when the endpoint is behind other proxies
+	// that the upstream service trusts.
+	ForwardedHeadersAppend = ForwardedHeadersMode(upstream.ForwardedHeadersAppend)
+	// Any Via and X-Forwarded-* headers received with the request are
+	// discarded, so that clients can't spoof them, and replaced with what the
+	// agent saw.
+	ForwardedHeadersReplace = ForwardedHeadersMode(upstream.ForwardedHeadersReplace)
+)
+
+// WithForwardedHeaders adds Via, X-Forwarded-For, X-Forwarded-Proto and
+// X-Forwarded-Host headers to the requests proxied to the upstream service
+// when the tunnel is started with [golang.ngrok.com/ngrok.ListenAndForward],
+// so that it sees who the client was without custom middleware.
+// X-Forwarded-For is the client address from the connection's proxy header,
+// and X-Forwarded-Proto the protocol the client used to reach the endpoint.
+//
+// As with [WithRequestLimits], the tunnel is served by an HTTP server in the
+// agent rather than forwarding connections untouched.
+func WithForwardedHeaders(mode ForwardedHeadersMode) HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.Upstream.ForwardedHeaders = upstream.ForwardedHeadersMode(mode)
+	})
+}
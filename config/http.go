@@ -7,6 +7,7 @@ import (
 
 	"golang.ngrok.com/ngrok/internal/pb"
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+	"golang.ngrok.com/ngrok/internal/upstream"
 )
 
 type HTTPEndpointOption interface {
@@ -37,6 +38,8 @@ type httpOptions struct {
 	// The scheme that this edge should use.
 	// Defaults to [SchemeHTTPS].
 	Scheme Scheme
+	// Whether to redirect the plain-HTTP variant of an https endpoint to it.
+	HTTPRedirect bool
 
 	// The fqdn to request for this edge
 	Domain string
@@ -123,7 +126,7 @@ func (cfg *httpOptions) toProtoConfig() *proto.HTTPEndpoint {
 	opts.OAuth = cfg.OAuth.toProtoConfig()
 	opts.OIDC = cfg.OIDC.toProtoConfig()
 	opts.WebhookVerification = cfg.WebhookVerification.toProtoConfig()
-	opts.IPRestriction = cfg.commonOpts.CIDRRestrictions.toProtoConfig()
+	opts.IPRestriction = cfg.commonOpts.ipRestriction()
 	opts.UserAgentFilter = cfg.UserAgentFilter.toProtoConfig()
 	opts.TrafficPolicy = cfg.TrafficPolicy
 
@@ -148,6 +151,7 @@ func (cfg *httpOptions) WithForwardsTo(url *url.URL) {
 func (cfg httpOptions) Extra() proto.BindExtra {
 	return proto.BindExtra{
 		Name:          cfg.Name,
+		Token:         cfg.BindToken,
 		Metadata:      cfg.Metadata,
 		Description:   cfg.Description,
 		Bindings:      cfg.Bindings,
@@ -170,6 +174,22 @@ func (cfg httpOptions) Labels() map[string]string {
 	return nil
 }
 
+func (cfg httpOptions) RedirectsHTTP() bool {
+	return cfg.HTTPRedirect && cfg.Proto() == string(SchemeHTTPS)
+}
+
+// The upstream options, with whether the identity headers on requests can be
+// trusted filled in from the authentication configured on the endpoint.
+func (cfg *httpOptions) UpstreamOptions() upstream.Options {
+	opts := cfg.Upstream
+	if opts.Identity != nil {
+		identity := *opts.Identity
+		identity.Trusted = cfg.OAuth != nil || cfg.OIDC != nil
+		opts.Identity = &identity
+	}
+	return opts
+}
+
 func (cfg httpOptions) HTTPServer() *http.Server {
 	return cfg.httpServer
 }
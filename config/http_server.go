@@ -0,0 +1,41 @@
+package config
+
+import (
+	"log"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// HTTPServerSettings tunes the [net/http.Server] that serves a tunnel within
+// the agent, whether it was passed to
+// [golang.ngrok.com/ngrok.Session].ListenAndServeHTTP, created by
+// ListenAndHandleHTTP, or started by ListenAndForward to proxy requests (see
+// [WithHostRouting] and [WithRequestLimits]). Zero fields keep the server's
+// own settings, so that fields set on a server passed to ListenAndServeHTTP
+// take precedence. Likewise, the ReadHeaderTimeout and MaxHeaderBytes of
+// [WithRequestLimits] take precedence over those set here.
+type HTTPServerSettings struct {
+	// How long a client may take to send the request headers. Without it,
+	// a client can hold a connection open indefinitely by sending its
+	// headers slowly.
+	ReadHeaderTimeout time.Duration
+	// How long an idle keep-alive connection is kept open waiting for the
+	// next request.
+	IdleTimeout time.Duration
+	// The most bytes read for the request line and headers.
+	MaxHeaderBytes int
+	// The logger for errors accepting connections, reading requests and
+	// recovering from panicking handlers. By default these are logged to
+	// the session's logger at warning level, rather than to the standard
+	// library's log package.
+	ErrorLog *log.Logger
+}
+
+// WithHTTPServerSettings applies the given settings to the HTTP server that
+// serves the tunnel within the agent.
+func WithHTTPServerSettings(settings HTTPServerSettings) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.HTTPServer = upstream.HTTPServer(settings)
+	})
+}
@@ -0,0 +1,31 @@
+package config
+
+type identityOption struct {
+	id    string
+	token string
+}
+
+// WithIdentity starts the endpoint with the ID and token of an endpoint from a
+// previous session, as reported by the tunnel's Identity method, so that the
+// ngrok service treats it as the same endpoint. Persist the identity when an
+// endpoint starts and pass it back after the application restarts to keep the
+// endpoint's ID stable in the dashboard and API.
+func WithIdentity(id, token string) interface {
+	HTTPEndpointOption
+	TCPEndpointOption
+	TLSEndpointOption
+} {
+	return identityOption{id: id, token: token}
+}
+
+func (opt identityOption) ApplyHTTP(opts *httpOptions) {
+	opts.BindID, opts.BindToken = opt.id, opt.token
+}
+
+func (opt identityOption) ApplyTLS(opts *tlsOptions) {
+	opts.BindID, opts.BindToken = opt.id, opt.token
+}
+
+func (opt identityOption) ApplyTCP(opts *tcpOptions) {
+	opts.BindID, opts.BindToken = opt.id, opt.token
+}
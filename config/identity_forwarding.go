@@ -0,0 +1,49 @@
+package config
+
+import (
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// IdentityForwarding configures how the identity of the users that an
+// endpoint's OAuth or OIDC authenticated is passed to the upstream service.
+type IdentityForwarding struct {
+	// Whether to set X-Forwarded-User, X-Forwarded-Email and
+	// X-Forwarded-Preferred-Username to the user's ID, email address and
+	// name, as authenticating proxies conventionally do.
+	Headers bool
+	// If set, a JWT asserting the user's identity is sent as a bearer token
+	// in the Authorization header, signed with this key using HS256. The
+	// user's ID is its subject, and their email address and name are in the
+	// "email" and "name" claims.
+	JWTKey []byte
+	// The issuer of the JWTs. Defaults to "ngrok".
+	JWTIssuer string
+	// How long the JWTs are valid for. Defaults to a minute.
+	JWTTTL time.Duration
+}
+
+// WithIdentityForwarding passes the identity of the users authenticated by the
+// endpoint's [WithOAuth] or [WithOIDC] to the upstream service when the tunnel
+// is started with [golang.ngrok.com/ngrok.ListenAndForward], in the form set
+// by fwd.
+//
+// The ngrok service adds the identity in ngrok-auth-user-* headers, which
+// clients can also send themselves. They are only trusted when the endpoint
+// authenticates users with WithOAuth or WithOIDC; otherwise they are removed
+// from requests. Inbound copies of the headers set from the identity are
+// always removed, so that the upstream service can rely on them.
+//
+// As with [WithRequestLimits], the tunnel is served by an HTTP server in the
+// agent rather than forwarding connections untouched.
+func WithIdentityForwarding(fwd IdentityForwarding) HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.Upstream.Identity = &upstream.Identity{
+			Headers:   fwd.Headers,
+			JWTKey:    fwd.JWTKey,
+			JWTIssuer: fwd.JWTIssuer,
+			JWTTTL:    fwd.JWTTTL,
+		}
+	})
+}
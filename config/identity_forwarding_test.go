@@ -0,0 +1,28 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityForwarding(t *testing.T) {
+	fwd := WithIdentityForwarding(IdentityForwarding{Headers: true})
+	require.Nil(t, HTTPEndpoint().(*httpOptions).UpstreamOptions().Identity)
+
+	// The identity headers are only trusted if the endpoint authenticates
+	// users, whatever order the options are in.
+	for _, tc := range []struct {
+		opts    []HTTPEndpointOption
+		trusted bool
+	}{
+		{[]HTTPEndpointOption{fwd}, false},
+		{[]HTTPEndpointOption{fwd, WithOAuth("google")}, true},
+		{[]HTTPEndpointOption{WithOIDC("https://issuer.example.com", "id", "secret"), fwd}, true},
+	} {
+		identity := HTTPEndpoint(tc.opts...).(*httpOptions).UpstreamOptions().Identity
+		require.NotNil(t, identity)
+		require.True(t, identity.Headers)
+		require.Equal(t, tc.trusted, identity.Trusted)
+	}
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testIdentity[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	optsFunc := func(opts ...any) Tunnel {
+		return makeOpts(assertSlice[OT](opts)...)
+	}
+
+	cases := testCases[T, any]{
+		{
+			name: "absent",
+			opts: optsFunc(),
+			expectExtra: &matchBindExtra{
+				Token: ptr(""),
+			},
+		},
+		{
+			name: "with identity",
+			opts: optsFunc(WithIdentity("tun_123", "secret")),
+			expectExtra: &matchBindExtra{
+				Token: ptr("secret"),
+			},
+		},
+	}
+
+	cases.runAll(t)
+
+	require.Equal(t, "", optsFunc().(T).Identity())
+	require.Equal(t, "tun_123", optsFunc(WithIdentity("tun_123", "secret")).(T).Identity())
+}
+
+func TestIdentity(t *testing.T) {
+	testIdentity[*httpOptions](t, HTTPEndpoint)
+	testIdentity[*tlsOptions](t, TLSEndpoint)
+	testIdentity[*tcpOptions](t, TCPEndpoint)
+}
@@ -0,0 +1,37 @@
+package config
+
+import "time"
+
+// RequestLimits bounds the HTTP requests that are proxied to the upstream
+// service, so that it isn't exposed to oversized or slowly trickled requests
+// arriving through the tunnel. Zero fields impose no limit.
+type RequestLimits struct {
+	// The most bytes read for the request line and headers. Requests with
+	// larger headers are rejected with 431 Request Header Fields Too Large.
+	MaxHeaderBytes int
+	// The largest request body that is proxied. Requests with larger bodies
+	// are rejected with 413 Content Too Large.
+	MaxBodyBytes int64
+	// How long a client may take to send the request headers. This guards
+	// against slowloris attacks, which hold connections open by sending
+	// headers a byte at a time.
+	ReadHeaderTimeout time.Duration
+	// How long a client may take to send the whole request, including its
+	// body.
+	ReadTimeout time.Duration
+}
+
+// WithRequestLimits limits the requests proxied to the upstream service when
+// the tunnel is started with [golang.ngrok.com/ngrok.ListenAndForward]. The
+// tunnel is served by an HTTP server in the agent that enforces the limits,
+// as it is with [WithHostRouting], rather than forwarding connections
+// untouched. Its non-zero limits take precedence over the settings of
+// [WithHTTPServerSettings] for the same fields.
+func WithRequestLimits(limits RequestLimits) HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.Upstream.MaxHeaderBytes = limits.MaxHeaderBytes
+		cfg.Upstream.MaxBodyBytes = limits.MaxBodyBytes
+		cfg.Upstream.ReadHeaderTimeout = limits.ReadHeaderTimeout
+		cfg.Upstream.ReadTimeout = limits.ReadTimeout
+	})
+}
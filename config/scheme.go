@@ -16,3 +16,15 @@ func WithScheme(scheme Scheme) HTTPEndpointOption {
 		cfg.Scheme = scheme
 	})
 }
+
+// WithHTTPRedirect also binds the plain-HTTP variant of an https endpoint, on
+// the same domain, and answers its requests with a 308 Permanent Redirect to
+// the https URL. It replaces starting a second endpoint with
+// WithScheme(SchemeHTTP) and serving the redirects yourself. The redirecting
+// endpoint is closed along with the https one. It has no effect on endpoints
+// with the http scheme.
+func WithHTTPRedirect() HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.HTTPRedirect = true
+	})
+}
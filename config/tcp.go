@@ -51,7 +51,7 @@ func (cfg *tcpOptions) toProtoConfig() *proto.TCPEndpoint {
 	return &proto.TCPEndpoint{
 		URL:           cfg.URL,
 		Addr:          cfg.RemoteAddr,
-		IPRestriction: cfg.commonOpts.CIDRRestrictions.toProtoConfig(),
+		IPRestriction: cfg.commonOpts.ipRestriction(),
 		ProxyProto:    proto.ProxyProto(cfg.commonOpts.ProxyProto),
 		TrafficPolicy: cfg.commonOpts.TrafficPolicy,
 	}
@@ -72,6 +72,7 @@ func (cfg *tcpOptions) WithForwardsTo(url *url.URL) {
 func (cfg tcpOptions) Extra() proto.BindExtra {
 	return proto.BindExtra{
 		Name:          cfg.Name,
+		Token:         cfg.BindToken,
 		Metadata:      cfg.Metadata,
 		Description:   cfg.Description,
 		Bindings:      cfg.Bindings,
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// Template is a reusable set of options for one type of endpoint, from which
+// fleets of similar endpoints are stamped out. Options that every endpoint
+// shares, like traffic policy, bindings, metadata and pooling, are set once
+// on the template, and each endpoint adds or overrides options of its own:
+//
+//	tmpl, err := config.HTTPEndpointTemplate(
+//		config.WithTrafficPolicy(policy),
+//		config.WithMetadata("fleet=web"),
+//	)
+//	api := tmpl.Endpoint(config.WithURL("https://api.example.com"))
+//	www := tmpl.Endpoint(config.WithURL("https://www.example.com"))
+//
+// Options given to [Template.Endpoint] are applied after the template's, so
+// they take precedence. A Template is immutable and safe for concurrent use,
+// provided the options it holds are.
+type Template[O any] struct {
+	opts  []O
+	build func(...O) Tunnel
+}
+
+// HTTPEndpointTemplate creates a [Template] for HTTP endpoints, checking that
+// its options make sense for more than one endpoint. See [HTTPEndpoint].
+func HTTPEndpointTemplate(opts ...HTTPEndpointOption) (*Template[HTTPEndpointOption], error) {
+	return newTemplate(HTTPEndpoint, opts)
+}
+
+// TCPEndpointTemplate creates a [Template] for TCP endpoints, checking that
+// its options make sense for more than one endpoint. See [TCPEndpoint].
+func TCPEndpointTemplate(opts ...TCPEndpointOption) (*Template[TCPEndpointOption], error) {
+	return newTemplate(TCPEndpoint, opts)
+}
+
+// TLSEndpointTemplate creates a [Template] for TLS endpoints, checking that
+// its options make sense for more than one endpoint. See [TLSEndpoint].
+func TLSEndpointTemplate(opts ...TLSEndpointOption) (*Template[TLSEndpointOption], error) {
+	return newTemplate(TLSEndpoint, opts)
+}
+
+// LabeledTunnelTemplate creates a [Template] for labeled tunnels. See
+// [LabeledTunnel].
+func LabeledTunnelTemplate(opts ...LabeledTunnelOption) (*Template[LabeledTunnelOption], error) {
+	return newTemplate(LabeledTunnel, opts)
+}
+
+func newTemplate[O any](build func(...O) Tunnel, opts []O) (*Template[O], error) {
+	if err := validateTemplate(build(opts...)); err != nil {
+		return nil, err
+	}
+	return &Template[O]{opts: append([]O(nil), opts...), build: build}, nil
+}
+
+// With returns a new template that extends this one with more options,
+// checking them as the template constructors do.
+func (t *Template[O]) With(opts ...O) (*Template[O], error) {
+	return newTemplate(t.build, t.combine(opts))
+}
+
+// Endpoint builds the configuration for a new endpoint from the template's
+// options followed by the overrides. Each call returns an independent
+// configuration.
+func (t *Template[O]) Endpoint(overrides ...O) Tunnel {
+	return t.build(t.combine(overrides)...)
+}
+
+func (t *Template[O]) combine(opts []O) []O {
+	combined := make([]O, 0, len(t.opts)+len(opts))
+	combined = append(combined, t.opts...)
+	return append(combined, opts...)
+}
+
+// Check the options of a template for mistakes that would only show up once
+// several endpoints had been started from it.
+func validateTemplate(cfg Tunnel) error {
+	var (
+		common *commonOpts
+		addr   string
+	)
+	switch cfg := cfg.(type) {
+	case *httpOptions:
+		common = &cfg.commonOpts
+		addr = firstNonEmpty(cfg.URL, cfg.Domain, cfg.Hostname, cfg.Subdomain)
+	case *tlsOptions:
+		common = &cfg.commonOpts
+		addr = firstNonEmpty(cfg.URL, cfg.Domain, cfg.Hostname, cfg.Subdomain)
+	case *tcpOptions:
+		common = &cfg.commonOpts
+		addr = firstNonEmpty(cfg.URL, cfg.RemoteAddr)
+	case *labeledOptions:
+		common = &cfg.commonOpts
+	default:
+		return nil
+	}
+
+	for _, u := range append([]string{common.URL}, common.FallbackURLs...) {
+		if u == "" {
+			continue
+		}
+		if _, err := url.Parse(u); err != nil {
+			return fmt.Errorf("invalid endpoint template: %w", err)
+		}
+	}
+	// Every endpoint stamped from the template would request the same
+	// address, which only the first could bind unless they pool.
+	if addr != "" && !common.AllowsPooling {
+		return fmt.Errorf("invalid endpoint template: every endpoint would request %q, which requires config.WithAllowsPooling(true)", addr)
+	}
+	return nil
+}
+
+func firstNonEmpty(strs ...string) string {
+	for _, s := range strs {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
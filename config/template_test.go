@@ -0,0 +1,54 @@
+package config
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplate(t *testing.T) {
+	tmpl, err := HTTPEndpointTemplate(
+		WithMetadata("fleet"),
+		WithBindings("internal"),
+		WithTrafficPolicy(`{"on_http_request": []}`),
+	)
+	require.NoError(t, err)
+
+	api := tmpl.Endpoint(WithURL("https://api.example.com")).(*httpOptions)
+	www := tmpl.Endpoint(WithURL("https://www.example.com"), WithMetadata("www")).(*httpOptions)
+	require.Equal(t, "https://api.example.com", api.URL)
+	require.Equal(t, "https://www.example.com", www.URL)
+	require.Equal(t, "fleet", api.Metadata)
+	require.Equal(t, "www", www.Metadata, "overrides take precedence")
+	require.Equal(t, []string{"internal"}, api.Bindings)
+	require.Equal(t, api.TrafficPolicy, www.TrafficPolicy)
+
+	// Each endpoint's configuration is independent.
+	api.WithForwardsTo(&url.URL{Scheme: "http", Host: "localhost:8080"})
+	require.Empty(t, www.commonOpts.ForwardsTo)
+
+	labeled, err := LabeledTunnelTemplate(WithLabel("edge", "edghts_123"))
+	require.NoError(t, err)
+	a := labeled.Endpoint(WithMetadata("a")).(*labeledOptions)
+	b := labeled.Endpoint(WithLabel("replica", "b")).(*labeledOptions)
+	require.Equal(t, map[string]string{"edge": "edghts_123"}, a.labels)
+	require.Equal(t, map[string]string{"edge": "edghts_123", "replica": "b"}, b.labels)
+}
+
+func TestTemplateValidation(t *testing.T) {
+	_, err := HTTPEndpointTemplate(WithDomain("app.example.com"))
+	require.ErrorContains(t, err, "WithAllowsPooling")
+	_, err = TCPEndpointTemplate(WithRemoteAddr("1.tcp.ngrok.io:12345"))
+	require.ErrorContains(t, err, "WithAllowsPooling")
+	_, err = TLSEndpointTemplate(WithURL("tls://app.example.com"))
+	require.ErrorContains(t, err, "WithAllowsPooling")
+
+	pooled, err := HTTPEndpointTemplate(WithDomain("app.example.com"), WithAllowsPooling(true))
+	require.NoError(t, err)
+	_, err = pooled.With(WithAllowsPooling(false))
+	require.ErrorContains(t, err, "WithAllowsPooling")
+
+	_, err = HTTPEndpointTemplate(WithURLFallback("https://%zz"))
+	require.ErrorContains(t, err, "invalid endpoint template")
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"crypto/x509"
 	"net/http"
 	"net/url"
@@ -53,6 +54,10 @@ type tlsOptions struct {
 	// The certificate to use for TLS termination at the ngrok edge in PEM
 	// format.
 	CertPEM []byte
+	// The configuration for terminating TLS in the agent instead, if any.
+	agentTLS *tls.Config
+	// Answers TLS-ALPN-01 challenges when TLS is terminated in the agent.
+	alpnChallenges func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 
 	// An HTTP Server to run traffic on
 	// Deprecated: Pass HTTP server refs via session.ListenAndServeHTTP instead.
@@ -68,7 +73,7 @@ func (cfg *tlsOptions) toProtoConfig() *proto.TLSEndpoint {
 		Hostname:   cfg.Hostname,
 	}
 
-	opts.IPRestriction = cfg.commonOpts.CIDRRestrictions.toProtoConfig()
+	opts.IPRestriction = cfg.commonOpts.ipRestriction()
 	opts.TrafficPolicy = cfg.commonOpts.TrafficPolicy
 
 	opts.MutualTLSAtEdge = mutualTLSEndpointOption(cfg.MutualTLSCA).toProtoConfig()
@@ -100,6 +105,7 @@ func (cfg *tlsOptions) WithForwardsTo(url *url.URL) {
 func (cfg tlsOptions) Extra() proto.BindExtra {
 	return proto.BindExtra{
 		Name:          cfg.Name,
+		Token:         cfg.BindToken,
 		Metadata:      cfg.Metadata,
 		Description:   cfg.Description,
 		Bindings:      cfg.Bindings,
@@ -119,6 +125,17 @@ func (cfg tlsOptions) Labels() map[string]string {
 	return nil
 }
 
+func (cfg *tlsOptions) AgentTLSConfig() *tls.Config {
+	return cfg.agentTLS
+}
+
+func (cfg *tlsOptions) TLSALPNChallenges() func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	if cfg.agentTLS == nil {
+		return nil
+	}
+	return cfg.alpnChallenges
+}
+
 func (cfg tlsOptions) HTTPServer() *http.Server {
 	return cfg.httpServer
 }
@@ -1,5 +1,7 @@
 package config
 
+import "crypto/tls"
+
 type TLSTerminationLocation int
 
 const (
@@ -27,6 +29,7 @@ func (tt tlsTermination) ApplyTLS(cfg *tlsOptions) {
 		// TODO: implement this in the tunnel `Accept` call.
 		panic("automatic tls termination in-app is not yet supported")
 	case TLSAtEdge:
+		cfg.agentTLS = nil
 		cfg.terminateAtEdge = true
 		cfg.KeyPEM = tt.key
 		cfg.CertPEM = tt.cert
@@ -59,6 +62,7 @@ func WithTLSTermination(opts ...TLSTerminationOption) TLSEndpointOption {
 // Deprecated: Use WithCustomEdgeTermination instead.
 func WithTermination(certPEM, keyPEM []byte) TLSEndpointOption {
 	return tlsOptionFunc(func(cfg *tlsOptions) {
+		cfg.agentTLS = nil
 		cfg.terminateAtEdge = true
 		cfg.CertPEM = certPEM
 		cfg.KeyPEM = keyPEM
@@ -83,3 +87,44 @@ func WithTLSTerminationKeyPair(certPEM, keyPEM []byte) TLSTerminationOption {
 		cfg.key = keyPEM
 	})
 }
+
+// WithAgentTLSTermination terminates TLS in the agent rather than at the ngrok
+// edge, using the given configuration. The edge passes TLS connections through
+// untouched, and connections accepted from the tunnel, including those
+// forwarded by [golang.ngrok.com/ngrok.ListenAndForward], are plaintext.
+//
+// The configuration is used as-is, so clients can be authenticated with
+// certificates by setting its ClientAuth and ClientCAs fields. The verified
+// certificates are available from the TLSConnectionState method of the
+// accepted connections.
+//
+// This replaces any termination at the ngrok edge requested by
+// [WithTLSTermination].
+func WithAgentTLSTermination(config *tls.Config) TLSEndpointOption {
+	return tlsOptionFunc(func(cfg *tlsOptions) {
+		cfg.terminateAtEdge = false
+		cfg.KeyPEM = nil
+		cfg.CertPEM = nil
+		cfg.agentTLS = config
+	})
+}
+
+// WithTLSALPNChallenges answers ACME TLS-ALPN-01 challenges (RFC 8737) on an
+// endpoint that terminates TLS in the agent with [WithAgentTLSTermination], so
+// that certificates for custom domains pointed at ngrok can be issued from
+// inside the application.
+//
+// Connections whose ClientHello offers the "acme-tls/1" protocol are answered
+// with the certificate returned by getCertificate, and closed without being
+// returned from Accept or forwarded. The GetCertificate method of
+// [golang.org/x/crypto/acme/autocert.Manager] answers them, as does a
+// function returning the certificate from
+// [golang.org/x/crypto/acme.Client.TLSALPN01ChallengeCert] for the pending
+// challenge.
+//
+// It has no effect unless TLS is terminated in the agent.
+func WithTLSALPNChallenges(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) TLSEndpointOption {
+	return tlsOptionFunc(func(cfg *tlsOptions) {
+		cfg.alpnChallenges = getCertificate
+	})
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"crypto/tls"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -57,7 +58,31 @@ func TestTLSTermination(t *testing.T) {
 				require.Equal(t, []byte("key"), actual.Key)
 			},
 		},
+		{
+			name: "with agent termination",
+			opts: TLSEndpoint(WithTermination([]byte("cert"), []byte("key")), WithAgentTLSTermination(&tls.Config{})),
+			expectOpts: func(t *testing.T, opts *proto.TLSEndpoint) {
+				require.Nil(t, opts.TLSTermination)
+			},
+		},
+		{
+			name: "with edge termination after agent termination",
+			opts: TLSEndpoint(WithAgentTLSTermination(&tls.Config{}), WithTLSTermination()),
+			expectOpts: func(t *testing.T, opts *proto.TLSEndpoint) {
+				require.NotNil(t, opts.TLSTermination)
+			},
+		},
 	}
 
 	cases.runAll(t)
 }
+
+func TestAgentTLSTermination(t *testing.T) {
+	config := &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}
+	opts := TLSEndpoint(WithAgentTLSTermination(config)).(*tlsOptions)
+	require.Same(t, config, opts.AgentTLSConfig())
+
+	require.Nil(t, TLSEndpoint().(*tlsOptions).AgentTLSConfig())
+	require.Nil(t, TLSEndpoint(WithAgentTLSTermination(config), WithTLSTermination()).(*tlsOptions).AgentTLSConfig())
+	require.Nil(t, HTTPEndpoint().(*httpOptions).AgentTLSConfig())
+}
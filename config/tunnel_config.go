@@ -1,9 +1,11 @@
 package config
 
 import (
+	"crypto/tls"
 	"net/url"
 
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+	"golang.ngrok.com/ngrok/internal/upstream"
 )
 
 // Tunnel is a marker interface for options that can be used to start
@@ -26,4 +28,23 @@ type tunnelConfigPrivate interface {
 	// Extra config when auto-forwarding to a URL.
 	// Normal operation should use the functional builder.
 	WithForwardsTo(*url.URL)
+	// Options for connecting to the upstream service when auto-forwarding.
+	UpstreamOptions() upstream.Options
+	// The ID of an endpoint from a previous session to start the endpoint
+	// as, if any.
+	Identity() string
+	// URLs to try in order if the requested one can't be bound.
+	URLFallbacks() []string
+	// Whether the tunnel is closed rather than let its URL change.
+	StableURL() bool
+	// Whether to return an existing tunnel bound to the same URL.
+	ReuseExisting() bool
+	// The rate limit on accepting connections, if any.
+	AcceptRateLimit() (perSecond float64, burst int)
+	// The CIDRs to enforce in the agent, if any.
+	LocalCIDRRestrictions() (allow, deny []string)
+	// The configuration for terminating TLS in the agent, if any.
+	AgentTLSConfig() *tls.Config
+	// The function answering TLS-ALPN-01 challenges, if any.
+	TLSALPNChallenges() func(*tls.ClientHelloInfo) (*tls.Certificate, error)
 }
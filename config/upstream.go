@@ -0,0 +1,278 @@
+package config
+
+import (
+	"context"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// upstreamOption modifies the options used to connect to the upstream service
+// when forwarding.
+type upstreamOption func(opts *upstream.Options)
+
+func (opt upstreamOption) ApplyCommon(cfg *commonOpts) {
+	opt(&cfg.Upstream)
+}
+
+func (opt upstreamOption) ApplyHTTP(cfg *httpOptions) {
+	opt.ApplyCommon(&cfg.commonOpts)
+}
+
+func (opt upstreamOption) ApplyTCP(cfg *tcpOptions) {
+	opt.ApplyCommon(&cfg.commonOpts)
+}
+
+func (opt upstreamOption) ApplyTLS(cfg *tlsOptions) {
+	opt.ApplyCommon(&cfg.commonOpts)
+}
+
+func (opt upstreamOption) ApplyLabeled(cfg *labeledOptions) {
+	opt.ApplyCommon(&cfg.commonOpts)
+}
+
+// WithUpstreamDialer sets the function used to connect to the upstream service
+// when the tunnel is started with [golang.ngrok.com/ngrok.ListenAndForward].
+//
+// The function is called with the network and address derived from the
+// forwarding URL, e.g. "tcp" and "localhost:8080", or "unix" and
+// "/var/run/docker.sock" for a unix:// URL. It may be used to reach upstreams
+// that need special handling, such as local daemons that expect specific
+// socket options or credentials, while still going through the forwarder.
+func WithUpstreamDialer(dial func(ctx context.Context, network, address string) (net.Conn, error)) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.Dial = dial
+	})
+}
+
+// WithUpstreamDialerControl sets a function that is called on each socket
+// created to connect to the upstream service when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward], after it's created and before
+// it connects. Use it to set socket options on the connections to the
+// upstream, such as SO_MARK for traffic shaping or SO_BINDTODEVICE to pick an
+// interface on multi-homed hosts. See [net.Dialer] for details.
+//
+// It has no effect if a dialer was set with [WithUpstreamDialer].
+func WithUpstreamDialerControl(control func(network, address string, c syscall.RawConn) error) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.Control = control
+	})
+}
+
+// WithUpstreamKeepAlive sets the interval between TCP keep-alive probes on
+// the connections to the upstream service when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward]. As with [net.Dialer], zero uses
+// the default of 15 seconds and a negative duration disables keep-alives.
+//
+// It has no effect if a dialer was set with [WithUpstreamDialer].
+func WithUpstreamKeepAlive(period time.Duration) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.KeepAlive = period
+	})
+}
+
+// WithUpstreamResolver sets the resolver used to look up the upstream host
+// when the tunnel is started with [golang.ngrok.com/ngrok.ListenAndForward],
+// in place of the one configured for the session with
+// [golang.ngrok.com/ngrok.WithResolver] or the system resolver.
+//
+// It has no effect if a dialer was set with [WithUpstreamDialer].
+func WithUpstreamResolver(resolver *net.Resolver) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.Resolver = resolver
+	})
+}
+
+// WithUpstreamRoundRobin distributes connections across all of the addresses
+// that the upstream hostname resolves to, rotating through them for
+// successive connections. Addresses that fail to connect are skipped for a
+// short time in favor of the others, which gives basic load distribution
+// across backend replicas behind a single DNS name.
+func WithUpstreamRoundRobin() Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.RoundRobin = true
+	})
+}
+
+// WithUpstreamCAs sets the certificate authorities used to verify the
+// certificate of the upstream service when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward] to an https:// or tls:// URL,
+// in place of the system roots. Use it for upstreams with certificates
+// issued by a private CA.
+func WithUpstreamCAs(pool *x509.CertPool) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.RootCAs = pool
+	})
+}
+
+// WithUpstreamCAFile is like [WithUpstreamCAs], but reads the certificate
+// authorities from a PEM file. The file is read when the tunnel is started,
+// which fails if it can't be read or holds no certificates. If combined with
+// [WithUpstreamCAs], the certificates of both are trusted.
+func WithUpstreamCAFile(path string) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.CAFile = path
+	})
+}
+
+// WithUpstreamInsecureSkipVerify accepts any certificate presented by the
+// upstream service when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward] to an https:// or tls:// URL.
+// The connection is still encrypted, but not protected from interception,
+// so this should be limited to development.
+func WithUpstreamInsecureSkipVerify() Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.InsecureSkipVerify = true
+	})
+}
+
+// WithUpstreamDialTimeout limits how long each attempt to connect to the
+// upstream service may take when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward]. By default, only the context of
+// the forwarded connection bounds it.
+func WithUpstreamDialTimeout(d time.Duration) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.DialTimeout = d
+	})
+}
+
+// WithUpstreamRetry retries failed connections to the upstream service up to
+// n more times when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward], so that clients ride out a
+// restart of the upstream rather than being disconnected. It waits backoff
+// before the first retry, and twice as long before each retry after that.
+func WithUpstreamRetry(n int, backoff time.Duration) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.Retries = n
+		opts.RetryBackoff = backoff
+	})
+}
+
+// WithConnectionIdleTimeout closes connections forwarded by
+// [golang.ngrok.com/ngrok.ListenAndForward] once no bytes have passed in
+// either direction for the given duration. This reclaims half-open
+// connections left behind by clients that went away without closing them,
+// such as mobile clients losing their network. The connection closed event
+// reports the reason as "idle". For HTTP endpoints with host routing, it's
+// used as the idle timeout of keep-alive connections instead.
+//
+// A zero duration, the default, never closes idle connections.
+func WithConnectionIdleTimeout(d time.Duration) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		opts.IdleTimeout = d
+	})
+}
+
+// WithSpillBuffer buffers the data arriving on each connection forwarded by
+// [golang.ngrok.com/ngrok.ListenAndForward] faster than the upstream service
+// reads it, such as a large upload to a slow backend. A small amount is held
+// in memory, and the rest in a temporary file in dir, up to maxBytes per
+// connection. Once a connection's buffer is full, no more is read from it
+// until the upstream catches up, which slows down its client without holding
+// up the other connections on the session. The files are removed as their
+// connections close. An empty dir uses the default directory for temporary
+// files.
+func WithSpillBuffer(dir string, maxBytes int64) Options {
+	return upstreamOption(func(opts *upstream.Options) {
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		opts.SpillDir = dir
+		opts.SpillMaxBytes = maxBytes
+	})
+}
+
+// WithWebSocketKeepalive pings the clients of WebSockets forwarded by
+// [golang.ngrok.com/ngrok.ListenAndForward] every interval, once the upstream
+// service accepts the upgrade. The pongs the clients answer with count as
+// activity for [WithConnectionIdleTimeout], so long-lived sockets that are
+// otherwise quiet aren't closed as idle, and aren't passed on to the upstream.
+// A socket whose client hasn't answered a ping by the time the next one is due
+// is closed, with "keepalive" as the reason in its connection closed event.
+//
+// Only the first response on each connection is inspected for an upgrade, as
+// browsers open a new connection for each WebSocket. It has no effect with
+// [WithHostRouting] or [WithRequestLimits].
+func WithWebSocketKeepalive(interval time.Duration) HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.Upstream.WebSocketKeepalive = interval
+	})
+}
+
+// WithSNIRouter routes connections to different upstream services based on
+// the server name (SNI) that the client requests in its TLS ClientHello. This
+// lets a single wildcard TLS endpoint front several local services when it is
+// started with [golang.ngrok.com/ngrok.ListenAndForward].
+//
+// Keys are server names, either exact like "api.example.com" or wildcards
+// like "*.example.com" that match a single leading label; exact names take
+// precedence. Values are the "host:port" addresses that replace the host of
+// the forwarding URL. Connections that match no route, or that don't request
+// a server name, are forwarded to the forwarding URL.
+//
+// Routing requires the TLS connection to reach the agent intact, so it only
+// applies when TLS is not terminated at the ngrok edge.
+func WithSNIRouter(routes map[string]string) TLSEndpointOption {
+	return tlsOptionFunc(func(cfg *tlsOptions) {
+		cfg.Upstream.SNIRoutes = make(map[string]string, len(routes))
+		for name, addr := range routes {
+			cfg.Upstream.SNIRoutes[strings.ToLower(name)] = addr
+		}
+	})
+}
+
+// WithHostRouting proxies each request to a different upstream service based
+// on its Host header when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward]. This lets a single endpoint bound
+// to a wildcard domain, such as "*.example.ngrok.app", dispatch to several
+// local backends without a separate reverse proxy.
+//
+// Keys are host names, either exact like "api.example.ngrok.app" or wildcards
+// like "*.example.ngrok.app" that match a single leading label; exact names
+// take precedence. Values are the upstream URLs, which may use the http,
+// https or unix schemes. Requests that match no route are proxied to the
+// forwarding URL. The original Host header is passed through to the upstream.
+func WithHostRouting(routes map[string]*url.URL) HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.Upstream.HostRoutes = make(map[string]*url.URL, len(routes))
+		for host, target := range routes {
+			cfg.Upstream.HostRoutes[strings.ToLower(host)] = target
+		}
+	})
+}
+
+// WithUpstreamSelector proxies each request to the upstream chosen by
+// selector when the tunnel is started with
+// [golang.ngrok.com/ngrok.ListenAndForward], so that requests can be split
+// between upstreams by their headers, cookies or paths, such as to send a
+// canary group to a new version of a service:
+//
+//	config.WithUpstreamSelector(func(r *http.Request) string {
+//		if r.Header.Get("X-Canary") == "1" {
+//			return "http://localhost:8081"
+//		}
+//		return ""
+//	})
+//
+// The selector returns an upstream URL, which may use the http, https or
+// unix schemes, or the empty string to route the request as if it weren't
+// set: by [WithHostRouting], or to the forwarding URL. Requests for URLs that
+// can't be parsed fail with 502 Bad Gateway. The selector is called
+// concurrently, and mustn't read the request's body.
+//
+// The agent keeps a proxy, with its pool of connections, for each of the 64
+// most recently chosen upstreams. Selectors are meant to choose from a fixed
+// set of upstreams; those choosing from more, such as by building URLs from
+// request data, still work but reconnect more often.
+func WithUpstreamSelector(selector func(*http.Request) string) HTTPEndpointOption {
+	return httpOptionFunc(func(cfg *httpOptions) {
+		cfg.Upstream.Selector = selector
+	})
+}
@@ -0,0 +1,122 @@
+package config
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testUpstreamDialer[T tunnelConfigPrivate, OT any](t *testing.T,
+	makeOpts func(...OT) Tunnel,
+) {
+	dial := func(context.Context, string, string) (net.Conn, error) {
+		return nil, nil
+	}
+
+	absent := makeOpts().(T)
+	require.Nil(t, absent.UpstreamOptions().Dial)
+
+	present := makeOpts(WithUpstreamDialer(dial).(OT)).(T)
+	require.NotNil(t, present.UpstreamOptions().Dial)
+	require.False(t, present.UpstreamOptions().RoundRobin)
+
+	roundRobin := makeOpts(WithUpstreamRoundRobin().(OT)).(T)
+	require.True(t, roundRobin.UpstreamOptions().RoundRobin)
+
+	control := func(string, string, syscall.RawConn) error { return nil }
+	socketOpts := makeOpts(WithUpstreamDialerControl(control).(OT), WithUpstreamKeepAlive(time.Minute).(OT)).(T)
+	require.NotNil(t, socketOpts.UpstreamOptions().Control)
+	require.Equal(t, time.Minute, socketOpts.UpstreamOptions().KeepAlive)
+
+	resolver := &net.Resolver{PreferGo: true}
+	resolved := makeOpts(WithUpstreamResolver(resolver).(OT)).(T)
+	require.Same(t, resolver, resolved.UpstreamOptions().Resolver)
+
+	idle := makeOpts(WithConnectionIdleTimeout(time.Minute).(OT)).(T)
+	require.Equal(t, time.Minute, idle.UpstreamOptions().IdleTimeout)
+	require.Zero(t, absent.UpstreamOptions().IdleTimeout)
+
+	spill := makeOpts(WithSpillBuffer("/var/tmp", 1<<20).(OT)).(T)
+	require.Equal(t, "/var/tmp", spill.UpstreamOptions().SpillDir)
+	require.Equal(t, int64(1<<20), spill.UpstreamOptions().SpillMaxBytes)
+	require.Empty(t, absent.UpstreamOptions().SpillDir)
+}
+
+func TestUpstreamDialer(t *testing.T) {
+	testUpstreamDialer[*httpOptions](t, HTTPEndpoint)
+	testUpstreamDialer[*tlsOptions](t, TLSEndpoint)
+	testUpstreamDialer[*tcpOptions](t, TCPEndpoint)
+	testUpstreamDialer[*labeledOptions](t, LabeledTunnel)
+}
+
+func TestSNIRouter(t *testing.T) {
+	opts := TLSEndpoint(WithSNIRouter(map[string]string{
+		"API.example.com": "localhost:8443",
+		"*.example.com":   "localhost:9443",
+	})).(*tlsOptions)
+	require.Equal(t, map[string]string{
+		"api.example.com": "localhost:8443",
+		"*.example.com":   "localhost:9443",
+	}, opts.UpstreamOptions().SNIRoutes)
+}
+
+func TestHostRouting(t *testing.T) {
+	api := &url.URL{Scheme: "http", Host: "localhost:3000"}
+	opts := HTTPEndpoint(WithHostRouting(map[string]*url.URL{
+		"API.example.ngrok.app": api,
+	})).(*httpOptions)
+	require.Equal(t, map[string]*url.URL{
+		"api.example.ngrok.app": api,
+	}, opts.UpstreamOptions().HostRoutes)
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	check := func(context.Context) error { return nil }
+	for _, cfg := range []Tunnel{
+		HTTPEndpoint(WithAllowsPooling(true), WithPoolHealthCheck(time.Second, check)),
+		TLSEndpoint(WithAllowsPooling(true), WithPoolHealthCheck(time.Second, check)),
+		TCPEndpoint(WithAllowsPooling(true), WithPoolHealthCheck(time.Second, check)),
+	} {
+		opts := cfg.(tunnelConfigPrivate).UpstreamOptions()
+		require.NotNil(t, opts.HealthCheck)
+		require.Equal(t, time.Second, opts.HealthInterval)
+		require.False(t, opts.HealthGate)
+	}
+
+	opts := HTTPEndpoint(WithAllowsPooling(true), WithPoolReadinessCheck(time.Second, check)).(tunnelConfigPrivate).UpstreamOptions()
+	require.NotNil(t, opts.HealthCheck)
+	require.Equal(t, time.Second, opts.HealthInterval)
+	require.True(t, opts.HealthGate)
+}
+
+func TestUpstreamSelector(t *testing.T) {
+	opts := HTTPEndpoint(WithUpstreamSelector(func(r *http.Request) string {
+		return r.Header.Get("X-Upstream")
+	})).(*httpOptions)
+	selector := opts.UpstreamOptions().Selector
+	require.NotNil(t, selector)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Upstream", "http://localhost:8081")
+	require.Equal(t, "http://localhost:8081", selector(req))
+}
+
+func TestHTTPServerSettings(t *testing.T) {
+	settings := HTTPServerSettings{ReadHeaderTimeout: time.Second, IdleTimeout: time.Minute, MaxHeaderBytes: 1 << 10}
+	for _, cfg := range []Tunnel{
+		HTTPEndpoint(WithHTTPServerSettings(settings)),
+		TLSEndpoint(WithHTTPServerSettings(settings)),
+		LabeledTunnel(WithLabel("edge", "edghts_1"), WithHTTPServerSettings(settings)),
+	} {
+		opts := cfg.(tunnelConfigPrivate).UpstreamOptions()
+		require.Equal(t, time.Second, opts.HTTPServer.ReadHeaderTimeout)
+		require.Equal(t, time.Minute, opts.HTTPServer.IdleTimeout)
+		require.Equal(t, 1<<10, opts.HTTPServer.MaxHeaderBytes)
+	}
+}
@@ -21,3 +21,86 @@ func (opt urlOption) ApplyTLS(opts *tlsOptions) {
 func (opt urlOption) ApplyTCP(opts *tcpOptions) {
 	opts.URL = string(opt)
 }
+
+type urlFallbackOption []string
+
+// WithURLFallback provides URLs to try, in order, if the ngrok service refuses
+// to bind the endpoint's requested URL or domain, for example because the
+// domain is in use by another agent. Use the empty string to fall back to a
+// randomly assigned URL. The URL that was bound is reported by the tunnel's
+// URL method.
+func WithURLFallback(urls ...string) interface {
+	HTTPEndpointOption
+	TLSEndpointOption
+	TCPEndpointOption
+} {
+	return urlFallbackOption(urls)
+}
+
+func (opt urlFallbackOption) ApplyHTTP(opts *httpOptions) {
+	opts.FallbackURLs = append(opts.FallbackURLs, opt...)
+}
+
+func (opt urlFallbackOption) ApplyTLS(opts *tlsOptions) {
+	opts.FallbackURLs = append(opts.FallbackURLs, opt...)
+}
+
+func (opt urlFallbackOption) ApplyTCP(opts *tcpOptions) {
+	opts.FallbackURLs = append(opts.FallbackURLs, opt...)
+}
+
+type stableURLOption bool
+
+// WithStableURLRequired closes the tunnel rather than let its URL change. A
+// tunnel bound to a randomly assigned URL or TCP address may be assigned a
+// different one when its session reconnects, which is otherwise reported by
+// an EventEndpointURLChanged. With this option, the tunnel's Accept method
+// instead fails with an error matching ErrURLChanged, so that applications
+// which register the URL elsewhere can start a new tunnel and register it
+// again.
+func WithStableURLRequired() interface {
+	HTTPEndpointOption
+	TLSEndpointOption
+	TCPEndpointOption
+} {
+	return stableURLOption(true)
+}
+
+func (opt stableURLOption) ApplyHTTP(opts *httpOptions) {
+	opts.StableURLRequired = bool(opt)
+}
+
+func (opt stableURLOption) ApplyTLS(opts *tlsOptions) {
+	opts.StableURLRequired = bool(opt)
+}
+
+func (opt stableURLOption) ApplyTCP(opts *tcpOptions) {
+	opts.StableURLRequired = bool(opt)
+}
+
+type reuseExistingOption bool
+
+// WithReuseExisting makes the session's Listen methods return the tunnel the
+// session already has bound to the endpoint's URL, if any, rather than fail
+// with an error matching ErrEndpointAlreadyBound. Only endpoints that request
+// a specific URL or domain, and that aren't both pooled with
+// [WithAllowsPooling], are matched.
+func WithReuseExisting() interface {
+	HTTPEndpointOption
+	TLSEndpointOption
+	TCPEndpointOption
+} {
+	return reuseExistingOption(true)
+}
+
+func (opt reuseExistingOption) ApplyHTTP(opts *httpOptions) {
+	opts.ReuseExistingTunnel = bool(opt)
+}
+
+func (opt reuseExistingOption) ApplyTLS(opts *tlsOptions) {
+	opts.ReuseExistingTunnel = bool(opt)
+}
+
+func (opt reuseExistingOption) ApplyTCP(opts *tcpOptions) {
+	opts.ReuseExistingTunnel = bool(opt)
+}
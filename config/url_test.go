@@ -0,0 +1,18 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLFallback(t *testing.T) {
+	for fallback, cfg := range map[string]Tunnel{
+		"https://b.example.com":  HTTPEndpoint(WithURL("https://a.example.com"), WithURLFallback("https://b.example.com", "")),
+		"tls://b.example.com":    TLSEndpoint(WithURL("tls://a.example.com"), WithURLFallback("tls://b.example.com", "")),
+		"tcp://2.tcp.ngrok.io:2": TCPEndpoint(WithURL("tcp://1.tcp.ngrok.io:1"), WithURLFallback("tcp://2.tcp.ngrok.io:2", "")),
+	} {
+		require.Equal(t, []string{fallback, ""}, cfg.(tunnelConfigPrivate).URLFallbacks())
+	}
+	require.Empty(t, HTTPEndpoint().(tunnelConfigPrivate).URLFallbacks())
+}
@@ -0,0 +1,264 @@
+package ngrok
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"golang.ngrok.com/muxado/v2"
+)
+
+// CloseInitiator identifies which side closed a connection.
+type CloseInitiator int
+
+const (
+	// It isn't known which side closed the connection.
+	CloseInitiatorUnknown CloseInitiator = iota
+	// The connection was closed by this application.
+	CloseInitiatorLocal
+	// The connection was closed or reset by the ngrok edge, e.g. because the
+	// client went away, an edge timeout elapsed, or a traffic policy denied
+	// it.
+	CloseInitiatorEdge
+)
+
+func (i CloseInitiator) String() string {
+	switch i {
+	case CloseInitiatorLocal:
+		return "local"
+	case CloseInitiatorEdge:
+		return "edge"
+	}
+	return "unknown"
+}
+
+// ConnCloseKind classifies why a connection accepted from a [Tunnel] was
+// closed.
+type ConnCloseKind int
+
+const (
+	// It isn't known why the connection was closed.
+	CloseKindUnknown ConnCloseKind = iota
+	// The application closed the connection.
+	CloseKindLocal
+	// The client finished sending, and the ngrok edge closed its side of
+	// the connection.
+	CloseKindClientEOF
+	// The upstream service a forwarder connected the connection to closed
+	// its side of the connection.
+	CloseKindUpstreamEOF
+	// The connection was idle for longer than the forwarder's idle timeout.
+	CloseKindIdleTimeout
+	// The client stopped answering a forwarder's WebSocket keepalive pings.
+	CloseKindKeepalive
+	// An interceptor configured for the tunnel refused the connection.
+	CloseKindPolicy
+	// The session's connection to the ngrok service ended, e.g. so that it
+	// could reconnect.
+	CloseKindSessionReconnect
+	// A forwarder failed to connect to the upstream service.
+	CloseKindDialError
+	// The ngrok edge reset the connection.
+	CloseKindReset
+)
+
+func (k ConnCloseKind) String() string {
+	switch k {
+	case CloseKindLocal:
+		return "local"
+	case CloseKindClientEOF:
+		return "client_eof"
+	case CloseKindUpstreamEOF:
+		return "upstream_eof"
+	case CloseKindIdleTimeout:
+		return "idle_timeout"
+	case CloseKindKeepalive:
+		return "keepalive"
+	case CloseKindPolicy:
+		return "policy"
+	case CloseKindSessionReconnect:
+		return "session_reconnect"
+	case CloseKindDialError:
+		return "dial_error"
+	case CloseKindReset:
+		return "reset"
+	}
+	return "unknown"
+}
+
+// MarshalText encodes the kind as its name, e.g. "client_eof".
+func (k ConnCloseKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// ConnCloseReason describes why a connection accepted from a [Tunnel] was
+// closed, as far as it can be determined.
+type ConnCloseReason struct {
+	// Which side closed the connection.
+	ClosedBy CloseInitiator
+	// Why the connection was closed.
+	Kind ConnCloseKind
+	// The error code the ngrok edge sent when resetting the connection, or
+	// zero if it wasn't reset.
+	Code uint32
+	// A short description of the reason, e.g. "stream cancelled".
+	Reason string
+}
+
+func (r ConnCloseReason) String() string {
+	return fmt.Sprintf("closed by %s: %s", r.ClosedBy, r.Reason)
+}
+
+var (
+	localCloseReason       = ConnCloseReason{ClosedBy: CloseInitiatorLocal, Kind: CloseKindLocal, Reason: "closed"}
+	upstreamEOFCloseReason = ConnCloseReason{ClosedBy: CloseInitiatorLocal, Kind: CloseKindUpstreamEOF, Reason: "upstream closed"}
+	dialErrorCloseReason   = ConnCloseReason{ClosedBy: CloseInitiatorLocal, Kind: CloseKindDialError, Reason: "upstream dial failed"}
+)
+
+// ConnResetError is returned from reads and writes on a [Conn] after the ngrok
+// edge has reset it. Use [errors.As] to inspect the reason.
+type ConnResetError struct {
+	Reason ConnCloseReason
+	// The underlying error.
+	Err error
+}
+
+func (e *ConnResetError) Error() string {
+	return fmt.Sprintf("connection reset by the ngrok edge: %s (code %d)", e.Reason.Reason, e.Reason.Code)
+}
+
+func (e *ConnResetError) Unwrap() error {
+	return e.Err
+}
+
+// The prefix of the errors muxado returns for resets sent by the remote side.
+const peerResetPrefix = "Stream reset by peer"
+
+// Determine why a read or write on a tunnel connection failed. Returns false
+// if the error says nothing about why the connection closed.
+func closeReasonFromError(err error) (ConnCloseReason, bool) {
+	if errors.Is(err, io.EOF) {
+		return ConnCloseReason{ClosedBy: CloseInitiatorEdge, Kind: CloseKindClientEOF, Reason: "closed"}, true
+	}
+	code, inner := muxado.GetError(err)
+	switch {
+	case code == muxado.RemoteGoneAway:
+		return ConnCloseReason{ClosedBy: CloseInitiatorEdge, Kind: CloseKindSessionReconnect, Code: uint32(code), Reason: "session ended by the edge"}, true
+	case code == muxado.SessionClosed:
+		// The session's connection is gone, whichever side ended it.
+		return ConnCloseReason{Kind: CloseKindSessionReconnect, Code: uint32(code), Reason: "session closed"}, true
+	case code != muxado.ErrorUnknown && inner != nil && strings.HasPrefix(inner.Error(), peerResetPrefix):
+		return ConnCloseReason{ClosedBy: CloseInitiatorEdge, Kind: CloseKindReset, Code: uint32(code), Reason: resetReason(code)}, true
+	}
+	return ConnCloseReason{}, false
+}
+
+// Describe the error code sent with a reset.
+func resetReason(code muxado.ErrorCode) string {
+	switch code {
+	case muxado.NoError:
+		return "no error"
+	case muxado.ProtocolError:
+		return "protocol error"
+	case muxado.InternalError:
+		return "internal error"
+	case muxado.FlowControlError:
+		return "flow control error"
+	case muxado.StreamClosed:
+		return "stream closed"
+	case muxado.StreamRefused:
+		return "stream refused"
+	case muxado.StreamCancelled:
+		return "stream cancelled"
+	case muxado.StreamReset:
+		return "stream reset"
+	case muxado.FrameSizeError:
+		return "frame size error"
+	case muxado.AcceptQueueFull:
+		return "accept queue full"
+	case muxado.EnhanceYourCalm:
+		return "rate limited"
+	case muxado.RemoteGoneAway:
+		return "remote gone away"
+	case muxado.StreamsExhausted:
+		return "streams exhausted"
+	case muxado.WriteTimeout:
+		return "write timed out"
+	case muxado.SessionClosed:
+		return "session closed"
+	case muxado.PeerEOF:
+		return "peer closed"
+	}
+	return fmt.Sprintf("error code %d", code)
+}
+
+// Record the reason for the first failure that explains why the connection
+// closed, wrapping resets so that callers can inspect them.
+func (c *connImpl) observeErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	reason, ok := closeReasonFromError(err)
+	if !ok {
+		return err
+	}
+	c.closeReason.CompareAndSwap(nil, &reason)
+	if errors.Is(err, io.EOF) || reason.ClosedBy != CloseInitiatorEdge {
+		return err
+	}
+	return &ConnResetError{Reason: reason, Err: err}
+}
+
+// CloseReason returns why the connection was closed, as far as is known.
+func (c *connImpl) CloseReason() ConnCloseReason {
+	if reason := c.closeReason.Load(); reason != nil {
+		return *reason
+	}
+	return ConnCloseReason{}
+}
+
+// The connection accepted from a tunnel beneath the wrappers a forwarder adds
+// to it, or nil if it wasn't accepted from a tunnel.
+func acceptedConn(conn net.Conn) *connImpl {
+	for {
+		switch c := conn.(type) {
+		case *connImpl:
+			return c
+		case *spillConn:
+			conn = c.Conn
+		case *idleConn:
+			conn = c.Conn
+		case *wsKeepaliveConn:
+			conn = c.raw
+		case *replayConn:
+			conn = c.Conn
+		default:
+			return nil
+		}
+	}
+}
+
+// Record why conn is about to be closed, unless a reason was already
+// recorded.
+func setCloseReason(conn net.Conn, reason *ConnCloseReason) {
+	if impl := acceptedConn(conn); impl != nil {
+		impl.closeReason.CompareAndSwap(nil, reason)
+	}
+}
+
+// An upstream connection that records when the upstream finishes sending as
+// the reason its tunnel connection is closed.
+type upstreamConn struct {
+	net.Conn
+	tunnelConn net.Conn
+}
+
+func (c *upstreamConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if errors.Is(err, io.EOF) {
+		setCloseReason(c.tunnelConn, &upstreamEOFCloseReason)
+	}
+	return n, err
+}
@@ -0,0 +1,170 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/muxado/v2"
+	"golang.ngrok.com/muxado/v2/frame"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// A connection whose reads fail with a fixed error.
+type failingConn struct {
+	net.Conn
+	err error
+}
+
+func (c *failingConn) Read([]byte) (int, error) { return 0, c.err }
+
+// Open a muxado stream whose remote side, standing in for the edge, resets
+// it with the given code.
+func resetStream(t *testing.T, code muxado.ErrorCode) net.Conn {
+	local, remote := net.Pipe()
+	client := muxado.Client(local, nil)
+	t.Cleanup(func() {
+		client.Close()
+		remote.Close()
+	})
+
+	stream, err := client.OpenStream()
+	require.NoError(t, err)
+	go func() { _, _ = stream.Write([]byte("x")) }()
+
+	edge := frame.NewFramer(remote, remote)
+	for {
+		f, err := edge.ReadFrame()
+		require.NoError(t, err)
+		if _, ok := f.(*frame.Data); ok {
+			rst := new(frame.Rst)
+			require.NoError(t, rst.Pack(f.StreamId(), frame.ErrorCode(code)))
+			require.NoError(t, edge.WriteFrame(rst))
+			go func() { _, _ = io.Copy(io.Discard, remote) }()
+			return stream
+		}
+	}
+}
+
+func TestConnResetByEdge(t *testing.T) {
+	conn := &connImpl{Conn: resetStream(t, muxado.StreamCancelled)}
+
+	_, err := io.ReadAll(conn)
+	var reset *ConnResetError
+	require.True(t, errors.As(err, &reset), "%v", err)
+	require.Equal(t, ConnCloseReason{
+		ClosedBy: CloseInitiatorEdge,
+		Kind:     CloseKindReset,
+		Code:     uint32(muxado.StreamCancelled),
+		Reason:   "stream cancelled",
+	}, reset.Reason)
+
+	// Closing afterwards doesn't change who closed it.
+	conn.Close()
+	require.Equal(t, CloseInitiatorEdge, conn.CloseReason().ClosedBy)
+}
+
+func TestConnCloseReason(t *testing.T) {
+	local, _ := net.Pipe()
+	conn := &connImpl{Conn: local}
+	require.Equal(t, ConnCloseReason{}, conn.CloseReason())
+	conn.Close()
+	require.Equal(t, CloseInitiatorLocal, conn.CloseReason().ClosedBy)
+
+	conn = &connImpl{Conn: &failingConn{err: io.EOF}}
+	_, err := conn.Read(nil)
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, CloseInitiatorEdge, conn.CloseReason().ClosedBy)
+	require.Equal(t, CloseKindClientEOF, conn.CloseReason().Kind)
+
+	// The session going away isn't reported as a reset.
+	local, remote := net.Pipe()
+	defer remote.Close()
+	client := muxado.Client(local, nil)
+	stream, err := client.OpenStream()
+	require.NoError(t, err)
+	client.Close()
+	conn = &connImpl{Conn: stream}
+	_, err = conn.Read(make([]byte, 1))
+	var reset *ConnResetError
+	require.False(t, errors.As(err, &reset), "%v", err)
+	require.Equal(t, CloseKindSessionReconnect, conn.CloseReason().Kind)
+
+	// Errors that don't say who closed the connection are left alone.
+	other := errors.New("deadline exceeded")
+	conn = &connImpl{Conn: &failingConn{err: other}}
+	_, err = conn.Read(nil)
+	require.Equal(t, other, err)
+	require.Equal(t, ConnCloseReason{}, conn.CloseReason())
+}
+
+func TestForwardedConnCloseReason(t *testing.T) {
+	run := func(t *testing.T, dialErr error, finish func(client, backend net.Conn)) ConnCloseReason {
+		rec := &eventRecorder{}
+		sess := &sessionImpl{}
+		sess.setInner(&sessionInner{Logger: slog.New(discardHandler{})})
+		q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+		tun := &tunnelImpl{
+			Sess:   sess,
+			Tunnel: &benchTunnel{queuedTunnel: q},
+			events: newEventDispatcher([]EventHandler{rec.handle}),
+		}
+
+		backends := make(chan net.Conn, 1)
+		opts := upstream.Options{
+			Dial: func(context.Context, string, string) (net.Conn, error) {
+				if dialErr != nil {
+					backends <- nil
+					return nil, dialErr
+				}
+				local, backend := net.Pipe()
+				backends <- backend
+				return local, nil
+			},
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		forwardTunnel(ctx, tun, &url.URL{Scheme: "tcp", Host: "localhost:1234"}, opts)
+
+		local, client := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+		finish(client, <-backends)
+
+		require.Equal(t, []EventType{EventTypeConnectionOpened, EventTypeConnectionClosed}, rec.waitFor(t, 2))
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return rec.events[1].(*EventConnectionClosed).CloseReason
+	}
+
+	t.Run("client EOF", func(t *testing.T) {
+		reason := run(t, nil, func(client, _ net.Conn) { client.Close() })
+		require.Equal(t, CloseKindClientEOF, reason.Kind)
+	})
+	t.Run("upstream EOF", func(t *testing.T) {
+		reason := run(t, nil, func(_, backend net.Conn) { backend.Close() })
+		require.Equal(t, upstreamEOFCloseReason, reason)
+	})
+	t.Run("dial error", func(t *testing.T) {
+		reason := run(t, errors.New("connection refused"), func(net.Conn, net.Conn) {})
+		require.Equal(t, dialErrorCloseReason, reason)
+	})
+}
+
+func TestAcceptedConn(t *testing.T) {
+	local, _ := net.Pipe()
+	impl := &connImpl{Conn: local}
+	wrapped := &replayConn{Conn: &idleConn{Conn: impl}}
+	require.Same(t, impl, acceptedConn(wrapped))
+	require.Nil(t, acceptedConn(local))
+
+	setCloseReason(wrapped, &dialErrorCloseReason)
+	require.Equal(t, CloseKindDialError, impl.CloseReason().Kind)
+	require.Equal(t, "dial_error", CloseKindDialError.String())
+}
@@ -0,0 +1,57 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// A context that is canceled when a session or tunnel closes, so that the
+// connections accepted from it can stop their work promptly.
+type lifetime struct {
+	once   sync.Once
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Returns the context, deriving it from parent the first time it's needed.
+func (l *lifetime) context(parent context.Context) context.Context {
+	l.once.Do(func() { l.ctx, l.cancel = context.WithCancel(parent) })
+	return l.ctx
+}
+
+// Cancel the context. Safe to call more than once.
+func (l *lifetime) end() {
+	l.context(context.Background())
+	l.cancel()
+}
+
+// The context of the connections accepted from the tunnel, which is canceled
+// when either the tunnel or its session closes.
+func (t *tunnelImpl) context() context.Context {
+	parent := context.Background()
+	if s, ok := t.Sess.(*sessionImpl); ok {
+		parent = s.life.context(context.Background())
+	}
+	return t.life.context(parent)
+}
+
+// Context returns a context that is canceled when the connection, its tunnel,
+// or its session is closed.
+func (c *connImpl) Context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// Join the connections as join does, closing both if ctx is canceled first.
+func joinContext(ctx context.Context, logger *slog.Logger, left, right net.Conn) {
+	stop := context.AfterFunc(ctx, func() {
+		left.Close()
+		right.Close()
+	})
+	defer stop()
+	join(logger, left, right)
+}
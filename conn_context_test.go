@@ -0,0 +1,80 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func acceptConn(t *testing.T, tun *tunnelImpl, q *queuedTunnel) Conn {
+	local, _ := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	return conn.(Conn)
+}
+
+func requireCanceled(t *testing.T, ctx context.Context) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context was not canceled")
+	}
+}
+
+func TestConnContext(t *testing.T) {
+	sess := &sessionImpl{}
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{Sess: sess, Tunnel: q}
+
+	closed := acceptConn(t, tun, q)
+	require.NoError(t, closed.Context().Err())
+	require.NoError(t, closed.Close())
+	requireCanceled(t, closed.Context())
+
+	open := acceptConn(t, tun, q)
+	require.NoError(t, tun.Close())
+	requireCanceled(t, open.Context())
+
+	tun = &tunnelImpl{Sess: sess, Tunnel: q}
+	open = acceptConn(t, tun, q)
+	sess.life.end()
+	requireCanceled(t, open.Context())
+}
+
+func TestForwardConnContext(t *testing.T) {
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{Logger: slog.New(discardHandler{})})
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{Sess: sess, Tunnel: &benchTunnel{queuedTunnel: q}}
+
+	dialed := make(chan context.Context, 1)
+	opts := upstream.Options{
+		Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			dialed <- ctx
+			backend, _ := net.Pipe()
+			return backend, nil
+		},
+	}
+	forwardTunnel(context.Background(), tun, &url.URL{Scheme: "tcp", Host: "localhost:1234"}, opts)
+
+	local, client := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	dialCtx := <-dialed
+	require.NoError(t, dialCtx.Err())
+
+	// Closing the session stops forwarding without either side closing.
+	sess.life.end()
+	requireCanceled(t, dialCtx)
+	_, err := client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
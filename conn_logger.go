@@ -0,0 +1,47 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"net"
+)
+
+// ConnLogger derives a logger for a connection accepted from an ngrok
+// [Tunnel], with attributes identifying the tunnel URL, the connection, and
+// the client address. Connections that didn't come from ngrok are described
+// only by their remote address.
+//
+// If logger is nil, [slog.Default] is used.
+func ConnLogger(logger *slog.Logger, conn net.Conn) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	attrs := make([]any, 0, 4)
+	if c, ok := conn.(*connImpl); ok {
+		if c.tun != nil {
+			attrs = append(attrs, slog.String("url", c.tun.URL()), slog.String("conn_id", c.ID()))
+		}
+		attrs = append(attrs, slog.String("proto", c.Proto()))
+	}
+	attrs = append(attrs, slog.String("client_addr", conn.RemoteAddr().String()))
+
+	return logger.With(attrs...)
+}
+
+type connLoggerKey struct{}
+
+// ContextWithConnLogger returns a copy of ctx that carries a logger for conn
+// derived with [ConnLogger]. Retrieve it with [LoggerFromContext].
+func ContextWithConnLogger(ctx context.Context, logger *slog.Logger, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connLoggerKey{}, ConnLogger(logger, conn))
+}
+
+// LoggerFromContext returns the logger stored by [ContextWithConnLogger], or
+// [slog.Default] if there is none.
+func LoggerFromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(connLoggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,45 @@
+package ngrok
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+type urlTunnel struct {
+	queuedTunnel
+}
+
+func (u *urlTunnel) RemoteBindConfig() *tunnel_client.RemoteBindConfig {
+	return &tunnel_client.RemoteBindConfig{URL: "https://example.ngrok.app"}
+}
+
+func TestConnLogger(t *testing.T) {
+	q := &urlTunnel{queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}}
+	tun := &tunnelImpl{Tunnel: q}
+
+	local, _ := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{Conn: local, Header: proto.ProxyHeader{Proto: "https"}}
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	base := slog.New(slog.NewTextHandler(&buf, nil))
+
+	ctx := ContextWithConnLogger(context.Background(), base, conn)
+	LoggerFromContext(ctx).Info("hello")
+
+	require.Contains(t, buf.String(), "url=https://example.ngrok.app")
+	require.Contains(t, buf.String(), "conn_id=test-1")
+	require.Contains(t, buf.String(), "proto=https")
+	require.Contains(t, buf.String(), "client_addr=pipe")
+
+	require.Equal(t, slog.Default(), LoggerFromContext(context.Background()))
+}
@@ -0,0 +1,196 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// IPPreference determines which address family is tried first when dialing a
+// host that resolves to both IPv4 and IPv6 addresses.
+type IPPreference int
+
+const (
+	// IPPreferenceDefault tries addresses in the order returned by the
+	// resolver, which on most systems prefers IPv6.
+	IPPreferenceDefault IPPreference = iota
+	// IPPreferenceIPv4 tries IPv4 addresses first.
+	IPPreferenceIPv4
+	// IPPreferenceIPv6 tries IPv6 addresses first.
+	IPPreferenceIPv6
+)
+
+// The delay between starting connection attempts to successive addresses, as
+// recommended by RFC 8305.
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// A [Dialer] that races connection attempts to all of a host's addresses,
+// alternating between address families, as described in RFC 8305 ("Happy
+// Eyeballs Version 2").
+type happyEyeballsDialer struct {
+	dialer     net.Dialer
+	resolver   *net.Resolver
+	preference IPPreference
+	// Defaults to happyEyeballsDelay.
+	delay time.Duration
+}
+
+func (d *happyEyeballsDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *happyEyeballsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if network != "tcp" {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, address)
+	}
+
+	resolver := d.resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	return d.race(ctx, interleaveAddrs(addrs, d.preference), port)
+}
+
+func newHappyEyeballsDialer(preference IPPreference, resolver *net.Resolver) *happyEyeballsDialer {
+	return &happyEyeballsDialer{
+		dialer:     net.Dialer{Resolver: resolver},
+		resolver:   resolver,
+		preference: preference,
+	}
+}
+
+// Returns a copy of the dialer that configures the sockets it creates, and
+// resolves host names, as the upstream options say.
+func (d *happyEyeballsDialer) withUpstreamOptions(opts upstream.Options) *happyEyeballsDialer {
+	withOpts := *d
+	withOpts.dialer.Control = opts.Control
+	withOpts.dialer.KeepAlive = opts.KeepAlive
+	if opts.Resolver != nil {
+		withOpts.dialer.Resolver = opts.Resolver
+		withOpts.resolver = opts.Resolver
+	}
+	return &withOpts
+}
+
+// Start a connection attempt to each address in turn, waiting for the
+// previous one to either fail or exceed the attempt delay. The first
+// successful connection wins and the rest are cancelled.
+func (d *happyEyeballsDialer) race(ctx context.Context, addrs []net.IPAddr, port string) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("no addresses to dial")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	delay := d.delay
+	if delay == 0 {
+		delay = happyEyeballsDelay
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	// Buffered so that abandoned attempts never block.
+	results := make(chan result, len(addrs))
+
+	var (
+		next        int
+		inflight    int
+		nextAttempt <-chan time.Time
+		errs        []error
+	)
+	start := func() {
+		address := net.JoinHostPort(addrs[next].String(), port)
+		next++
+		inflight++
+		go func() {
+			conn, err := d.dialer.DialContext(ctx, "tcp", address)
+			results <- result{conn, err}
+		}()
+		nextAttempt = nil
+		if next < len(addrs) {
+			nextAttempt = time.After(delay)
+		}
+	}
+
+	start()
+	for inflight > 0 {
+		select {
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				cancel()
+				// Clean up any attempts that succeed after the winner.
+				go func(remaining int) {
+					for ; remaining > 0; remaining-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(inflight)
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			// Don't wait out the delay if the last attempt already failed.
+			if next < len(addrs) {
+				start()
+			}
+		case <-nextAttempt:
+			start()
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// Order addresses so that address families alternate, starting with the
+// preferred family. The relative order within each family is preserved.
+func interleaveAddrs(addrs []net.IPAddr, pref IPPreference) []net.IPAddr {
+	var v4, v6 []net.IPAddr
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			v4 = append(v4, addr)
+		} else {
+			v6 = append(v6, addr)
+		}
+	}
+
+	first, second := v6, v4
+	switch pref {
+	case IPPreferenceIPv4:
+		first, second = v4, v6
+	case IPPreferenceDefault:
+		if len(addrs) > 0 && addrs[0].IP.To4() != nil {
+			first, second = v4, v6
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(addrs))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
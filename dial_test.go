@@ -0,0 +1,114 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func TestInterleaveAddrs(t *testing.T) {
+	v4a := net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	v4b := net.IPAddr{IP: net.ParseIP("192.0.2.2")}
+	v6a := net.IPAddr{IP: net.ParseIP("2001:db8::1")}
+	v6b := net.IPAddr{IP: net.ParseIP("2001:db8::2")}
+	v6c := net.IPAddr{IP: net.ParseIP("2001:db8::3")}
+
+	addrs := []net.IPAddr{v6a, v6b, v6c, v4a, v4b}
+
+	require.Equal(t, []net.IPAddr{v6a, v4a, v6b, v4b, v6c}, interleaveAddrs(addrs, IPPreferenceDefault))
+	require.Equal(t, []net.IPAddr{v6a, v4a, v6b, v4b, v6c}, interleaveAddrs(addrs, IPPreferenceIPv6))
+	require.Equal(t, []net.IPAddr{v4a, v6a, v4b, v6b, v6c}, interleaveAddrs(addrs, IPPreferenceIPv4))
+	require.Equal(t, []net.IPAddr{v4a, v4b}, interleaveAddrs([]net.IPAddr{v4a, v4b}, IPPreferenceIPv6))
+}
+
+func TestHappyEyeballsRace(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// Nothing listens on 127.0.0.2, so the first attempt is refused and the
+	// second should start immediately rather than after the delay.
+	dialer := &happyEyeballsDialer{delay: time.Hour}
+	addrs := []net.IPAddr{
+		{IP: net.ParseIP("127.0.0.2")},
+		{IP: net.ParseIP("127.0.0.1")},
+	}
+
+	conn, err := dialer.race(context.Background(), addrs, port)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, l.Addr().String(), conn.RemoteAddr().String())
+}
+
+func TestHappyEyeballsAllFail(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	dialer := &happyEyeballsDialer{}
+	_, err = dialer.race(context.Background(), []net.IPAddr{
+		{IP: net.ParseIP("127.0.0.1")},
+		{IP: net.ParseIP("127.0.0.2")},
+	}, port)
+	require.Error(t, err)
+}
+
+func TestHappyEyeballsSocketOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var controlled int
+	base := &happyEyeballsDialer{}
+	dialer := base.withUpstreamOptions(upstream.Options{
+		Control: func(string, string, syscall.RawConn) error {
+			controlled++
+			return nil
+		},
+		KeepAlive: time.Minute,
+	})
+	require.Nil(t, base.dialer.Control)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	require.Equal(t, 1, controlled)
+	require.Equal(t, time.Minute, dialer.dialer.KeepAlive)
+}
+
+// A resolver that fails every lookup, counting them.
+func countingResolver(lookups *atomic.Int32) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			lookups.Add(1)
+			return nil, errors.New("no dns here")
+		},
+	}
+}
+
+func TestHappyEyeballsResolver(t *testing.T) {
+	var sessionLookups, upstreamLookups atomic.Int32
+	dialer := newHappyEyeballsDialer(IPPreferenceDefault, countingResolver(&sessionLookups))
+
+	_, err := dialer.DialContext(context.Background(), "tcp", "backend.test:80")
+	require.Error(t, err)
+	require.NotZero(t, sessionLookups.Load())
+
+	sessionLookups.Store(0)
+	upstreamDialer := dialer.withUpstreamOptions(upstream.Options{Resolver: countingResolver(&upstreamLookups)})
+	_, err = upstreamDialer.DialContext(context.Background(), "tcp", "backend.test:80")
+	require.Error(t, err)
+	require.NotZero(t, upstreamLookups.Load())
+	require.Zero(t, sessionLookups.Load())
+}
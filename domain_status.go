@@ -0,0 +1,146 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+)
+
+// DomainStatus describes the DNS setup of an endpoint's domain, as reported by
+// [TunnelInfo].DomainStatus. Custom domains, unlike those ngrok assigns, need
+// a CNAME record pointing them at ngrok before they serve traffic, and a
+// certificate that ngrok provisions once the record is in place.
+type DomainStatus struct {
+	// The endpoint's domain.
+	Domain string
+	// Whether the domain is one of ngrok's, which needs no DNS record.
+	Managed bool
+	// The target of the CNAME record to create for a custom domain.
+	CNAMETarget string
+	// Whether the domain's CNAME record points at CNAMETarget, as seen by
+	// the session's resolver.
+	DNSReady bool
+	// Whether ngrok has provisioned a certificate for the domain.
+	CertificateReady bool
+	// The error from the latest attempt to provision a certificate, if it
+	// failed.
+	CertificateError string
+}
+
+// Ready reports whether the domain is set up to serve traffic.
+func (d *DomainStatus) Ready() bool {
+	return d.Managed || d.DNSReady && d.CertificateReady
+}
+
+// Record returns the DNS record to create for a custom domain, in zone file
+// syntax, or the empty string for ngrok's domains.
+func (d *DomainStatus) Record() string {
+	if d.Managed {
+		return ""
+	}
+	return dnsName(d.Domain) + " CNAME " + dnsName(d.CNAMETarget)
+}
+
+func dnsName(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
+
+// WaitForDomain polls the DNS setup of the tunnel's domain every interval
+// until it's ready to serve traffic, then returns its status. If the context
+// is done first, the latest status is returned with the context's error.
+func WaitForDomain(ctx context.Context, tun TunnelInfo, interval time.Duration) (*DomainStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := tun.DomainStatus(ctx)
+		if err != nil || status.Ready() {
+			return status, err
+		}
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// The parts of a reserved domain resource used by DomainStatus.
+type apiReservedDomain struct {
+	Domain      string  `json:"domain"`
+	CNAMETarget *string `json:"cname_target"`
+	Certificate *struct {
+		ID string `json:"id"`
+	} `json:"certificate"`
+	CertificateManagementStatus *struct {
+		ProvisioningJob *struct {
+			ErrorCode string `json:"error_code"`
+			Msg       string `json:"msg"`
+		} `json:"provisioning_job"`
+	} `json:"certificate_management_status"`
+}
+
+func (t *tunnelImpl) DomainStatus(ctx context.Context) (*DomainStatus, error) {
+	sess, _ := t.Sess.(*sessionImpl)
+	if sess == nil || sess.api == nil {
+		return nil, errMissingAPIKey{}
+	}
+	u, err := neturl.Parse(t.URL())
+	if err != nil || u.Hostname() == "" || t.Proto() == "tcp" {
+		return nil, errDomainStatus{Domain: t.URL(), Inner: errNoDomain}
+	}
+	status, err := sess.domainStatus(ctx, u.Hostname())
+	if err != nil {
+		return nil, errDomainStatus{Domain: u.Hostname(), Inner: err}
+	}
+	return status, nil
+}
+
+// Look up the DNS setup of a domain on the account. Domains that aren't
+// reserved on it were assigned by ngrok.
+func (s *sessionImpl) domainStatus(ctx context.Context, domain string) (*DomainStatus, error) {
+	reserved, err := s.findReservedDomain(ctx, domain)
+	if err != nil {
+		return nil, err
+	}
+	status := &DomainStatus{Domain: domain}
+	if reserved == nil || reserved.CNAMETarget == nil || *reserved.CNAMETarget == "" {
+		status.Managed = true
+		return status, nil
+	}
+
+	status.CNAMETarget = *reserved.CNAMETarget
+	status.CertificateReady = reserved.Certificate != nil
+	if mgmt := reserved.CertificateManagementStatus; mgmt != nil && mgmt.ProvisioningJob != nil {
+		status.CertificateError = mgmt.ProvisioningJob.Msg
+	}
+	resolver := net.DefaultResolver
+	if dialer, ok := s.upstreamDialer.(*happyEyeballsDialer); ok && dialer.resolver != nil {
+		resolver = dialer.resolver
+	}
+	if cname, err := resolver.LookupCNAME(ctx, domain); err == nil {
+		status.DNSReady = strings.EqualFold(dnsName(cname), dnsName(status.CNAMETarget))
+	}
+	return status, nil
+}
+
+func (s *sessionImpl) findReservedDomain(ctx context.Context, domain string) (*apiReservedDomain, error) {
+	for page := "/reserved_domains"; page != ""; {
+		var resp struct {
+			ReservedDomains []apiReservedDomain `json:"reserved_domains"`
+			NextPageURI     string              `json:"next_page_uri"`
+		}
+		if err := s.api.do(ctx, http.MethodGet, page, nil, &resp); err != nil {
+			return nil, err
+		}
+		for i := range resp.ReservedDomains {
+			if strings.EqualFold(resp.ReservedDomains[i].Domain, domain) {
+				return &resp.ReservedDomains[i], nil
+			}
+		}
+		page = resp.NextPageURI
+	}
+	return nil, nil
+}
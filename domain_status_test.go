@@ -0,0 +1,148 @@
+package ngrok
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// A resolver that answers CNAME lookups from records, and fails the rest.
+func cnameResolver(records map[string]string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				defer server.Close()
+				for {
+					// Not a net.PacketConn, so messages are length-prefixed
+					// as they are over TCP.
+					var size uint16
+					if binary.Read(server, binary.BigEndian, &size) != nil {
+						return
+					}
+					buf := make([]byte, size)
+					if _, err := io.ReadFull(server, buf); err != nil {
+						return
+					}
+					var msg dnsmessage.Message
+					if msg.Unpack(buf) != nil || len(msg.Questions) != 1 {
+						return
+					}
+					q := msg.Questions[0]
+					msg.Response = true
+					msg.RCode = dnsmessage.RCodeNameError
+					if target, ok := records[q.Name.String()]; ok && q.Type == dnsmessage.TypeCNAME {
+						msg.RCode = dnsmessage.RCodeSuccess
+						msg.Answers = []dnsmessage.Resource{{
+							Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 60},
+							Body:   &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(target)},
+						}}
+					}
+					out, err := msg.Pack()
+					if err != nil {
+						return
+					}
+					if binary.Write(server, binary.BigEndian, uint16(len(out))) != nil {
+						return
+					}
+					if _, err := server.Write(out); err != nil {
+						return
+					}
+				}
+			}()
+			return client, nil
+		},
+	}
+}
+
+func TestDomainStatus(t *testing.T) {
+	certificate := `null`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/reserved_domains", r.URL.Path)
+		_, _ = w.Write([]byte(`{"reserved_domains":[` +
+			`{"domain":"app.ngrok.app","cname_target":null},` +
+			`{"domain":"app.example.com","cname_target":"3x9.cname.ngrok.app","certificate":` + certificate + `,` +
+			`"certificate_management_status":{"provisioning_job":{"msg":"waiting for DNS"}}}` +
+			`],"next_page_uri":null}`))
+	}))
+	defer srv.Close()
+
+	records := map[string]string{}
+	sess := &sessionImpl{
+		api:            newAPIClient("key"),
+		upstreamDialer: newHappyEyeballsDialer(IPPreferenceDefault, cnameResolver(records)),
+	}
+	sess.api.baseURL = srv.URL
+	ctx := context.Background()
+
+	status, err := sess.domainStatus(ctx, "app.ngrok.app")
+	require.NoError(t, err)
+	require.True(t, status.Managed)
+	require.True(t, status.Ready())
+	require.Empty(t, status.Record())
+
+	// Domains that aren't reserved were assigned by ngrok.
+	status, err = sess.domainStatus(ctx, "1a2b.ngrok-free.app")
+	require.NoError(t, err)
+	require.True(t, status.Managed)
+
+	status, err = sess.domainStatus(ctx, "App.Example.com")
+	require.NoError(t, err)
+	require.False(t, status.Managed)
+	require.Equal(t, "App.Example.com. CNAME 3x9.cname.ngrok.app.", status.Record())
+	require.False(t, status.DNSReady)
+	require.False(t, status.CertificateReady)
+	require.Equal(t, "waiting for DNS", status.CertificateError)
+	require.False(t, status.Ready())
+
+	records["App.Example.com."] = "3x9.cname.ngrok.app."
+	certificate = `{"id":"cert_1"}`
+	status, err = sess.domainStatus(ctx, "App.Example.com")
+	require.NoError(t, err)
+	require.True(t, status.DNSReady)
+	require.True(t, status.CertificateReady)
+	require.True(t, status.Ready())
+}
+
+func TestWaitForDomain(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tun := &domainStatusTunnel{statuses: []*DomainStatus{
+		{Domain: "app.example.com", CNAMETarget: "3x9.cname.ngrok.app"},
+		{Domain: "app.example.com", CNAMETarget: "3x9.cname.ngrok.app", DNSReady: true},
+		{Domain: "app.example.com", CNAMETarget: "3x9.cname.ngrok.app", DNSReady: true, CertificateReady: true},
+	}}
+	status, err := WaitForDomain(ctx, tun, time.Millisecond)
+	require.NoError(t, err)
+	require.True(t, status.Ready())
+	require.Empty(t, tun.statuses)
+
+	tun.statuses = []*DomainStatus{{Domain: "app.example.com"}}
+	ctx, cancel = context.WithCancel(ctx)
+	cancel()
+	status, err = WaitForDomain(ctx, tun, time.Hour)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Equal(t, "app.example.com", status.Domain)
+}
+
+// A tunnel that reports the given domain statuses in turn.
+type domainStatusTunnel struct {
+	Tunnel
+	statuses []*DomainStatus
+}
+
+func (t *domainStatusTunnel) DomainStatus(context.Context) (*DomainStatus, error) {
+	status := t.statuses[0]
+	t.statuses = t.statuses[1:]
+	return status, nil
+}
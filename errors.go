@@ -1,9 +1,17 @@
 package ngrok
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/url"
 	"strings"
+	"syscall"
+	"time"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
 )
 
 // Error is an error enriched with a specific ErrorCode.
@@ -25,6 +33,87 @@ type Error interface {
 	ErrorCode() string
 }
 
+// ErrSessionClosing is matched by the errors from requests made on a [Session]
+// after it has begun closing, or that were cut off by it closing. Check for it
+// with [errors.Is].
+var ErrSessionClosing = tunnel_client.ErrSessionClosing
+
+// Sentinels for the kinds of errors returned by this package, for matching
+// with [errors.Is]. They match any error of their kind, whatever its details.
+// See also [Temporary] and [Retryable] for deciding whether to try again.
+var (
+	// ErrAuthFailed matches errors authenticating a [Session].
+	ErrAuthFailed error = errAuthFailed{}
+	// ErrSessionDial matches errors dialing the ngrok service.
+	ErrSessionDial error = errSessionDial{}
+	// ErrConnectTimeout matches errors from [Connect] exceeding the timeout
+	// set by [WithConnectTimeout].
+	ErrConnectTimeout error = errConnectTimeout{}
+	// ErrConnectAttempts matches errors from [Connect] exceeding the
+	// attempts set by [WithMaxConnectAttempts].
+	ErrConnectAttempts error = errConnectAttempts{}
+	// ErrProxyInit matches errors constructing a dialer from the URL given
+	// to [WithProxyURL].
+	ErrProxyInit error = errProxyInit{}
+	// ErrProxyConnect matches errors from a proxy refusing to connect to
+	// the ngrok service.
+	ErrProxyConnect error = errProxyConnect{}
+	// ErrListen matches errors starting a [Tunnel].
+	ErrListen error = errListen{}
+	// ErrAcceptFailed matches the errors returned by [Tunnel]'s Accept
+	// method.
+	ErrAcceptFailed error = errAcceptFailed{}
+	// ErrReserveDomain matches errors reserving a domain for a tunnel.
+	ErrReserveDomain error = errReserveDomain{}
+	// ErrMissingAPIKey matches errors from operations that need an API key
+	// when none was configured with [WithAPIKey].
+	ErrMissingAPIKey error = errMissingAPIKey{}
+	// ErrRemoteEndpoints matches errors listing the endpoints on the
+	// account.
+	ErrRemoteEndpoints error = errRemoteEndpoints{}
+	// ErrDisconnectOwnSession matches the error from disconnecting a
+	// [RemoteEndpoint] served by the session that listed it, which would
+	// stop the session itself.
+	ErrDisconnectOwnSession error = errDisconnectOwnSession{}
+	// ErrDomainStatus matches errors looking up the DNS setup of an
+	// endpoint's domain.
+	ErrDomainStatus error = errDomainStatus{}
+	// ErrNotHealthy matches errors starting a pooled [Tunnel] whose
+	// readiness check, set with config.WithPoolReadinessCheck, didn't pass
+	// before the context expired.
+	ErrNotHealthy error = errNotHealthy{}
+	// ErrUpstreamDial matches failures to connect to the upstream service
+	// of a forwarded tunnel.
+	ErrUpstreamDial error = errUpstreamDial{}
+	// ErrUpstreamTLS matches failed TLS handshakes with the upstream service
+	// of a forwarded tunnel.
+	ErrUpstreamTLS error = errUpstreamTLS{}
+	// ErrUpstreamCAFile matches errors loading the file given to
+	// config.WithUpstreamCAFile.
+	ErrUpstreamCAFile error = errUpstreamCAFile{}
+	// ErrPanic matches errors from a goroutine of the [Session] that
+	// panicked, and was recovered rather than crashing the process.
+	ErrPanic error = errPanic{}
+	// ErrOffline matches the warning reported by a [Session] that fell back
+	// to listening locally with [WithOfflineFallback].
+	ErrOffline error = errOffline{}
+	// ErrURLChanged matches the error a [Tunnel] started with
+	// config.WithStableURLRequired fails with when it would have been
+	// assigned a different URL after reconnecting.
+	ErrURLChanged error = errURLChanged{}
+	// ErrEndpointAlreadyBound matches errors starting a [Tunnel] with a URL
+	// that another tunnel of the same session is already bound to. Use
+	// [errors.As] with an [*EndpointAlreadyBoundError] to get that tunnel.
+	ErrEndpointAlreadyBound error = &EndpointAlreadyBoundError{}
+	// ErrUnsupportedSession matches errors from functions that need a
+	// [Session] returned by [Connect] when given another implementation.
+	ErrUnsupportedSession error = errUnsupportedSession{}
+	// ErrLimitExceeded matches errors from the ngrok service refusing to
+	// start a session or endpoint because the account has reached its
+	// limit. Use [errors.As] with a [*LimitExceededError] for the details.
+	ErrLimitExceeded error = &LimitExceededError{}
+)
+
 // Errors arising from authentication failure.
 type errAuthFailed struct {
 	// Whether the error was generated by the remote server, or in the sending
@@ -92,6 +181,134 @@ func (e errListen) Is(target error) bool {
 	return ok
 }
 
+// Error arising from a failure to reserve a domain for a tunnel.
+type errReserveDomain struct {
+	// The domain that could not be reserved.
+	Domain string
+	// The underlying error.
+	Inner error
+}
+
+func (e errReserveDomain) Error() string {
+	return fmt.Sprintf("failed to reserve domain \"%s\": %v", e.Domain, e.Inner)
+}
+
+func (e errReserveDomain) Unwrap() error {
+	return e.Inner
+}
+
+func (e errReserveDomain) Is(target error) bool {
+	_, ok := target.(errReserveDomain)
+	return ok
+}
+
+// Error returned when a pooled tunnel's readiness check never passed.
+type errNotHealthy struct {
+	// The last error from the check.
+	Inner error
+}
+
+func (e errNotHealthy) Error() string {
+	return fmt.Sprintf("health check did not pass before joining pool: %v", e.Inner)
+}
+
+func (e errNotHealthy) Unwrap() error {
+	return e.Inner
+}
+
+func (e errNotHealthy) Is(target error) bool {
+	_, ok := target.(errNotHealthy)
+	return ok
+}
+
+// Error returned when a function is given a Session that wasn't returned by
+// Connect.
+type errUnsupportedSession struct {
+	// The function that was called.
+	Op string
+}
+
+func (e errUnsupportedSession) Error() string {
+	return fmt.Sprintf("ngrok: %s requires a Session returned by Connect", e.Op)
+}
+
+func (e errUnsupportedSession) Is(target error) bool {
+	_, ok := target.(errUnsupportedSession)
+	return ok
+}
+
+// Error returned when an operation needs an API key but none was configured
+// with [WithAPIKey].
+type errMissingAPIKey struct{}
+
+func (e errMissingAPIKey) Error() string {
+	return "an ngrok API key is required, configure one with WithAPIKey"
+}
+
+func (e errMissingAPIKey) Is(target error) bool {
+	_, ok := target.(errMissingAPIKey)
+	return ok
+}
+
+// Error arising from a failure to list the endpoints on the account.
+type errRemoteEndpoints struct {
+	// The underlying error.
+	Inner error
+}
+
+func (e errRemoteEndpoints) Error() string {
+	return fmt.Sprintf("failed to list endpoints: %v", e.Inner)
+}
+
+func (e errRemoteEndpoints) Unwrap() error {
+	return e.Inner
+}
+
+func (e errRemoteEndpoints) Is(target error) bool {
+	_, ok := target.(errRemoteEndpoints)
+	return ok
+}
+
+// Error returned when disconnecting an endpoint served by the session that
+// listed it.
+type errDisconnectOwnSession struct {
+	// The ID of the endpoint.
+	EndpointID string
+}
+
+func (e errDisconnectOwnSession) Error() string {
+	return fmt.Sprintf("endpoint %s is served by this session, which disconnecting it would stop", e.EndpointID)
+}
+
+func (e errDisconnectOwnSession) Is(target error) bool {
+	_, ok := target.(errDisconnectOwnSession)
+	return ok
+}
+
+// The reason an endpoint without a domain has no domain status.
+var errNoDomain = errors.New("the endpoint has no domain")
+
+// Error arising from a failure to look up the DNS setup of a domain.
+type errDomainStatus struct {
+	// The domain, or the URL of an endpoint without one.
+	Domain string
+	// The underlying error.
+	Inner error
+}
+
+func (e errDomainStatus) Error() string {
+	return fmt.Sprintf("failed to get the status of %s: %v", e.Domain, e.Inner)
+}
+
+func (e errDomainStatus) Unwrap() error {
+	return e.Inner
+}
+
+func (e errDomainStatus) Is(target error) bool {
+	_, ok := target.(errDomainStatus)
+	return ok
+}
+
 // Errors arising from a failure to construct a [golang.org/x/net/proxy.Dialer] from a [url.URL].
 type errProxyInit struct {
 	// The provided proxy URL.
@@ -113,6 +330,23 @@ func (e errProxyInit) Is(target error) bool {
 	return ok
 }
 
+// Error arising from a proxy refusing a CONNECT request.
+type errProxyConnect struct {
+	// The address to which a connection was requested.
+	Addr string
+	// The status returned by the proxy.
+	Status string
+}
+
+func (e errProxyConnect) Error() string {
+	return fmt.Sprintf("proxy refused connection to \"%s\": %s", e.Addr, e.Status)
+}
+
+func (e errProxyConnect) Is(target error) bool {
+	_, ok := target.(errProxyConnect)
+	return ok
+}
+
 // Error arising from a failure to dial the ngrok server.
 type errSessionDial struct {
 	// The address to which a connection was attempted.
@@ -134,6 +368,154 @@ func (e errSessionDial) Is(target error) bool {
 	return ok
 }
 
+// Error arising from [Connect] exceeding the timeout set by [WithConnectTimeout].
+type errConnectTimeout struct {
+	// The configured timeout.
+	Timeout time.Duration
+	// The errors encountered while connecting, including the context error.
+	Inner error
+}
+
+func (e errConnectTimeout) Error() string {
+	return fmt.Sprintf("failed to connect to ngrok within %s: %v", e.Timeout, e.Inner)
+}
+
+func (e errConnectTimeout) Unwrap() error {
+	return e.Inner
+}
+
+func (e errConnectTimeout) Is(target error) bool {
+	_, ok := target.(errConnectTimeout)
+	return ok
+}
+
+// Error arising from [Connect] exceeding the attempts set by [WithMaxConnectAttempts].
+type errConnectAttempts struct {
+	// The number of failed attempts made.
+	Attempts int
+	// The errors encountered on each attempt.
+	Inner error
+}
+
+func (e errConnectAttempts) Error() string {
+	return fmt.Sprintf("failed to connect to ngrok after %d attempts: %v", e.Attempts, e.Inner)
+}
+
+func (e errConnectAttempts) Unwrap() error {
+	return e.Inner
+}
+
+func (e errConnectAttempts) Is(target error) bool {
+	_, ok := target.(errConnectAttempts)
+	return ok
+}
+
+// Error arising from a failure to connect to the upstream service of a
+// forwarded tunnel.
+type errUpstreamDial struct {
+	// The address of the upstream service.
+	Address string
+	// The number of attempts made.
+	Attempts int
+	// The error from the last attempt.
+	Inner error
+}
+
+func (e errUpstreamDial) Error() string {
+	if e.Attempts > 1 {
+		return fmt.Sprintf("failed to connect to upstream \"%s\" after %d attempts: %v", e.Address, e.Attempts, e.Inner)
+	}
+	return fmt.Sprintf("failed to connect to upstream \"%s\": %v", e.Address, e.Inner)
+}
+
+func (e errUpstreamDial) Unwrap() error {
+	return e.Inner
+}
+
+func (e errUpstreamDial) Is(target error) bool {
+	_, ok := target.(errUpstreamDial)
+	return ok
+}
+
+// Error arising from a failed TLS handshake with the upstream service of a
+// forwarded tunnel.
+type errUpstreamTLS struct {
+	// The URL of the upstream service.
+	URL string
+	// The handshake error.
+	Inner error
+}
+
+func (e errUpstreamTLS) Error() string {
+	return fmt.Sprintf("TLS handshake with upstream %s failed: %v", e.URL, e.Inner)
+}
+
+func (e errUpstreamTLS) Unwrap() error {
+	return e.Inner
+}
+
+func (e errUpstreamTLS) Is(target error) bool {
+	_, ok := target.(errUpstreamTLS)
+	return ok
+}
+
+// Error arising from loading the file set by config.WithUpstreamCAFile.
+type errUpstreamCAFile struct {
+	// The path to the file.
+	Path string
+	// The underlying error.
+	Inner error
+}
+
+func (e errUpstreamCAFile) Error() string {
+	return fmt.Sprintf("failed to load upstream CA file %q: %v", e.Path, e.Inner)
+}
+
+func (e errUpstreamCAFile) Unwrap() error {
+	return e.Inner
+}
+
+func (e errUpstreamCAFile) Is(target error) bool {
+	_, ok := target.(errUpstreamCAFile)
+	return ok
+}
+
+// Warning from a [Session] that fell back to listening locally, as set up by
+// [WithOfflineFallback].
+type errOffline struct {
+	// Why the session couldn't connect to ngrok.
+	Inner error
+}
+
+func (e errOffline) Error() string {
+	return fmt.Sprintf("not connected to ngrok, listening locally: %v", e.Inner)
+}
+
+func (e errOffline) Unwrap() error {
+	return e.Inner
+}
+
+func (e errOffline) Is(target error) bool {
+	_, ok := target.(errOffline)
+	return ok
+}
+
+// Error from a goroutine that panicked, recovered so that the session and its
+// tunnels can carry on.
+type errPanic struct {
+	// The value passed to panic.
+	Recovered any
+}
+
+func (e errPanic) Error() string {
+	return fmt.Sprintf("recovered from panic: %v", e.Recovered)
+}
+
+func (e errPanic) Is(target error) bool {
+	_, ok := target.(errPanic)
+	return ok
+}
+
 // Generic ngrok error that requires no parsing
 type ngrokError struct {
 	Message string
@@ -162,3 +544,112 @@ func (e ngrokError) Is(target error) bool {
 	_, ok := target.(ngrokError)
 	return ok
 }
+
+// Temporary reports whether err was caused by a transient condition, such as
+// a network timeout or a dropped connection, which is expected to pass
+// without any change to the configuration or the account.
+func Temporary(err error) bool {
+	if err == nil || final(err) {
+		return false
+	}
+	var authErr errAuthFailed
+	if errors.As(err, &authErr) && !authErr.Remote {
+		return true
+	}
+	if errors.Is(err, errSessionDial{}) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, syscall.ENETUNREACH) ||
+		errors.Is(err, syscall.EHOSTUNREACH)
+}
+
+// Retryable reports whether the operation that returned err may succeed if
+// it's tried again unchanged. This includes [Temporary] errors, [Connect]
+// giving up after its timeout or attempts run out, proxies that are
+// unavailable, and account limits, which free up as other sessions or
+// endpoints stop. Other errors reported by the ngrok service with an error
+// code, configuration errors, failed authentication, closed sessions and
+// tunnels, and canceled contexts aren't retryable.
+func Retryable(err error) bool {
+	if errors.Is(err, ErrLimitExceeded) {
+		return true
+	}
+	if err == nil || final(err) {
+		return false
+	}
+	if Temporary(err) {
+		return true
+	}
+	var proxyErr errProxyConnect
+	if errors.As(err, &proxyErr) {
+		return strings.HasPrefix(proxyErr.Status, "5")
+	}
+	return errors.Is(err, errConnectTimeout{}) ||
+		errors.Is(err, errConnectAttempts{}) ||
+		errors.Is(err, errOffline{})
+}
+
+// Whether err is certain to recur however many times the operation is tried.
+func final(err error) bool {
+	var nerr Error
+	if errors.As(err, &nerr) && nerr.ErrorCode() != "" {
+		return true
+	}
+	var authErr errAuthFailed
+	if errors.As(err, &authErr) && authErr.Remote {
+		return true
+	}
+	return errors.Is(err, ErrSessionClosing) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, errMissingAPIKey{}) ||
+		errors.Is(err, errProxyInit{}) ||
+		errors.Is(err, errUpstreamCAFile{}) ||
+		isCertificateError(err)
+}
+
+// Error from a tunnel that was closed rather than let its URL change.
+type errURLChanged struct {
+	// The URL the tunnel was bound to before reconnecting.
+	Old string
+	// The URL it was assigned after reconnecting.
+	New string
+}
+
+func (e errURLChanged) Error() string {
+	return fmt.Sprintf("endpoint URL changed from \"%s\" to \"%s\" after reconnecting", e.Old, e.New)
+}
+
+func (e errURLChanged) Is(target error) bool {
+	_, ok := target.(errURLChanged)
+	return ok
+}
+
+// EndpointAlreadyBoundError is returned when starting a [Tunnel] with a URL
+// that another tunnel of the same session is already bound to, which the
+// ngrok service would refuse unless both allow pooling. See also
+// config.WithReuseExisting.
+type EndpointAlreadyBoundError struct {
+	// The URL the tunnel requested.
+	URL string
+	// The session's tunnel that's already bound to it.
+	Tunnel Tunnel
+}
+
+func (e *EndpointAlreadyBoundError) Error() string {
+	return fmt.Sprintf("endpoint \"%s\" is already bound by tunnel %s of this session", e.URL, e.Tunnel.ID())
+}
+
+func (e *EndpointAlreadyBoundError) Is(target error) bool {
+	_, ok := target.(*EndpointAlreadyBoundError)
+	return ok
+}
@@ -1,7 +1,11 @@
 package ngrok
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"io"
+	"syscall"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -48,3 +52,44 @@ func TestNgrokErrorWrapping(t *testing.T) {
 
 	require.False(t, errors.As(nonNgrokErr, &nerr))
 }
+
+func TestErrorSentinels(t *testing.T) {
+	err := fmt.Errorf("connecting: %w", errConnectAttempts{
+		Attempts: 2,
+		Inner:    errSessionDial{Addr: "connect.ngrok-agent.com:443", Inner: io.EOF},
+	})
+	require.ErrorIs(t, err, ErrConnectAttempts)
+	require.ErrorIs(t, err, ErrSessionDial)
+	require.NotErrorIs(t, err, ErrAuthFailed)
+	require.ErrorIs(t, errListen{Inner: io.EOF}, ErrListen)
+}
+
+func TestRetryable(t *testing.T) {
+	cases := []struct {
+		name      string
+		err       error
+		temporary bool
+		retryable bool
+	}{
+		{"nil", nil, false, false},
+		{"session dial", errSessionDial{Inner: syscall.ECONNREFUSED}, true, true},
+		{"auth request not sent", errAuthFailed{false, io.ErrUnexpectedEOF}, true, true},
+		{"auth rejected", errAuthFailed{true, errors.New("bad authtoken")}, false, false},
+		{"listen reset", errListen{Inner: syscall.ECONNRESET}, true, true},
+		{"listen rejected", errListen{Inner: proto.StringError("endpoint in use\n\nERR_NGROK_334")}, false, false},
+		{"connect attempts", errConnectAttempts{2, errors.New("dial failed")}, false, true},
+		{"connect attempts rejected", errConnectAttempts{1, errAuthFailed{true, io.EOF}}, false, false},
+		{"connect timeout", errConnectTimeout{Inner: context.DeadlineExceeded}, true, true},
+		{"proxy unavailable", errProxyConnect{Status: "503 Service Unavailable"}, false, true},
+		{"proxy forbidden", errProxyConnect{Status: "403 Forbidden"}, false, false},
+		{"missing api key", errMissingAPIKey{}, false, false},
+		{"session closing", errListen{Inner: ErrSessionClosing}, false, false},
+		{"canceled", fmt.Errorf("listen: %w", context.Canceled), false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.temporary, Temporary(tc.err), "Temporary")
+			require.Equal(t, tc.retryable, Retryable(tc.err), "Retryable")
+		})
+	}
+}
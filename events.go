@@ -0,0 +1,532 @@
+package ngrok
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of an [Event].
+type EventType int
+
+// All event types emitted by a [Session].
+const (
+	EventTypeSessionConnected EventType = iota + 1
+	EventTypeSessionDisconnected
+	EventTypeTunnelStarted
+	EventTypeTunnelClosed
+	EventTypeConnectionOpened
+	EventTypeConnectionClosed
+	EventTypeBudgetExceeded
+	EventTypePoolMembersChanged
+	EventTypeHeartbeatMissed
+	EventTypeUpstreamTLSFailed
+	EventTypeUpstreamDialFailed
+	EventTypeAgentWarning
+	EventTypeEndpointURLChanged
+	EventTypeAcceptThrottled
+	EventTypeHeartbeatReceived
+	EventTypeTLSHandshakeFailed
+	EventTypeLegConnected
+	EventTypeLegDisconnected
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventTypeSessionConnected:
+		return "SessionConnected"
+	case EventTypeSessionDisconnected:
+		return "SessionDisconnected"
+	case EventTypeTunnelStarted:
+		return "TunnelStarted"
+	case EventTypeTunnelClosed:
+		return "TunnelClosed"
+	case EventTypeConnectionOpened:
+		return "ConnectionOpened"
+	case EventTypeConnectionClosed:
+		return "ConnectionClosed"
+	case EventTypeBudgetExceeded:
+		return "BudgetExceeded"
+	case EventTypePoolMembersChanged:
+		return "PoolMembersChanged"
+	case EventTypeHeartbeatMissed:
+		return "HeartbeatMissed"
+	case EventTypeUpstreamTLSFailed:
+		return "UpstreamTLSFailed"
+	case EventTypeUpstreamDialFailed:
+		return "UpstreamDialFailed"
+	case EventTypeAgentWarning:
+		return "AgentWarning"
+	case EventTypeEndpointURLChanged:
+		return "EndpointURLChanged"
+	case EventTypeAcceptThrottled:
+		return "AcceptThrottled"
+	case EventTypeHeartbeatReceived:
+		return "HeartbeatReceived"
+	case EventTypeTLSHandshakeFailed:
+		return "TLSHandshakeFailed"
+	case EventTypeLegConnected:
+		return "LegConnected"
+	case EventTypeLegDisconnected:
+		return "LegDisconnected"
+	}
+	return "Unknown"
+}
+
+// Event is something that happened to a [Session] or one of its tunnels or
+// connections. Type-switch on the concrete Event* types to access the details
+// of each event.
+//
+// Events are delivered to handlers configured with [WithEventHandler] or
+// added with [Session.AddEventHandler] according to the following
+// guarantees:
+//
+//   - Events are delivered one at a time, in the order in which they
+//     occurred, to each handler in the order the handlers were configured.
+//   - [EventSessionConnected] is emitted before [Connect] returns, and so
+//     precedes any events for the tunnels of the session. It may not have
+//     been delivered yet when Connect returns.
+//   - [EventTunnelStarted] precedes any [EventConnectionOpened] for that
+//     tunnel.
+//   - [EventConnectionOpened] precedes the [EventConnectionClosed] for the
+//     same connection.
+//   - [EventTunnelClosed] follows the [EventConnectionClosed] for every
+//     connection accepted from that tunnel. If connections remain open when
+//     the tunnel is closed, the event is deferred until they are closed.
+//
+// Handlers are called from a dedicated goroutine, so a slow handler delays
+// the delivery of subsequent events but never blocks the session itself.
+type Event interface {
+	// EventType returns the kind of the event.
+	EventType() EventType
+	// Timestamp returns the time at which the event occurred.
+	Timestamp() time.Time
+}
+
+// EventHandler is the callback type for [WithEventHandler].
+type EventHandler func(Event)
+
+// WithEventHandler configures a function which is called with each [Event]
+// emitted by the [Session]. It may be provided multiple times to configure
+// multiple handlers.
+//
+// See [Event] for the ordering guarantees provided to handlers.
+func WithEventHandler(handler EventHandler) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.EventHandlers = append(cfg.EventHandlers, handler)
+	}
+}
+
+// EventSubscription identifies a handler added with
+// [Session.AddEventHandler], for removing it with
+// [Session.RemoveEventHandler].
+type EventSubscription struct {
+	sub *subscriber
+}
+
+// Deliver events to a channel until the context is done, after which the
+// channel is closed.
+func (d *eventDispatcher) channel(ctx context.Context) <-chan Event {
+	ch := make(chan Event)
+	var (
+		mu     sync.Mutex
+		closed bool
+	)
+	sub := d.addHandler(func(ev Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- ev:
+		case <-ctx.Done():
+		}
+	})
+	go func() {
+		<-ctx.Done()
+		d.removeHandler(sub)
+		mu.Lock()
+		defer mu.Unlock()
+		closed = true
+		close(ch)
+	}()
+	return ch
+}
+
+// WithEventReplay makes the [Session] keep its last n events, and send them
+// to each handler added with [Session.AddEventHandler] before any new events.
+// This lets monitoring that attaches after [Connect] returns see the state
+// the session is already in, such as the [EventSessionConnected] it missed.
+func WithEventReplay(n int) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.EventReplay = n
+	}
+}
+
+type baseEvent struct {
+	Type       EventType
+	OccurredAt time.Time
+}
+
+func newBaseEvent(typ EventType) baseEvent {
+	return baseEvent{Type: typ, OccurredAt: time.Now()}
+}
+
+func (e baseEvent) EventType() EventType {
+	return e.Type
+}
+
+func (e baseEvent) Timestamp() time.Time {
+	return e.OccurredAt
+}
+
+// EventSessionConnected is emitted each time the [Session] connects or
+// reconnects to the ngrok service.
+type EventSessionConnected struct {
+	baseEvent
+	Session Session
+}
+
+// EventSessionDisconnected is emitted each time the [Session] disconnects
+// from the ngrok service. A nil Error means that the session has stopped and
+// will not reconnect.
+type EventSessionDisconnected struct {
+	baseEvent
+	Session Session
+	Error   error
+}
+
+// EventHeartbeatMissed is emitted each time a heartbeat interval passes
+// without a response from the ngrok service. It gives early warning that the
+// session is about to be declared dead, which happens once Remaining reaches
+// zero without a response. See [WithHeartbeatInterval] and
+// [WithHeartbeatTolerance].
+type EventHeartbeatMissed struct {
+	baseEvent
+	Session Session
+	// The leg whose heartbeats were missed. See [LegStatus].
+	Leg int
+	// The number of consecutive heartbeat intervals without a response.
+	Missed int
+	// The time since the last heartbeat response.
+	SinceLastHeartbeat time.Duration
+	// The time left before the session is disconnected unless a response
+	// arrives.
+	Remaining time.Duration
+}
+
+// EventHeartbeatReceived is emitted each time the ngrok service responds to a
+// heartbeat, with statistics for judging the health of the session's
+// connection. See [SupervisorStatus] for a summary of its health.
+type EventHeartbeatReceived struct {
+	baseEvent
+	Session Session
+	// The leg the heartbeat was sent on. See [LegStatus].
+	Leg int
+	// The round trip time of the heartbeat.
+	Latency time.Duration
+	// The number of heartbeat intervals that passed without a response
+	// before this one.
+	Missed int
+	// The time that was left before the session would have been
+	// disconnected when the response arrived.
+	Remaining time.Duration
+	// The mean variation between the latencies of consecutive heartbeats,
+	// smoothed over the recent ones as for RTP (RFC 3550).
+	Jitter time.Duration
+}
+
+// EventAgentWarning is emitted after [EventSessionConnected] when the ngrok
+// service warns the agent as it connects, such as that its version is
+// deprecated, or with a banner about the account's plan.
+type EventAgentWarning struct {
+	baseEvent
+	Session Session
+	// The deprecation of this client version, if any.
+	Deprecation *AgentVersionDeprecated
+	// The message the ngrok service asked the agent to show, if any.
+	Banner string
+}
+
+// EventTunnelStarted is emitted when a [Tunnel] is started.
+type EventTunnelStarted struct {
+	baseEvent
+	Tunnel Tunnel
+}
+
+// EventEndpointURLChanged is emitted when a [Tunnel] is assigned a different
+// URL after its session reconnects, as can happen to tunnels with randomly
+// assigned URLs or TCP addresses. The tunnel's URL method reports the new URL
+// by the time the event is emitted. See config.WithStableURLRequired to close
+// the tunnel instead.
+type EventEndpointURLChanged struct {
+	baseEvent
+	Tunnel Tunnel
+	OldURL string
+	NewURL string
+}
+
+// EventTunnelClosed is emitted when a [Tunnel] is closed and all of the
+// connections accepted from it have been closed.
+type EventTunnelClosed struct {
+	baseEvent
+	Tunnel Tunnel
+}
+
+// EventConnectionOpened is emitted when a connection is accepted from a
+// [Tunnel].
+type EventConnectionOpened struct {
+	baseEvent
+	Tunnel Tunnel
+	Conn   Conn
+}
+
+// EventConnectionClosed is emitted when a connection accepted from a
+// [Tunnel] is closed.
+type EventConnectionClosed struct {
+	baseEvent
+	Tunnel Tunnel
+	Conn   Conn
+	// How long the connection was open.
+	Duration time.Duration
+	// The number of bytes read from and written to the connection.
+	BytesRead    int64
+	BytesWritten int64
+	// Why the connection was closed, including whether the ngrok edge or
+	// this application closed it.
+	CloseReason ConnCloseReason
+}
+
+// Delivers events to handlers in order on a dedicated goroutine. Emitting an
+// event never blocks. A nil dispatcher discards events.
+type eventDispatcher struct {
+	mu       sync.Mutex
+	handlers []*subscriber
+	queue    []queuedEvent
+	draining bool
+	// Closed when the current drain finishes.
+	drained chan struct{}
+	// High-water mark of the queue length.
+	maxQueue int
+
+	// The most recent events, oldest first, for handlers added later.
+	replay  int
+	history []Event
+}
+
+// An event along with the handlers it's for, which are those registered when
+// it was emitted, or the one being caught up on past events.
+type queuedEvent struct {
+	ev       Event
+	handlers []*subscriber
+}
+
+// A registered handler, which stops receiving events once removed, even those
+// already queued for it.
+type subscriber struct {
+	handler EventHandler
+	removed atomic.Bool
+}
+
+func newEventDispatcher(handlers []EventHandler) *eventDispatcher {
+	d := &eventDispatcher{}
+	for _, handler := range handlers {
+		d.handlers = append(d.handlers, &subscriber{handler: handler})
+	}
+	return d
+}
+
+func (d *eventDispatcher) emit(ev Event) {
+	if d == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.replay > 0 {
+		if len(d.history) == d.replay {
+			d.history[0] = nil
+			d.history = d.history[1:]
+		}
+		d.history = append(d.history, ev)
+	}
+	if len(d.handlers) == 0 {
+		return
+	}
+	d.enqueue(queuedEvent{ev: ev, handlers: d.handlers})
+}
+
+// Register a handler, which is first sent the events kept for replay.
+func (d *eventDispatcher) addHandler(handler EventHandler) *subscriber {
+	sub := &subscriber{handler: handler}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, ev := range d.history {
+		d.enqueue(queuedEvent{ev: ev, handlers: []*subscriber{sub}})
+	}
+	// Events already queued keep the handlers they were emitted to, so
+	// the slice is copied rather than appended to in place.
+	handlers := make([]*subscriber, 0, len(d.handlers)+1)
+	handlers = append(handlers, d.handlers...)
+	d.handlers = append(handlers, sub)
+	return sub
+}
+
+func (d *eventDispatcher) removeHandler(sub *subscriber) {
+	sub.removed.Store(true)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	handlers := make([]*subscriber, 0, len(d.handlers))
+	for _, h := range d.handlers {
+		if h != sub {
+			handlers = append(handlers, h)
+		}
+	}
+	d.handlers = handlers
+}
+
+// Must be called with the lock held.
+func (d *eventDispatcher) enqueue(qe queuedEvent) {
+	d.queue = append(d.queue, qe)
+	d.maxQueue = max(d.maxQueue, len(d.queue))
+	if !d.draining {
+		d.draining = true
+		d.drained = make(chan struct{})
+		go d.drain()
+	}
+}
+
+func (d *eventDispatcher) maxQueued() int64 {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return int64(d.maxQueue)
+}
+
+// Wait for the events queued so far to be delivered.
+func (d *eventDispatcher) wait(ctx context.Context) error {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	draining, drained := d.draining, d.drained
+	d.mu.Unlock()
+	if !draining {
+		return nil
+	}
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *eventDispatcher) drain() {
+	for {
+		d.mu.Lock()
+		if len(d.queue) == 0 {
+			d.draining = false
+			close(d.drained)
+			d.mu.Unlock()
+			return
+		}
+		qe := d.queue[0]
+		d.queue[0] = queuedEvent{}
+		d.queue = d.queue[1:]
+		d.mu.Unlock()
+
+		for _, sub := range qe.handlers {
+			if !sub.removed.Load() {
+				sub.handler(qe.ev)
+			}
+		}
+	}
+}
+
+// Tracks the connections open on a tunnel so that its closed event can be
+// deferred until after their closed events.
+type tunnelEvents struct {
+	mu         sync.Mutex
+	open       int
+	closed     bool
+	closedSent bool
+}
+
+// Record a newly accepted connection. Returns false if the tunnel's closed
+// event has already been emitted, in which case the connection is not
+// tracked.
+func (t *tunnelImpl) connOpened(conn *connImpl) bool {
+	if t.events == nil {
+		return false
+	}
+	t.eventState.mu.Lock()
+	defer t.eventState.mu.Unlock()
+	if t.eventState.closedSent {
+		return false
+	}
+	t.eventState.open++
+	t.events.emit(&EventConnectionOpened{
+		baseEvent: newBaseEvent(EventTypeConnectionOpened),
+		Tunnel:    t,
+		Conn:      conn,
+	})
+	return true
+}
+
+func (t *tunnelImpl) connClosed(conn *connImpl) {
+	t.eventState.mu.Lock()
+	defer t.eventState.mu.Unlock()
+	t.eventState.open--
+	t.events.emit(&EventConnectionClosed{
+		baseEvent:    newBaseEvent(EventTypeConnectionClosed),
+		Tunnel:       t,
+		Conn:         conn,
+		Duration:     time.Since(conn.opened),
+		BytesRead:    conn.bytesRead.Load(),
+		BytesWritten: conn.bytesWritten.Load(),
+		CloseReason:  conn.CloseReason(),
+	})
+	t.maybeSendClosed()
+}
+
+func (t *tunnelImpl) tunnelClosed() {
+	// Every concurrent Accept caller sees the tunnel close, but only the
+	// first cleans up after it.
+	if t.closed.Swap(true) {
+		return
+	}
+	t.stopped.end()
+	t.stopHealthCheck()
+	if t.redirect != nil {
+		t.goroutines.spawn(func() { _ = t.redirect.Close() })
+	}
+	if !t.drains {
+		t.life.end()
+	}
+	if s, ok := t.Sess.(*sessionImpl); ok {
+		s.removeTunnel(t)
+	}
+	if t.events == nil {
+		return
+	}
+	t.eventState.mu.Lock()
+	defer t.eventState.mu.Unlock()
+	t.eventState.closed = true
+	t.maybeSendClosed()
+}
+
+// Must be called with the event state lock held.
+func (t *tunnelImpl) maybeSendClosed() {
+	s := &t.eventState
+	if s.closed && s.open == 0 && !s.closedSent {
+		s.closedSent = true
+		t.events.emit(&EventTunnelClosed{
+			baseEvent: newBaseEvent(EventTypeTunnelClosed),
+			Tunnel:    t,
+		})
+	}
+}
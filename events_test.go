@@ -0,0 +1,223 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// Collects events delivered to a handler.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) handle(ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, ev)
+}
+
+func (r *eventRecorder) types() []EventType {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := make([]EventType, 0, len(r.events))
+	for _, ev := range r.events {
+		types = append(types, ev.EventType())
+	}
+	return types
+}
+
+func (r *eventRecorder) waitFor(t *testing.T, n int) []EventType {
+	require.Eventually(t, func() bool {
+		return len(r.types()) >= n
+	}, time.Second, time.Millisecond)
+	return r.types()
+}
+
+func TestEventDispatchOrder(t *testing.T) {
+	var mu sync.Mutex
+	var first, second []int64
+	record := func(into *[]int64) EventHandler {
+		return func(ev Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			*into = append(*into, ev.Timestamp().UnixNano())
+		}
+	}
+	d := newEventDispatcher([]EventHandler{record(&first), record(&second)})
+
+	for i := 0; i < 1000; i++ {
+		d.emit(&EventSessionConnected{baseEvent: baseEvent{OccurredAt: time.Unix(0, int64(i))}})
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(second) == 1000
+	}, time.Second, time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range first {
+		require.Equal(t, int64(i), first[i])
+	}
+	require.Equal(t, first, second)
+}
+
+func TestEventReplay(t *testing.T) {
+	early := &eventRecorder{}
+	d := newEventDispatcher([]EventHandler{early.handle})
+	d.replay = 2
+
+	emit := func(i int64) {
+		d.emit(&EventSessionConnected{baseEvent: baseEvent{OccurredAt: time.Unix(0, i)}})
+	}
+	for i := int64(0); i < 3; i++ {
+		emit(i)
+	}
+
+	late := &eventRecorder{}
+	d.addHandler(late.handle)
+	emit(3)
+
+	early.waitFor(t, 4)
+	late.waitFor(t, 3)
+	stamps := func(r *eventRecorder) []int64 {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		var out []int64
+		for _, ev := range r.events {
+			out = append(out, ev.Timestamp().UnixNano())
+		}
+		return out
+	}
+	require.Equal(t, []int64{0, 1, 2, 3}, stamps(early))
+	// Only the last two events are replayed, followed by the new one.
+	require.Equal(t, []int64{1, 2, 3}, stamps(late))
+}
+
+func TestRemoveEventHandler(t *testing.T) {
+	d := newEventDispatcher(nil)
+	kept, removed := &eventRecorder{}, &eventRecorder{}
+	d.addHandler(kept.handle)
+	sub := d.addHandler(removed.handle)
+
+	d.emit(&EventSessionConnected{baseEvent: newBaseEvent(EventTypeSessionConnected)})
+	kept.waitFor(t, 1)
+	removed.waitFor(t, 1)
+
+	d.removeHandler(sub)
+	d.emit(&EventSessionDisconnected{baseEvent: newBaseEvent(EventTypeSessionDisconnected)})
+	kept.waitFor(t, 2)
+	require.NoError(t, d.wait(context.Background()))
+	require.Equal(t, []EventType{EventTypeSessionConnected}, removed.types())
+}
+
+func TestEventChannel(t *testing.T) {
+	d := newEventDispatcher(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	events := d.channel(ctx)
+
+	d.emit(&EventSessionConnected{baseEvent: newBaseEvent(EventTypeSessionConnected)})
+	require.Equal(t, EventTypeSessionConnected, (<-events).EventType())
+
+	cancel()
+	for range events {
+	}
+	// Events after the channel closed are dropped without blocking.
+	d.emit(&EventSessionDisconnected{baseEvent: newBaseEvent(EventTypeSessionDisconnected)})
+	require.NoError(t, d.wait(context.Background()))
+}
+
+func TestTunnelClosedAfterConnections(t *testing.T) {
+	rec := &eventRecorder{}
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 2)}
+	tun := &tunnelImpl{
+		Tunnel: q,
+		events: newEventDispatcher([]EventHandler{rec.handle}),
+	}
+
+	for i := 0; i < 2; i++ {
+		local, _ := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	}
+	first, err := tun.Accept()
+	require.NoError(t, err)
+	second, err := tun.Accept()
+	require.NoError(t, err)
+
+	require.NoError(t, tun.Close())
+	require.NoError(t, first.Close())
+	require.Equal(t, []EventType{
+		EventTypeConnectionOpened,
+		EventTypeConnectionOpened,
+		EventTypeConnectionClosed,
+	}, rec.waitFor(t, 3))
+
+	// Closing twice only reports once.
+	require.NoError(t, second.Close())
+	require.NoError(t, second.Close())
+	require.Equal(t, []EventType{
+		EventTypeConnectionOpened,
+		EventTypeConnectionOpened,
+		EventTypeConnectionClosed,
+		EventTypeConnectionClosed,
+		EventTypeTunnelClosed,
+	}, rec.waitFor(t, 5))
+
+	require.Never(t, func() bool { return len(rec.types()) > 5 }, 50*time.Millisecond, time.Millisecond)
+}
+
+func TestConnectionClosedEventCounts(t *testing.T) {
+	rec := &eventRecorder{}
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{
+		Tunnel: q,
+		events: newEventDispatcher([]EventHandler{rec.handle}),
+	}
+
+	local, remote := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	go func() {
+		_, _ = remote.Write([]byte("hello"))
+		_, _ = remote.Read(make([]byte, 3))
+	}()
+	_, err = conn.Read(make([]byte, 5))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("bye"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	rec.waitFor(t, 2)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	closed, ok := rec.events[1].(*EventConnectionClosed)
+	require.True(t, ok)
+	require.Equal(t, int64(5), closed.BytesRead)
+	require.Equal(t, int64(3), closed.BytesWritten)
+	require.Equal(t, conn, closed.Conn)
+}
+
+func TestEventDispatcherWait(t *testing.T) {
+	release := make(chan struct{})
+	d := newEventDispatcher([]EventHandler{func(Event) { <-release }})
+	d.emit(&EventSessionConnected{baseEvent: newBaseEvent(EventTypeSessionConnected)})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, d.wait(ctx), context.DeadlineExceeded)
+
+	close(release)
+	require.NoError(t, d.wait(context.Background()))
+	require.NoError(t, (*eventDispatcher)(nil).wait(context.Background()))
+}
@@ -9,6 +9,7 @@ import (
 
 	"golang.ngrok.com/ngrok"
 	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/ngrokconn"
 )
 
 func main() {
@@ -30,10 +31,11 @@ func run(ctx context.Context) error {
 	var serv fasthttp.Server
 
 	serv.Handler = func(ctx *fasthttp.RequestCtx) {
-		fmt.Fprintf(ctx, "Hello! You're requesting %q", ctx.RequestURI())
+		md, _ := ngrokconn.FromConn(ctx.Conn())
+		fmt.Fprintf(ctx, "Hello %s! You're requesting %q from %s", md.ClientAddr, ctx.RequestURI(), md.TunnelURL)
 	}
 
-	err = serv.Serve(tun)
+	err = serv.Serve(ngrokconn.Listener(tun))
 	if err != nil {
 		return err
 	}
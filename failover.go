@@ -0,0 +1,123 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// How long to wait for each connect address to answer a latency probe.
+const failoverProbeTimeout = 2 * time.Second
+
+// WithConnectFailover makes the [Session] fail over across the connect
+// addresses the ngrok service returns when it authenticates, rather than
+// only retrying the server it was configured with. Each time the session
+// reconnects, it probes the addresses with a TCP connect and tries them in
+// order of lowest latency until one of them connects.
+//
+// If a region was chosen with [WithRegion], only the addresses in that region
+// are used. Failover applies to the primary leg only, since [WithMultiLeg]
+// already spreads the session across the connect addresses.
+func WithConnectFailover(enable bool) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ConnectFailover = enable
+	}
+}
+
+// The connect addresses a session may fail over to.
+type connectFailover struct {
+	dialer Dialer
+	region string
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+// Record the connect addresses returned by the most recent authentication.
+func (f *connectFailover) update(addrs []proto.ConnectAddress) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.addrs = f.addrs[:0]
+	for _, addr := range addrs {
+		if f.region != "" && addr.Region != f.region {
+			continue
+		}
+		f.addrs = append(f.addrs, addr.ServerAddr)
+	}
+}
+
+// Return the candidate addresses, starting with primary, ordered by the
+// latency of a TCP connect to each. Addresses that didn't answer the probe
+// come last, in their original order.
+func (f *connectFailover) order(ctx context.Context, primary string) []string {
+	f.mu.Lock()
+	candidates := []string{primary}
+	for _, addr := range f.addrs {
+		if addr != primary {
+			candidates = append(candidates, addr)
+		}
+	}
+	f.mu.Unlock()
+	if len(candidates) == 1 {
+		return candidates
+	}
+
+	latencies := make([]time.Duration, len(candidates))
+	ctx, cancel := context.WithTimeout(ctx, failoverProbeTimeout)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i, addr := range candidates {
+		wg.Add(1)
+		go func(i int, addr string) {
+			defer wg.Done()
+			start := time.Now()
+			conn, err := f.dialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				latencies[i] = -1
+				return
+			}
+			latencies[i] = time.Since(start)
+			_ = conn.Close()
+		}(i, addr)
+	}
+	wg.Wait()
+
+	indices := make([]int, len(candidates))
+	for i := range indices {
+		indices[i] = i
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		la, lb := latencies[indices[a]], latencies[indices[b]]
+		if la < 0 || lb < 0 {
+			return lb < 0 && la >= 0
+		}
+		return la < lb
+	})
+	ordered := make([]string, len(candidates))
+	for i, idx := range indices {
+		ordered[i] = candidates[idx]
+	}
+	return ordered
+}
+
+// Dial the candidate addresses in order until one connects, returning the
+// connection and its address.
+func (f *connectFailover) dial(ctx context.Context, primary string) (net.Conn, string, error) {
+	var errs error
+	for _, addr := range f.order(ctx, primary) {
+		conn, err := f.dialer.DialContext(ctx, "tcp", addr)
+		if err == nil {
+			return conn, addr, nil
+		}
+		errs = errors.Join(errs, errSessionDial{addr, err})
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, primary, errs
+}
@@ -0,0 +1,87 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// A dialer that takes a fixed time to connect to each address, and fails to
+// connect to the ones without one.
+type latencyDialer struct {
+	latencies map[string]time.Duration
+
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (d *latencyDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *latencyDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dialed = append(d.dialed, address)
+	d.mu.Unlock()
+	latency, ok := d.latencies[address]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+	time.Sleep(latency)
+	local, _ := net.Pipe()
+	return local, nil
+}
+
+func TestConnectFailoverOrder(t *testing.T) {
+	dialer := &latencyDialer{latencies: map[string]time.Duration{
+		"us:443": 30 * time.Millisecond,
+		"eu:443": time.Millisecond,
+		"ap:443": 15 * time.Millisecond,
+	}}
+	f := &connectFailover{dialer: dialer}
+	require.Equal(t, []string{"us:443"}, f.order(context.Background(), "us:443"))
+
+	f.update([]proto.ConnectAddress{
+		{Region: "us", ServerAddr: "us:443"},
+		{Region: "in", ServerAddr: "in:443"},
+		{Region: "eu", ServerAddr: "eu:443"},
+		{Region: "ap", ServerAddr: "ap:443"},
+	})
+	require.Equal(t, []string{"eu:443", "ap:443", "us:443", "in:443"}, f.order(context.Background(), "us:443"))
+
+	// Region pinning keeps the session in its region.
+	f = &connectFailover{dialer: dialer, region: "us"}
+	f.update([]proto.ConnectAddress{
+		{Region: "us", ServerAddr: "us:443"},
+		{Region: "eu", ServerAddr: "eu:443"},
+	})
+	require.Equal(t, []string{"us:443"}, f.order(context.Background(), "us:443"))
+}
+
+func TestConnectFailoverDial(t *testing.T) {
+	dialer := &latencyDialer{latencies: map[string]time.Duration{
+		"eu:443": time.Millisecond,
+	}}
+	f := &connectFailover{dialer: dialer}
+	f.update([]proto.ConnectAddress{
+		{Region: "us", ServerAddr: "us:443"},
+		{Region: "eu", ServerAddr: "eu:443"},
+	})
+
+	conn, addr, err := f.dial(context.Background(), "us:443")
+	require.NoError(t, err)
+	require.NotNil(t, conn)
+	require.Equal(t, "eu:443", addr)
+
+	dialer.latencies = nil
+	_, _, err = f.dial(context.Background(), "us:443")
+	var dialErr errSessionDial
+	require.ErrorAs(t, err, &dialErr)
+}
@@ -6,14 +6,17 @@ import (
 	"crypto/tls"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/inconshreveable/log15/v3"
 	"golang.org/x/sync/errgroup"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
 )
 
 // Forwarder is a tunnel that has every connection forwarded to some URL.
@@ -23,13 +26,15 @@ type Forwarder interface {
 
 	// Close is a convenience method for calling Tunnel.CloseWithContext
 	// with a context that has a timeout of 5 seconds. This also allows the
-	// Tunnel to satisfy the io.Closer interface.
+	// Tunnel to satisfy the io.Closer interface. Connections that are
+	// already being forwarded are left to finish on their own.
 	Close() error
 
-	// CloseWithContext closes the Tunnel. Closing a tunnel is an operation
+	// CloseWithContext closes the Tunnel and then waits for the connections
+	// that are being forwarded to finish. Closing a tunnel is an operation
 	// that involves sending a "close" message over the parent session.
-	// Since this is a network operation, it is most correct to provide a
-	// context with a timeout.
+	// If the context expires first, any remaining connections are closed
+	// and the context's error is returned.
 	CloseWithContext(context.Context) error
 
 	// Session returns the tunnel's parent Session object that it
@@ -44,16 +49,74 @@ type Forwarder interface {
 type forwarder struct {
 	Tunnel
 	mainGroup *errgroup.Group
+	// The connections being forwarded, if any.
+	active *activeConns
 }
 
 func (fwd *forwarder) Wait() error {
 	return fwd.mainGroup.Wait()
 }
 
+func (fwd *forwarder) CloseWithContext(ctx context.Context) error {
+	if err := fwd.Tunnel.CloseWithContext(ctx); err != nil {
+		return err
+	}
+	if fwd.active == nil {
+		return nil
+	}
+	return fwd.active.drain(ctx)
+}
+
+// Tracks the connections being forwarded so that they can be drained.
+type activeConns struct {
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+func (a *activeConns) add(conn net.Conn) {
+	a.wg.Add(1)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.conns == nil {
+		a.conns = make(map[net.Conn]struct{})
+	}
+	a.conns[conn] = struct{}{}
+}
+
+func (a *activeConns) done(conn net.Conn) {
+	a.mu.Lock()
+	delete(a.conns, conn)
+	a.mu.Unlock()
+	a.wg.Done()
+}
+
+// Wait for all connections to finish, closing them if the context expires
+// first.
+func (a *activeConns) drain(ctx context.Context) error {
+	drained := make(chan struct{})
+	go func() {
+		a.wg.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		a.mu.Lock()
+		for conn := range a.conns {
+			conn.Close()
+		}
+		a.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
 // compile-time check that we're implementing the proper interface
 var _ Forwarder = (*forwarder)(nil)
 
-func join(logger log15.Logger, left, right net.Conn) {
+func join(logger *slog.Logger, left, right net.Conn) {
 	g := &sync.WaitGroup{}
 	g.Add(2)
 	go func() {
@@ -71,15 +134,36 @@ func join(logger log15.Logger, left, right net.Conn) {
 	g.Wait()
 }
 
-func forwardTunnel(ctx context.Context, tun Tunnel, url *url.URL) Forwarder {
-	mainGroup, ctx := errgroup.WithContext(ctx)
-	fwdTasks := &sync.WaitGroup{}
+func forwardTunnel(parent context.Context, tun Tunnel, url *url.URL, opts upstream.Options) Forwarder {
+	mainGroup, ctx := errgroup.WithContext(parent)
+	active := &activeConns{}
 
 	sess := tun.Session()
 	sessImpl := sess.(*sessionImpl)
-	logger := sessImpl.inner().Logger.New("task", "forward", "toUrl", url, "tunnelUrl", tun.URL())
+	logger := sessImpl.inner().Logger.With("task", "forward", "toUrl", url, "tunnelUrl", tun.URL())
+
+	if opts.RoundRobin {
+		opts.Dial = newRoundRobinDialer(baseUpstreamDial(opts), opts.Resolver).DialContext
+	}
 
-	mainGroup.Go(func() error {
+	if len(opts.HostRoutes) > 0 || opts.Selector != nil || limitsRequests(opts) || opts.ForwardedHeaders != upstream.ForwardedHeadersNone || opts.Identity != nil {
+		return forwardHTTP(ctx, mainGroup, logger, tun, url, opts, sessImpl.upstreamFailed(tun))
+	}
+
+	// Forwarded connections outlive the tunnel until they're drained, so
+	// their contexts are canceled once they have all finished.
+	impl, _ := tun.(*tunnelImpl)
+	if impl != nil {
+		impl.drains = true
+	}
+
+	mainGroup.Go(sessImpl.goroutines.guard(func() error {
+		if impl != nil {
+			defer sessImpl.goroutines.spawn(func() {
+				active.wg.Wait()
+				impl.life.end()
+			})
+		}
 		for {
 			if ctxErr := ctx.Err(); ctxErr != nil {
 				return ctxErr
@@ -90,24 +174,101 @@ func forwardTunnel(ctx context.Context, tun Tunnel, url *url.URL) Forwarder {
 				return err
 			}
 			logger.Debug("accept connection from", "address", conn.RemoteAddr())
-			fwdTasks.Add(1)
+			active.add(conn)
 
-			go func() {
+			sessImpl.goroutines.spawn(func() {
+				defer active.done(conn)
+				// Closed already unless forwarding panicked.
+				defer conn.Close()
 				ngrokConn := conn.(Conn)
+				tunnelConn := ngrokConn
+
+				// Stop forwarding when either the connection or the
+				// forwarder is done.
+				connCtx, cancel := context.WithCancel(ngrokConn.Context())
+				defer cancel()
+				defer context.AfterFunc(parent, cancel)()
 
-				backend, err := openBackend(ctx, logger, tun, ngrokConn, url)
+				if opts.SpillDir != "" {
+					ngrokConn = newSpillConn(ngrokConn, opts.SpillDir, opts.SpillMaxBytes, &sessImpl.goroutines)
+				}
+				if opts.IdleTimeout > 0 {
+					var stop func()
+					ngrokConn, stop = closeWhenIdle(ngrokConn, sessImpl.clock(), opts.IdleTimeout, cancel)
+					defer stop()
+				}
+				if opts.WebSocketKeepalive > 0 && isHTTP(tunnelConn.Proto()) {
+					var stop func()
+					ngrokConn, stop = keepWebSocketAlive(ngrokConn, tunnelConn, opts.WebSocketKeepalive, cancel)
+					defer stop()
+				}
+
+				target := url
+				if len(opts.SNIRoutes) > 0 && ngrokConn.PassthroughTLS() {
+					var serverName string
+					ngrokConn, target, serverName = routeSNI(ngrokConn, url, opts.SNIRoutes)
+					logger.Debug("routed by server name", "serverName", serverName, "url", target)
+				}
+
+				backend, err := openBackend(connCtx, logger, tun, ngrokConn, target, opts)
 				if err != nil {
 					defer ngrokConn.Close()
 					logger.Warn("failed to connect to backend url", "error", err)
-					fwdTasks.Done()
+					sessImpl.upstreamFailed(tun)(target, err)
 					return
 				}
 
-				join(logger.New("url", url), ngrokConn, backend)
-				fwdTasks.Done()
-			}()
+				connLogger := logger.With("url", target)
+				joinContext(connCtx, connLogger, ngrokConn, &upstreamConn{Conn: backend, tunnelConn: ngrokConn})
+				if impl := acceptedConn(ngrokConn); impl != nil {
+					connLogger.Debug("connection closed",
+						"address", conn.RemoteAddr(),
+						"reason", impl.CloseReason().Kind,
+						"duration", time.Since(impl.opened),
+						"bytesRead", impl.bytesRead.Load(),
+						"bytesWritten", impl.bytesWritten.Load(),
+					)
+				}
+			})
 		}
-	})
+	}))
+
+	return &forwarder{
+		Tunnel:    tun,
+		mainGroup: mainGroup,
+		active:    active,
+	}
+}
+
+// Serve HTTP on the tunnel, proxying each request to the upstream chosen by its
+// Host header or the upstream selector, within the configured request limits.
+func forwardHTTP(ctx context.Context, mainGroup *errgroup.Group, logger *slog.Logger, tun Tunnel, url *url.URL, opts upstream.Options, onError func(*url.URL, error)) Forwarder {
+	handler := newHostRouter(logger, url, opts, onError)
+	if opts.Identity != nil {
+		handler = forwardIdentity(handler, opts.Identity, time.Now)
+	}
+	if opts.MaxBodyBytes > 0 {
+		handler = limitRequestBody(handler, opts.MaxBodyBytes)
+	}
+	server := &http.Server{
+		Handler:           handler,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+		IdleTimeout:       opts.IdleTimeout,
+		MaxHeaderBytes:    opts.MaxHeaderBytes,
+		ReadHeaderTimeout: opts.ReadHeaderTimeout,
+		ReadTimeout:       opts.ReadTimeout,
+		// Requests are proxied with the client address and protocol from
+		// the proxy header of the connection they arrived on.
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, tunnelConnKey{}, conn)
+		},
+	}
+	configureHTTPServer(server, opts.HTTPServer, logger)
+	// Close the server along with the tunnel so that it can drain requests.
+	if impl, ok := tun.(*tunnelImpl); ok {
+		impl.server = server
+	}
+	mainGroup.Go(func() error { return server.Serve(listenerFor(tun)) })
 
 	return &forwarder{
 		Tunnel:    tun,
@@ -116,19 +277,24 @@ func forwardTunnel(ctx context.Context, tun Tunnel, url *url.URL) Forwarder {
 }
 
 // TODO: use an actual reverse proxy for http/s tunnels so that the host header gets set?
-func openBackend(ctx context.Context, logger log15.Logger, tun Tunnel, tunnelConn Conn, url *url.URL) (net.Conn, error) {
-	host := url.Hostname()
-	port := url.Port()
-	if port == "" {
-		switch {
-		case usesTLS(url.Scheme):
-			port = "443"
-		case isHTTP(url.Scheme):
-			port = "80"
-		default:
-			return nil, fmt.Errorf("no default tcp port available for %s", url.Scheme)
+func openBackend(ctx context.Context, logger *slog.Logger, tun Tunnel, tunnelConn Conn, url *url.URL, opts upstream.Options) (net.Conn, error) {
+	network, address := "tcp", ""
+	if isUnix(url.Scheme) {
+		network, address = "unix", url.Path
+	} else {
+		port := url.Port()
+		if port == "" {
+			switch {
+			case usesTLS(url.Scheme):
+				port = "443"
+			case isHTTP(url.Scheme):
+				port = "80"
+			default:
+				return nil, fmt.Errorf("no default tcp port available for %s", url.Scheme)
+			}
+			logger.Debug("set default port", "port", port)
 		}
-		logger.Debug("set default port", "port", port)
+		address = net.JoinHostPort(url.Hostname(), port)
 	}
 	var appProto string
 	if fwdProto, ok := tun.(interface{ ForwardsProto() string }); ok {
@@ -138,10 +304,7 @@ func openBackend(ctx context.Context, logger log15.Logger, tun Tunnel, tunnelCon
 	// Create TLS config if necessary
 	var tlsConfig *tls.Config
 	if usesTLS(url.Scheme) {
-		tlsConfig = &tls.Config{
-			ServerName:    url.Hostname(),
-			Renegotiation: tls.RenegotiateOnceAsClient,
-		}
+		tlsConfig = upstreamTLSConfig(opts, url.Hostname())
 		// If the backend is TLS and we've requested HTTP2, we'll need to
 		// make the backend aware of that via ALPN.
 		if appProto == "http2" {
@@ -150,12 +313,12 @@ func openBackend(ctx context.Context, logger log15.Logger, tun Tunnel, tunnelCon
 		}
 	}
 
-	dialer := &net.Dialer{}
-	address := fmt.Sprintf("%s:%s", host, port)
-	logger.Debug("dial backend tcp", "address", address)
+	dial := upstreamDial(opts)
+	logger.Debug("dial backend", "network", network, "address", address)
 
-	conn, err := dialer.DialContext(ctx, "tcp", address)
+	conn, err := dial(ctx, network, address)
 	if err != nil {
+		setCloseReason(tunnelConn, &dialErrorCloseReason)
 		defer tunnelConn.Close()
 
 		// TODO: this http error is only valid for http/1.1. If the edge is
@@ -170,12 +333,33 @@ func openBackend(ctx context.Context, logger log15.Logger, tun Tunnel, tunnelCon
 
 	if usesTLS(url.Scheme) && !tunnelConn.PassthroughTLS() {
 		logger.Debug("establishing TLS connection with backend")
-		return tls.Client(conn, tlsConfig), nil
+		// Handshake up front so that a certificate that can't be verified
+		// is reported rather than silently dropping the connection.
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			setCloseReason(tunnelConn, &dialErrorCloseReason)
+			defer tunnelConn.Close()
+			if isHTTP(tunnelConn.Proto()) && appProto != "http2" {
+				_ = writeHTTPError(tunnelConn, err)
+			}
+			return nil, errUpstreamTLS{url.String(), err}
+		}
+		return tlsConn, nil
 	}
 
 	return conn, nil
 }
 
+// The function used to connect to the upstream service: the configured one, or
+// a dialer with the configured socket options.
+func baseUpstreamDial(opts upstream.Options) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if opts.Dial != nil {
+		return opts.Dial
+	}
+	return (&net.Dialer{Control: opts.Control, KeepAlive: opts.KeepAlive, Resolver: opts.Resolver}).DialContext
+}
+
 func writeHTTPError(w io.Writer, err error) error {
 	resp := &http.Response{}
 	resp.StatusCode = http.StatusBadGateway
@@ -192,6 +376,10 @@ func usesTLS(scheme string) bool {
 	}
 }
 
+func isUnix(scheme string) bool {
+	return strings.ToLower(scheme) == "unix"
+}
+
 func isHTTP(scheme string) bool {
 	switch strings.ToLower(scheme) {
 	case "https", "http":
@@ -0,0 +1,317 @@
+package ngrok
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/muxado/v2"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// The payload sizes that the forwarding benchmarks send each way.
+var forwardPayloads = []struct {
+	name string
+	size int
+}{
+	{"small", 64},
+	{"large", 1 << 20},
+}
+
+// The most allocations a round trip through the forwarder may make, by
+// upstream mode and payload. These are about twice what's measured, so that
+// they only trip on real regressions, and should be lowered along with any
+// improvement to the hot path.
+var forwardAllocBudgets = map[string]map[string]float64{
+	"tcp":  {"small": 100, "large": 500},
+	"tls":  {"small": 2000, "large": 2200},
+	"http": {"small": 200, "large": 600},
+}
+
+func BenchmarkForward(b *testing.B) {
+	for _, mode := range []string{"tcp", "tls", "http"} {
+		for _, payload := range forwardPayloads {
+			b.Run(mode+"/"+payload.name, func(b *testing.B) {
+				fb := newForwardBench(b, mode)
+				data := bytes.Repeat([]byte{'x'}, payload.size)
+				b.SetBytes(int64(payload.size))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if err := fb.roundTrip(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestForwardAllocBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping allocation budgets in short mode")
+	}
+	for mode, budgets := range forwardAllocBudgets {
+		for _, payload := range forwardPayloads {
+			t.Run(mode+"/"+payload.name, func(t *testing.T) {
+				fb := newForwardBench(t, mode)
+				data := bytes.Repeat([]byte{'x'}, payload.size)
+				// Warm up pools and the TLS session cache before measuring.
+				require.NoError(t, fb.roundTrip(data))
+
+				var err error
+				allocs := testing.AllocsPerRun(20, func() {
+					if rtErr := fb.roundTrip(data); rtErr != nil {
+						err = rtErr
+					}
+				})
+				require.NoError(t, err)
+				t.Logf("%.0f allocations per round trip", allocs)
+				require.LessOrEqual(t, allocs, budgets[payload.name], "forwarding allocated more than its budget")
+			})
+		}
+	}
+}
+
+// Forwards the connections of a tunnel to an in-memory upstream. The tunnel's
+// connections are muxado streams opened by a fake edge, as they are from the
+// ngrok service.
+type forwardBench struct {
+	mode     string
+	edge     muxado.Session
+	fwd      *forwarder
+	upstream *pipeListener
+	// The size of the payload the upstream should echo.
+	size atomic.Int64
+	// The TLS configuration of the client connecting through the tunnel.
+	clientTLS *tls.Config
+}
+
+func newForwardBench(tb testing.TB, mode string) *forwardBench {
+	edgeSide, agentSide := net.Pipe()
+	edge := muxado.Server(edgeSide, nil)
+	agent := muxado.Client(agentSide, nil)
+
+	header := proto.ProxyHeader{Proto: mode, PassthroughTLS: mode == "tls"}
+	queued := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn)}
+	go func() {
+		for {
+			stream, err := agent.AcceptStream()
+			if err != nil {
+				close(queued.conns)
+				return
+			}
+			queued.conns <- &tunnel_client.ProxyConn{Header: header, Conn: stream}
+		}
+	}()
+
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{Logger: slog.New(discardHandler{})})
+	sess.acct = &sessionAccounting{session: sess}
+	tun := &tunnelImpl{
+		Sess:       sess,
+		Tunnel:     &benchTunnel{queuedTunnel: queued},
+		acct:       sess.acct,
+		goroutines: &sess.goroutines,
+	}
+
+	fb := &forwardBench{mode: mode, edge: edge, upstream: newPipeListener()}
+	switch mode {
+	case "tcp":
+		go fb.serveEcho(fb.upstream)
+	case "tls":
+		cert := testCert(tb, "localhost")
+		pool := x509.NewCertPool()
+		pool.AddCert(cert.Leaf)
+		fb.clientTLS = &tls.Config{ServerName: "localhost", RootCAs: pool, ClientSessionCache: tls.NewLRUClientSessionCache(1)}
+		go fb.serveEcho(tls.NewListener(fb.upstream, &tls.Config{Certificates: []tls.Certificate{cert}}))
+	case "http":
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+			_, _ = io.Copy(w, r.Body)
+		})}
+		go func() { _ = server.Serve(fb.upstream) }()
+	}
+
+	opts := upstream.Options{
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			return fb.upstream.dial()
+		},
+	}
+	fb.fwd = forwardTunnel(context.Background(), tun, &url.URL{Scheme: mode, Host: "localhost:8080"}, opts).(*forwarder)
+
+	tb.Cleanup(func() {
+		_ = edge.Close()
+		_ = agent.Close()
+		_ = fb.upstream.Close()
+		_ = fb.fwd.Wait()
+	})
+	return fb
+}
+
+// Send the payload through the tunnel as a client would, and wait for the
+// upstream to echo it back and the forwarder to close the connection.
+func (fb *forwardBench) roundTrip(payload []byte) error {
+	fb.size.Store(int64(len(payload)))
+	stream, err := fb.edge.OpenStream()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		stream.Close()
+		fb.fwd.active.wg.Wait()
+	}()
+
+	var client net.Conn = stream
+	if fb.clientTLS != nil {
+		client = tls.Client(stream, fb.clientTLS)
+	}
+
+	// Clients write and read at the same time, which keeps large payloads
+	// from filling the flow control windows.
+	written := make(chan error, 1)
+	go func() {
+		if fb.mode == "http" {
+			_, err := fmt.Fprintf(client, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", len(payload))
+			if err != nil {
+				written <- err
+				return
+			}
+		}
+		_, err := client.Write(payload)
+		written <- err
+	}()
+
+	var n int64
+	if fb.mode == "http" {
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil {
+			return err
+		}
+		n, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+	} else if n, err = io.Copy(io.Discard, client); err != nil {
+		return err
+	}
+	if err := <-written; err != nil {
+		return err
+	}
+	if n != int64(len(payload)) {
+		return fmt.Errorf("echoed %d bytes, want %d", n, len(payload))
+	}
+	return nil
+}
+
+// Echo the payload sent on each accepted connection, then close it.
+func (fb *forwardBench) serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = io.CopyN(conn, conn, fb.size.Load())
+		}()
+	}
+}
+
+// A tunnel fed by a queue, bound to a fixed URL.
+type benchTunnel struct {
+	*queuedTunnel
+}
+
+func (t *benchTunnel) RemoteBindConfig() *tunnel_client.RemoteBindConfig {
+	return &tunnel_client.RemoteBindConfig{URL: "tcp://bench.ngrok.test:1"}
+}
+
+func (t *benchTunnel) ForwardsProto() string {
+	return ""
+}
+
+// A listener whose connections are in-memory pipes.
+type pipeListener struct {
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+func (l *pipeListener) dial() (net.Conn, error) {
+	local, remote := net.Pipe()
+	select {
+	case l.conns <- remote:
+		return local, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+}
+
+// Generate a self-signed certificate for name, usable by both servers and
+// clients.
+func testCert(tb testing.TB, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(tb, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(tb, err)
+	leaf, err := x509.ParseCertificate(der)
+	require.NoError(tb, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
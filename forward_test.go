@@ -1,13 +1,22 @@
 package ngrok
 
 import (
+	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"net/url"
+	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 
-	"github.com/inconshreveable/log15/v3"
 	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+	"golang.ngrok.com/ngrok/internal/upstream"
 )
 
 func TestHalfCloseJoin(t *testing.T) {
@@ -29,7 +38,7 @@ func TestHalfCloseJoin(t *testing.T) {
 	waitJoinDone := make(chan struct{})
 	go func() {
 		defer close(waitJoinDone)
-		join(log15.New(), ngrokEndpoint, agent)
+		join(slog.New(discardHandler{}), ngrokEndpoint, agent)
 	}()
 
 	_, err = browser.Write([]byte("hello world"))
@@ -44,3 +53,151 @@ func TestHalfCloseJoin(t *testing.T) {
 
 	<-waitJoinDone
 }
+
+func testTunnelConn(t *testing.T, scheme string) (Conn, net.Conn) {
+	tunnelSide, clientSide := net.Pipe()
+	t.Cleanup(func() {
+		tunnelSide.Close()
+		clientSide.Close()
+	})
+	return &connImpl{
+		Conn: tunnelSide,
+		Proxy: &tunnel_client.ProxyConn{
+			Header: proto.ProxyHeader{Proto: scheme},
+			Conn:   tunnelSide,
+		},
+	}, clientSide
+}
+
+func TestOpenBackendUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upstream.sock")
+	l, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = conn.Write([]byte("hello"))
+	}()
+
+	tunnelConn, _ := testTunnelConn(t, "tcp")
+	backend, err := openBackend(context.Background(), slog.New(discardHandler{}), nil, tunnelConn,
+		&url.URL{Scheme: "unix", Path: path}, upstream.Options{})
+	require.NoError(t, err)
+	defer backend.Close()
+
+	var b [len("hello")]byte
+	_, err = io.ReadFull(backend, b[:])
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b[:]))
+}
+
+func TestOpenBackendCustomDialer(t *testing.T) {
+	var network, address string
+	dialed, _ := net.Pipe()
+	opts := upstream.Options{
+		Dial: func(_ context.Context, n, a string) (net.Conn, error) {
+			network, address = n, a
+			return dialed, nil
+		},
+	}
+
+	tunnelConn, _ := testTunnelConn(t, "tcp")
+	backend, err := openBackend(context.Background(), slog.New(discardHandler{}), nil, tunnelConn,
+		&url.URL{Scheme: "http", Host: "example.com"}, opts)
+	require.NoError(t, err)
+	require.Equal(t, dialed, backend)
+	require.Equal(t, "tcp", network)
+	require.Equal(t, "example.com:80", address)
+}
+
+func TestOpenBackendDialerControl(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	var controlled []string
+	opts := upstream.Options{
+		Control: func(network, address string, _ syscall.RawConn) error {
+			controlled = append(controlled, network+" "+address)
+			return nil
+		},
+		KeepAlive: -1,
+	}
+
+	tunnelConn, _ := testTunnelConn(t, "tcp")
+	backend, err := openBackend(context.Background(), slog.New(discardHandler{}), nil, tunnelConn,
+		&url.URL{Scheme: "tcp", Host: l.Addr().String()}, opts)
+	require.NoError(t, err)
+	defer backend.Close()
+	require.Equal(t, []string{"tcp4 " + l.Addr().String()}, controlled)
+
+	// The control function can fail the dial.
+	opts.Control = func(string, string, syscall.RawConn) error {
+		return errors.New("denied")
+	}
+	_, err = openBackend(context.Background(), slog.New(discardHandler{}), nil, tunnelConn,
+		&url.URL{Scheme: "tcp", Host: l.Addr().String()}, opts)
+	require.ErrorContains(t, err, "denied")
+}
+
+// A tunnel whose unbind request never completes.
+type stuckTunnel struct {
+	queuedTunnel
+	unblock chan struct{}
+}
+
+func (s *stuckTunnel) Close() error {
+	<-s.unblock
+	return errors.New("unbind failed")
+}
+
+func TestCloseWithContextBoundsUnbind(t *testing.T) {
+	stuck := &stuckTunnel{unblock: make(chan struct{})}
+	defer close(stuck.unblock)
+	tun := &tunnelImpl{Tunnel: stuck}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, tun.CloseWithContext(ctx), context.DeadlineExceeded)
+}
+
+func TestCloseWithContextProtocolError(t *testing.T) {
+	stuck := &stuckTunnel{unblock: make(chan struct{})}
+	close(stuck.unblock)
+	tun := &tunnelImpl{Tunnel: stuck}
+
+	err := tun.CloseWithContext(context.Background())
+	require.EqualError(t, err, "unbind failed")
+	require.NotErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestForwarderCloseDrains(t *testing.T) {
+	active := &activeConns{}
+	fwd := &forwarder{
+		Tunnel: &tunnelImpl{Tunnel: &queuedTunnel{}},
+		active: active,
+	}
+
+	finished, _ := net.Pipe()
+	active.add(finished)
+	stuck, peer := net.Pipe()
+	active.add(stuck)
+
+	go active.done(finished)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, fwd.CloseWithContext(ctx), context.DeadlineExceeded)
+
+	// The connection that was still being forwarded was cut off.
+	_, err := peer.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+	active.done(stuck)
+
+	require.NoError(t, active.drain(context.Background()))
+}
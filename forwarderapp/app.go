@@ -0,0 +1,349 @@
+// Package forwarderapp is a skeleton for agents that forward a single ngrok
+// endpoint to an upstream service, like the ngrok-forward-lite example, but
+// with the parts that a real agent needs: flag and config file parsing,
+// logging, and a graceful shutdown on SIGINT or SIGTERM that lets the
+// connections being forwarded finish before the session is closed.
+//
+// The simplest agent is a main function that calls [Main]:
+//
+//	func main() {
+//		forwarderapp.Main()
+//	}
+//
+// Agents with their own flags or session options can parse the arguments and
+// run the forwarder themselves:
+//
+//	cfg, err := forwarderapp.ParseArgs(os.Args[0], os.Args[1:])
+//	...
+//	err = forwarderapp.Run(ctx, cfg, ngrok.WithMetadata("my-agent"))
+package forwarderapp
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	stdlog "log"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/log"
+)
+
+// DefaultShutdownTimeout is how long connections are given to finish once
+// the agent is told to stop, unless the config says otherwise.
+const DefaultShutdownTimeout = 30 * time.Second
+
+// Config describes the endpoint to start and where to forward it. It can be
+// read from a YAML file with [LoadConfig], or from flags with [ParseArgs].
+type Config struct {
+	// The authtoken to connect with. Defaults to the NGROK_AUTHTOKEN
+	// environment variable.
+	Authtoken string `yaml:"authtoken"`
+	// The address of the ngrok service. Defaults to the public service.
+	ServerAddr string `yaml:"server_addr"`
+	// The URL or host:port to forward connections to. Addresses without a
+	// scheme are forwarded over TCP.
+	Upstream string `yaml:"upstream"`
+	// The endpoint protocol: "http", "tcp" or "tls". Defaults to "http".
+	Proto string `yaml:"proto"`
+	// The domain of HTTP and TLS endpoints.
+	Domain string `yaml:"domain"`
+	// The reserved address of TCP endpoints.
+	RemoteAddr string `yaml:"remote_addr"`
+	// A traffic policy, in YAML or JSON, to apply to the endpoint.
+	TrafficPolicy string `yaml:"traffic_policy"`
+	// Opaque metadata for the endpoint.
+	Metadata string `yaml:"metadata"`
+	// The level to log at, such as "info" or "debug". Defaults to "info".
+	LogLevel string `yaml:"log_level"`
+	// How long connections are given to finish once the agent is told to
+	// stop. Defaults to [DefaultShutdownTimeout].
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Proto:           "http",
+		LogLevel:        "info",
+		ShutdownTimeout: DefaultShutdownTimeout,
+	}
+}
+
+// LoadConfig reads a YAML config file. Fields that the file leaves out take
+// their defaults.
+func LoadConfig(path string) (*Config, error) {
+	cfg := defaultConfig()
+	if err := cfg.load(path); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer f.Close()
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// ParseArgs parses an agent's command line arguments into a config. The
+// -config flag names a YAML file to read first, and the other flags override
+// the fields it sets. The upstream may be given as the sole positional
+// argument.
+func ParseArgs(name string, args []string) (*Config, error) {
+	cfg := defaultConfig()
+	path, err := cfg.parseFlags(name, args)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		// Parse the flags again over the file's settings, so that the
+		// ones given on the command line win.
+		cfg = defaultConfig()
+		if err := cfg.load(path); err != nil {
+			return nil, err
+		}
+		if _, err := cfg.parseFlags(name, args); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, cfg.validate()
+}
+
+// Parse the flags into the config, returning the config file to read.
+func (cfg *Config) parseFlags(name string, args []string) (string, error) {
+	var (
+		path       string
+		policyFile string
+	)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags] [upstream]\n", name)
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&path, "config", "", "YAML config file to read")
+	fs.StringVar(&cfg.Authtoken, "authtoken", cfg.Authtoken, "authtoken to connect with (default $NGROK_AUTHTOKEN)")
+	fs.StringVar(&cfg.ServerAddr, "server-addr", cfg.ServerAddr, "address of the ngrok service")
+	fs.StringVar(&cfg.Upstream, "upstream", cfg.Upstream, "URL or host:port to forward to")
+	fs.StringVar(&cfg.Proto, "proto", cfg.Proto, "endpoint protocol: http, tcp or tls")
+	fs.StringVar(&cfg.Domain, "domain", cfg.Domain, "domain of HTTP and TLS endpoints")
+	fs.StringVar(&cfg.RemoteAddr, "remote-addr", cfg.RemoteAddr, "reserved address of TCP endpoints")
+	fs.StringVar(&policyFile, "traffic-policy-file", "", "file containing a traffic policy for the endpoint")
+	fs.StringVar(&cfg.Metadata, "metadata", cfg.Metadata, "opaque metadata for the endpoint")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "level to log at")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long connections are given to finish on shutdown")
+	if err := fs.Parse(args); err != nil {
+		return "", err
+	}
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		cfg.Upstream = fs.Arg(0)
+	default:
+		fs.Usage()
+		return "", fmt.Errorf("expected one upstream, got %d arguments", fs.NArg())
+	}
+	if policyFile != "" {
+		buf, err := os.ReadFile(policyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read traffic policy: %w", err)
+		}
+		cfg.TrafficPolicy = string(buf)
+	}
+	return path, nil
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Upstream == "" {
+		return errors.New("no upstream to forward to")
+	}
+	if _, err := cfg.upstreamURL(); err != nil {
+		return err
+	}
+	switch cfg.Proto {
+	case "http", "tcp", "tls":
+	default:
+		return fmt.Errorf("unsupported endpoint protocol %q", cfg.Proto)
+	}
+	if cfg.TrafficPolicy != "" {
+		var policy map[string]any
+		if err := yaml.Unmarshal([]byte(cfg.TrafficPolicy), &policy); err != nil {
+			return fmt.Errorf("invalid traffic policy: %w", err)
+		}
+	}
+	if _, err := log.LogLevelFromString(cfg.LogLevel); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (cfg *Config) upstreamURL() (*url.URL, error) {
+	upstream := cfg.Upstream
+	if !strings.Contains(upstream, "://") {
+		upstream = "tcp://" + upstream
+	}
+	u, err := url.Parse(upstream)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream: %w", err)
+	}
+	return u, nil
+}
+
+func (cfg *Config) tunnel() config.Tunnel {
+	switch cfg.Proto {
+	case "tcp":
+		var opts []config.TCPEndpointOption
+		if cfg.RemoteAddr != "" {
+			opts = append(opts, config.WithRemoteAddr(cfg.RemoteAddr))
+		}
+		if cfg.TrafficPolicy != "" {
+			opts = append(opts, config.WithTrafficPolicy(cfg.TrafficPolicy))
+		}
+		if cfg.Metadata != "" {
+			opts = append(opts, config.WithMetadata(cfg.Metadata))
+		}
+		return config.TCPEndpoint(opts...)
+	case "tls":
+		var opts []config.TLSEndpointOption
+		if cfg.Domain != "" {
+			opts = append(opts, config.WithDomain(cfg.Domain))
+		}
+		if cfg.TrafficPolicy != "" {
+			opts = append(opts, config.WithTrafficPolicy(cfg.TrafficPolicy))
+		}
+		if cfg.Metadata != "" {
+			opts = append(opts, config.WithMetadata(cfg.Metadata))
+		}
+		return config.TLSEndpoint(opts...)
+	default:
+		var opts []config.HTTPEndpointOption
+		if cfg.Domain != "" {
+			opts = append(opts, config.WithDomain(cfg.Domain))
+		}
+		if cfg.TrafficPolicy != "" {
+			opts = append(opts, config.WithTrafficPolicy(cfg.TrafficPolicy))
+		}
+		if cfg.Metadata != "" {
+			opts = append(opts, config.WithMetadata(cfg.Metadata))
+		}
+		return config.HTTPEndpoint(opts...)
+	}
+}
+
+// Main parses the process's arguments and runs the forwarder until it's
+// signaled to stop, exiting with a non-zero status if either fails.
+func Main() {
+	cfg, err := ParseArgs(os.Args[0], os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		stdlog.Fatal(err)
+	}
+	if err := Run(context.Background(), cfg); err != nil {
+		stdlog.Fatal(err)
+	}
+}
+
+// Run connects to ngrok and forwards an endpoint to the config's upstream
+// until ctx is done or the process receives SIGINT or SIGTERM. The endpoint
+// is then closed, and the connections being forwarded are given the config's
+// shutdown timeout to finish before the session is closed. If the ngrok
+// service stops the endpoint, it's started again.
+//
+// The options are passed to [ngrok.Connect] after the ones built from the
+// config, so they can override them.
+func Run(ctx context.Context, cfg *Config, opts ...ngrok.ConnectOption) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	upstream, _ := cfg.upstreamURL()
+	level, _ := log.LogLevelFromString(cfg.LogLevel)
+	logger := &stdLogger{lvl: level}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	connectOpts := []ngrok.ConnectOption{ngrok.WithLogger(logger)}
+	if cfg.Authtoken != "" {
+		connectOpts = append(connectOpts, ngrok.WithAuthtoken(cfg.Authtoken))
+	} else {
+		connectOpts = append(connectOpts, ngrok.WithAuthtokenFromEnv())
+	}
+	if cfg.ServerAddr != "" {
+		connectOpts = append(connectOpts, ngrok.WithServer(cfg.ServerAddr))
+	}
+	sess, err := ngrok.Connect(ctx, append(connectOpts, opts...)...)
+	if err != nil {
+		return err
+	}
+
+	for {
+		// The forwarder isn't tied to ctx, so that a signal closes it
+		// gracefully below rather than cutting its connections off.
+		fwd, err := sess.ListenAndForward(context.WithoutCancel(ctx), upstream, cfg.tunnel())
+		if err != nil {
+			_ = sess.Close()
+			return err
+		}
+		logger.Log(ctx, log.LogLevelInfo, "forwarding", map[string]any{
+			"url":      fwd.URL(),
+			"upstream": upstream.String(),
+		})
+
+		done := make(chan error, 1)
+		go func() { done <- fwd.Wait() }()
+		select {
+		case err := <-done:
+			if err == nil {
+				return sess.Close()
+			}
+			logger.Log(ctx, log.LogLevelWarn, "forwarding stopped, starting a new endpoint", map[string]any{
+				"err": err,
+			})
+			continue
+		case <-ctx.Done():
+		}
+
+		logger.Log(ctx, log.LogLevelInfo, "shutting down", map[string]any{
+			"timeout": cfg.ShutdownTimeout,
+		})
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), cfg.ShutdownTimeout)
+		defer cancel()
+		err = fwd.CloseWithContext(shutdownCtx)
+		if closeErr := sess.CloseWithContext(shutdownCtx); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+}
+
+// Logs to the Go standard logger.
+type stdLogger struct {
+	lvl log.LogLevel
+}
+
+func (l *stdLogger) Log(ctx context.Context, lvl log.LogLevel, msg string, data map[string]interface{}) {
+	if lvl > l.lvl {
+		return
+	}
+	lvlName, _ := log.StringFromLogLevel(lvl)
+	stdlog.Printf("[%s] %s %v", lvlName, msg, data)
+}
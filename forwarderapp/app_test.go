@@ -0,0 +1,144 @@
+package forwarderapp
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.yml")
+	require.NoError(t, os.WriteFile(path, []byte(contents), 0o600))
+	return path
+}
+
+func TestParseArgs(t *testing.T) {
+	path := writeConfig(t, `
+upstream: localhost:8080
+proto: tcp
+remote_addr: 1.tcp.ngrok.io:12345
+shutdown_timeout: 5s
+`)
+
+	cfg, err := ParseArgs("agent", []string{"-config", path})
+	require.NoError(t, err)
+	require.Equal(t, &Config{
+		Upstream:        "localhost:8080",
+		Proto:           "tcp",
+		RemoteAddr:      "1.tcp.ngrok.io:12345",
+		LogLevel:        "info",
+		ShutdownTimeout: 5 * time.Second,
+	}, cfg)
+
+	// Flags override the file, wherever they're given.
+	cfg, err = ParseArgs("agent", []string{"-proto", "tls", "-config", path, "-log-level", "debug", "localhost:8443"})
+	require.NoError(t, err)
+	require.Equal(t, "tls", cfg.Proto)
+	require.Equal(t, "debug", cfg.LogLevel)
+	require.Equal(t, "localhost:8443", cfg.Upstream)
+	require.Equal(t, 5*time.Second, cfg.ShutdownTimeout)
+
+	cfg, err = ParseArgs("agent", []string{"http://localhost:8080"})
+	require.NoError(t, err)
+	require.Equal(t, "http", cfg.Proto)
+	require.Equal(t, DefaultShutdownTimeout, cfg.ShutdownTimeout)
+}
+
+func TestParseArgsInvalid(t *testing.T) {
+	for name, args := range map[string][]string{
+		"no upstream":    {},
+		"two upstreams":  {"localhost:80", "localhost:81"},
+		"bad proto":      {"-proto", "udp", "localhost:80"},
+		"bad log level":  {"-log-level", "loud", "localhost:80"},
+		"unknown field":  {"-config", writeConfig(t, "upstreams: localhost:80\n")},
+		"missing config": {"-config", filepath.Join(t.TempDir(), "missing.yml")},
+		"bad policy":     {"-traffic-policy-file", writeConfig(t, "- not a policy\n"), "localhost:80"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseArgs("agent", args)
+			require.Error(t, err)
+		})
+	}
+}
+
+// An address that's free to listen on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestRunGracefulShutdown(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	addr := freeAddr(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- Run(ctx, &Config{
+			Upstream:        upstream.Addr().String(),
+			Proto:           "tcp",
+			LogLevel:        "info",
+			ShutdownTimeout: 5 * time.Second,
+		}, ngrok.WithOfflineFallback(addr))
+	}()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", addr)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+
+	// The forwarded connection keeps working until it's done.
+	cancel()
+	select {
+	case err := <-done:
+		t.Fatalf("Run returned with a connection open: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = conn.Write([]byte("world"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "world", string(buf))
+
+	require.NoError(t, conn.Close())
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run didn't return after the connection closed")
+	}
+}
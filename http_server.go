@@ -0,0 +1,126 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// Apply the settings from config.WithHTTPServerSettings to the fields that a
+// server serving a tunnel leaves unset. Its errors go to the session's logger
+// unless it or the settings name another.
+func configureHTTPServer(server *http.Server, settings upstream.HTTPServer, logger *slog.Logger) {
+	if server.ReadHeaderTimeout == 0 {
+		server.ReadHeaderTimeout = settings.ReadHeaderTimeout
+	}
+	if server.IdleTimeout == 0 {
+		server.IdleTimeout = settings.IdleTimeout
+	}
+	if server.MaxHeaderBytes == 0 {
+		server.MaxHeaderBytes = settings.MaxHeaderBytes
+	}
+	if server.ErrorLog == nil {
+		server.ErrorLog = settings.ErrorLog
+	}
+	if server.ErrorLog == nil && logger != nil {
+		server.ErrorLog = slog.NewLogLogger(logger.Handler(), slog.LevelWarn)
+	}
+}
+
+// The server for a tunnel served by ListenAndServeHTTP: a copy of the
+// caller's server configured with the settings from
+// config.WithHTTPServerSettings, so that the caller's server is left
+// untouched. The copy is shut down along with server.
+func copyServerFor(server *http.Server, appProto string, settings upstream.HTTPServer, logger *slog.Logger) *http.Server {
+	copied := cloneHTTPServer(server)
+	configureHTTPServer(copied, settings, logger)
+	server.RegisterOnShutdown(func() { _ = copied.Shutdown(context.Background()) })
+	return tunnelServer(copied, appProto)
+}
+
+// The server for the connections of a tunnel that declared appProto with
+// config.WithAppProtocol. The edge speaks HTTP/2 without TLS to http2
+// tunnels, so they're served by a copy of server with its handler wrapped,
+// leaving the caller's server untouched. The copy is shut down along with
+// server.
+func tunnelServer(server *http.Server, appProto string) *http.Server {
+	if appProto != "http2" {
+		return server
+	}
+	h2 := cloneHTTPServer(server)
+	handler := h2.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	h2.Handler = h2c.NewHandler(handler, &http2.Server{})
+	server.RegisterOnShutdown(func() { _ = h2.Shutdown(context.Background()) })
+	return h2
+}
+
+// Copy the settings of a server, which can't be copied by value.
+func cloneHTTPServer(s *http.Server) *http.Server {
+	return &http.Server{
+		Addr:                         s.Addr,
+		Handler:                      s.Handler,
+		DisableGeneralOptionsHandler: s.DisableGeneralOptionsHandler,
+		TLSConfig:                    s.TLSConfig,
+		ReadTimeout:                  s.ReadTimeout,
+		ReadHeaderTimeout:            s.ReadHeaderTimeout,
+		WriteTimeout:                 s.WriteTimeout,
+		IdleTimeout:                  s.IdleTimeout,
+		MaxHeaderBytes:               s.MaxHeaderBytes,
+		TLSNextProto:                 s.TLSNextProto,
+		ConnState:                    s.ConnState,
+		ErrorLog:                     s.ErrorLog,
+		BaseContext:                  s.BaseContext,
+		ConnContext:                  s.ConnContext,
+	}
+}
+
+// How long ServeHTTP waits for requests in progress once its context is done.
+const serveHTTPShutdownTimeout = 5 * time.Second
+
+func (t *tunnelImpl) ServeHTTP(ctx context.Context, handler http.Handler) error {
+	server := tunnelServer(&http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}, t.ForwardsProto())
+	served := make(chan error, 1)
+	t.goroutines.spawn(func() { served <- server.Serve(serverListener{t}) })
+
+	select {
+	case err := <-served:
+		// The tunnel stopped accepting connections, so drain the requests
+		// on the ones it already accepted.
+		_ = server.Shutdown(context.Background())
+		if t.closed.Load() {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serveHTTPShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			_ = server.Close()
+		}
+		<-served
+		return ctx.Err()
+	}
+}
+
+// A server for ListenAndHandleHTTP. A nil handler, like a nil
+// [http.Server.Handler], serves [http.DefaultServeMux].
+func handlerServer(handler *http.Handler) *http.Server {
+	server := &http.Server{}
+	if handler != nil {
+		server.Handler = *handler
+	}
+	return server
+}
@@ -0,0 +1,189 @@
+package ngrok
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func TestHTTPServerSettings(t *testing.T) {
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	var errLog bytes.Buffer
+	server := &http.Server{Handler: http.NotFoundHandler(), IdleTimeout: time.Minute}
+	fwd, err := sess.ListenAndServeHTTP(ctx, config.HTTPEndpoint(config.WithHTTPServerSettings(config.HTTPServerSettings{
+		ReadHeaderTimeout: 5 * time.Second,
+		IdleTimeout:       30 * time.Second,
+		MaxHeaderBytes:    8 << 10,
+		ErrorLog:          log.New(&errLog, "", 0),
+	})), server)
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	served := fwd.(*forwarder).Tunnel.(*tunnelImpl).server
+	require.Equal(t, 5*time.Second, served.ReadHeaderTimeout)
+	require.Equal(t, time.Minute, served.IdleTimeout)
+	require.Equal(t, 8<<10, served.MaxHeaderBytes)
+	served.ErrorLog.Print("http: TLS handshake error")
+	require.Equal(t, "http: TLS handshake error\n", errLog.String())
+
+	// The caller's server is left as it was.
+	require.Zero(t, server.ReadHeaderTimeout)
+	require.Zero(t, server.MaxHeaderBytes)
+	require.Nil(t, server.ErrorLog)
+}
+
+func TestListenAndServeHTTPCopiesServer(t *testing.T) {
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	})}
+	fwd, err := sess.ListenAndServeHTTP(ctx, config.HTTPEndpoint(), server)
+	require.NoError(t, err)
+	defer fwd.Close()
+	require.Nil(t, server.ErrorLog)
+
+	resp, err := http.Get(fwd.URL())
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(body))
+
+	// Shutting down the caller's server shuts down the copy.
+	require.NoError(t, server.Shutdown(ctx))
+	require.ErrorIs(t, fwd.Wait(), http.ErrServerClosed)
+}
+
+func TestHTTPServerErrorLog(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	server := &http.Server{}
+	configureHTTPServer(server, upstream.HTTPServer{}, logger.With("clientid", "tun_1"))
+	server.ErrorLog.Print("http: panic serving 127.0.0.1:1234: boom")
+	require.Equal(t, "level=WARN msg=\"http: panic serving 127.0.0.1:1234: boom\" clientid=tun_1\n", out.String())
+}
+
+func TestListenAndServeHTTP2(t *testing.T) {
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	})}
+	handler := server.Handler
+	fwd, err := sess.ListenAndServeHTTP(ctx, config.HTTPEndpoint(config.WithAppProtocol("http2")), server)
+	require.NoError(t, err)
+	defer fwd.Close()
+	require.Equal(t, "HTTP/2.0", getHTTP2(t, fwd.URL()))
+	// The caller's server is left as it was.
+	require.Equal(t, fmt.Sprint(handler), fmt.Sprint(server.Handler))
+
+	// Shutting it down shuts down the server that serves the tunnel.
+	require.NoError(t, server.Shutdown(ctx))
+	require.ErrorIs(t, fwd.Wait(), http.ErrServerClosed)
+}
+
+func TestListenHTTPServerHTTP2(t *testing.T) {
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	})}
+	tun, err := sess.Listen(ctx, config.HTTPEndpoint(
+		config.WithAppProtocol("http2"),
+		config.WithHTTPServer(server),
+	))
+	require.NoError(t, err)
+	defer tun.Close()
+	require.Equal(t, "HTTP/2.0", getHTTP2(t, tun.URL()))
+}
+
+// Get the body at url over HTTP/2 without TLS, as the edge does.
+func getHTTP2(t *testing.T, url string) string {
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestTunnelServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	proto := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	})
+	serve := func(ctx context.Context, tun Tunnel) chan error {
+		done := make(chan error, 1)
+		go func() { done <- tun.ServeHTTP(ctx, proto) }()
+		return done
+	}
+
+	// Serving stops once the context is done, closing the tunnel.
+	tun, err := sess.Listen(ctx, config.HTTPEndpoint())
+	require.NoError(t, err)
+	serveCtx, cancel := context.WithCancel(ctx)
+	done := serve(serveCtx, tun)
+	resp, err := http.Get(tun.URL())
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Equal(t, "HTTP/1.1", string(body))
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+	_, err = tun.Accept()
+	require.Error(t, err)
+
+	// Or once the tunnel is closed.
+	tun, err = sess.Listen(ctx, config.HTTPEndpoint(config.WithAppProtocol("http2")))
+	require.NoError(t, err)
+	done = serve(ctx, tun)
+	require.Equal(t, "HTTP/2.0", getHTTP2(t, tun.URL()))
+	require.NoError(t, tun.Close())
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,99 @@
+package ngrok
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// The headers the ngrok service adds to requests from users that an endpoint's
+// OAuth or OIDC authenticated.
+const (
+	authUserIDHeader    = "Ngrok-Auth-User-Id"
+	authUserEmailHeader = "Ngrok-Auth-User-Email"
+	authUserNameHeader  = "Ngrok-Auth-User-Name"
+)
+
+const (
+	defaultJWTIssuer = "ngrok"
+	defaultJWTTTL    = time.Minute
+)
+
+// The identity of an authenticated user, as passed on to the upstream.
+type forwardedIdentity struct {
+	ID    string
+	Email string
+	Name  string
+}
+
+// Pass the identity of the user that sent each request on to the upstream in
+// the configured form, removing any copies of the headers it's passed in that
+// the client sent.
+func forwardIdentity(next http.Handler, opts *upstream.Identity, now func() time.Time) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var id forwardedIdentity
+		if opts.Trusted {
+			id = forwardedIdentity{
+				ID:    r.Header.Get(authUserIDHeader),
+				Email: r.Header.Get(authUserEmailHeader),
+				Name:  r.Header.Get(authUserNameHeader),
+			}
+		} else {
+			r.Header.Del(authUserIDHeader)
+			r.Header.Del(authUserEmailHeader)
+			r.Header.Del(authUserNameHeader)
+		}
+		authenticated := id != forwardedIdentity{}
+
+		if opts.Headers {
+			r.Header.Del("X-Forwarded-User")
+			r.Header.Del("X-Forwarded-Email")
+			r.Header.Del("X-Forwarded-Preferred-Username")
+			if authenticated {
+				setNonEmpty(r.Header, "X-Forwarded-User", id.ID)
+				setNonEmpty(r.Header, "X-Forwarded-Email", id.Email)
+				setNonEmpty(r.Header, "X-Forwarded-Preferred-Username", id.Name)
+			}
+		}
+		if len(opts.JWTKey) > 0 && authenticated {
+			r.Header.Set("Authorization", "Bearer "+identityJWT(id, opts, now()))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setNonEmpty(h http.Header, name, value string) {
+	if value != "" {
+		h.Set(name, value)
+	}
+}
+
+// Sign a JWT asserting the identity with HS256.
+func identityJWT(id forwardedIdentity, opts *upstream.Identity, now time.Time) string {
+	issuer, ttl := opts.JWTIssuer, opts.JWTTTL
+	if issuer == "" {
+		issuer = defaultJWTIssuer
+	}
+	if ttl <= 0 {
+		ttl = defaultJWTTTL
+	}
+	claims, _ := json.Marshal(struct {
+		Issuer    string `json:"iss"`
+		Subject   string `json:"sub,omitempty"`
+		Email     string `json:"email,omitempty"`
+		Name      string `json:"name,omitempty"`
+		IssuedAt  int64  `json:"iat"`
+		ExpiresAt int64  `json:"exp"`
+	}{issuer, id.ID, id.Email, id.Name, now.Unix(), now.Add(ttl).Unix()})
+
+	enc := base64.RawURLEncoding
+	signed := enc.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + enc.EncodeToString(claims)
+	mac := hmac.New(sha256.New, opts.JWTKey)
+	mac.Write([]byte(signed))
+	return signed + "." + enc.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,77 @@
+package ngrok
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func TestForwardIdentity(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	forward := func(opts *upstream.Identity, header http.Header) http.Header {
+		var seen http.Header
+		handler := forwardIdentity(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			seen = r.Header
+		}), opts, func() time.Time { return now })
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header = header
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		return seen
+	}
+	signedIn := func() http.Header {
+		return http.Header{
+			"Ngrok-Auth-User-Id":    {"google-123"},
+			"Ngrok-Auth-User-Email": {"alice@example.com"},
+			"X-Forwarded-User":      {"admin"},
+		}
+	}
+
+	// The identity is passed on when the endpoint authenticates users.
+	h := forward(&upstream.Identity{Trusted: true, Headers: true}, signedIn())
+	require.Equal(t, "google-123", h.Get("X-Forwarded-User"))
+	require.Equal(t, "alice@example.com", h.Get("X-Forwarded-Email"))
+	require.Empty(t, h.Values("X-Forwarded-Preferred-Username"))
+	require.Equal(t, "google-123", h.Get("Ngrok-Auth-User-Id"))
+
+	// Otherwise the headers were sent by the client, and are removed.
+	h = forward(&upstream.Identity{Headers: true}, signedIn())
+	require.Empty(t, h.Values("X-Forwarded-User"))
+	require.Empty(t, h.Values("Ngrok-Auth-User-Id"))
+	require.Empty(t, h.Values("Ngrok-Auth-User-Email"))
+
+	key := []byte("secret")
+	h = forward(&upstream.Identity{Trusted: true, JWTKey: key}, signedIn())
+	require.Equal(t, "admin", h.Get("X-Forwarded-User"))
+	token, ok := strings.CutPrefix(h.Get("Authorization"), "Bearer ")
+	require.True(t, ok)
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	require.Equal(t, base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), parts[2])
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims map[string]any
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	require.Equal(t, map[string]any{
+		"iss":   "ngrok",
+		"sub":   "google-123",
+		"email": "alice@example.com",
+		"iat":   float64(now.Unix()),
+		"exp":   float64(now.Add(time.Minute).Unix()),
+	}, claims)
+
+	// No token is issued without an identity.
+	h = forward(&upstream.Identity{JWTKey: key}, http.Header{"Authorization": {"Basic abc"}})
+	require.Equal(t, "Basic abc", h.Get("Authorization"))
+}
@@ -0,0 +1,78 @@
+package ngrok
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.ngrok.com/ngrok/clock"
+)
+
+// The close reason reported for connections closed by an idle timeout.
+var idleCloseReason = ConnCloseReason{ClosedBy: CloseInitiatorLocal, Kind: CloseKindIdleTimeout, Reason: "idle"}
+
+// A forwarded connection that records when bytes last passed through it.
+type idleConn struct {
+	Conn
+	clock clock.Clock
+	// The time of the last read or write, in nanoseconds since the Unix
+	// epoch.
+	last atomic.Int64
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.last.Store(c.clock.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		c.last.Store(c.clock.Now().UnixNano())
+	}
+	return n, err
+}
+
+// Call onIdle once no bytes have been read from or written to conn for the
+// timeout. Since every byte forwarded in either direction passes through the
+// tunnel side, watching it alone is enough. Returns the conn to forward
+// instead, and a function that stops watching it.
+func closeWhenIdle(conn Conn, clk clock.Clock, timeout time.Duration, onIdle func()) (Conn, func()) {
+	idle := &idleConn{Conn: conn, clock: clk}
+	idle.last.Store(clk.Now().UnixNano())
+
+	var (
+		mu      sync.Mutex
+		timer   clock.Timer
+		stopped bool
+	)
+	mu.Lock()
+	defer mu.Unlock()
+	timer = clk.AfterFunc(timeout, func() {
+		mu.Lock()
+		if stopped {
+			mu.Unlock()
+			return
+		}
+		if since := clk.Now().Sub(time.Unix(0, idle.last.Load())); since < timeout {
+			timer.Reset(timeout - since)
+			mu.Unlock()
+			return
+		}
+		mu.Unlock()
+
+		if impl, ok := conn.(*connImpl); ok {
+			impl.closeReason.CompareAndSwap(nil, &idleCloseReason)
+		}
+		onIdle()
+	})
+	return idle, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		stopped = true
+		timer.Stop()
+	}
+}
@@ -0,0 +1,68 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/clock"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func TestConnectionIdleTimeout(t *testing.T) {
+	rec := &eventRecorder{}
+	clk := clock.NewFake(time.Now())
+	sess := &sessionImpl{clk: clk}
+	sess.setInner(&sessionInner{Logger: slog.New(discardHandler{})})
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{
+		Sess:   sess,
+		Tunnel: &benchTunnel{queuedTunnel: q},
+		events: newEventDispatcher([]EventHandler{rec.handle}),
+	}
+
+	backends := make(chan net.Conn, 1)
+	opts := upstream.Options{
+		IdleTimeout: time.Minute,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			local, backend := net.Pipe()
+			backends <- backend
+			return local, nil
+		},
+	}
+	forwardTunnel(context.Background(), tun, &url.URL{Scheme: "tcp", Host: "localhost:1234"}, opts)
+
+	local, client := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	backend := <-backends
+	send := func() {
+		_, err := client.Write([]byte("ping"))
+		require.NoError(t, err)
+		_, err = io.ReadFull(backend, make([]byte, 4))
+		require.NoError(t, err)
+	}
+
+	// Traffic keeps the connection open past the timeout.
+	for i := 0; i < 6; i++ {
+		clk.BlockUntil(1)
+		clk.Advance(opts.IdleTimeout / 2)
+		send()
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(opts.IdleTimeout)
+	_, err := client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	require.Equal(t, []EventType{EventTypeConnectionOpened, EventTypeConnectionClosed}, rec.waitFor(t, 2))
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	require.Equal(t, idleCloseReason, rec.events[1].(*EventConnectionClosed).CloseReason)
+}
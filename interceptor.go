@@ -0,0 +1,109 @@
+package ngrok
+
+import (
+	"log/slog"
+	"net"
+	"sync"
+)
+
+// The close reason reported for connections rejected by a [ConnInterceptor].
+var interceptedCloseReason = ConnCloseReason{ClosedBy: CloseInitiatorLocal, Kind: CloseKindPolicy, Reason: "interceptor"}
+
+// ConnInterceptor is the callback type for [WithConnInterceptor]. It returns
+// the connection to hand to the application, which may wrap conn, or an error
+// to close conn instead.
+type ConnInterceptor func(conn Conn) (Conn, error)
+
+// WithConnInterceptor configures a function which every connection accepted
+// from the session's tunnels passes through before [Tunnel.Accept] returns it,
+// including those forwarded by ListenAndForward and served by
+// ListenAndServeHTTP. Use it for cross-cutting features such as custom
+// allowlists, authentication preambles read from the connection, or wrapping
+// connections to record them. It may be provided multiple times, and the
+// interceptors are called in the order they were configured, each with the
+// connection returned by the one before.
+//
+// Connections that an interceptor returns an error for are closed, and the
+// error is logged. Each connection is intercepted in its own goroutine, so a
+// slow interceptor doesn't hold up the connections behind it.
+func WithConnInterceptor(interceptor ConnInterceptor) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ConnInterceptors = append(cfg.ConnInterceptors, interceptor)
+	}
+}
+
+// Passes the connections of a tunnel through its interceptors. Connections are
+// accepted from the tunnel by a dedicated goroutine, like [policyPump].
+type interceptPump struct {
+	startOnce sync.Once
+	ready     chan net.Conn
+	done      chan struct{}
+	err       error
+}
+
+// Get the next connection that made it through the interceptors.
+func (t *tunnelImpl) acceptIntercepted() (net.Conn, error) {
+	p := &t.intercepts
+	p.startOnce.Do(func() {
+		p.ready = make(chan net.Conn)
+		p.done = make(chan struct{})
+		t.goroutines.spawn(t.runInterceptors)
+	})
+
+	select {
+	case conn := <-p.ready:
+		if t.closed.Load() {
+			conn.Close()
+			return nil, net.ErrClosed
+		}
+		return conn, nil
+	case <-p.done:
+		return nil, p.err
+	case <-t.stopping():
+		return nil, net.ErrClosed
+	}
+}
+
+func (t *tunnelImpl) runInterceptors() {
+	p := &t.intercepts
+	for {
+		conn, err := t.acceptConn()
+		if err != nil {
+			p.err = err
+			close(p.done)
+			return
+		}
+
+		t.goroutines.spawn(func() {
+			intercepted, err := t.intercept(conn.(*connImpl))
+			if err != nil {
+				t.log(func(l *slog.Logger) {
+					l.Info("connection rejected by interceptor", "clientid", t.Tunnel.ID(), "remote_addr", conn.RemoteAddr(), "err", err)
+				})
+				return
+			}
+			select {
+			case p.ready <- intercepted:
+			case <-p.done:
+				intercepted.Close()
+			case <-t.stopping():
+				intercepted.Close()
+			}
+		})
+	}
+}
+
+// Run a connection through the interceptors, closing it if one fails.
+func (t *tunnelImpl) intercept(conn *connImpl) (Conn, error) {
+	var intercepted Conn = conn
+	for _, interceptor := range t.interceptors {
+		next, err := interceptor(intercepted)
+		if err != nil {
+			conn.closeReason.CompareAndSwap(nil, &interceptedCloseReason)
+			intercepted.Close()
+			return nil, err
+		}
+		intercepted = next
+	}
+	return intercepted, nil
+}
@@ -0,0 +1,103 @@
+package ngrok
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+type taggedConn struct {
+	Conn
+}
+
+func TestConnInterceptor(t *testing.T) {
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 3)}
+	tun := &tunnelImpl{
+		Tunnel: q,
+		interceptors: []ConnInterceptor{
+			// Require a preamble before the application's data.
+			func(conn Conn) (Conn, error) {
+				preamble := make([]byte, 4)
+				if _, err := io.ReadFull(conn, preamble); err != nil {
+					return nil, err
+				}
+				if string(preamble) != "OPEN" {
+					return nil, errors.New("bad preamble")
+				}
+				return conn, nil
+			},
+			func(conn Conn) (Conn, error) {
+				return &taggedConn{conn}, nil
+			},
+		},
+	}
+
+	send := func() net.Conn {
+		local, remote := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+		return remote
+	}
+
+	// A client that's slow to send its preamble doesn't hold up the others.
+	slow := send()
+	defer slow.Close()
+	rejected := send()
+	go func() { _, _ = rejected.Write([]byte("NOPE")) }()
+	allowed := send()
+	go func() { _, _ = allowed.Write([]byte("OPENhello")) }()
+
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	require.IsType(t, &taggedConn{}, conn)
+	b := make([]byte, 5)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	// The rejected connection was closed.
+	_, err = rejected.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestConnInterceptorClose(t *testing.T) {
+	// The client tunnel never stops handing out connections, as when it's
+	// still waiting for the unbind request.
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 2)}
+	intercepted := make(chan struct{}, 2)
+	tun := &tunnelImpl{
+		Tunnel: q,
+		interceptors: []ConnInterceptor{func(conn Conn) (Conn, error) {
+			intercepted <- struct{}{}
+			return conn, nil
+		}},
+	}
+	send := func() net.Conn {
+		local, remote := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+		return remote
+	}
+
+	send()
+	_, err := tun.Accept()
+	require.NoError(t, err)
+	<-intercepted
+
+	// A connection that made it through the interceptors, but that the
+	// application hasn't accepted yet, is closed along with the tunnel.
+	pending := send()
+	<-intercepted
+	require.NoError(t, tun.Close())
+	// Pipes that are already closed refuse deadlines.
+	_ = pending.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = pending.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+
+	_, err = tun.Accept()
+	require.ErrorIs(t, err, net.ErrClosed)
+}
@@ -0,0 +1,18 @@
+// Package rawstream lets the rawsession package open streams on the sessions
+// of the root package, without adding the capability to their public API.
+package rawstream
+
+import (
+	"errors"
+	"net"
+
+	"golang.ngrok.com/muxado/v2"
+)
+
+// ErrNotConnected is returned by Open when the session has no connection to
+// the ngrok service.
+var ErrNotConnected = errors.New("the session isn't connected to the ngrok service")
+
+// Open opens a stream of the given type on a session returned by
+// ngrok.Connect. It's set by the root package when it's initialized.
+var Open func(sess any, typ muxado.StreamType) (net.Conn, error)
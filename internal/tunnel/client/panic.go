@@ -0,0 +1,23 @@
+package client
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Recover a panic, run cleanup, and report the panic to onPanic. Without a
+// handler, the panic isn't recovered. Must be deferred directly.
+func recoverPanic(onPanic func(recovered any, stack []byte), cleanup func(recovered any)) {
+	if onPanic == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		cleanup(r)
+		onPanic(r, debug.Stack())
+	}
+}
+
+// The error a leg reconnects with after a panic.
+func panicError(recovered any) error {
+	return fmt.Errorf("recovered from panic: %v", recovered)
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/muxado/v2"
+)
+
+const (
+	// Proxied data is written in chunks of at most this size, so that it
+	// yields to urgent writes between them.
+	bulkChunkSize = 16 << 10
+	// The longest a chunk of proxied data waits for urgent writes to finish.
+	// Urgent writes are small, so this only comes into play when one of
+	// their streams is out of flow control window.
+	maxBulkDefer = 100 * time.Millisecond
+)
+
+// Gives the writes on streams the client opens, which carry RPCs like Auth
+// and Bind and the heartbeats, priority over the writes of proxied
+// connections. muxado writes frames in the order they're sent, so a tunnel
+// saturating the session would otherwise queue heartbeats behind its data
+// for long enough to be taken for a dead connection.
+type priorityGate struct {
+	mu     sync.Mutex
+	urgent int
+	// Closed when no urgent writes are in progress.
+	idle chan struct{}
+}
+
+func newPriorityGate() *priorityGate {
+	idle := make(chan struct{})
+	close(idle)
+	return &priorityGate{idle: idle}
+}
+
+func (g *priorityGate) begin() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.urgent == 0 {
+		g.idle = make(chan struct{})
+	}
+	g.urgent++
+}
+
+func (g *priorityGate) end() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.urgent--
+	if g.urgent == 0 {
+		close(g.idle)
+	}
+}
+
+// Wait for the urgent writes in progress to finish, for up to maxBulkDefer.
+func (g *priorityGate) wait() {
+	g.mu.Lock()
+	idle := g.idle
+	g.mu.Unlock()
+	select {
+	case <-idle:
+		return
+	default:
+	}
+	timer := time.NewTimer(maxBulkDefer)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+}
+
+// A muxado session whose locally opened streams write urgently.
+type prioritySession struct {
+	muxado.Session
+	gate *priorityGate
+}
+
+func (s *prioritySession) OpenStream() (muxado.Stream, error) {
+	stream, err := s.Session.OpenStream()
+	if err != nil {
+		return nil, err
+	}
+	return &urgentStream{Stream: stream, gate: s.gate}, nil
+}
+
+type urgentStream struct {
+	muxado.Stream
+	gate *priorityGate
+}
+
+func (s *urgentStream) Write(p []byte) (int, error) {
+	s.gate.begin()
+	defer s.gate.end()
+	return s.Stream.Write(p)
+}
+
+// A proxied connection whose writes yield to urgent ones.
+type bulkConn struct {
+	net.Conn
+	gate *priorityGate
+}
+
+func (c *bulkConn) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > bulkChunkSize {
+			chunk = chunk[:bulkChunkSize]
+		}
+		c.gate.wait()
+		written, err := c.Conn.Write(chunk)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[written:]
+	}
+	return n, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A connection that records the size of each write.
+type chunkConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes []int
+}
+
+func (c *chunkConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, len(p))
+	return len(p), nil
+}
+
+func TestBulkConnYields(t *testing.T) {
+	gate := newPriorityGate()
+	under := &chunkConn{}
+	conn := &bulkConn{Conn: under, gate: gate}
+
+	n, err := conn.Write(make([]byte, bulkChunkSize*2+1))
+	require.NoError(t, err)
+	require.Equal(t, bulkChunkSize*2+1, n)
+	require.Equal(t, []int{bulkChunkSize, bulkChunkSize, 1}, under.writes)
+
+	// Proxied data waits for an urgent write in progress.
+	gate.begin()
+	written := make(chan struct{})
+	go func() {
+		_, _ = conn.Write([]byte("data"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("bulk write didn't wait for the urgent one")
+	case <-time.After(maxBulkDefer / 4):
+	}
+	gate.end()
+	<-written
+
+	// But not forever.
+	gate.begin()
+	defer gate.end()
+	start := time.Now()
+	_, err = conn.Write([]byte("data"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), maxBulkDefer)
+}
@@ -4,17 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.ngrok.com/muxado/v2"
+	"golang.ngrok.com/ngrok/clock"
 	"golang.ngrok.com/ngrok/internal/tunnel/netx"
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
-
-	log "github.com/inconshreveable/log15/v3"
-	logext "github.com/inconshreveable/log15/v3/ext"
 )
 
 type RawSession interface {
@@ -26,12 +26,44 @@ type RawSession interface {
 
 	SrvInfo() (proto.SrvInfoResp, error)
 
+	// OpenStream opens a stream of a type that isn't part of the ngrok
+	// protocol. Its writes yield to RPCs and heartbeats, as those of
+	// proxied connections do.
+	OpenStream(typ muxado.StreamType) (net.Conn, error)
+
 	Latency() <-chan time.Duration
+	Misses() <-chan HeartbeatMiss
+	Beats() <-chan HeartbeatBeat
 	Heartbeat() (time.Duration, error)
 
 	Close() error
 }
 
+// HeartbeatMiss reports that the server hasn't responded to heartbeats for
+// one or more heartbeat intervals. The session is terminated once Remaining
+// reaches zero.
+type HeartbeatMiss struct {
+	// The number of consecutive heartbeat intervals without a response.
+	Missed int
+	// The time since the last response, or since the session started.
+	Since time.Duration
+	// The time left before the session is terminated unless a response
+	// arrives.
+	Remaining time.Duration
+}
+
+// HeartbeatBeat reports a response to a heartbeat.
+type HeartbeatBeat struct {
+	// The round trip time of the heartbeat.
+	Latency time.Duration
+	// The number of heartbeat intervals that passed without a response
+	// before this one.
+	Missed int
+	// The time that was left before the session would have been terminated
+	// when the response arrived.
+	Remaining time.Duration
+}
+
 type HandlerRespFunc func(v any) error
 type SessionHandler interface {
 	OnStop(*proto.Stop, HandlerRespFunc)
@@ -46,28 +78,71 @@ type SessionHandler interface {
 // When RawSession.Accept() returns an error, that means the session is dead.
 // Client sessions run over a muxado session.
 type rawSession struct {
-	mux        *muxado.Heartbeat // the muxado session we're multiplexing streams over
-	id         string            // session id for logging purposes
-	handler    SessionHandler    // callbacks to allow the application to handle requests from the server
+	mux        *muxado.Heartbeat         // the muxado session we're multiplexing streams over
+	bulk       muxado.TypedStreamSession // opens streams whose writes aren't urgent
+	priority   *priorityGate             // lets RPCs and heartbeats cut ahead of proxied data
+	id         string                    // session id for logging purposes
+	handler    SessionHandler            // callbacks to allow the application to handle requests from the server
+	tracer     *Tracer                   // records the messages exchanged with the server, if set
 	latency    chan time.Duration
+	misses     chan HeartbeatMiss
+	beats      chan HeartbeatBeat
+	lastBeat   atomic.Int64 // unix nanoseconds of the last heartbeat response
+	missed     atomic.Int64 // heartbeat intervals missed since the last response
+	interval   time.Duration
+	tolerance  time.Duration
+	clock      clock.Clock // times the checks for missed heartbeats
+	done       chan struct{}
 	closed     bool
 	closedLock sync.RWMutex
-	log.Logger
 	remoteAddr net.Addr
+
+	// The logger is tagged with the id once the session authenticates, which
+	// can race with requests on a session that's reconnecting.
+	logMu  sync.RWMutex
+	logger *slog.Logger
 }
 
 // Creates a new client tunnel session with the given id
 // running over the given muxado session.
-func NewRawSession(logger log.Logger, mux muxado.Session, heartbeatConfig *muxado.HeartbeatConfig, handler SessionHandler) RawSession {
-	return newRawSession(mux, newLogger(logger), heartbeatConfig, handler)
+func NewRawSession(logger *slog.Logger, mux muxado.Session, heartbeatConfig *muxado.HeartbeatConfig, handler SessionHandler) RawSession {
+	return newRawSession(mux, newLogger(logger), heartbeatConfig, handler, nil, clock.System)
+}
+
+// NewTracedRawSession creates a client session like NewRawSession, which
+// records the messages it exchanges with the server with tracer, and checks
+// for missed heartbeats with clk.
+func NewTracedRawSession(logger *slog.Logger, mux muxado.Session, heartbeatConfig *muxado.HeartbeatConfig, handler SessionHandler, tracer *Tracer, clk clock.Clock) RawSession {
+	return newRawSession(mux, newLogger(logger), heartbeatConfig, handler, tracer, clk)
 }
 
-func newRawSession(mux muxado.Session, logger log.Logger, heartbeatConfig *muxado.HeartbeatConfig, handler SessionHandler) RawSession {
-	s := &rawSession{Logger: logger, handler: handler, latency: make(chan time.Duration), remoteAddr: mux.RemoteAddr()}
-	typed := muxado.NewTypedStreamSession(mux)
+func newRawSession(mux muxado.Session, logger *slog.Logger, heartbeatConfig *muxado.HeartbeatConfig, handler SessionHandler, tracer *Tracer, clk clock.Clock) RawSession {
+	if clk == nil {
+		clk = clock.System
+	}
+	s := &rawSession{
+		logger:     logger,
+		handler:    handler,
+		tracer:     tracer,
+		latency:    make(chan time.Duration),
+		misses:     make(chan HeartbeatMiss, 1),
+		beats:      make(chan HeartbeatBeat, 1),
+		done:       make(chan struct{}),
+		remoteAddr: mux.RemoteAddr(),
+		priority:   newPriorityGate(),
+		clock:      clk,
+	}
+	s.lastBeat.Store(clk.Now().UnixNano())
+	if heartbeatConfig == nil {
+		heartbeatConfig = muxado.NewHeartbeatConfig()
+	}
+	s.bulk = muxado.NewTypedStreamSession(mux)
+	typed := muxado.NewTypedStreamSession(&prioritySession{Session: mux, gate: s.priority})
 	heart := muxado.NewHeartbeat(typed, s.onHeartbeat, heartbeatConfig)
 	s.mux = heart
+	s.interval, s.tolerance = heartbeatConfig.Interval, heartbeatConfig.Tolerance
 	heart.Start()
+	go s.watchHeartbeats(heartbeatConfig.Interval, heartbeatConfig.Tolerance)
 	return s
 }
 
@@ -85,10 +160,12 @@ func (s *rawSession) Auth(id string, extra proto.AuthExtra) (resp proto.AuthResp
 	}
 
 	// set client id / log tag only if it changed
+	s.logMu.Lock()
 	if s.id != resp.ClientID {
 		s.id = resp.ClientID
-		s.Logger = s.Logger.New("clientid", s.id)
+		s.logger = s.logger.With("clientid", s.id)
 	}
+	s.logMu.Unlock()
 	return
 }
 
@@ -144,6 +221,15 @@ func (s *rawSession) SrvInfo() (resp proto.SrvInfoResp, err error) {
 	return
 }
 
+func (s *rawSession) OpenStream(typ muxado.StreamType) (net.Conn, error) {
+	stream, err := s.bulk.OpenTypedStream(typ)
+	s.log().Debug("open raw stream", "type", typ, "err", err)
+	if err != nil {
+		return nil, err
+	}
+	return &bulkConn{Conn: stream, gate: s.priority}, nil
+}
+
 func (s *rawSession) Heartbeat() (time.Duration, error) {
 	if latency, ok := s.mux.Beat(); !ok {
 		return 0, errors.New("remote failed to reply to heatbeat")
@@ -156,6 +242,14 @@ func (s *rawSession) Latency() <-chan time.Duration {
 	return s.latency
 }
 
+func (s *rawSession) Misses() <-chan HeartbeatMiss {
+	return s.misses
+}
+
+func (s *rawSession) Beats() <-chan HeartbeatBeat {
+	return s.beats
+}
+
 // Accept returns the next stream initiated by the server over the underlying muxado session
 func (s *rawSession) Accept() (netx.LoggedConn, error) {
 	for {
@@ -165,10 +259,10 @@ func (s *rawSession) Accept() (netx.LoggedConn, error) {
 		}
 
 		reqType := proto.ReqType(raw.StreamType())
-		s.Debug("tunnel Accept", "reqType", reqType, "remoteAddr", s.remoteAddr)
+		s.log().Debug("tunnel Accept", "reqType", reqType, "remoteAddr", s.remoteAddr)
 		deserialize := func(v any) (ok bool) {
 			if err := json.NewDecoder(raw).Decode(v); err != nil {
-				s.Error("failed to deserialize", "type", reflect.TypeOf(v), "err", err)
+				s.log().Error("failed to deserialize", "type", reflect.TypeOf(v), "err", err)
 
 				// we're abusing the fact that all error responses have the same type
 				var errResp struct {
@@ -178,19 +272,20 @@ func (s *rawSession) Accept() (netx.LoggedConn, error) {
 
 				buf, err := json.Marshal(&errResp)
 				if err != nil {
-					s.Error("failed to encode response", "err", err)
+					s.log().Error("failed to encode response", "err", err)
 					return
 				}
 				if _, err := raw.Write(buf); err != nil {
-					s.Warn("failed to write error response", "err", err)
+					s.log().Warn("failed to write error response", "err", err)
 					return
 				}
 				return false
 			}
+			s.tracer.message("recv", reqType, v)
 			return true
 		}
 
-		respFunc := s.respFunc(raw)
+		respFunc := s.respFunc(raw, reqType)
 		switch reqType {
 		case proto.RestartReq:
 			var req proto.Restart
@@ -213,16 +308,30 @@ func (s *rawSession) Accept() (netx.LoggedConn, error) {
 				go s.handler.OnStopTunnel(&req, respFunc)
 			}
 		default:
-			return netx.NewLoggedConn(s.Logger, raw, "type", "proxy", "sess", s.id), nil
+			s.logMu.RLock()
+			logger, id := s.logger, s.id
+			s.logMu.RUnlock()
+			return netx.NewLoggedConn(logger, &bulkConn{Conn: raw, gate: s.priority}, "type", "proxy", "sess", id), nil
 		}
 	}
 }
 
-func (s *rawSession) respFunc(raw net.Conn) func(v any) error {
+func (s *rawSession) getTracer() *Tracer {
+	return s.tracer
+}
+
+func (s *rawSession) log() *slog.Logger {
+	s.logMu.RLock()
+	defer s.logMu.RUnlock()
+	return s.logger
+}
+
+func (s *rawSession) respFunc(raw net.Conn, reqType proto.ReqType) func(v any) error {
 	return func(v any) error {
+		s.tracer.message("send", reqType, v)
 		buf, err := json.Marshal(v)
 		if err != nil {
-			s.Error("failed to write response", "err", err)
+			s.log().Error("failed to write response", "err", err)
 			return err
 		}
 		if _, err = raw.Write(buf); err != nil {
@@ -244,6 +353,9 @@ func (s *rawSession) Close() error {
 	if !s.closed {
 		s.closed = true
 		close(s.latency)
+		close(s.misses)
+		close(s.beats)
+		close(s.done)
 	}
 	return err
 }
@@ -253,7 +365,7 @@ func (s *rawSession) Close() error {
 // type which allows the remote side to know in advance what type of payload to
 // deserialize.
 func (s *rawSession) rpc(reqtype proto.ReqType, req any, resp any) error {
-	l := s.New("reqtype", reqtype)
+	l := s.log().With("reqtype", reqtype)
 
 	stream, err := s.mux.OpenTypedStream(muxado.StreamType(reqtype))
 	l.Debug("open stream", "err", err)
@@ -262,26 +374,28 @@ func (s *rawSession) rpc(reqtype proto.ReqType, req any, resp any) error {
 	}
 	defer stream.Close()
 
+	s.tracer.message("send", reqtype, req)
 	enc := json.NewEncoder(stream)
 	err = enc.Encode(req)
-	s.Debug("encode request", "sid", stream.Id(), "req", req, "err", err)
+	s.log().Debug("encode request", "sid", stream.Id(), "req", req, "err", err)
 	if err != nil {
 		return err
 	}
 
 	dec := json.NewDecoder(stream)
 	err = dec.Decode(resp)
-	s.Debug("decoded response", "sid", stream.Id(), "resp", resp, "err", err)
+	s.log().Debug("decoded response", "sid", stream.Id(), "resp", resp, "err", err)
 	if err != nil {
 		return err
 	}
+	s.tracer.message("recv", reqtype, resp)
 
 	return nil
 }
 
 func (s *rawSession) onHeartbeat(pingTime time.Duration, timeout bool) {
 	if timeout {
-		s.Error("heartbeat timeout, terminating session")
+		s.log().Error("heartbeat timeout, terminating session")
 		s.Close()
 		return
 	}
@@ -296,13 +410,72 @@ func (s *rawSession) onHeartbeat(pingTime time.Duration, timeout bool) {
 		return
 	}
 
-	s.Debug("heartbeat received", "latency_ms", int(pingTime.Milliseconds()))
+	now := s.clock.Now()
+	since := now.Sub(time.Unix(0, s.lastBeat.Swap(now.UnixNano())))
+	s.log().Debug("heartbeat received", "latency_ms", int(pingTime.Milliseconds()))
 	select {
 	case s.latency <- pingTime:
 	default:
 	}
+	select {
+	case s.beats <- HeartbeatBeat{
+		Latency:   pingTime,
+		Missed:    int(s.missed.Swap(0)),
+		Remaining: max(s.interval+s.tolerance-since, 0),
+	}:
+	default:
+	}
+}
+
+// Reports each heartbeat interval that passes without a response, ahead of
+// the heartbeat timeout that terminates the session. A response is allowed a
+// quarter of an interval of lateness before it counts as missed.
+func (s *rawSession) watchHeartbeats(interval, tolerance time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	missed := 0
+	last := s.lastBeat.Load()
+	for {
+		deadline := time.Unix(0, last).Add(time.Duration(missed+1)*interval + interval/4)
+		timer := s.clock.NewTimer(deadline.Sub(s.clock.Now()))
+		select {
+		case <-s.done:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		if beat := s.lastBeat.Load(); beat != last {
+			last, missed = beat, 0
+			continue
+		}
+		missed++
+		s.missed.Store(int64(missed))
+
+		since := s.clock.Now().Sub(time.Unix(0, last))
+		miss := HeartbeatMiss{
+			Missed:    missed,
+			Since:     since,
+			Remaining: max(interval+tolerance-since, 0),
+		}
+		s.log().Warn("heartbeat missed", "missed", miss.Missed, "since", miss.Since, "remaining", miss.Remaining)
+		s.sendMiss(miss)
+	}
+}
+
+func (s *rawSession) sendMiss(miss HeartbeatMiss) {
+	s.closedLock.RLock()
+	defer s.closedLock.RUnlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.misses <- miss:
+	default:
+	}
 }
 
-func newLogger(parent log.Logger) log.Logger {
-	return parent.New("obj", "csess", "id", logext.RandId(6))
+func newLogger(parent *slog.Logger) *slog.Logger {
+	return parent.With("obj", "csess", "id", netx.RandID(6))
 }
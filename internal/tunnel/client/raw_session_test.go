@@ -2,15 +2,22 @@ package client
 
 import (
 	"context"
+	"io"
+	"log/slog"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/inconshreveable/log15/v3"
+	"github.com/stretchr/testify/require"
 
 	"golang.ngrok.com/muxado/v2"
+	"golang.ngrok.com/ngrok/clock"
 )
 
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
 type dummyStream struct{}
 
 func (d *dummyStream) Read(bs []byte) (int, error)  { return 0, nil }
@@ -18,7 +25,7 @@ func (d *dummyStream) Write(bs []byte) (int, error) { return 0, nil }
 func (d *dummyStream) Close() error                 { return nil }
 
 func TestRawSessionDoubleClose(t *testing.T) {
-	r := NewRawSession(log15.New(), muxado.Client(&dummyStream{}, nil), nil, nil)
+	r := NewRawSession(testLogger(), muxado.Client(&dummyStream{}, nil), nil, nil)
 
 	// Verify that closing the session twice doesn't cause a panic
 	r.Close()
@@ -26,7 +33,7 @@ func TestRawSessionDoubleClose(t *testing.T) {
 }
 
 func TestHeartbeatTimeout(t *testing.T) {
-	r := NewRawSession(log15.New(), muxado.Client(&dummyStream{}, nil), nil, nil)
+	r := NewRawSession(testLogger(), muxado.Client(&dummyStream{}, nil), nil, nil)
 	// Make sure we don't deadlock
 	r.(*rawSession).onHeartbeat(1, true)
 }
@@ -46,7 +53,7 @@ testloop:
 		}
 
 		ctx, cancel := context.WithCancel(ctx)
-		r := NewRawSession(log15.New(), muxado.Client(&dummyStream{}, nil), nil, nil)
+		r := NewRawSession(testLogger(), muxado.Client(&dummyStream{}, nil), nil, nil)
 
 		wg := sync.WaitGroup{}
 		wg.Add(1)
@@ -75,3 +82,62 @@ testloop:
 		wg.Wait()
 	}
 }
+
+func TestHeartbeatMisses(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	config := muxado.NewHeartbeatConfig()
+	config.Interval = time.Minute
+	config.Tolerance = time.Hour
+	r := newRawSession(muxado.Client(&dummyStream{}, nil), testLogger(), config, nil, nil, clk)
+	defer r.Close()
+
+	// Each check waits for the previous one to set its timer.
+	advance := func(d time.Duration) HeartbeatMiss {
+		clk.BlockUntil(1)
+		clk.Advance(d)
+		return <-r.Misses()
+	}
+
+	// A response is allowed a quarter of an interval of lateness.
+	first := advance(config.Interval + config.Interval/4)
+	require.Equal(t, HeartbeatMiss{
+		Missed:    1,
+		Since:     config.Interval + config.Interval/4,
+		Remaining: config.Tolerance - config.Interval/4,
+	}, first)
+	require.Equal(t, 2, advance(config.Interval).Missed)
+
+	// A response resets the count.
+	r.(*rawSession).onHeartbeat(time.Millisecond, false)
+	clk.BlockUntil(1)
+	clk.Advance(config.Interval)
+	require.Equal(t, 1, advance(config.Interval/4).Missed)
+}
+
+func TestHeartbeatBeats(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	config := muxado.NewHeartbeatConfig()
+	config.Interval = time.Minute
+	config.Tolerance = time.Hour
+	r := newRawSession(muxado.Client(&dummyStream{}, nil), testLogger(), config, nil, nil, clk)
+	defer r.Close()
+
+	clk.BlockUntil(1)
+	clk.Advance(config.Interval + config.Interval/4)
+	<-r.Misses()
+
+	// The response reports the intervals missed before it.
+	r.(*rawSession).onHeartbeat(time.Millisecond, false)
+	require.Equal(t, HeartbeatBeat{
+		Latency:   time.Millisecond,
+		Missed:    1,
+		Remaining: config.Tolerance - config.Interval/4,
+	}, <-r.Beats())
+
+	clk.Advance(config.Interval)
+	r.(*rawSession).onHeartbeat(2*time.Millisecond, false)
+	require.Equal(t, HeartbeatBeat{
+		Latency:   2 * time.Millisecond,
+		Remaining: config.Tolerance,
+	}, <-r.Beats())
+}
@@ -1,20 +1,29 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"log/slog"
+	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	log "github.com/inconshreveable/log15/v3"
 	"github.com/jpillora/backoff"
 
+	"golang.ngrok.com/muxado/v2"
+
+	"golang.ngrok.com/ngrok/clock"
 	"golang.ngrok.com/ngrok/internal/tunnel/netx"
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
 )
 
 var ErrSessionNotReady = errors.New("an ngrok tunnel session has not yet been established")
 
+// ErrSessionClosing is returned by requests made on a session after it has
+// begun closing, or that were cut off by it closing.
+var ErrSessionClosing = errors.New("the ngrok tunnel session is closing")
+
 // Wraps a RawSession so that it can be safely swapped out
 type swapRaw struct {
 	raw atomic.Pointer[RawSession]
@@ -67,6 +76,13 @@ func (s *swapRaw) SrvInfo() (resp proto.SrvInfoResp, err error) {
 	return proto.SrvInfoResp{}, ErrSessionNotReady
 }
 
+func (s *swapRaw) OpenStream(typ muxado.StreamType) (net.Conn, error) {
+	if raw := s.get(); raw != nil {
+		return raw.OpenStream(typ)
+	}
+	return nil, ErrSessionNotReady
+}
+
 func (s *swapRaw) Heartbeat() (time.Duration, error) {
 	if raw := s.get(); raw != nil {
 		return raw.Heartbeat()
@@ -81,6 +97,20 @@ func (s *swapRaw) Latency() <-chan time.Duration {
 	return nil
 }
 
+func (s *swapRaw) Misses() <-chan HeartbeatMiss {
+	if raw := s.get(); raw != nil {
+		return raw.Misses()
+	}
+	return nil
+}
+
+func (s *swapRaw) Beats() <-chan HeartbeatBeat {
+	if raw := s.get(); raw != nil {
+		return raw.Beats()
+	}
+	return nil
+}
+
 func (s *swapRaw) Close() error {
 	raw := s.get()
 	if raw == nil {
@@ -89,6 +119,13 @@ func (s *swapRaw) Close() error {
 	return raw.Close()
 }
 
+func (s *swapRaw) getTracer() *Tracer {
+	if traced, ok := s.get().(interface{ getTracer() *Tracer }); ok {
+		return traced.getTracer()
+	}
+	return nil
+}
+
 func (s *swapRaw) Accept() (netx.LoggedConn, error) {
 	return s.get().Accept()
 }
@@ -99,14 +136,74 @@ type reconnectingSession struct {
 	stateChanges      chan<- error
 	clientID          string
 	cb                ReconnectCallback
+	opts              ReconnectOptions
 	sessions          []*session
 	failPermanentOnce sync.Once
-	log.Logger
+	*slog.Logger
+
+	gate      *rpcGate
+	done      chan struct{}
+	closeOnce sync.Once
+	// The goroutines running each leg's connect and receive loops.
+	legs sync.WaitGroup
+	// Guards sends on stateChanges against it being closed.
+	stateMu     sync.Mutex
+	stateClosed bool
 }
 
 type RawSessionDialer func(legNumber uint32) (RawSession, error)
 type ReconnectCallback func(s Session, r RawSession, legNumber uint32) (int, error)
 
+// Backoff configures the wait between successive reconnect attempts. Zero
+// fields fall back to the defaults of 500ms to 30s with a factor of 2.
+type Backoff struct {
+	Min    time.Duration
+	Max    time.Duration
+	Factor float64
+	Jitter bool
+}
+
+func (b Backoff) new() *backoff.Backoff {
+	boff := &backoff.Backoff{
+		Min:    500 * time.Millisecond,
+		Max:    30 * time.Second,
+		Factor: 2,
+		Jitter: b.Jitter,
+	}
+	if b.Min > 0 {
+		boff.Min = b.Min
+	}
+	if b.Max > 0 {
+		boff.Max = b.Max
+	}
+	if b.Factor > 0 {
+		boff.Factor = b.Factor
+	}
+	return boff
+}
+
+// ReconnectOptions tunes the reconnect loop of a reconnecting session.
+type ReconnectOptions struct {
+	Backoff Backoff
+	// Called after each failed attempt with the number of consecutive
+	// failures, the error encountered, and the wait before the next attempt.
+	Notify func(attempt int, err error, next time.Duration)
+	// Called with panics recovered from the session's goroutines. A leg
+	// that panics is closed and reconnects, and a proxy connection that
+	// panics is closed. If nil, panics aren't recovered.
+	OnPanic func(recovered any, stack []byte)
+	// The clock that times the waits between attempts, and the heartbeats of
+	// the raw sessions. If nil, the system clock is used.
+	Clock clock.Clock
+	// Called when a tunnel is bound again after a reconnect with a different
+	// URL than before, once the tunnel reports the new one. If it returns an
+	// error, the tunnel is unbound and closed with that error.
+	OnURLChange func(t Tunnel, oldURL, newURL string) error
+	// Called when a leg connects, once its tunnels are bound again, and
+	// when it loses its connection, with the error it lost it with.
+	OnLegChange func(leg uint32, connected bool, err error)
+}
+
 // Establish Session(s) that reconnect across temporary network failures. The
 // returned Session object uses the given dialer to reconnect whenever Accept
 // would have failed with a temporary error. When a reconnecting session is
@@ -126,12 +223,15 @@ type ReconnectCallback func(s Session, r RawSession, legNumber uint32) (int, err
 //
 // When using MultiLeg, there will be multiple underlying Sessions which are kept
 // in sync. This struct will broadcast calls to all underlying Sessions.
-func NewReconnectingSession(logger log.Logger, dialer RawSessionDialer, stateChanges chan<- error, cb ReconnectCallback) Session {
+func NewReconnectingSession(logger *slog.Logger, dialer RawSessionDialer, stateChanges chan<- error, cb ReconnectCallback, opts ReconnectOptions) Session {
 	s := &reconnectingSession{
 		dialer:       dialer,
 		stateChanges: stateChanges,
 		cb:           cb,
+		opts:         opts,
 		Logger:       logger,
+		gate:         &rpcGate{},
+		done:         make(chan struct{}),
 	}
 
 	// setup an initial connection
@@ -140,22 +240,31 @@ func NewReconnectingSession(logger log.Logger, dialer RawSessionDialer, stateCha
 	return s
 }
 
-func (s *reconnectingSession) createTunnelClientSession(logger log.Logger) {
+func (s *reconnectingSession) createTunnelClientSession(logger *slog.Logger) {
 	swapper := new(swapRaw)
 	tcs := &session{
 		swapper:   swapper,
 		raw:       swapper,
 		Logger:    newLogger(logger),
 		legNumber: uint32(len(s.sessions)),
+		gate:      s.gate,
+		legs:      func() []*session { return s.sessions },
+		onPanic:   s.opts.OnPanic,
 	}
 	s.sessions = append(s.sessions, tcs)
 
+	s.legs.Add(1)
 	go func() {
-		err := s.connect(nil, tcs)
+		defer s.legs.Done()
+		var err error
+		if panicked := s.guard(tcs, func() { err = s.connect(nil, tcs) }); panicked != nil {
+			s.receive(tcs, panicError(panicked))
+			return
+		}
 		if err != nil {
 			return
 		}
-		s.receive(tcs)
+		s.receive(tcs, nil)
 	}()
 }
 
@@ -166,6 +275,13 @@ func (s *reconnectingSession) firstSession() *session {
 	return s.sessions[0]
 }
 
+func (s *reconnectingSession) clock() clock.Clock {
+	if s.opts.Clock == nil {
+		return clock.System
+	}
+	return s.opts.Clock
+}
+
 func (s *reconnectingSession) Heartbeat() (time.Duration, error) {
 	if sess := s.firstSession(); sess != nil {
 		return sess.Heartbeat()
@@ -180,9 +296,9 @@ func (s *reconnectingSession) Latency() <-chan time.Duration {
 	return nil
 }
 
-func (s *reconnectingSession) Listen(protocol string, opts any, extra proto.BindExtra, forwardsTo string, forwardsProto string) (Tunnel, error) {
+func (s *reconnectingSession) Listen(protocol string, opts any, extra proto.BindExtra, id string, forwardsTo string, forwardsProto string) (Tunnel, error) {
 	return s.listenTunnel(func(session *session) (Tunnel, error) {
-		return session.Listen(protocol, opts, extra, forwardsTo, forwardsProto)
+		return session.Listen(protocol, opts, extra, id, forwardsTo, forwardsProto)
 	})
 }
 
@@ -200,7 +316,9 @@ func (s *reconnectingSession) listenTunnel(listen func(*session) (Tunnel, error)
 		}
 		// connect this tunnel to the other legs
 		for _, session := range s.sessions[1:] {
-			if e := s.reconnectTunnelToSession(session.raw, tun.(*tunnel), make(map[string]*tunnel), tun.ID()); e != nil {
+			if e := s.gate.do(func() error {
+				return s.reconnectTunnelToSession(session.raw, tun.(*tunnel), make(map[string]*tunnel), tun.ID())
+			}); e != nil {
 				return nil, e
 			}
 			// use locking method
@@ -218,24 +336,33 @@ func (s *reconnectingSession) SrvInfo() (resp proto.SrvInfoResp, err error) {
 	return proto.SrvInfoResp{}, ErrSessionNotReady
 }
 
+func (s *reconnectingSession) OpenStream(typ muxado.StreamType) (net.Conn, error) {
+	if sess := s.firstSession(); sess != nil {
+		return sess.OpenStream(typ)
+	}
+	return nil, ErrSessionNotReady
+}
+
 func (s *reconnectingSession) ListenHTTP(opts *proto.HTTPEndpoint, extra proto.BindExtra, forwardsTo string, forwardsProto string) (Tunnel, error) {
-	return s.Listen("http", opts, extra, forwardsTo, forwardsProto)
+	return s.Listen("http", opts, extra, "", forwardsTo, forwardsProto)
 }
 
 func (s *reconnectingSession) ListenHTTPS(opts *proto.HTTPEndpoint, extra proto.BindExtra, forwardsTo string, forwardsProto string) (Tunnel, error) {
-	return s.Listen("https", opts, extra, forwardsTo, forwardsProto)
+	return s.Listen("https", opts, extra, "", forwardsTo, forwardsProto)
 }
 
 func (s *reconnectingSession) ListenTCP(opts *proto.TCPEndpoint, extra proto.BindExtra, forwardsTo string) (Tunnel, error) {
-	return s.Listen("tcp", opts, extra, forwardsTo, "")
+	return s.Listen("tcp", opts, extra, "", forwardsTo, "")
 }
 
 func (s *reconnectingSession) ListenTLS(opts *proto.TLSEndpoint, extra proto.BindExtra, forwardsTo string) (Tunnel, error) {
-	return s.Listen("tls", opts, extra, forwardsTo, "")
+	return s.Listen("tls", opts, extra, "", forwardsTo, "")
 }
 
 func (s *reconnectingSession) Close() error {
+	s.gate.close()
 	atomic.StoreInt32(&s.closed, 1)
+	s.closeOnce.Do(func() { close(s.done) })
 	var err error
 	for _, session := range s.sessions {
 		serr := session.Close()
@@ -246,6 +373,58 @@ func (s *reconnectingSession) Close() error {
 	return err
 }
 
+// Reconnect closes the connection of each leg, which the receive loops see
+// as a dropped connection and reconnect from, binding the tunnels again.
+func (s *reconnectingSession) Reconnect() error {
+	if atomic.LoadInt32(&s.closed) == 1 {
+		return ErrSessionClosing
+	}
+	var err error
+	for _, session := range s.sessions {
+		serr := session.raw.Close()
+		if serr != nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// CloseWithContext lets the requests in flight finish before closing the
+// session, then waits for its reconnect loops to exit. If the context expires
+// first, the session is closed regardless and the context's error returned.
+func (s *reconnectingSession) CloseWithContext(ctx context.Context) error {
+	s.gate.close()
+	waitErr := s.gate.wait(ctx)
+	closeErr := s.Close()
+	if waitErr == nil {
+		waitErr = waitContext(ctx, s.legs.Wait)
+	}
+	if waitErr != nil {
+		return waitErr
+	}
+	return closeErr
+}
+
+func (s *reconnectingSession) legChanged(session *session, connected bool, err error) {
+	if s.opts.OnLegChange != nil {
+		s.opts.OnLegChange(session.legNumber, connected, err)
+	}
+}
+
+// Publish a state change. Once the session is closed, nobody may be listening
+// any more, so this gives up rather than block forever.
+func (s *reconnectingSession) sendState(err error) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+	if s.stateClosed {
+		return
+	}
+	select {
+	case s.stateChanges <- err:
+	case <-s.done:
+	}
+}
+
 func (s *reconnectingSession) CloseTunnel(clientID string, e error) error {
 	var err error
 	for _, session := range s.sessions {
@@ -257,7 +436,9 @@ func (s *reconnectingSession) CloseTunnel(clientID string, e error) error {
 	return err
 }
 
-func (s *reconnectingSession) receive(session *session) {
+// Accept proxy connections on a leg until it fails permanently. If acceptErr
+// is set, the leg reconnects first.
+func (s *reconnectingSession) receive(session *session, acceptErr error) {
 	// when we shut down, close all of the open tunnels
 	defer func() {
 		session.RLock()
@@ -267,12 +448,28 @@ func (s *reconnectingSession) receive(session *session) {
 		session.RUnlock()
 	}()
 
+	err := acceptErr
+	for {
+		panicked := s.guard(session, func() { s.acceptProxies(session, err) })
+		if panicked == nil {
+			return
+		}
+		// The leg was closed, so reconnect it.
+		err = panicError(panicked)
+	}
+}
+
+func (s *reconnectingSession) acceptProxies(session *session, acceptErr error) {
+	err := acceptErr
 	for {
-		// accept the next proxy connection
-		proxy, err := session.raw.Accept()
 		if err == nil {
-			go session.handleProxy(proxy)
-			continue
+			// accept the next proxy connection
+			var proxy netx.LoggedConn
+			proxy, err = session.raw.Accept()
+			if err == nil {
+				go session.handleProxy(proxy)
+				continue
+			}
 		}
 
 		// we disconnected, reconnect
@@ -285,6 +482,16 @@ func (s *reconnectingSession) receive(session *session) {
 	}
 }
 
+// Run f, closing the leg if it panics. Returns the recovered value, if any.
+func (s *reconnectingSession) guard(session *session, f func()) (panicked any) {
+	defer recoverPanic(s.opts.OnPanic, func(r any) {
+		panicked = r
+		_ = session.raw.Close()
+	})
+	f()
+	return nil
+}
+
 func (s *reconnectingSession) Auth(extra proto.AuthExtra) (resp proto.AuthResp, err error) {
 	if len(s.sessions) < int(extra.LegNumber) {
 		err = errors.New("leg number out of range")
@@ -303,16 +510,11 @@ func (s *reconnectingSession) Auth(extra proto.AuthExtra) (resp proto.AuthResp,
 }
 
 func (s *reconnectingSession) connect(acceptErr error, connSession *session) error {
-	boff := &backoff.Backoff{
-		Min:    500 * time.Millisecond,
-		Max:    30 * time.Second,
-		Factor: 2,
-		Jitter: false,
-	}
+	boff := s.opts.Backoff.new()
 
 	failTemp := func(err error, raw RawSession) {
 		s.Error("failed to reconnect session", "err", err)
-		s.stateChanges <- err
+		s.sendState(err)
 
 		// if the retry loop failed after the session was opened, then make sure to close it
 		if raw != nil {
@@ -321,13 +523,24 @@ func (s *reconnectingSession) connect(acceptErr error, connSession *session) err
 
 		// session failed, wait before reconnecting
 		wait := boff.Duration()
+		if s.opts.Notify != nil {
+			s.opts.Notify(int(boff.Attempt()), err, wait)
+		}
 		s.Debug("sleep before reconnect", "secs", int(wait.Seconds()))
-		time.Sleep(wait)
+		timer := s.clock().NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-s.done:
+			timer.Stop()
+		}
 	}
 
 	failPermanent := func(err error) error {
 		s.failPermanentOnce.Do(func() {
-			s.stateChanges <- err
+			s.sendState(err)
+			s.stateMu.Lock()
+			defer s.stateMu.Unlock()
+			s.stateClosed = true
 			close(s.stateChanges)
 		})
 		return err
@@ -341,6 +554,11 @@ func (s *reconnectingSession) connect(acceptErr error, connSession *session) err
 		// reconnected tunnels, which may have different IDs
 		newTunnels := make(map[string]*tunnel, len(session.tunnels))
 		for oldID, t := range session.tunnels {
+			// paused tunnels are bound again when they're resumed
+			if t.paused.Load() {
+				newTunnels[oldID] = t
+				continue
+			}
 			if err := s.reconnectTunnelToSession(raw, t, newTunnels, oldID); err != nil {
 				return err
 			}
@@ -352,7 +570,8 @@ func (s *reconnectingSession) connect(acceptErr error, connSession *session) err
 	if acceptErr != nil {
 		if atomic.LoadInt32(&s.closed) == 0 {
 			connSession.Error("session closed, starting reconnect loop", "err", acceptErr)
-			s.stateChanges <- acceptErr
+			s.legChanged(connSession, false, acceptErr)
+			s.sendState(acceptErr)
 		}
 	}
 
@@ -388,7 +607,7 @@ func (s *reconnectingSession) connect(acceptErr error, connSession *session) err
 			// set up the next connection. additional sessions will
 			// continue to chain on from there until all legs are
 			// established
-			s.createTunnelClientSession(s)
+			s.createTunnelClientSession(s.Logger)
 			// not done with initial setup yet
 			sendStateChange = false
 		}
@@ -399,13 +618,14 @@ func (s *reconnectingSession) connect(acceptErr error, connSession *session) err
 			failTemp(err, raw)
 			continue
 		}
+		s.legChanged(connSession, true, nil)
 
 		if sendStateChange {
 			// reset wait
 			boff.Reset()
 
 			s.Info("client session established")
-			s.stateChanges <- nil
+			s.sendState(nil)
 		}
 		return nil
 	}
@@ -436,6 +656,16 @@ func (s *reconnectingSession) reconnectTunnelToSession(raw RawSession, t *tunnel
 		}
 		respErr = resp.Error
 
+		if respErr == "" && resp.URL != "" && resp.URL != tCfg.URL {
+			t.url.Store(resp.URL)
+			if s.opts.OnURLChange != nil {
+				if err := s.opts.OnURLChange(t, tCfg.URL, resp.URL); err != nil {
+					_, _ = raw.Unlisten(t.ID())
+					t.CloseWithError(err)
+					return nil
+				}
+			}
+		}
 		newTunnels[oldID] = t
 	}
 
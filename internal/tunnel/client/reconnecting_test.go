@@ -0,0 +1,312 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/clock"
+	"golang.ngrok.com/ngrok/internal/tunnel/netx"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestReconnectNotify(t *testing.T) {
+	dialErr := errors.New("dial failed")
+	dialer := func(legNumber uint32) (RawSession, error) {
+		return nil, dialErr
+	}
+
+	type notification struct {
+		attempt int
+		err     error
+		next    time.Duration
+	}
+	notifications := make(chan notification, 16)
+
+	stateChanges := make(chan error, 32)
+	sess := NewReconnectingSession(testLogger(), dialer, stateChanges, nil, ReconnectOptions{
+		Backoff: Backoff{Min: time.Millisecond, Max: 4 * time.Millisecond, Factor: 2},
+		Notify: func(attempt int, err error, next time.Duration) {
+			notifications <- notification{attempt, err, next}
+		},
+	})
+
+	for i, want := range []time.Duration{time.Millisecond, 2 * time.Millisecond, 4 * time.Millisecond, 4 * time.Millisecond} {
+		n := <-notifications
+		require.Equal(t, i+1, n.attempt)
+		require.ErrorIs(t, n.err, dialErr)
+		require.Equal(t, want, n.next)
+		require.ErrorIs(t, <-stateChanges, dialErr)
+	}
+
+	require.NoError(t, sess.Close())
+	for range stateChanges {
+	}
+}
+
+func TestReconnectWaitsOnClock(t *testing.T) {
+	dialErr := errors.New("dial failed")
+	dials := make(chan struct{}, 16)
+	dialer := func(legNumber uint32) (RawSession, error) {
+		dials <- struct{}{}
+		return nil, dialErr
+	}
+
+	clk := clock.NewFake(time.Now())
+	stateChanges := make(chan error, 32)
+	sess := NewReconnectingSession(testLogger(), dialer, stateChanges, nil, ReconnectOptions{
+		Backoff: Backoff{Min: time.Minute, Max: time.Hour, Factor: 2},
+		Clock:   clk,
+	})
+
+	for _, wait := range []time.Duration{time.Minute, 2 * time.Minute} {
+		<-dials
+		require.ErrorIs(t, <-stateChanges, dialErr)
+
+		// The next attempt waits for the backoff to pass on the clock.
+		clk.BlockUntil(1)
+		clk.Advance(wait - time.Nanosecond)
+		require.Empty(t, dials)
+		clk.Advance(time.Nanosecond)
+	}
+	<-dials
+
+	require.NoError(t, sess.Close())
+	for range stateChanges {
+	}
+}
+
+// A raw session whose Listen requests block until released or closed.
+type blockingRaw struct {
+	RawSession
+	listening chan struct{}
+	release   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newBlockingRaw() *blockingRaw {
+	return &blockingRaw{
+		listening: make(chan struct{}, 1),
+		release:   make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (r *blockingRaw) Listen(string, any, proto.BindExtra, string, string, string) (proto.BindResp, error) {
+	r.listening <- struct{}{}
+	select {
+	case <-r.release:
+		return proto.BindResp{ClientID: "tunnel"}, nil
+	case <-r.closed:
+		return proto.BindResp{}, errors.New("muxado session closed")
+	}
+}
+
+func (r *blockingRaw) Accept() (netx.LoggedConn, error) {
+	<-r.closed
+	return nil, errors.New("muxado session closed")
+}
+
+func (r *blockingRaw) Close() error {
+	r.closeOnce.Do(func() { close(r.closed) })
+	return nil
+}
+
+func connectBlocking(t *testing.T) (Session, *blockingRaw, chan error) {
+	raw := newBlockingRaw()
+	stateChanges := make(chan error)
+	sess := NewReconnectingSession(testLogger(), func(uint32) (RawSession, error) {
+		return raw, nil
+	}, stateChanges, func(Session, RawSession, uint32) (int, error) {
+		return 1, nil
+	}, ReconnectOptions{})
+	require.NoError(t, <-stateChanges)
+	return sess, raw, stateChanges
+}
+
+func listenAsync(sess Session, raw *blockingRaw) chan error {
+	result := make(chan error, 1)
+	go func() {
+		_, err := sess.Listen("tcp", nil, proto.BindExtra{}, "", "", "")
+		result <- err
+	}()
+	<-raw.listening
+	return result
+}
+
+func TestCloseFailsInFlightRequests(t *testing.T) {
+	sess, raw, stateChanges := connectBlocking(t)
+	result := listenAsync(sess, raw)
+
+	// Nobody reads state changes while closing; that must not block or panic.
+	require.NoError(t, sess.Close())
+	require.ErrorIs(t, <-result, ErrSessionClosing)
+
+	_, err := sess.Listen("tcp", nil, proto.BindExtra{}, "", "", "")
+	require.ErrorIs(t, err, ErrSessionClosing)
+
+	for range stateChanges {
+	}
+}
+
+func TestCloseWithContextWaitsForRequests(t *testing.T) {
+	sess, raw, stateChanges := connectBlocking(t)
+	go func() {
+		for range stateChanges {
+		}
+	}()
+	result := listenAsync(sess, raw)
+
+	closed := make(chan error, 1)
+	go func() { closed <- sess.CloseWithContext(context.Background()) }()
+
+	select {
+	case <-closed:
+		t.Fatal("closed before the request in flight finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(raw.release)
+	require.NoError(t, <-result)
+	require.NoError(t, <-closed)
+}
+
+func TestCloseWithContextExpires(t *testing.T) {
+	sess, raw, stateChanges := connectBlocking(t)
+	go func() {
+		for range stateChanges {
+		}
+	}()
+	result := listenAsync(sess, raw)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, sess.CloseWithContext(ctx), context.DeadlineExceeded)
+	require.ErrorIs(t, <-result, ErrSessionClosing)
+}
+
+// A raw session that records the tunnels bound and unbound through it.
+type bindingRaw struct {
+	*blockingRaw
+	mu    sync.Mutex
+	binds []string
+}
+
+func (r *bindingRaw) Listen(_ string, _ any, _ proto.BindExtra, id string, _ string, _ string) (proto.BindResp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.binds = append(r.binds, "listen "+id)
+	return proto.BindResp{ClientID: "tun_1"}, nil
+}
+
+func (r *bindingRaw) Unlisten(id string) (proto.UnbindResp, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.binds = append(r.binds, "unlisten "+id)
+	return proto.UnbindResp{}, nil
+}
+
+func TestPauseResume(t *testing.T) {
+	raw := &bindingRaw{blockingRaw: newBlockingRaw()}
+	stateChanges := make(chan error)
+	sess := NewReconnectingSession(testLogger(), func(uint32) (RawSession, error) {
+		return raw, nil
+	}, stateChanges, func(Session, RawSession, uint32) (int, error) {
+		return 1, nil
+	}, ReconnectOptions{})
+	require.NoError(t, <-stateChanges)
+	go func() {
+		for range stateChanges {
+		}
+	}()
+
+	tun, err := sess.Listen("https", nil, proto.BindExtra{}, "", "", "")
+	require.NoError(t, err)
+	require.NoError(t, tun.Pause())
+	require.NoError(t, tun.Pause())
+	require.NoError(t, tun.Resume())
+	require.NoError(t, tun.Resume())
+	require.NoError(t, tun.Close())
+
+	require.Equal(t, []string{"listen ", "unlisten tun_1", "listen tun_1", "unlisten tun_1"}, raw.binds)
+	require.NoError(t, sess.Close())
+}
+
+func TestPauseResumeMultiLeg(t *testing.T) {
+	raws := []*bindingRaw{{blockingRaw: newBlockingRaw()}, {blockingRaw: newBlockingRaw()}}
+	stateChanges := make(chan error)
+	sess := NewReconnectingSession(testLogger(), func(leg uint32) (RawSession, error) {
+		return raws[leg], nil
+	}, stateChanges, func(Session, RawSession, uint32) (int, error) {
+		return len(raws), nil
+	}, ReconnectOptions{})
+	require.NoError(t, <-stateChanges)
+	go func() {
+		for range stateChanges {
+		}
+	}()
+
+	tun, err := sess.Listen("https", nil, proto.BindExtra{}, "", "", "")
+	require.NoError(t, err)
+	require.NoError(t, tun.Pause())
+	require.NoError(t, tun.Resume())
+
+	require.Equal(t, []string{"listen ", "unlisten tun_1", "listen tun_1"}, raws[0].binds)
+	require.Equal(t, []string{"listen tun_1", "unlisten tun_1", "listen tun_1"}, raws[1].binds)
+	require.NoError(t, sess.Close())
+}
+
+func TestListenWithID(t *testing.T) {
+	raw := &bindingRaw{blockingRaw: newBlockingRaw()}
+	stateChanges := make(chan error)
+	sess := NewReconnectingSession(testLogger(), func(uint32) (RawSession, error) {
+		return raw, nil
+	}, stateChanges, func(Session, RawSession, uint32) (int, error) {
+		return 1, nil
+	}, ReconnectOptions{})
+	require.NoError(t, <-stateChanges)
+	go func() {
+		for range stateChanges {
+		}
+	}()
+
+	_, err := sess.Listen("https", nil, proto.BindExtra{}, "tun_saved", "", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"listen tun_saved"}, raw.binds)
+	require.NoError(t, sess.Close())
+}
+
+func TestReconnectAfterPanic(t *testing.T) {
+	panics := make(chan any, 1)
+	var calls atomic.Int32
+	stateChanges := make(chan error, 8)
+	sess := NewReconnectingSession(testLogger(), func(uint32) (RawSession, error) {
+		return newBlockingRaw(), nil
+	}, stateChanges, func(Session, RawSession, uint32) (int, error) {
+		if calls.Add(1) == 1 {
+			panic("boom")
+		}
+		return 1, nil
+	}, ReconnectOptions{
+		Backoff: Backoff{Min: time.Millisecond},
+		OnPanic: func(recovered any, stack []byte) {
+			require.NotEmpty(t, stack)
+			panics <- recovered
+		},
+	})
+
+	// The panic is reported, and the leg reconnects.
+	require.Equal(t, "boom", <-panics)
+	require.ErrorContains(t, <-stateChanges, "recovered from panic: boom")
+	require.NoError(t, <-stateChanges)
+	require.EqualValues(t, 2, calls.Load())
+
+	require.NoError(t, sess.Close())
+	for range stateChanges {
+	}
+}
@@ -1,9 +1,12 @@
 package client
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io"
+	"log/slog"
 	"net"
 	"strconv"
 	"sync"
@@ -12,8 +15,6 @@ import (
 	"golang.ngrok.com/ngrok/internal/tunnel/netx"
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
 
-	log "github.com/inconshreveable/log15/v3"
-
 	"golang.ngrok.com/muxado/v2"
 )
 
@@ -38,7 +39,7 @@ type Session interface {
 	//
 	// Applications will typically prefer to call the protocol-specific methods like
 	// ListenHTTP, ListenTCP, etc.
-	Listen(protocol string, opts any, extra proto.BindExtra, forwardsTo string, forwardsProto string) (Tunnel, error)
+	Listen(protocol string, opts any, extra proto.BindExtra, id string, forwardsTo string, forwardsProto string) (Tunnel, error)
 
 	// Listen negotiates with the server to create a new remote listen for the
 	// given labels. It returns a *Tunnel on success from which the caller can
@@ -61,6 +62,10 @@ type Session interface {
 
 	SrvInfo() (proto.SrvInfoResp, error)
 
+	// OpenStream opens a stream of a type that isn't part of the ngrok
+	// protocol on the connection to the server.
+	OpenStream(typ muxado.StreamType) (net.Conn, error)
+
 	// Send a muxado heartbeat and record the latency
 	Heartbeat() (time.Duration, error)
 
@@ -71,25 +76,39 @@ type Session interface {
 	// from the tunnel's Accept() method.
 	CloseTunnel(clientID string, err error) error
 
+	// Drops the connection to the server so that the session reconnects.
+	// Returns an error if the session doesn't reconnect.
+	Reconnect() error
+
 	// Closes the session
 	Close() error
+
+	// Closes the session once the requests in flight have finished, then
+	// waits for its internal goroutines to exit, both bounded by the context.
+	CloseWithContext(ctx context.Context) error
 }
 
 type session struct {
 	swapper *swapRaw
 	raw     RawSession
 	sync.RWMutex
-	log.Logger
+	*slog.Logger
 	tunnels   map[string]*tunnel
 	legNumber uint32
+	gate      *rpcGate
+	// Returns every leg of the session this one belongs to, if it has more
+	// than one.
+	legs func() []*session
+	// Called with panics recovered from handling proxy connections.
+	onPanic func(recovered any, stack []byte)
 }
 
 // NewSession starts a new go-tunnel client session running over the given
 // muxado session.
-func NewSession(logger log.Logger, mux muxado.Session, heartbeatConfig *muxado.HeartbeatConfig, handler SessionHandler) Session {
+func NewSession(logger *slog.Logger, mux muxado.Session, heartbeatConfig *muxado.HeartbeatConfig, handler SessionHandler) Session {
 	logger = newLogger(logger)
 	s := &session{
-		raw:     newRawSession(mux, logger, heartbeatConfig, handler),
+		raw:     newRawSession(mux, logger, heartbeatConfig, handler, nil, nil),
 		Logger:  logger,
 		tunnels: make(map[string]*tunnel),
 	}
@@ -114,12 +133,20 @@ func (s *session) Latency() <-chan time.Duration {
 	return s.raw.Latency()
 }
 
-func (s *session) Heartbeat() (time.Duration, error) {
-	return s.raw.Heartbeat()
+func (s *session) Heartbeat() (latency time.Duration, err error) {
+	err = s.gate.do(func() (err error) {
+		latency, err = s.raw.Heartbeat()
+		return
+	})
+	return
 }
 
-func (s *session) Listen(protocol string, opts any, extra proto.BindExtra, forwardsTo string, forwardsProto string) (Tunnel, error) {
-	resp, err := s.raw.Listen(protocol, opts, extra, "", forwardsTo, forwardsProto)
+func (s *session) Listen(protocol string, opts any, extra proto.BindExtra, id string, forwardsTo string, forwardsProto string) (Tunnel, error) {
+	var resp proto.BindResp
+	err := s.gate.do(func() (err error) {
+		resp, err = s.raw.Listen(protocol, opts, extra, id, forwardsTo, forwardsProto)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -139,7 +166,11 @@ func (s *session) Listen(protocol string, opts any, extra proto.BindExtra, forwa
 }
 
 func (s *session) ListenLabel(labels map[string]string, metadata string, forwardsTo string, forwardsProto string) (Tunnel, error) {
-	resp, err := s.raw.ListenLabel(labels, metadata, forwardsTo, forwardsProto)
+	var resp proto.StartTunnelWithLabelResp
+	err := s.gate.do(func() (err error) {
+		resp, err = s.raw.ListenLabel(labels, metadata, forwardsTo, forwardsProto)
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -159,27 +190,39 @@ func (s *session) ListenLabel(labels map[string]string, metadata string, forward
 }
 
 func (s *session) ListenHTTP(opts *proto.HTTPEndpoint, extra proto.BindExtra, forwardsTo string, forwardsProto string) (Tunnel, error) {
-	return s.Listen("http", opts, extra, forwardsTo, forwardsProto)
+	return s.Listen("http", opts, extra, "", forwardsTo, forwardsProto)
 }
 
 func (s *session) ListenHTTPS(opts *proto.HTTPEndpoint, extra proto.BindExtra, forwardsTo string, forwardsProto string) (Tunnel, error) {
-	return s.Listen("https", opts, extra, forwardsTo, forwardsProto)
+	return s.Listen("https", opts, extra, "", forwardsTo, forwardsProto)
 }
 
 func (s *session) ListenTCP(opts *proto.TCPEndpoint, extra proto.BindExtra, forwardsTo string) (Tunnel, error) {
-	return s.Listen("tcp", opts, extra, forwardsTo, "")
+	return s.Listen("tcp", opts, extra, "", forwardsTo, "")
 }
 
 func (s *session) ListenTLS(opts *proto.TLSEndpoint, extra proto.BindExtra, forwardsTo string) (Tunnel, error) {
-	return s.Listen("tls", opts, extra, forwardsTo, "")
+	return s.Listen("tls", opts, extra, "", forwardsTo, "")
 }
 
 func (s *session) ListenSSH(opts *proto.SSHOptions, extra proto.BindExtra, forwardsTo string) (Tunnel, error) {
-	return s.Listen("ssh", opts, extra, forwardsTo, "")
+	return s.Listen("ssh", opts, extra, "", forwardsTo, "")
+}
+
+func (s *session) SrvInfo() (resp proto.SrvInfoResp, err error) {
+	err = s.gate.do(func() (err error) {
+		resp, err = s.raw.SrvInfo()
+		return
+	})
+	return
 }
 
-func (s *session) SrvInfo() (proto.SrvInfoResp, error) {
-	return s.raw.SrvInfo()
+func (s *session) OpenStream(typ muxado.StreamType) (conn net.Conn, err error) {
+	err = s.gate.do(func() (err error) {
+		conn, err = s.raw.OpenStream(typ)
+		return
+	})
+	return
 }
 
 func (s *session) CloseTunnel(clientId string, err error) error {
@@ -195,6 +238,16 @@ func (s *session) Close() error {
 	return s.raw.Close()
 }
 
+func (s *session) Reconnect() error {
+	return errors.New("the session does not reconnect")
+}
+
+// CloseWithContext closes the session. A plain session has no requests or
+// reconnect loops to wait for.
+func (s *session) CloseWithContext(_ context.Context) error {
+	return s.Close()
+}
+
 func (s *session) receive() {
 	// when we shut down, close all of the open tunnels
 	defer func() {
@@ -217,6 +270,8 @@ func (s *session) receive() {
 }
 
 func (s *session) handleProxy(proxy netx.LoggedConn) {
+	defer recoverPanic(s.onPanic, func(any) { _ = proxy.Close() })
+	received := time.Now()
 	proxyError := func(msg string, args ...any) {
 		proxy.Error(msg, args...)
 		proxy.Close()
@@ -230,6 +285,10 @@ func (s *session) handleProxy(proxy netx.LoggedConn) {
 		return
 	}
 
+	if traced, ok := s.raw.(interface{ getTracer() *Tracer }); ok {
+		traced.getTracer().message("recv", proto.ProxyReq, &proxyHdr)
+	}
+
 	// find tunnel
 	tunnel, ok := s.getTunnel(proxyHdr.ID)
 	if !ok {
@@ -240,7 +299,7 @@ func (s *session) handleProxy(proxy netx.LoggedConn) {
 	tunnel.shut.RLock()
 	defer tunnel.shut.RUnlock()
 	// deliver proxy connection + wrap it so it has a proper RemoteAddr()
-	tunnel.handleConn(newProxyConn(proxy, proxyHdr))
+	tunnel.handleConn(newProxyConn(proxy, proxyHdr, received))
 }
 
 // Public so we can use it in lib/tunnel/server/functional_test.go
@@ -259,8 +318,16 @@ func (s *session) unlisten(bindID string) error {
 	// delete tunnel
 	s.delTunnel(bindID)
 
-	// ask server to unlisten
-	resp, err := s.raw.Unlisten(bindID)
+	return s.unbind(bindID)
+}
+
+// Ask the server to unlisten a tunnel, without removing it from the registry.
+func (s *session) unbind(bindID string) error {
+	var resp proto.UnbindResp
+	err := s.gate.do(func() (err error) {
+		resp, err = s.raw.Unlisten(bindID)
+		return
+	})
 	if err != nil {
 		return err
 	}
@@ -274,6 +341,34 @@ func (s *session) unlisten(bindID string) error {
 	return nil
 }
 
+// Ask the server to listen for a tunnel that was unbound, under the same ID.
+func (s *session) rebind(t *tunnel) error {
+	cfg := t.RemoteBindConfig()
+	extra := t.bindExtra
+	extra.Token = cfg.Token
+
+	var resp proto.BindResp
+	err := s.gate.do(func() (err error) {
+		resp, err = s.raw.Listen(cfg.ConfigProto, cfg.Opts, extra, t.ID(), t.ForwardsTo(), t.ForwardsProto())
+		return
+	})
+	if err != nil {
+		return err
+	}
+	if resp.Error != "" {
+		return proto.StringError(resp.Error)
+	}
+	return nil
+}
+
+// The legs of the session, which tunnels are bound on all of.
+func (s *session) legSessions() []*session {
+	if s.legs == nil {
+		return []*session{s}
+	}
+	return s.legs()
+}
+
 func (s *session) getTunnel(id string) (t *tunnel, ok bool) {
 	s.RLock()
 	defer s.RUnlock()
@@ -298,7 +393,7 @@ type proxyConn struct {
 	addr *net.TCPAddr
 }
 
-func newProxyConn(conn netx.LoggedConn, hdr proto.ProxyHeader) *ProxyConn {
+func newProxyConn(conn netx.LoggedConn, hdr proto.ProxyHeader, received time.Time) *ProxyConn {
 	pconn := &proxyConn{LoggedConn: conn}
 
 	ip, strport, err := net.SplitHostPort(hdr.ClientAddr)
@@ -320,8 +415,9 @@ func newProxyConn(conn netx.LoggedConn, hdr proto.ProxyHeader) *ProxyConn {
 	}
 
 	return &ProxyConn{
-		Header: hdr,
-		Conn:   pconn,
+		Header:   hdr,
+		Conn:     pconn,
+		Received: received,
 	}
 }
 
@@ -1,6 +1,11 @@
 package client
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
 
 // facilitates controlled shutdowns of resources
 type shutdown struct {
@@ -31,3 +36,68 @@ func (s *shutdown) Shut(fn func()) {
 	s.Unlock()
 	s.once.Do(fn)
 }
+
+// Tracks the requests in flight on a session so that it can refuse new ones
+// once it starts closing and wait for the rest to finish. A nil gate admits
+// every request.
+type rpcGate struct {
+	mu      sync.Mutex
+	closing bool
+	pending sync.WaitGroup
+}
+
+// Run a request, failing with ErrSessionClosing if the session is closing. If
+// the request fails because the session closed underneath it, the error also
+// matches ErrSessionClosing.
+func (g *rpcGate) do(fn func() error) error {
+	if g == nil {
+		return fn()
+	}
+	g.mu.Lock()
+	if g.closing {
+		g.mu.Unlock()
+		return ErrSessionClosing
+	}
+	g.pending.Add(1)
+	g.mu.Unlock()
+	defer g.pending.Done()
+
+	err := fn()
+	if err != nil && g.isClosing() && !errors.Is(err, ErrSessionClosing) {
+		return fmt.Errorf("%w: %w", ErrSessionClosing, err)
+	}
+	return err
+}
+
+// Refuse any further requests.
+func (g *rpcGate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closing = true
+}
+
+func (g *rpcGate) isClosing() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.closing
+}
+
+// Wait for the requests in flight to finish, or for the context to expire.
+func (g *rpcGate) wait(ctx context.Context) error {
+	return waitContext(ctx, g.pending.Wait)
+}
+
+// Run a blocking wait, giving up once the context expires.
+func waitContext(ctx context.Context, wait func()) error {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
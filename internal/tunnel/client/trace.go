@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"strings"
+
+	"golang.ngrok.com/muxado/v2/frame"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// A Tracer writes the protocol messages exchanged with the server, and
+// optionally a summary of each muxado frame, as JSON lines. Credentials in
+// the messages are hidden. A nil *Tracer traces nothing.
+type Tracer struct {
+	logger *slog.Logger
+	frames bool
+}
+
+// NewTracer creates a Tracer that writes to w.
+func NewTracer(w io.Writer, frames bool) *Tracer {
+	return &Tracer{
+		logger: slog.New(slog.NewJSONHandler(w, nil)),
+		frames: frames,
+	}
+}
+
+var reqTypeNames = map[proto.ReqType]string{
+	proto.AuthReq:                 "Auth",
+	proto.BindReq:                 "Bind",
+	proto.UnbindReq:               "Unbind",
+	proto.StartTunnelWithLabelReq: "StartTunnelWithLabel",
+	proto.ProxyReq:                "Proxy",
+	proto.RestartReq:              "Restart",
+	proto.StopReq:                 "Stop",
+	proto.UpdateReq:               "Update",
+	proto.SrvInfoReq:              "SrvInfo",
+	proto.StopTunnelReq:           "StopTunnel",
+}
+
+// The fields whose values are hidden, matched case-insensitively against
+// the end of their names. "key" covers the private keys uploaded for TLS
+// termination at the edge.
+var secretFields = []string{"token", "secret", "password", "cookie", "key"}
+
+// Record a message sent to or received from the server, in the direction
+// "send" or "recv".
+func (t *Tracer) message(dir string, reqType proto.ReqType, msg any) {
+	if t == nil {
+		return
+	}
+	body, err := redact(msg)
+	if err != nil {
+		return
+	}
+	name, ok := reqTypeNames[reqType]
+	if !ok {
+		name = "Unknown"
+	}
+	t.logger.LogAttrs(context.Background(), slog.LevelInfo, "message",
+		slog.String("dir", dir),
+		slog.String("type", name),
+		slog.Any("body", body),
+	)
+}
+
+// Marshal a message with the values of its secret fields hidden.
+func redact(msg any) (json.RawMessage, error) {
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var tree any
+	if err := json.Unmarshal(buf, &tree); err != nil {
+		return nil, err
+	}
+	return json.Marshal(redactTree(tree))
+}
+
+func redactTree(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, val := range v {
+			if s, ok := val.(string); ok && s != "" && isSecret(key) {
+				v[key] = proto.ObfuscatedString(s).String()
+				continue
+			}
+			v[key] = redactTree(val)
+		}
+	case []any:
+		for i, val := range v {
+			v[i] = redactTree(val)
+		}
+	}
+	return v
+}
+
+func isSecret(field string) bool {
+	field = strings.ToLower(field)
+	for _, secret := range secretFields {
+		if strings.HasSuffix(field, secret) {
+			return true
+		}
+	}
+	return false
+}
+
+// TraceFrames wraps a muxado framer so that it records a summary of each
+// frame, if frame tracing is enabled.
+func (t *Tracer) TraceFrames(f frame.Framer) frame.Framer {
+	if t == nil || !t.frames {
+		return f
+	}
+	return &tracingFramer{Framer: f, tracer: t}
+}
+
+type tracingFramer struct {
+	frame.Framer
+	tracer *Tracer
+}
+
+func (f *tracingFramer) WriteFrame(fr frame.Frame) error {
+	err := f.Framer.WriteFrame(fr)
+	if err == nil {
+		f.tracer.frame("send", fr)
+	}
+	return err
+}
+
+func (f *tracingFramer) ReadFrame() (frame.Frame, error) {
+	fr, err := f.Framer.ReadFrame()
+	if err == nil {
+		f.tracer.frame("recv", fr)
+	}
+	return fr, err
+}
+
+func (t *Tracer) frame(dir string, fr frame.Frame) {
+	t.logger.LogAttrs(context.Background(), slog.LevelInfo, "frame",
+		slog.String("dir", dir),
+		slog.String("type", fr.Type().String()),
+		slog.Uint64("stream", uint64(fr.StreamId())),
+		slog.Uint64("length", uint64(fr.Length())),
+		slog.Uint64("flags", uint64(fr.Flags())),
+	)
+}
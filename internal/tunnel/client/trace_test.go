@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/muxado/v2/frame"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestTracerRedacts(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer(&buf, false)
+	tracer.message("send", proto.AuthReq, &proto.Auth{
+		Extra: proto.AuthExtra{Authtoken: "s3cr3t-authtoken", OS: "linux"},
+	})
+	tracer.message("send", proto.BindReq, &proto.Bind{
+		Proto: "https",
+		Opts: map[string]any{
+			"BasicAuth": []any{map[string]any{"Username": "me", "CleartextPassword": "hunter2"}},
+		},
+	})
+
+	out := buf.String()
+	require.NotContains(t, out, "s3cr3t-authtoken")
+	require.NotContains(t, out, "hunter2")
+
+	dec := json.NewDecoder(&buf)
+	var line struct {
+		Dir  string
+		Type string
+		Body map[string]any
+	}
+	require.NoError(t, dec.Decode(&line))
+	require.Equal(t, "send", line.Dir)
+	require.Equal(t, "Auth", line.Type)
+	extra := line.Body["Extra"].(map[string]any)
+	require.Equal(t, "HIDDEN", extra["Authtoken"])
+	require.Equal(t, "linux", extra["OS"])
+
+	require.NoError(t, dec.Decode(&line))
+	require.Equal(t, "Bind", line.Type)
+	require.Equal(t, "https", line.Body["Proto"])
+}
+
+func TestTracerFrames(t *testing.T) {
+	var nilTracer *Tracer
+	nilTracer.message("send", proto.AuthReq, &proto.Auth{})
+
+	var wire, trace bytes.Buffer
+	framer := NewTracer(&trace, false).TraceFrames(frame.NewFramer(&wire, &wire))
+	_, traced := framer.(*tracingFramer)
+	require.False(t, traced, "frames shouldn't be traced unless enabled")
+
+	framer = NewTracer(&trace, true).TraceFrames(frame.NewFramer(&wire, &wire))
+	var data frame.Data
+	require.NoError(t, data.Pack(3, []byte("hello"), true, true))
+	require.NoError(t, framer.WriteFrame(&data))
+	_, err := framer.ReadFrame()
+	require.NoError(t, err)
+
+	dec := json.NewDecoder(&trace)
+	for _, dir := range []string{"send", "recv"} {
+		var line struct {
+			Msg    string
+			Dir    string
+			Type   string
+			Stream uint64
+			Length uint64
+		}
+		require.NoError(t, dec.Decode(&line))
+		require.Equal(t, "frame", line.Msg)
+		require.Equal(t, dir, line.Dir)
+		require.Equal(t, "DATA", line.Type)
+		require.Equal(t, uint64(3), line.Stream)
+		require.Equal(t, uint64(5), line.Length)
+	}
+}
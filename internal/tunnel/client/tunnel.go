@@ -5,6 +5,7 @@ import (
 	"net"
 	"net/url"
 	"sync/atomic"
+	"time"
 
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
 )
@@ -17,11 +18,19 @@ type Tunnel interface {
 	ID() string
 	ForwardsTo() string
 	ForwardsProto() string
+	// Pause asks the server to stop routing connections to the tunnel
+	// without closing it, and Resume asks it to start again. A paused
+	// tunnel stays paused across reconnects.
+	Pause() error
+	Resume() error
 }
 
 type ProxyConn struct {
 	Header proto.ProxyHeader
 	Conn   net.Conn
+	// The time at which the stream for this connection was received from
+	// the server.
+	Received time.Time
 }
 
 // A Tunnel is a net.Listener that Accept()'s connections from a
@@ -29,7 +38,7 @@ type ProxyConn struct {
 type tunnel struct {
 	id            atomic.Value
 	configProto   string
-	url           string
+	url           atomic.Value // the URL assigned by the most recent bind
 	opts          any
 	token         string
 	bindExtra     proto.BindExtra
@@ -41,16 +50,21 @@ type tunnel struct {
 	unlisten   func() error    // call this function to close the tunnel
 	closeError error           // error to use on accept error after a tunnel close
 
+	sess   *session    // the session to bind and unbind the tunnel through
+	paused atomic.Bool // whether the server has been asked to stop routing to it
+
 	shut shutdown // for clean shutdowns
 }
 
 func newTunnel(resp proto.BindResp, extra proto.BindExtra, s *session, forwardsTo string, forwardsProto string) *tunnel {
 	id := atomic.Value{}
 	id.Store(resp.ClientID)
+	u := atomic.Value{}
+	u.Store(resp.URL)
 	return &tunnel{
 		id:            id,
 		configProto:   resp.Proto,
-		url:           resp.URL,
+		url:           u,
 		opts:          resp.Opts,
 		token:         resp.Extra.Token,
 		bindExtra:     extra, // this makes the reconnecting session a little easier
@@ -59,6 +73,7 @@ func newTunnel(resp proto.BindResp, extra proto.BindExtra, s *session, forwardsT
 		forwardsTo:    forwardsTo,
 		forwardsProto: forwardsProto,
 		closeError:    errors.New("Listener closed"),
+		sess:          s,
 	}
 }
 
@@ -113,6 +128,50 @@ func (t *tunnel) Close() (err error) {
 	return
 }
 
+var errPauseLabeled = errors.New("labeled tunnels can't be paused")
+
+// Pause asks the server to stop routing connections to the tunnel while it
+// stays open locally, e.g. to take it out of an endpoint pool. The tunnel is
+// unbound on every leg of the session.
+func (t *tunnel) Pause() error {
+	if t.labels != nil {
+		return errPauseLabeled
+	}
+	if !t.paused.CompareAndSwap(false, true) {
+		return nil
+	}
+	legs := t.sess.legSessions()
+	for i, leg := range legs {
+		if err := leg.unbind(t.ID()); err != nil {
+			// bind the legs already unbound again, so it isn't half paused
+			for _, unbound := range legs[:i] {
+				_ = unbound.rebind(t)
+			}
+			t.paused.Store(false)
+			return err
+		}
+	}
+	return nil
+}
+
+// Resume binds a paused tunnel again on every leg, under the same ID.
+func (t *tunnel) Resume() error {
+	if !t.paused.CompareAndSwap(true, false) {
+		return nil
+	}
+	legs := t.sess.legSessions()
+	for i, leg := range legs {
+		if err := leg.rebind(t); err != nil {
+			for _, bound := range legs[:i] {
+				_ = bound.unbind(t.ID())
+			}
+			t.paused.Store(true)
+			return err
+		}
+	}
+	return nil
+}
+
 // Addr returns the address of the public endpoint of the tunnel listener on the
 // remote machine.
 func (t *tunnel) Addr() net.Addr {
@@ -135,11 +194,18 @@ func (t *tunnel) ID() string {
 	return t.id.Load().(string)
 }
 
+// URL returns the URL assigned by the most recent bind, which is empty for
+// label tunnels.
+func (t *tunnel) URL() string {
+	u, _ := t.url.Load().(string)
+	return u
+}
+
 // RemoteBindConfig returns more detailed information about the public endpoint of the
 // tunnel listener on the remote machine.
 func (t *tunnel) RemoteBindConfig() *RemoteBindConfig {
 	return &RemoteBindConfig{
-		URL:         t.url,
+		URL:         t.URL(),
 		ConfigProto: t.configProto,
 		Opts:        t.opts,
 		Token:       t.token,
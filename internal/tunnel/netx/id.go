@@ -0,0 +1,17 @@
+package netx
+
+import (
+	"encoding/hex"
+	"math/rand"
+)
+
+// RandID creates a random identifier from idlen random bytes, hex encoded.
+// Useful for assigning mostly-unique identifiers for logging that are unlikely
+// to collide because of their short lifespan.
+func RandID(idlen int) string {
+	b := make([]byte, idlen)
+	for i := range b {
+		b[i] = byte(rand.Intn(256))
+	}
+	return hex.EncodeToString(b)
+}
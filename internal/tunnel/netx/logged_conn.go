@@ -1,23 +1,24 @@
 package netx
 
 import (
+	"log/slog"
 	"net"
-
-	log "github.com/inconshreveable/log15/v3"
-	logext "github.com/inconshreveable/log15/v3/ext"
 )
 
 // LoggedConn is a connection with an embedded logger
 type LoggedConn interface {
 	net.Conn
-	log.Logger
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
 
 	Unwrap() net.Conn
 }
 
 type logged struct {
 	net.Conn
-	log.Logger
+	*slog.Logger
 	id string
 }
 
@@ -37,12 +38,12 @@ func (c *logged) Unwrap() net.Conn {
 	return c.Conn
 }
 
-func NewLoggedConn(parent log.Logger, conn net.Conn, ctx ...any) LoggedConn {
+func NewLoggedConn(parent *slog.Logger, conn net.Conn, ctx ...any) LoggedConn {
 	c := &logged{
 		Conn: conn,
-		id:   logext.RandId(6),
+		id:   RandID(6),
 	}
-	c.Logger = parent.New(append([]any{"id", c.id}, ctx...)...)
+	c.Logger = parent.With(append([]any{"id", c.id}, ctx...)...)
 	if _, ok := conn.(closeReader); !ok {
 		return c
 	}
@@ -0,0 +1,125 @@
+// Package upstream holds the options that control how the forwarder connects
+// to the upstream service. They are set via the config package and consumed by
+// the forwarder in the root package, but never sent over the wire.
+package upstream
+
+import (
+	"context"
+	"crypto/x509"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// HTTPServer holds settings for the http.Servers that serve an endpoint
+// within the agent. Zero fields leave the server's own settings in place.
+type HTTPServer struct {
+	ReadHeaderTimeout time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// ErrorLog replaces the session's logger for the server's errors.
+	ErrorLog *log.Logger
+}
+
+// Options for connecting to the upstream service of a forwarded tunnel.
+type Options struct {
+	// Dial, if set, replaces the default dialer used to connect to the
+	// upstream service.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+	// Control, KeepAlive and Resolver configure the default dialer, as the
+	// fields of the same names on [net.Dialer] do. They're ignored if Dial is
+	// set.
+	Control   func(network, address string, c syscall.RawConn) error
+	KeepAlive time.Duration
+	Resolver  *net.Resolver
+	// RoundRobin rotates successive connections across the addresses the
+	// upstream hostname resolves to.
+	RoundRobin bool
+	// SNIRoutes maps the server names requested by end-to-end TLS
+	// connections to the upstream addresses they are forwarded to.
+	SNIRoutes map[string]string
+	// HostRoutes maps the Host headers of HTTP requests to the upstream
+	// services they are proxied to.
+	HostRoutes map[string]*url.URL
+	// Selector, if set, chooses the upstream URL each HTTP request is
+	// proxied to, or returns the empty string to route it by HostRoutes.
+	Selector func(*http.Request) string
+	// HealthCheck, if set, is called every HealthInterval while a pooled
+	// tunnel is open. The tunnel leaves its pool while it fails.
+	HealthCheck    func(ctx context.Context) error
+	HealthInterval time.Duration
+	// HealthGate holds a pooled tunnel back from binding until HealthCheck
+	// first passes.
+	HealthGate bool
+	// IdleTimeout, if set, closes forwarded connections that have had no
+	// traffic in either direction for this long.
+	IdleTimeout time.Duration
+	// RootCAs and the certificates in the PEM file at CAFile verify the
+	// certificates of TLS upstreams in place of the system roots. The file is
+	// loaded when forwarding starts.
+	RootCAs *x509.CertPool
+	CAFile  string
+	// InsecureSkipVerify accepts any certificate from TLS upstreams.
+	InsecureSkipVerify bool
+	// DialTimeout, if set, limits each attempt to connect to the upstream.
+	DialTimeout time.Duration
+	// Retries is how many more times a failed connection to the upstream
+	// is attempted, waiting RetryBackoff before the first retry and twice
+	// as long before each one after.
+	Retries      int
+	RetryBackoff time.Duration
+	// MaxHeaderBytes, MaxBodyBytes, ReadHeaderTimeout and ReadTimeout, if
+	// set, limit the requests proxied to the upstream of an HTTP endpoint.
+	MaxHeaderBytes    int
+	MaxBodyBytes      int64
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	// HTTPServer tunes the HTTP servers that serve the endpoint within the
+	// agent.
+	HTTPServer HTTPServer
+	// ForwardedHeaders controls the Via and X-Forwarded-* headers added to
+	// the requests proxied to the upstream of an HTTP endpoint.
+	ForwardedHeaders ForwardedHeadersMode
+	// Identity, if set, passes the identity of the users the endpoint
+	// authenticated to the upstream of an HTTP endpoint.
+	Identity *Identity
+	// WebSocketKeepalive, if set, is how often forwarded WebSockets are
+	// pinged to keep them alive.
+	WebSocketKeepalive time.Duration
+	// SpillDir, if set, is the directory that data arriving on a forwarded
+	// connection faster than the upstream reads it is buffered in, up to
+	// SpillMaxBytes per connection.
+	SpillDir      string
+	SpillMaxBytes int64
+}
+
+// Identity configures how the identity of authenticated users is passed to the
+// upstream service.
+type Identity struct {
+	// Trusted is whether the endpoint authenticates users, so that the
+	// identity headers on requests were added by the ngrok service.
+	Trusted bool
+	// Headers sets the conventional X-Forwarded-* identity headers.
+	Headers bool
+	// JWTKey, if set, signs a JWT asserting the identity, which is issued by
+	// JWTIssuer and valid for JWTTTL.
+	JWTKey    []byte
+	JWTIssuer string
+	JWTTTL    time.Duration
+}
+
+// ForwardedHeadersMode is how the forwarder sets the Via and X-Forwarded-*
+// headers of proxied requests.
+type ForwardedHeadersMode int
+
+const (
+	// ForwardedHeadersNone leaves the headers alone.
+	ForwardedHeadersNone ForwardedHeadersMode = iota
+	// ForwardedHeadersAppend adds to the headers received with the request.
+	ForwardedHeadersAppend
+	// ForwardedHeadersReplace discards the headers received with the request.
+	ForwardedHeadersReplace
+)
@@ -0,0 +1,89 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// LeaderLock is a lock shared by the replicas of an application, such as a
+// Kubernetes Lease or a row in a database, used by [ListenAsLeader] to elect
+// the one replica that binds an endpoint.
+type LeaderLock interface {
+	// Lock blocks until this replica holds the lock, or ctx is done. The
+	// returned context is canceled if the lock is lost before Unlock is
+	// called, for instance because it couldn't be renewed.
+	Lock(ctx context.Context) (context.Context, error)
+	// Unlock releases the lock so that another replica may take it.
+	Unlock(ctx context.Context) error
+}
+
+// ListenAsLeader binds the endpoint configured by cfg only while this replica
+// holds lock, so that when several replicas publish the same URL without
+// pooling, one serves it and the others stand by.
+//
+// Once the lock is acquired, the endpoint is started and serve is called with
+// it, and with a context that's canceled when leadership is lost: if the lock
+// is lost, or if the session disconnects from the ngrok service, in which case
+// the lock is released so that a replica with a working session can take
+// over. The endpoint is then closed and, once the session has reconnected,
+// this replica contends for the lock again.
+//
+// ListenAsLeader returns once ctx is done, or serve returns while this
+// replica is still the leader, with the error it returned.
+func ListenAsLeader(ctx context.Context, sess Session, lock LeaderLock, cfg config.Tunnel, serve func(context.Context, Tunnel) error) error {
+	impl, err := unwrapSession(sess, "ListenAsLeader")
+	if err != nil {
+		return err
+	}
+	for {
+		if err := impl.conn.waitConnected(ctx); err != nil {
+			return err
+		}
+		lost, err := lock.Lock(ctx)
+		if err != nil {
+			return err
+		}
+		err = lead(ctx, lost, sess, impl, cfg, serve)
+		// Release the lock even if ctx is done, so that the next leader
+		// doesn't have to wait for it to expire.
+		unlockErr := lock.Unlock(context.WithoutCancel(ctx))
+		switch {
+		case ctx.Err() != nil:
+			return ctx.Err()
+		case !errors.Is(err, errLeadershipLost):
+			return err
+		case unlockErr != nil:
+			return unlockErr
+		}
+	}
+}
+
+var errLeadershipLost = errors.New("leadership lost")
+
+// Serve the endpoint as the leader. Returns errLeadershipLost if leadership
+// is lost first.
+func lead(ctx, lost context.Context, sess Session, impl *sessionImpl, cfg config.Tunnel, serve func(context.Context, Tunnel) error) error {
+	leadCtx, cancel := context.WithCancel(lost)
+	defer cancel()
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+	go func() {
+		if impl.conn.waitDisconnected(leadCtx) == nil {
+			cancel()
+		}
+	}()
+
+	tun, err := sess.Listen(leadCtx, cfg)
+	if err == nil {
+		closeOnLoss := context.AfterFunc(leadCtx, func() { _ = tun.Close() })
+		err = serve(leadCtx, tun)
+		closeOnLoss()
+		_ = tun.Close()
+	}
+	if leadCtx.Err() != nil {
+		return errLeadershipLost
+	}
+	return err
+}
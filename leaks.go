@@ -0,0 +1,211 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime"
+	"runtime/debug"
+	"sync/atomic"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// The number of sessions in the process that have connected and not yet
+// closed.
+var openSessions atomic.Int64
+
+// Resources counts what a [Session] is holding open. A count that keeps
+// growing in a long-running process usually means that tunnels or
+// connections aren't being closed.
+type Resources struct {
+	// The number of sessions open across the whole process.
+	Sessions int64
+	// The number of the session's tunnels that have not been closed.
+	Tunnels int
+	// The number of connections accepted from the session's tunnels that
+	// have not been closed.
+	Connections int64
+	// The number of goroutines started by this package for the session that
+	// are still running, such as those forwarding connections, applying an
+	// [AcceptPolicy], or calling handlers.
+	Goroutines int64
+}
+
+// WithLeakDetection enables warnings for a [Session] or [Tunnel] that is
+// garbage collected without being closed. Warnings are written to the logger
+// configured with [WithLogger].
+//
+// This relies on finalizers and is meant for debugging. A Session is kept
+// alive by the Tunnels and Forwarders created from it, so dropping all of
+// them without closing the Session is reported as well. While enabled, the
+// Session and the Tunnels it creates are wrappers, so they won't compare equal
+// to those passed to event handlers.
+func WithLeakDetection(enable bool) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.LeakDetection = enable
+	}
+}
+
+// Counts the goroutines started for a session. A nil count still starts them.
+type goroutineCount struct {
+	running atomic.Int64
+	// Called with the panics recovered from the goroutines. If nil, panics
+	// aren't recovered.
+	onPanic func(recovered any, stack []byte)
+}
+
+// Run f in a new goroutine, counting it until it returns.
+func (c *goroutineCount) spawn(f func()) {
+	if c == nil {
+		go f()
+		return
+	}
+	c.running.Add(1)
+	go func() {
+		defer c.running.Add(-1)
+		defer c.recover()
+		f()
+	}()
+}
+
+// Run f, returning an error in place of a panic, for goroutines whose errors
+// are reported elsewhere, like those of an errgroup.
+func (c *goroutineCount) guard(f func() error) func() error {
+	return func() (err error) {
+		if c == nil || c.onPanic == nil {
+			return f()
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				c.onPanic(r, debug.Stack())
+				err = errPanic{r}
+			}
+		}()
+		return f()
+	}
+}
+
+// Recover a panic and report it. Must be deferred directly.
+func (c *goroutineCount) recover() {
+	if c.onPanic == nil {
+		return
+	}
+	if r := recover(); r != nil {
+		c.onPanic(r, debug.Stack())
+	}
+}
+
+func (c *goroutineCount) load() int64 {
+	if c == nil {
+		return 0
+	}
+	return c.running.Load()
+}
+
+func (s *sessionImpl) ActiveResources() Resources {
+	res := Resources{
+		Sessions:   openSessions.Load(),
+		Goroutines: s.goroutines.load(),
+	}
+	if s.acct != nil {
+		res.Connections = s.acct.open.Load()
+	}
+	s.tunnelsMu.Lock()
+	res.Tunnels = len(s.tunnels)
+	s.tunnelsMu.Unlock()
+	return res
+}
+
+// Count the session as open until markClosed is called.
+func (s *sessionImpl) markOpen() {
+	openSessions.Add(1)
+}
+
+// Stop counting the session as open, and cancel the contexts of its
+// connections. Safe to call more than once.
+func (s *sessionImpl) markClosed() {
+	if s.closed.CompareAndSwap(false, true) {
+		openSessions.Add(-1)
+	}
+	s.life.end()
+}
+
+// A Session handed to the application while leak detection is enabled. It's
+// only referenced by the application and by the Tunnels and Forwarders it
+// hands out, so it becomes unreachable once the application drops all of
+// them, even though the session's goroutines still run.
+type leakCheckedSession struct {
+	*sessionImpl
+	logger *slog.Logger
+}
+
+func watchSession(s *sessionImpl, logger *slog.Logger) *leakCheckedSession {
+	handle := &leakCheckedSession{sessionImpl: s, logger: logger}
+	runtime.SetFinalizer(handle, func(*leakCheckedSession) {
+		if !s.closed.Load() {
+			logger.Warn("session was garbage collected without being closed", "clientid", s.inner().ClientID)
+		}
+	})
+	return handle
+}
+
+func (s *leakCheckedSession) Listen(ctx context.Context, cfg config.Tunnel) (Tunnel, error) {
+	impl, err := s.listen(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return s.watchTunnel(impl), nil
+}
+
+func (s *leakCheckedSession) watchTunnel(impl *tunnelImpl) Tunnel {
+	handle := &leakCheckedTunnel{tunnelImpl: impl, sess: s}
+	logger := s.logger
+	runtime.SetFinalizer(handle, func(*leakCheckedTunnel) {
+		if !impl.closed.Load() {
+			logger.Warn("tunnel was garbage collected without being closed", "clientid", impl.ID())
+		}
+	})
+	return handle
+}
+
+func (s *leakCheckedSession) ListenAndForward(ctx context.Context, backend *url.URL, cfg config.Tunnel) (Forwarder, error) {
+	return s.keepAlive(s.sessionImpl.ListenAndForward(ctx, backend, cfg))
+}
+
+func (s *leakCheckedSession) ListenAndServeHTTP(ctx context.Context, cfg config.Tunnel, server *http.Server) (Forwarder, error) {
+	return s.keepAlive(s.sessionImpl.ListenAndServeHTTP(ctx, cfg, server))
+}
+
+func (s *leakCheckedSession) ListenAndHandleHTTP(ctx context.Context, cfg config.Tunnel, handler *http.Handler) (Forwarder, error) {
+	return s.keepAlive(s.sessionImpl.ListenAndHandleHTTP(ctx, cfg, handler))
+}
+
+// Keep the session reachable for as long as the Forwarder is.
+func (s *leakCheckedSession) keepAlive(fwd Forwarder, err error) (Forwarder, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &leakCheckedForwarder{Forwarder: fwd, sess: s}, nil
+}
+
+// A Tunnel handed to the application while leak detection is enabled.
+type leakCheckedTunnel struct {
+	*tunnelImpl
+	sess Session
+}
+
+func (t *leakCheckedTunnel) Session() Session {
+	return t.sess
+}
+
+// A Forwarder handed to the application while leak detection is enabled.
+type leakCheckedForwarder struct {
+	Forwarder
+	sess Session
+}
+
+func (f *leakCheckedForwarder) Session() Session {
+	return f.sess
+}
@@ -0,0 +1,97 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// Sends the message of each record it handles.
+type messageHandler struct {
+	discardHandler
+	msgs chan string
+}
+
+func (h messageHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h messageHandler) Handle(_ context.Context, r slog.Record) error {
+	h.msgs <- r.Message
+	return nil
+}
+
+func TestActiveResources(t *testing.T) {
+	sess := &sessionImpl{}
+	sess.acct = &sessionAccounting{session: sess}
+	before := openSessions.Load()
+	sess.markOpen()
+
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{Sess: sess, Tunnel: q, acct: sess.acct, goroutines: &sess.goroutines}
+	sess.addTunnel(tun)
+	local, _ := net.Pipe()
+	q.conns <- &tunnel_client.ProxyConn{Conn: local}
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+
+	release := make(chan struct{})
+	sess.goroutines.spawn(func() { <-release })
+
+	require.Equal(t, Resources{
+		Sessions:    before + 1,
+		Tunnels:     1,
+		Connections: 1,
+		Goroutines:  1,
+	}, sess.ActiveResources())
+
+	close(release)
+	require.NoError(t, conn.Close())
+	require.NoError(t, tun.Close())
+	sess.markClosed()
+	sess.markClosed()
+	require.Eventually(t, func() bool {
+		return sess.ActiveResources() == Resources{Sessions: before}
+	}, time.Second, time.Millisecond)
+}
+
+func TestLeakDetection(t *testing.T) {
+	msgs := make(chan string, 4)
+	logger := slog.New(messageHandler{msgs: msgs})
+
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{ClientID: "sess_1"})
+	closedTun := &tunnelImpl{Sess: sess, Tunnel: &queuedTunnel{}}
+	leakedTun := &tunnelImpl{Sess: sess, Tunnel: &queuedTunnel{}}
+
+	func() {
+		handle := watchSession(sess, logger)
+		require.NoError(t, handle.watchTunnel(closedTun).Close())
+		handle.watchTunnel(leakedTun)
+	}()
+
+	// Each collection runs the finalizers of the handles found unreachable,
+	// and the session is only unreachable once its tunnels have been
+	// finalized.
+	var warnings []string
+	require.Eventually(t, func() bool {
+		runtime.GC()
+		for {
+			select {
+			case msg := <-msgs:
+				warnings = append(warnings, msg)
+			default:
+				return len(warnings) == 2
+			}
+		}
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{
+		"tunnel was garbage collected without being closed",
+		"session was garbage collected without being closed",
+	}, warnings)
+}
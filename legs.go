@@ -0,0 +1,117 @@
+package ngrok
+
+import (
+	"sync"
+	"time"
+)
+
+// LegStatus is the state of one of a session's connections to the ngrok
+// service. Sessions have a single leg, unless they're connected with
+// [WithMultiLeg], in which case the ngrok service may ask for more, each to a
+// different server.
+type LegStatus struct {
+	// The leg's number. The first leg is 0.
+	Leg int `json:"leg"`
+	// The address of the server the leg last connected to.
+	ServerAddr string `json:"server_addr"`
+	// Whether the leg is connected.
+	Connected bool `json:"connected"`
+	// When the leg last connected or disconnected.
+	Since time.Time `json:"since"`
+	// The number of times the leg has connected, including the first.
+	Connects uint64 `json:"connects"`
+	// The round trip time of the leg's last heartbeat.
+	Latency time.Duration `json:"latency"`
+	// The error the leg last disconnected with, if any.
+	LastError string `json:"last_error,omitempty"`
+}
+
+// EventLegConnected is emitted when one of a session's legs connects or
+// reconnects to the ngrok service, once its tunnels are bound. It's emitted
+// for every session, including those with a single leg.
+type EventLegConnected struct {
+	baseEvent
+	Session Session
+	// The leg's number. The first leg is 0.
+	Leg int
+	// The address of the server the leg connected to.
+	ServerAddr string
+}
+
+// EventLegDisconnected is emitted when one of a session's legs loses its
+// connection to the ngrok service. The leg reconnects unless the session is
+// closed, and the session is reported disconnected until it does.
+type EventLegDisconnected struct {
+	baseEvent
+	Session Session
+	// The leg's number. The first leg is 0.
+	Leg int
+	// The address of the server the leg was connected to.
+	ServerAddr string
+	Error      error
+}
+
+// The state of each of a session's legs, indexed by leg number.
+type legStates struct {
+	mu   sync.Mutex
+	legs []LegStatus
+}
+
+// Get the state of a leg, growing the list to hold it. The lock must be held.
+func (l *legStates) get(leg uint32) *LegStatus {
+	for len(l.legs) <= int(leg) {
+		l.legs = append(l.legs, LegStatus{Leg: len(l.legs)})
+	}
+	return &l.legs[leg]
+}
+
+func (l *legStates) set(leg uint32, addr string, connected bool, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	status := l.get(leg)
+	status.ServerAddr = addr
+	status.Connected = connected
+	status.Since = time.Now()
+	if connected {
+		status.Connects++
+	}
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+func (l *legStates) heartbeat(leg uint32, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.get(leg).Latency = latency
+}
+
+func (l *legStates) snapshot() []LegStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.legs) == 0 {
+		return nil
+	}
+	return append([]LegStatus(nil), l.legs...)
+}
+
+// Record a leg connecting or disconnecting, and emit the event for it.
+func (s *sessionImpl) legChanged(leg uint32, addr string, connected bool, err error) {
+	s.legs.set(leg, addr, connected, err)
+	if connected {
+		s.events.emit(&EventLegConnected{
+			baseEvent:  newBaseEvent(EventTypeLegConnected),
+			Session:    s,
+			Leg:        int(leg),
+			ServerAddr: addr,
+		})
+		return
+	}
+	s.events.emit(&EventLegDisconnected{
+		baseEvent:  newBaseEvent(EventTypeLegDisconnected),
+		Session:    s,
+		Leg:        int(leg),
+		ServerAddr: addr,
+		Error:      err,
+	})
+}
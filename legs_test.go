@@ -0,0 +1,38 @@
+package ngrok
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLegStatus(t *testing.T) {
+	rec := &eventRecorder{}
+	sess := &sessionImpl{events: newEventDispatcher([]EventHandler{rec.handle})}
+	require.Empty(t, sess.status().Legs)
+
+	sess.legChanged(0, "us.connect.ngrok-agent.com:443", true, nil)
+	sess.legChanged(1, "eu.connect.ngrok-agent.com:443", true, nil)
+	sess.legs.heartbeat(1, 20*time.Millisecond)
+	sess.legChanged(1, "eu.connect.ngrok-agent.com:443", false, errors.New("connection reset"))
+
+	legs := sess.status().Legs
+	require.Len(t, legs, 2)
+	require.Equal(t, 0, legs[0].Leg)
+	require.True(t, legs[0].Connected)
+	require.Equal(t, "us.connect.ngrok-agent.com:443", legs[0].ServerAddr)
+	require.Equal(t, 1, legs[1].Leg)
+	require.False(t, legs[1].Connected)
+	require.Equal(t, uint64(1), legs[1].Connects)
+	require.Equal(t, 20*time.Millisecond, legs[1].Latency)
+	require.Equal(t, "connection reset", legs[1].LastError)
+
+	require.Equal(t, []EventType{EventTypeLegConnected, EventTypeLegConnected, EventTypeLegDisconnected}, rec.waitFor(t, 3))
+	rec.mu.Lock()
+	down := rec.events[2].(*EventLegDisconnected)
+	rec.mu.Unlock()
+	require.Equal(t, 1, down.Leg)
+	require.EqualError(t, down.Error, "connection reset")
+}
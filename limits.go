@@ -0,0 +1,161 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// LimitKind identifies an account limit enforced by the ngrok service.
+type LimitKind int
+
+const (
+	// The limit isn't one this package knows about.
+	LimitUnknown LimitKind = iota
+	// The number of agent sessions the account may run at once.
+	LimitSessions
+	// The number of endpoints the account may run in a single session.
+	LimitEndpoints
+)
+
+func (k LimitKind) String() string {
+	switch k {
+	case LimitSessions:
+		return "sessions"
+	case LimitEndpoints:
+		return "endpoints"
+	}
+	return "unknown"
+}
+
+// The ngrok error codes for each kind of limit.
+var limitErrorCodes = map[string]LimitKind{
+	"ERR_NGROK_108": LimitSessions,
+	"ERR_NGROK_324": LimitEndpoints,
+}
+
+var (
+	// Matches the limit in messages like "limited to 1 simultaneous ngrok
+	// agent sessions" and "may not run more than 3 endpoints".
+	limitCountRegex = regexp.MustCompile(`(?:limited to|more than) (\d+)`)
+	// Matches hints like "retry after 30s" and "try again in 2 minutes".
+	retryAfterRegex = regexp.MustCompile(`(?i)(?:retry|try again) (?:after|in) (\d+) ?(ms|s|sec|secs|seconds?|m|min|mins|minutes?|h|hours?)\b`)
+)
+
+// LimitExceededError is returned when the ngrok service refuses to start a
+// session or an endpoint because the account is already running as many as
+// its plan allows. Match it with [ErrLimitExceeded], and use [errors.As] to
+// get the details. See also [WithWaitForCapacity].
+type LimitExceededError struct {
+	// The limit that was reached.
+	Kind LimitKind
+	// The number of sessions or endpoints the account is limited to, or
+	// zero if the ngrok service didn't say.
+	Limit int
+	// How long the ngrok service suggested waiting before trying again, or
+	// zero if it didn't say.
+	RetryAfter time.Duration
+	// The error returned by the ngrok service.
+	Err error
+}
+
+func (e *LimitExceededError) Error() string {
+	return fmt.Sprintf("account limit on %s reached: %v", e.Kind, e.Err)
+}
+
+func (e *LimitExceededError) Unwrap() error {
+	return e.Err
+}
+
+func (e *LimitExceededError) Is(target error) bool {
+	_, ok := target.(*LimitExceededError)
+	return ok
+}
+
+// Wrap an error from the ngrok service in a LimitExceededError if it reports
+// that an account limit was reached.
+func limitExceeded(err error) error {
+	var nerr Error
+	if err == nil || errors.Is(err, ErrLimitExceeded) || !errors.As(err, &nerr) {
+		return err
+	}
+	kind, ok := limitErrorCodes[nerr.ErrorCode()]
+	if !ok {
+		return err
+	}
+	limitErr := &LimitExceededError{Kind: kind, Err: err}
+	msg := nerr.Msg()
+	if m := limitCountRegex.FindStringSubmatch(msg); m != nil {
+		limitErr.Limit, _ = strconv.Atoi(m[1])
+	}
+	if m := retryAfterRegex.FindStringSubmatch(msg); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		unit := time.Second
+		switch {
+		case m[2] == "ms":
+			unit = time.Millisecond
+		case strings.HasPrefix(m[2], "m"):
+			unit = time.Minute
+		case strings.HasPrefix(m[2], "h"):
+			unit = time.Hour
+		}
+		limitErr.RetryAfter = time.Duration(n) * unit
+	}
+	return limitErr
+}
+
+// WithWaitForCapacity makes the [Session] wait for capacity to free up when
+// the account has reached its limit on sessions or endpoints, rather than
+// fail. [Connect] keeps trying to connect until the context passed to it is
+// done, without counting the attempts against [WithMaxConnectAttempts], and
+// the session's Listen methods keep trying to start the tunnel until the
+// context passed to them is done. Between attempts, they wait for as long as
+// the ngrok service suggests, or back off up to the maximum configured with
+// [WithReconnectBackoff].
+func WithWaitForCapacity() ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.WaitForCapacity = true
+	}
+}
+
+// The range of the waits between attempts to start a tunnel while waiting for
+// capacity, unless the ngrok service suggests one.
+const (
+	minCapacityWait = time.Second
+	maxCapacityWait = 30 * time.Second
+)
+
+// Retry listen while it fails because the account's endpoint limit is
+// reached, until ctx is done.
+func (s *sessionImpl) waitForCapacity(ctx context.Context, listen func() (tunnel_client.Tunnel, error)) func() (tunnel_client.Tunnel, error) {
+	return func() (tunnel_client.Tunnel, error) {
+		wait := minCapacityWait
+		for {
+			tunnel, err := listen()
+			var limitErr *LimitExceededError
+			if !errors.As(limitExceeded(err), &limitErr) || limitErr.Kind != LimitEndpoints {
+				return tunnel, err
+			}
+			next := wait
+			if limitErr.RetryAfter > 0 {
+				next = limitErr.RetryAfter
+			} else {
+				wait = min(wait*2, s.capacityWaitMax)
+			}
+			s.inner().Logger.Info("account endpoint limit reached, waiting for capacity", "wait", next, "err", err)
+			timer := s.clock().NewTimer(next)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, err
+			case <-timer.C():
+			}
+		}
+	}
+}
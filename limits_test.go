@@ -0,0 +1,36 @@
+package ngrok
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestLimitExceeded(t *testing.T) {
+	err := limitExceeded(proto.StringError("Your account is limited to 1 simultaneous ngrok agent sessions.\nPlease retry after 45s.\n\nERR_NGROK_108"))
+	var limitErr *LimitExceededError
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitSessions, limitErr.Kind)
+	require.Equal(t, 1, limitErr.Limit)
+	require.Equal(t, 45*time.Second, limitErr.RetryAfter)
+
+	err = limitExceeded(proto.StringError("Your account may not run more than 3 endpoints over a single ngrok agent session. Try again in 2 minutes.\n\nERR_NGROK_324"))
+	require.True(t, errors.As(err, &limitErr))
+	require.Equal(t, LimitEndpoints, limitErr.Kind)
+	require.Equal(t, 3, limitErr.Limit)
+	require.Equal(t, 2*time.Minute, limitErr.RetryAfter)
+	// The ngrok error is still available.
+	var nerr Error
+	require.True(t, errors.As(err, &nerr))
+	require.Equal(t, "ERR_NGROK_324", nerr.ErrorCode())
+	require.True(t, Retryable(errListen{err}))
+	require.False(t, Temporary(errListen{err}))
+
+	other := proto.StringError("endpoint in use\n\nERR_NGROK_334")
+	require.Equal(t, other, limitExceeded(other))
+	require.Nil(t, limitExceeded(nil))
+}
@@ -53,3 +53,9 @@ func (l *Logger) Log(ctx context.Context, level LogLevel, msg string, data map[s
 		l.inner.Error(msg, append(logArgs, "INVALID_LOG_LEVEL", level)...)
 	}
 }
+
+// Handler returns the handler of the wrapped slog.Logger. The ngrok library
+// logs through it directly rather than via the Log method.
+func (l *Logger) Handler() slog.Handler {
+	return l.inner.Handler()
+}
@@ -0,0 +1,139 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// LogRecord is a log message kept by a session connected with
+// [WithLogBuffer].
+type LogRecord struct {
+	Time    time.Time  `json:"time"`
+	Level   slog.Level `json:"level"`
+	Message string     `json:"message"`
+	// The record's attributes, including those the session's loggers were
+	// created with. Groups are nested as [slog.KindGroup] values.
+	Attrs []slog.Attr `json:"-"`
+}
+
+// WithLogBuffer keeps the session's n most recent log records, at debug level
+// and above, in memory, to be retrieved with the session's RecentLogs method.
+// This is independent of [WithLogger], so that applications without their own
+// logging can still report what the session was doing when something went
+// wrong.
+func WithLogBuffer(n int) ConnectOption {
+	return func(cfg *connectConfig) {
+		if n <= 0 {
+			cfg.logBuffer = nil
+			return
+		}
+		cfg.logBuffer = &logBuffer{records: newRing[LogRecord](n)}
+	}
+}
+
+// A fixed size buffer that overwrites its oldest items.
+type ring[T any] struct {
+	mu    sync.Mutex
+	items []T
+	next  int
+}
+
+func newRing[T any](size int) *ring[T] {
+	return &ring[T]{items: make([]T, 0, size)}
+}
+
+func (r *ring[T]) add(item T) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.items) < cap(r.items) {
+		r.items = append(r.items, item)
+		return
+	}
+	r.items[r.next] = item
+	r.next = (r.next + 1) % len(r.items)
+}
+
+// The items, oldest first.
+func (r *ring[T]) snapshot() []T {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]T, 0, len(r.items))
+	out = append(out, r.items[r.next:]...)
+	return append(out, r.items[:r.next]...)
+}
+
+// The records kept for WithLogBuffer.
+type logBuffer struct {
+	records *ring[LogRecord]
+}
+
+func (b *logBuffer) recent() []LogRecord {
+	if b == nil {
+		return nil
+	}
+	return b.records.snapshot()
+}
+
+func (b *logBuffer) handler() slog.Handler {
+	return &logBufferHandler{buf: b, scopes: []logScope{{}}}
+}
+
+// The attributes added to a logger within a group. The first scope has no
+// group.
+type logScope struct {
+	group string
+	attrs []slog.Attr
+}
+
+type logBufferHandler struct {
+	buf    *logBuffer
+	scopes []logScope
+}
+
+func (h *logBufferHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *logBufferHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	for i := len(h.scopes) - 1; i >= 0; i-- {
+		scope := h.scopes[i]
+		attrs = append(append([]slog.Attr(nil), scope.attrs...), attrs...)
+		if scope.group != "" && len(attrs) > 0 {
+			attrs = []slog.Attr{{Key: scope.group, Value: slog.GroupValue(attrs...)}}
+		}
+	}
+	h.buf.records.add(LogRecord{
+		Time:    r.Time,
+		Level:   r.Level,
+		Message: r.Message,
+		Attrs:   attrs,
+	})
+	return nil
+}
+
+func (h *logBufferHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	scopes := append([]logScope(nil), h.scopes...)
+	last := &scopes[len(scopes)-1]
+	last.attrs = append(append([]slog.Attr(nil), last.attrs...), attrs...)
+	return &logBufferHandler{buf: h.buf, scopes: scopes}
+}
+
+func (h *logBufferHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	scopes := append(append([]logScope(nil), h.scopes...), logScope{group: name})
+	return &logBufferHandler{buf: h.buf, scopes: scopes}
+}
+
+// RecentLogs returns the records kept with WithLogBuffer, oldest first.
+func (s *sessionImpl) RecentLogs() []LogRecord {
+	return s.logBuffer.recent()
+}
@@ -0,0 +1,58 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogBufferHandler(t *testing.T) {
+	buf := &logBuffer{records: newRing[LogRecord](2)}
+	logger := slog.New(buf.handler()).With("session", "s1").WithGroup("tunnel").With("id", "t1")
+
+	logger.Debug("first")
+	logger.Info("second", "n", 2)
+	logger.Warn("third", "n", 3)
+
+	records := buf.recent()
+	require.Len(t, records, 2)
+	require.Equal(t, "second", records[0].Message)
+	require.Equal(t, slog.LevelInfo, records[0].Level)
+	require.Equal(t, "third", records[1].Message)
+	require.Equal(t, []slog.Attr{
+		slog.String("session", "s1"),
+		slog.Group("tunnel", slog.String("id", "t1"), slog.Int("n", 3)),
+	}, records[1].Attrs)
+}
+
+func TestRecentLogs(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	sess, err := Connect(context.Background(),
+		WithServer(addr),
+		WithMaxConnectAttempts(1),
+		WithOfflineFallback("127.0.0.1:0"),
+		WithLogBuffer(10),
+	)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	records := sess.RecentLogs()
+	require.NotEmpty(t, records)
+	require.Contains(t, records[len(records)-1].Message, "locally")
+
+	sess, err = Connect(context.Background(),
+		WithServer(addr),
+		WithMaxConnectAttempts(1),
+		WithOfflineFallback("127.0.0.1:0"),
+	)
+	require.NoError(t, err)
+	defer sess.Close()
+	require.Nil(t, sess.RecentLogs())
+}
@@ -2,77 +2,87 @@ package ngrok
 
 import (
 	"context"
-	"fmt"
-
-	"github.com/inconshreveable/log15/v3"
+	"log/slog"
 
 	"golang.ngrok.com/ngrok/log"
 )
 
-type log15Handler struct {
-	log.Logger
-}
+// The level used for trace messages, which slog has no name for.
+const slogLevelTrace = slog.LevelDebug - 4
 
-// The internals all use log15, so we need to convert the public logging
-// interface to log15.
-// If the provided Logger also implements the log15 interface, downcast and use
-// that instead of wrapping again. This is the case for the Logger constructed
-// by the log15adapter module.
-// Otherwise, a new log15.Logger is constructed and the provided Logger used as
-// its Handler.
-func toLog15(l log.Logger) log15.Logger {
-	if logger, ok := l.(log15.Logger); ok {
-		return logger
+// The internals all use log/slog, so we need to convert the public logging
+// interface to a slog.Logger.
+// If the provided Logger exposes a slog.Handler, use it directly instead of
+// wrapping again. This is the case for the Logger constructed by the slog
+// adapter module.
+// Otherwise, a new slog.Logger is constructed with the provided Logger as its
+// Handler.
+func toSlog(l log.Logger) *slog.Logger {
+	if h, ok := l.(interface{ Handler() slog.Handler }); ok {
+		return slog.New(h.Handler())
 	}
+	return slog.New(&slogHandler{logger: l})
+}
 
-	logger := log15.New()
-	logger.SetHandler(&log15Handler{l})
+// Forwards slog records to a Logger.
+type slogHandler struct {
+	logger log.Logger
+	// Attributes added by WithAttrs, already qualified by their groups.
+	attrs []slog.Attr
+	// The qualifier for attributes added to this handler, e.g. "group.".
+	prefix string
+}
 
-	return logger
+func (h *slogHandler) Enabled(context.Context, slog.Level) bool {
+	return true
 }
 
-func (l *log15Handler) Log(r log15.Record) error {
-	lvl := log.LogLevelNone
-	switch r.Lvl {
-	case log15.LvlCrit:
-		lvl = log.LogLevelError
-	case log15.LvlError:
+func (h *slogHandler) Handle(ctx context.Context, r slog.Record) error {
+	lvl := log.LogLevelTrace
+	switch {
+	case r.Level >= slog.LevelError:
 		lvl = log.LogLevelError
-	case log15.LvlWarn:
+	case r.Level >= slog.LevelWarn:
 		lvl = log.LogLevelWarn
-	case log15.LvlInfo:
+	case r.Level >= slog.LevelInfo:
 		lvl = log.LogLevelInfo
-	case log15.LvlDebug:
+	case r.Level >= slog.LevelDebug:
 		lvl = log.LogLevelDebug
-	case log15.LvlDebug + 1:
-		// Also support trace, if someone happens to hack
-		// it in.
-		lvl = log.LogLevelTrace
 	}
 
-	data := make(map[string]interface{}, len(r.Ctx)/2)
-	for i := 0; i < len(r.Ctx); i += 2 {
-		var (
-			k  string
-			ok bool
-			v  interface{}
-		)
-		// The default upstream log15 formatter chooses to treat non-strings as
-		// errors. We'll be a bit nicer and Sprint it instead if we find one.
-		k, ok = r.Ctx[i].(string)
-		if !ok {
-			k = fmt.Sprint(r.Ctx[i])
-		}
-		// I think log15 guarantees an even number of context values, but just
-		// in case.
-		if len(r.Ctx) > i+1 {
-			v = r.Ctx[i+1]
-		} else {
-			v = "MISSING_VALUE"
-		}
-		data[k] = v
+	data := make(map[string]interface{}, len(h.attrs)+r.NumAttrs())
+	for _, a := range h.attrs {
+		data[a.Key] = a.Value.Resolve().Any()
 	}
+	r.Attrs(func(a slog.Attr) bool {
+		data[h.prefix+a.Key] = a.Value.Resolve().Any()
+		return true
+	})
 
-	l.Logger.Log(context.Background(), lvl, r.Msg, data)
+	h.logger.Log(ctx, lvl, r.Message, data)
 	return nil
 }
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := *h
+	out.attrs = make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	out.attrs = append(out.attrs, h.attrs...)
+	for _, a := range attrs {
+		out.attrs = append(out.attrs, slog.Attr{Key: h.prefix + a.Key, Value: a.Value})
+	}
+	return &out
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	out := *h
+	out.prefix = h.prefix + name + "."
+	return &out
+}
+
+// Drops all records, for sessions without a logger.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
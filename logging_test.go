@@ -0,0 +1,41 @@
+package ngrok
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/log"
+)
+
+type logRecord struct {
+	level log.LogLevel
+	msg   string
+	data  map[string]interface{}
+}
+
+type recordingLogger struct {
+	records []logRecord
+}
+
+func (l *recordingLogger) Log(_ context.Context, level log.LogLevel, msg string, data map[string]interface{}) {
+	l.records = append(l.records, logRecord{level, msg, data})
+}
+
+func TestSlogToLogger(t *testing.T) {
+	rec := &recordingLogger{}
+	logger := toSlog(rec).With("obj", "csess").WithGroup("tunnel").With("id", "tun_1")
+
+	logger.Warn("slow", "latency", 5)
+	logger.Log(context.Background(), slogLevelTrace, "trace")
+
+	require.Equal(t, []logRecord{
+		{log.LogLevelWarn, "slow", map[string]interface{}{
+			"obj": "csess", "tunnel.id": "tun_1", "tunnel.latency": int64(5),
+		}},
+		{log.LogLevelTrace, "trace", map[string]interface{}{
+			"obj": "csess", "tunnel.id": "tun_1",
+		}},
+	}, rec.records)
+}
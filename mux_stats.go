@@ -0,0 +1,146 @@
+package ngrok
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"golang.ngrok.com/muxado/v2/frame"
+)
+
+// MuxStats is a snapshot of the frames exchanged with the ngrok service over
+// the muxado session that carries a [Session]'s connections. Counters are
+// cumulative across reconnects.
+type MuxStats struct {
+	// The number of frames read from and written to the ngrok service.
+	FramesIn  uint64
+	FramesOut uint64
+	// The number of stream payload bytes read from and written to the ngrok
+	// service, not including frame headers.
+	BytesIn  uint64
+	BytesOut uint64
+	// The number of streams opened by either side.
+	StreamsOpened uint64
+	// The number of streams that haven't yet been closed by both sides or
+	// reset, on the current connection to the ngrok service.
+	OpenStreams int64
+	// The number of streams reset by the ngrok service and by the session.
+	ResetsIn  uint64
+	ResetsOut uint64
+}
+
+// The live counters backing [MuxStats].
+type muxStats struct {
+	framesIn, framesOut atomic.Uint64
+	bytesIn, bytesOut   atomic.Uint64
+	streamsOpened       atomic.Uint64
+	resetsIn, resetsOut atomic.Uint64
+	current             atomic.Pointer[countingFramer]
+}
+
+// Create the framer for a new muxado session, counting the frames that pass
+// through it. Set as the NewFramer of the session's muxado.Config.
+func (s *muxStats) newFramer(r io.Reader, w io.Writer) frame.Framer {
+	f := &countingFramer{
+		Framer: frame.NewFramer(r, w),
+		stats:  s,
+		open:   make(map[frame.StreamId]streamHalves),
+	}
+	s.current.Store(f)
+	return f
+}
+
+func (s *muxStats) snapshot() MuxStats {
+	stats := MuxStats{
+		FramesIn:      s.framesIn.Load(),
+		FramesOut:     s.framesOut.Load(),
+		BytesIn:       s.bytesIn.Load(),
+		BytesOut:      s.bytesOut.Load(),
+		StreamsOpened: s.streamsOpened.Load(),
+		ResetsIn:      s.resetsIn.Load(),
+		ResetsOut:     s.resetsOut.Load(),
+	}
+	if f := s.current.Load(); f != nil {
+		stats.OpenStreams = f.openStreams()
+	}
+	return stats
+}
+
+// Which sides of a stream have finished sending.
+type streamHalves struct {
+	finIn, finOut bool
+}
+
+type countingFramer struct {
+	frame.Framer
+	stats *muxStats
+
+	mu   sync.Mutex
+	open map[frame.StreamId]streamHalves
+}
+
+func (f *countingFramer) WriteFrame(fr frame.Frame) error {
+	err := f.Framer.WriteFrame(fr)
+	if err == nil {
+		f.stats.framesOut.Add(1)
+		f.track(fr, false)
+	}
+	return err
+}
+
+func (f *countingFramer) ReadFrame() (frame.Frame, error) {
+	fr, err := f.Framer.ReadFrame()
+	if err == nil {
+		f.stats.framesIn.Add(1)
+		f.track(fr, true)
+	}
+	return fr, err
+}
+
+func (f *countingFramer) track(fr frame.Frame, in bool) {
+	switch fr := fr.(type) {
+	case *frame.Data:
+		if in {
+			f.stats.bytesIn.Add(uint64(fr.Length()))
+		} else {
+			f.stats.bytesOut.Add(uint64(fr.Length()))
+		}
+		if !fr.Syn() && !fr.Fin() {
+			return
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		halves := f.open[fr.StreamId()]
+		if fr.Syn() {
+			f.stats.streamsOpened.Add(1)
+		}
+		if fr.Fin() {
+			if in {
+				halves.finIn = true
+			} else {
+				halves.finOut = true
+			}
+		}
+		if halves.finIn && halves.finOut {
+			delete(f.open, fr.StreamId())
+		} else {
+			f.open[fr.StreamId()] = halves
+		}
+	case *frame.Rst:
+		if in {
+			f.stats.resetsIn.Add(1)
+		} else {
+			f.stats.resetsOut.Add(1)
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		delete(f.open, fr.StreamId())
+	}
+}
+
+func (f *countingFramer) openStreams() int64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return int64(len(f.open))
+}
@@ -0,0 +1,66 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/muxado/v2"
+)
+
+func TestMuxStats(t *testing.T) {
+	var stats muxStats
+	agentSide, edgeSide := net.Pipe()
+	agent := muxado.Client(agentSide, &muxado.Config{NewFramer: stats.newFramer})
+	edge := muxado.Server(edgeSide, nil)
+	defer agent.Close()
+	defer edge.Close()
+
+	// The edge opens a stream, and the agent reads its request and replies.
+	stream, err := edge.OpenStream()
+	require.NoError(t, err)
+	go func() {
+		_, _ = stream.Write([]byte("hello"))
+		_ = stream.CloseWrite()
+	}()
+	accepted, err := agent.AcceptStream()
+	require.NoError(t, err)
+	body, err := io.ReadAll(accepted)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(body))
+	require.Equal(t, int64(1), stats.snapshot().OpenStreams)
+
+	_, err = accepted.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, accepted.CloseWrite())
+	body, err = io.ReadAll(stream)
+	require.NoError(t, err)
+	require.Equal(t, "hi", string(body))
+
+	// Then one that the agent resets, after the edge keeps writing to it once
+	// the agent has closed it.
+	reset, err := edge.OpenStream()
+	require.NoError(t, err)
+	_, err = reset.Write([]byte("x"))
+	require.NoError(t, err)
+	accepted, err = agent.AcceptStream()
+	require.NoError(t, err)
+	require.NoError(t, accepted.Close())
+	_, err = reset.Write([]byte("y"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return stats.snapshot().ResetsOut == 1
+	}, time.Second, time.Millisecond)
+	snap := stats.snapshot()
+	require.Equal(t, uint64(2), snap.StreamsOpened)
+	require.Equal(t, int64(0), snap.OpenStreams)
+	require.Equal(t, uint64(7), snap.BytesIn)
+	require.Equal(t, uint64(2), snap.BytesOut)
+	require.Positive(t, snap.FramesIn)
+	require.Positive(t, snap.FramesOut)
+	require.Zero(t, snap.ResetsIn)
+}
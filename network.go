@@ -0,0 +1,150 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// How long the session waits for a heartbeat after the network changes
+// before it gives up on its connection and reconnects.
+const networkProbeTimeout = 2 * time.Second
+
+// NetworkMonitor notifies a [Session] when the host's network changes, such as
+// when a laptop wakes from sleep or a mobile device moves between networks.
+// Implementations may watch netlink or routing socket notifications, or the
+// events of the platform's network manager.
+type NetworkMonitor interface {
+	// Changes returns a channel that receives a value each time the host's
+	// network changes. The channel is closed once the context is done.
+	Changes(ctx context.Context) <-chan struct{}
+}
+
+// WithNetworkMonitor makes the [Session] check its connection to the ngrok
+// service each time the [NetworkMonitor] reports that the network changed.
+// The session sends a heartbeat, and if it isn't answered within a couple of
+// seconds, the connection is assumed to be stranded on an address the host no
+// longer has, and the session reconnects right away rather than waiting for
+// its heartbeat tolerance to run out.
+//
+// Use [PollNetworkInterfaces] for a monitor that works on all platforms.
+func WithNetworkMonitor(monitor NetworkMonitor) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.NetworkMonitor = monitor
+	}
+}
+
+// PollNetworkInterfaces returns a [NetworkMonitor] that lists the addresses of
+// the host's network interfaces every interval, and reports a change whenever
+// the set of addresses differs from the last time.
+func PollNetworkInterfaces(interval time.Duration) NetworkMonitor {
+	return &interfacePoller{interval: interval, addrs: net.InterfaceAddrs}
+}
+
+type interfacePoller struct {
+	interval time.Duration
+	addrs    func() ([]net.Addr, error)
+}
+
+func (p *interfacePoller) Changes(ctx context.Context) <-chan struct{} {
+	changes := make(chan struct{}, 1)
+	go func() {
+		defer close(changes)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		last := p.snapshot()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			current := p.snapshot()
+			if current == last {
+				continue
+			}
+			last = current
+			// Coalesce changes the session hasn't seen yet.
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return changes
+}
+
+// The interface addresses in a comparable form. A listing that fails is
+// treated like a host without addresses.
+func (p *interfacePoller) snapshot() string {
+	addrs, err := p.addrs()
+	if err != nil {
+		return ""
+	}
+	strs := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		strs = append(strs, addr.String())
+	}
+	sort.Strings(strs)
+	return strings.Join(strs, ",")
+}
+
+// Probe the session's connection each time the network changes, and
+// reconnect if the probe fails.
+func watchNetwork(ctx context.Context, changes <-chan struct{}, probe func(context.Context) error, reconnect func() error, logger *slog.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-changes:
+			if !ok {
+				return
+			}
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, networkProbeTimeout)
+		err := probe(probeCtx)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+		logger.Info("network changed and the session stopped responding, reconnecting", "err", err)
+		if err := reconnect(); err != nil {
+			logger.Info("failed to reconnect after network change", "err", err)
+		}
+	}
+}
+
+// Watch the monitor until the session closes. The watcher shares the
+// session's lifetime, which must not be derived from the context passed to
+// Connect: the first parent it sees is kept, and canceling it would also
+// cancel the contexts of every connection accepted by the session.
+func (s *sessionImpl) watchNetworkChanges(monitor NetworkMonitor, logger *slog.Logger) {
+	ctx := s.life.context(context.Background())
+	changes := monitor.Changes(ctx)
+	s.goroutines.spawn(func() {
+		watchNetwork(ctx, changes, probeHeartbeat(s), func() error {
+			return s.inner().Reconnect()
+		}, logger)
+	})
+}
+
+// Send a heartbeat over the session, giving up when the context is done.
+func probeHeartbeat(s *sessionImpl) func(context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan error, 1)
+		s.goroutines.spawn(func() {
+			_, err := s.inner().Heartbeat()
+			done <- err
+		})
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return errors.New("heartbeat timed out")
+		}
+	}
+}
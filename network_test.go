@@ -0,0 +1,110 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func TestInterfacePoller(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		addrs = []net.Addr{&net.IPNet{IP: net.IPv4(192, 0, 2, 1), Mask: net.CIDRMask(24, 32)}}
+	)
+	poller := &interfacePoller{interval: time.Millisecond, addrs: func() ([]net.Addr, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return addrs, nil
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := poller.Changes(ctx)
+	select {
+	case <-changes:
+		t.Fatal("change reported while the addresses were the same")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	mu.Lock()
+	addrs = []net.Addr{&net.IPNet{IP: net.IPv4(198, 51, 100, 1), Mask: net.CIDRMask(24, 32)}}
+	mu.Unlock()
+	select {
+	case <-changes:
+	case <-time.After(time.Second):
+		t.Fatal("address change not reported")
+	}
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestWatchNetwork(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan struct{})
+	probeErr := make(chan error, 1)
+	var reconnects atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		watchNetwork(ctx, changes, func(context.Context) error {
+			return <-probeErr
+		}, func() error {
+			reconnects.Add(1)
+			return nil
+		}, slog.New(discardHandler{}))
+	}()
+
+	// A session that still answers stays connected.
+	probeErr <- nil
+	changes <- struct{}{}
+	// A session that doesn't reconnects.
+	probeErr <- errors.New("heartbeat timed out")
+	changes <- struct{}{}
+
+	close(changes)
+	<-done
+	require.EqualValues(t, 1, reconnects.Load())
+}
+
+// A NetworkMonitor that never reports a change, but records the context it
+// watches with.
+type idleMonitor chan context.Context
+
+func (m idleMonitor) Changes(ctx context.Context) <-chan struct{} {
+	m <- ctx
+	changes := make(chan struct{})
+	context.AfterFunc(ctx, func() { close(changes) })
+	return changes
+}
+
+func TestNetworkWatchLifetime(t *testing.T) {
+	sess := &sessionImpl{}
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 1)}
+	tun := &tunnelImpl{Sess: sess, Tunnel: q}
+
+	// The watcher is the first to use the session's lifetime, which only
+	// ends when the session closes.
+	monitor := make(idleMonitor, 1)
+	sess.watchNetworkChanges(monitor, slog.New(discardHandler{}))
+	watchCtx := <-monitor
+
+	conn := acceptConn(t, tun, q)
+	require.NoError(t, conn.Context().Err())
+	require.NoError(t, watchCtx.Err())
+
+	sess.life.end()
+	requireCanceled(t, watchCtx)
+	requireCanceled(t, conn.Context())
+}
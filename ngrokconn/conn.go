@@ -0,0 +1,133 @@
+// Package ngrokconn exposes the ngrok metadata of the connections accepted
+// from a tunnel to servers that don't build on net/http, such as fasthttp.
+// Those servers hand their handlers the connection, or a context of their
+// own, but have no equivalent of [net/http.Server.ConnContext] to attach the
+// metadata with.
+//
+// Serve the tunnel through a [Listener], and look the metadata up from the
+// connection in the handler:
+//
+//	server.Handler = func(ctx *fasthttp.RequestCtx) {
+//		md, _ := ngrokconn.FromConn(ctx.Conn())
+//		fmt.Fprintf(ctx, "hello %s via %s", md.ClientAddr, md.TunnelURL)
+//	}
+//	err := server.Serve(ngrokconn.Listener(tun))
+//
+// Frameworks that keep values in a [context.Context] can carry the metadata
+// with [NewContext] and [FromContext].
+//
+// Servers that open their own sockets rather than accepting from a
+// [net.Listener], such as gnet, can't serve a tunnel.
+package ngrokconn
+
+import (
+	"context"
+	"net"
+
+	"golang.ngrok.com/ngrok"
+)
+
+// Metadata is the ngrok metadata of a connection.
+type Metadata struct {
+	// The ID of the tunnel the connection was accepted from. Empty unless
+	// it was accepted through a [Listener].
+	TunnelID string
+	// The URL of the tunnel the connection was accepted from. Empty unless
+	// it was accepted through a [Listener].
+	TunnelURL string
+	// The address of the client that connected to the ngrok endpoint, from
+	// the connection's proxy header.
+	ClientAddr net.Addr
+	// The tunnel protocol (http, https, tls, or tcp) of the connection.
+	Proto string
+	// The type of the edge that matched the tunnel.
+	EdgeType ngrok.EdgeType
+	// Whether the connection carries end-to-end TLS.
+	PassthroughTLS bool
+}
+
+type contextKey struct{}
+
+// ContextKey is the key under which [NewContext] stores the connection's
+// *Metadata. It can be used directly with frameworks that keep values in
+// their own stores, e.g. fasthttp's RequestCtx.SetUserValue, after which
+// [FromContext] finds it through the RequestCtx.
+var ContextKey any = contextKey{}
+
+// NewContext returns a copy of ctx that carries the metadata.
+func NewContext(ctx context.Context, md *Metadata) context.Context {
+	return context.WithValue(ctx, ContextKey, md)
+}
+
+// FromContext returns the metadata stored in ctx by [NewContext].
+func FromContext(ctx context.Context) (*Metadata, bool) {
+	md, ok := ctx.Value(ContextKey).(*Metadata)
+	return md, ok
+}
+
+// FromConn returns the metadata of a connection accepted from a tunnel. It
+// sees through connections that wrap it, such as a [crypto/tls.Conn] or an
+// [golang.ngrok.com/ngrok/ngrokmux.Conn], as long as they expose the
+// connection they wrap with a NetConn or Unwrap method.
+func FromConn(conn net.Conn) (*Metadata, bool) {
+	for conn != nil {
+		switch c := conn.(type) {
+		case *metadataConn:
+			return c.md, true
+		case ngrok.Conn:
+			return metadataOf(c), true
+		case interface{ NetConn() net.Conn }:
+			conn = c.NetConn()
+		case interface{ Unwrap() net.Conn }:
+			conn = c.Unwrap()
+		default:
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+func metadataOf(conn ngrok.Conn) *Metadata {
+	return &Metadata{
+		ClientAddr:     conn.RemoteAddr(),
+		Proto:          conn.Proto(),
+		EdgeType:       conn.EdgeType(),
+		PassthroughTLS: conn.PassthroughTLS(),
+	}
+}
+
+// Listener wraps a tunnel so that the connections accepted from it also
+// carry the ID and URL of the tunnel for [FromConn].
+func Listener(tun ngrok.Tunnel) net.Listener {
+	return &listener{Tunnel: tun}
+}
+
+type listener struct {
+	ngrok.Tunnel
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Tunnel.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ngrokConn, ok := conn.(ngrok.Conn)
+	if !ok {
+		return conn, nil
+	}
+	md := metadataOf(ngrokConn)
+	md.TunnelID = l.Tunnel.ID()
+	md.TunnelURL = l.Tunnel.URL()
+	return &metadataConn{Conn: ngrokConn, md: md}, nil
+}
+
+// A connection from a Listener, which keeps the methods of the ngrok.Conn.
+type metadataConn struct {
+	ngrok.Conn
+	md *Metadata
+}
+
+// NetConn returns the connection accepted from the tunnel.
+func (c *metadataConn) NetConn() net.Conn {
+	return c.Conn
+}
@@ -0,0 +1,53 @@
+package ngrokconn
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestFromConn(t *testing.T) {
+	ctx := context.Background()
+	sess, err := ngrok.Connect(ctx, ngrok.WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+	tun, err := sess.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+
+	client, err := net.Dial("tcp", strings.TrimPrefix(tun.URL(), "tcp://"))
+	require.NoError(t, err)
+	defer client.Close()
+
+	l := Listener(tun)
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	_, ok := conn.(ngrok.Conn)
+	require.True(t, ok, "the ngrok.Conn methods are kept")
+
+	// Servers may wrap the connection before their handlers see it.
+	for _, wrapped := range []net.Conn{conn, tls.Server(conn, &tls.Config{})} {
+		md, ok := FromConn(wrapped)
+		require.True(t, ok)
+		require.Equal(t, tun.ID(), md.TunnelID)
+		require.Equal(t, tun.URL(), md.TunnelURL)
+		require.Equal(t, "tcp", md.Proto)
+		require.Equal(t, client.LocalAddr().String(), md.ClientAddr.String())
+
+		mdCtx, ok := FromContext(NewContext(ctx, md))
+		require.True(t, ok)
+		require.Same(t, md, mdCtx)
+	}
+
+	_, ok = FromConn(client)
+	require.False(t, ok)
+	_, ok = FromContext(ctx)
+	require.False(t, ok)
+}
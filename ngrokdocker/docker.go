@@ -0,0 +1,405 @@
+// Package ngrokdocker forwards ngrok endpoints to local Docker containers. It
+// finds the host port that a container's port is published on through the
+// Docker Engine API, and follows the container's events so that connections
+// go to the right place after it's restarted and published on a new port.
+//
+// The simplest use forwards an HTTP endpoint to a container by name:
+//
+//	fwd, err := ngrokdocker.Forward(ctx, sess, "web")
+//
+// For other endpoint types or Docker settings, watch the container and pass
+// its dialer to the endpoint:
+//
+//	c, err := ngrokdocker.Watch(ctx, "db", ngrokdocker.WithContainerPort("5432"))
+//	defer c.Close()
+//	fwd, err := sess.ListenAndForward(ctx, c.URL(),
+//		config.TCPEndpoint(config.WithUpstreamDialer(c.Dial)))
+package ngrokdocker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+)
+
+const defaultDockerHost = "unix:///var/run/docker.sock"
+
+// How long to wait before following the container's events again after the
+// stream breaks.
+const eventsRetryDelay = time.Second
+
+// Option configures how a container is watched.
+type Option func(*options)
+
+type options struct {
+	host   string
+	port   string
+	scheme string
+}
+
+// WithDockerHost sets the address of the Docker Engine API, either a
+// unix:///path/to/docker.sock or a tcp://host:port URL. It defaults to the
+// DOCKER_HOST environment variable, or the local Docker socket.
+func WithDockerHost(host string) Option {
+	return func(opts *options) {
+		opts.host = host
+	}
+}
+
+// WithContainerPort chooses which of the container's ports to forward to,
+// e.g. "8080" or "53/udp". By default, the lowest published TCP port is used.
+func WithContainerPort(port string) Option {
+	return func(opts *options) {
+		opts.port = port
+	}
+}
+
+// WithUpstreamScheme sets the scheme of the [Container.URL], such as "https"
+// for containers that serve TLS. It defaults to "http".
+func WithUpstreamScheme(scheme string) Option {
+	return func(opts *options) {
+		opts.scheme = scheme
+	}
+}
+
+// Forward starts an HTTP endpoint on the session that forwards to the
+// published port of a container, following it across restarts. The
+// container is no longer watched once the forwarder stops.
+func Forward(ctx context.Context, sess ngrok.Session, nameOrID string, opts ...config.HTTPEndpointOption) (ngrok.Forwarder, error) {
+	c, err := Watch(ctx, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	fwd, err := sess.ListenAndForward(ctx, c.URL(), config.HTTPEndpoint(append(opts, config.WithUpstreamDialer(c.Dial))...))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	go func() {
+		_ = fwd.Wait()
+		c.Close()
+	}()
+	return fwd, nil
+}
+
+// Container is a Docker container that's watched for restarts.
+type Container struct {
+	client *client
+	id     string
+	name   string
+	port   string
+	scheme string
+
+	mu   sync.RWMutex
+	addr string
+	url  *url.URL
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Watch finds the host port that a running container's port is published
+// on, and follows the container's events until the context is done or the
+// Container is closed.
+func Watch(ctx context.Context, nameOrID string, opts ...Option) (*Container, error) {
+	o := options{host: os.Getenv("DOCKER_HOST"), scheme: "http"}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.host == "" {
+		o.host = defaultDockerHost
+	}
+	if o.port != "" && !strings.Contains(o.port, "/") {
+		o.port += "/tcp"
+	}
+
+	client, err := newClient(o.host)
+	if err != nil {
+		return nil, err
+	}
+	info, err := client.inspect(ctx, nameOrID)
+	if err != nil {
+		return nil, err
+	}
+	if !info.State.Running {
+		return nil, fmt.Errorf("container %s is not running", nameOrID)
+	}
+	port := o.port
+	if port == "" {
+		port = info.defaultPort()
+	}
+	addr := info.hostAddr(port, client.host)
+	if addr == "" {
+		return nil, fmt.Errorf("port %s of container %s is not published", port, nameOrID)
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	c := &Container{
+		client: client,
+		id:     info.ID,
+		name:   strings.TrimPrefix(info.Name, "/"),
+		port:   port,
+		scheme: o.scheme,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	c.setAddr(addr)
+	go c.watch(watchCtx)
+	return c, nil
+}
+
+// ID returns the ID of the container.
+func (c *Container) ID() string {
+	return c.id
+}
+
+// Name returns the name of the container.
+func (c *Container) Name() string {
+	return c.name
+}
+
+// Addr returns the host address the container's port is published on, or an
+// empty string while the container isn't running.
+func (c *Container) Addr() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.addr
+}
+
+// URL returns the URL of the container's published port when it was first
+// found, to pass to [ngrok.Session.ListenAndForward]. Connections are made
+// with [Container.Dial], which follows the container to its current address.
+func (c *Container) URL() *url.URL {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.url
+}
+
+// Dial connects to the current address of the container's published port,
+// ignoring the address it's called with. Pass it to
+// [config.WithUpstreamDialer].
+func (c *Container) Dial(ctx context.Context, network, _ string) (net.Conn, error) {
+	addr := c.Addr()
+	if addr == "" {
+		return nil, fmt.Errorf("container %s is not running", c.name)
+	}
+	return (&net.Dialer{}).DialContext(ctx, network, addr)
+}
+
+// Close stops watching the container.
+func (c *Container) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *Container) setAddr(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addr = addr
+	if c.url == nil && addr != "" {
+		c.url = &url.URL{Scheme: c.scheme, Host: addr}
+	}
+}
+
+// Look the container's published port up again.
+func (c *Container) refresh(ctx context.Context) {
+	info, err := c.client.inspect(ctx, c.id)
+	if err != nil || !info.State.Running {
+		c.setAddr("")
+		return
+	}
+	c.setAddr(info.hostAddr(c.port, c.client.host))
+}
+
+func (c *Container) watch(ctx context.Context) {
+	defer close(c.done)
+	handle := func(action string) {
+		switch action {
+		case "start", "restart", "unpause":
+			c.refresh(ctx)
+		case "die", "stop", "kill", "pause", "destroy":
+			c.setAddr("")
+		}
+	}
+	for {
+		// The stream only ends with an error, after which it's followed
+		// again.
+		_ = c.client.events(ctx, c.id, handle)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventsRetryDelay):
+		}
+		// Catch up on anything missed while the stream was down.
+		c.refresh(ctx)
+	}
+}
+
+// A minimal client for the Docker Engine API.
+type client struct {
+	http *http.Client
+	base string
+	// The host of a tcp:// Docker host, which ports published on all
+	// interfaces are reached through.
+	host string
+}
+
+func newClient(dockerHost string) (*client, error) {
+	u, err := url.Parse(dockerHost)
+	if err != nil {
+		return nil, fmt.Errorf("invalid docker host %q: %w", dockerHost, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		path := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+		return &client{http: &http.Client{Transport: transport}, base: "http://docker"}, nil
+	case "tcp", "http":
+		return &client{http: &http.Client{}, base: "http://" + u.Host, host: u.Hostname()}, nil
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q", dockerHost)
+	}
+}
+
+func (c *client) get(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	u := c.base + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		var body struct{ Message string }
+		_ = json.NewDecoder(resp.Body).Decode(&body)
+		return nil, fmt.Errorf("docker API %s: %s: %s", path, resp.Status, body.Message)
+	}
+	return resp, nil
+}
+
+type portBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string
+}
+
+type containerInfo struct {
+	ID    string `json:"Id"`
+	Name  string
+	State struct {
+		Running bool
+	}
+	NetworkSettings struct {
+		Ports map[string][]portBinding
+	}
+}
+
+func (c *client) inspect(ctx context.Context, nameOrID string) (*containerInfo, error) {
+	resp, err := c.get(ctx, "/containers/"+url.PathEscape(nameOrID)+"/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var info containerInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// The lowest published TCP port of the container.
+func (info *containerInfo) defaultPort() string {
+	var ports []int
+	for port, bindings := range info.NetworkSettings.Ports {
+		num, proto, _ := strings.Cut(port, "/")
+		if n, err := strconv.Atoi(num); err == nil && proto == "tcp" && len(bindings) > 0 {
+			ports = append(ports, n)
+		}
+	}
+	if len(ports) == 0 {
+		return ""
+	}
+	sort.Ints(ports)
+	return strconv.Itoa(ports[0]) + "/tcp"
+}
+
+// The address a container port is published on, preferring IPv4, or an empty
+// string if it isn't published. Ports published on all interfaces are
+// reached through the Docker host.
+func (info *containerInfo) hostAddr(port, dockerHost string) string {
+	bindings := info.NetworkSettings.Ports[port]
+	if len(bindings) == 0 {
+		return ""
+	}
+	binding := bindings[0]
+	for _, b := range bindings {
+		if !strings.Contains(b.HostIP, ":") {
+			binding = b
+			break
+		}
+	}
+	ip := binding.HostIP
+	switch {
+	case (ip == "" || ip == "0.0.0.0" || ip == "::") && dockerHost != "":
+		ip = dockerHost
+	case ip == "" || ip == "0.0.0.0":
+		ip = "127.0.0.1"
+	case ip == "::":
+		ip = "::1"
+	}
+	return net.JoinHostPort(ip, binding.HostPort)
+}
+
+// Follow the events of a container, calling handle with the action of each,
+// until the stream ends or the context is done.
+func (c *client) events(ctx context.Context, id string, handle func(action string)) error {
+	filters, err := json.Marshal(map[string][]string{
+		"container": {id},
+		"type":      {"container"},
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := c.get(ctx, "/events", url.Values{"filters": {string(filters)}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var ev struct {
+			Action string
+		}
+		if err := dec.Decode(&ev); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("docker event stream ended: %w", err)
+		}
+		// Health checks report actions like "health_status: healthy".
+		action, _, _ := strings.Cut(ev.Action, ":")
+		handle(action)
+	}
+}
@@ -0,0 +1,162 @@
+package ngrokdocker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok"
+)
+
+// A fake Docker Engine API serving a single container.
+type fakeDocker struct {
+	*httptest.Server
+	events chan string
+
+	mu      sync.Mutex
+	port    string
+	running bool
+}
+
+func newFakeDocker(t *testing.T, port string) *fakeDocker {
+	d := &fakeDocker{events: make(chan string), port: port, running: true}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/web/json", d.inspect)
+	mux.HandleFunc("/containers/abc123/json", d.inspect)
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		var filters map[string][]string
+		_ = json.Unmarshal([]byte(r.URL.Query().Get("filters")), &filters)
+		if len(filters["container"]) != 1 || filters["container"][0] != "abc123" {
+			http.Error(w, "unexpected filters", http.StatusBadRequest)
+			return
+		}
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case action := <-d.events:
+				fmt.Fprintf(w, "{\"Type\":\"container\",\"Action\":%q}\n", action)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	d.Server = httptest.NewServer(mux)
+	t.Cleanup(d.Close)
+	return d
+}
+
+func (d *fakeDocker) inspect(w http.ResponseWriter, _ *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var info containerInfo
+	info.ID = "abc123"
+	info.Name = "/web"
+	info.State.Running = d.running
+	info.NetworkSettings.Ports = map[string][]portBinding{
+		"80/tcp":   {{HostIP: "0.0.0.0", HostPort: d.port}, {HostIP: "::", HostPort: d.port}},
+		"9000/tcp": nil,
+	}
+	_ = json.NewEncoder(w).Encode(info)
+}
+
+func (d *fakeDocker) set(port string, running bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.port, d.running = port, running
+}
+
+func (d *fakeDocker) host() string {
+	return "tcp://" + d.Listener.Addr().String()
+}
+
+func upstream(t *testing.T, body string) string {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body)
+	}))
+	t.Cleanup(srv.Close)
+	_, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	return port
+}
+
+func TestWatchFollowsRestarts(t *testing.T) {
+	first, second := upstream(t, "first"), upstream(t, "second")
+	docker := newFakeDocker(t, first)
+
+	c, err := Watch(context.Background(), "web", WithDockerHost(docker.host()))
+	require.NoError(t, err)
+	defer c.Close()
+	require.Equal(t, "abc123", c.ID())
+	require.Equal(t, "web", c.Name())
+	require.Equal(t, "http://127.0.0.1:"+first, c.URL().String())
+	require.Equal(t, "127.0.0.1:"+first, c.Addr())
+
+	// The container is restarted and published on a new port.
+	docker.set(second, true)
+	docker.events <- "restart"
+	require.Eventually(t, func() bool { return c.Addr() == "127.0.0.1:"+second }, time.Second, time.Millisecond)
+	require.Equal(t, "http://127.0.0.1:"+first, c.URL().String(), "the URL is fixed")
+
+	docker.set(second, false)
+	docker.events <- "die"
+	require.Eventually(t, func() bool { return c.Addr() == "" }, time.Second, time.Millisecond)
+	_, err = c.Dial(context.Background(), "tcp", c.URL().Host)
+	require.ErrorContains(t, err, "not running")
+}
+
+func TestWatchErrors(t *testing.T) {
+	docker := newFakeDocker(t, upstream(t, "up"))
+
+	_, err := Watch(context.Background(), "missing", WithDockerHost(docker.host()))
+	require.ErrorContains(t, err, "404")
+
+	_, err = Watch(context.Background(), "web", WithDockerHost(docker.host()), WithContainerPort("9000"))
+	require.ErrorContains(t, err, "not published")
+
+	docker.set("", false)
+	_, err = Watch(context.Background(), "web", WithDockerHost(docker.host()))
+	require.ErrorContains(t, err, "not running")
+
+	_, err = Watch(context.Background(), "web", WithDockerHost("npipe:////./pipe/docker_engine"))
+	require.ErrorContains(t, err, "unsupported")
+}
+
+func TestForward(t *testing.T) {
+	first, second := upstream(t, "first"), upstream(t, "second")
+	docker := newFakeDocker(t, first)
+	t.Setenv("DOCKER_HOST", docker.host())
+
+	ctx := context.Background()
+	sess, err := ngrok.Connect(ctx, ngrok.WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	fwd, err := Forward(ctx, sess, "web")
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	get := func() string {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+		resp, err := client.Get(fwd.URL())
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+	require.Equal(t, "first", get())
+
+	docker.set(second, true)
+	docker.events <- "start"
+	require.Eventually(t, func() bool { return strings.Contains(get(), "second") }, time.Second, 10*time.Millisecond)
+}
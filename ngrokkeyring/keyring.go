@@ -0,0 +1,62 @@
+// Package ngrokkeyring keeps ngrok authtokens in the operating system's
+// credential store rather than in plaintext configuration: the Keychain on
+// macOS, the Credential Manager on Windows, and the Secret Service (such as
+// GNOME Keyring or KWallet) elsewhere, through its secret-tool command.
+//
+// Store the authtoken once, e.g. when the user first signs in:
+//
+//	err := ngrokkeyring.Set("my-app", "ngrok", token)
+//
+// and then connect with it:
+//
+//	sess, err := ngrok.Connect(ctx,
+//		ngrokkeyring.WithAuthtokenFromKeyring("my-app", "ngrok"))
+package ngrokkeyring
+
+import (
+	"context"
+	"errors"
+
+	"golang.ngrok.com/ngrok"
+)
+
+// ErrNotFound is returned when no authtoken is stored for a service and
+// account.
+var ErrNotFound = errors.New("authtoken not found in keyring")
+
+// A credential store.
+type store interface {
+	get(service, account string) (string, error)
+	set(service, account, token string) error
+	delete(service, account string) error
+}
+
+// The store for this operating system, replaced by tests.
+var keyring store = osStore{}
+
+// Get returns the authtoken stored for the service and account, or
+// [ErrNotFound] if there isn't one.
+func Get(service, account string) (string, error) {
+	return keyring.get(service, account)
+}
+
+// Set stores the authtoken for the service and account, replacing any that
+// was stored before.
+func Set(service, account, token string) error {
+	return keyring.set(service, account, token)
+}
+
+// Delete removes the authtoken stored for the service and account, or
+// returns [ErrNotFound] if there isn't one.
+func Delete(service, account string) error {
+	return keyring.delete(service, account)
+}
+
+// WithAuthtokenFromKeyring configures the session to authenticate with the
+// authtoken stored for the service and account, which is read as the session
+// connects. [ngrok.Connect] fails if it can't be read.
+func WithAuthtokenFromKeyring(service, account string) ngrok.ConnectOption {
+	return ngrok.WithAuthtokenProvider(func(context.Context) (string, error) {
+		return Get(service, account)
+	})
+}
@@ -0,0 +1,48 @@
+package ngrokkeyring
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// The exit status of the security command when an item isn't found.
+const securityNotFound = 44
+
+// The macOS Keychain, through the security command.
+type osStore struct{}
+
+func (osStore) get(service, account string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", service, "-a", account, "-w").Output()
+	if err != nil {
+		return "", securityError(err)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (osStore) set(service, account, token string) error {
+	// Pass the command on stdin so that the token doesn't appear in the
+	// process list.
+	cmd := exec.Command("security", "-i")
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		quote(service), quote(account), quote(token)))
+	return securityError(cmd.Run())
+}
+
+func (osStore) delete(service, account string) error {
+	return securityError(exec.Command("security", "delete-generic-password", "-s", service, "-a", account).Run())
+}
+
+func securityError(err error) error {
+	var exit *exec.ExitError
+	if errors.As(err, &exit) && exit.ExitCode() == securityNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Quote an argument for the security command's interactive mode.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,74 @@
+package ngrokkeyring
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok"
+)
+
+// An in-memory credential store.
+type memStore map[[2]string]string
+
+func (m memStore) get(service, account string) (string, error) {
+	token, ok := m[[2]string{service, account}]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return token, nil
+}
+
+func (m memStore) set(service, account, token string) error {
+	m[[2]string{service, account}] = token
+	return nil
+}
+
+func (m memStore) delete(service, account string) error {
+	if _, err := m.get(service, account); err != nil {
+		return err
+	}
+	delete(m, [2]string{service, account})
+	return nil
+}
+
+func useStore(t *testing.T, s store) {
+	prev := keyring
+	keyring = s
+	t.Cleanup(func() { keyring = prev })
+}
+
+func TestKeyring(t *testing.T) {
+	useStore(t, memStore{})
+
+	_, err := Get("app", "ngrok")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, Set("app", "ngrok", "token"))
+	token, err := Get("app", "ngrok")
+	require.NoError(t, err)
+	require.Equal(t, "token", token)
+
+	require.NoError(t, Delete("app", "ngrok"))
+	require.ErrorIs(t, Delete("app", "ngrok"), ErrNotFound)
+}
+
+func TestWithAuthtokenFromKeyring(t *testing.T) {
+	useStore(t, memStore{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := ngrok.Connect(ctx, WithAuthtokenFromKeyring("app", "ngrok"))
+	require.ErrorIs(t, err, ErrNotFound)
+
+	// With a fallback, the session listens locally instead.
+	sess, err := ngrok.Connect(ctx,
+		WithAuthtokenFromKeyring("app", "ngrok"),
+		ngrok.WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+	require.Len(t, sess.Warnings(), 1)
+	require.ErrorIs(t, sess.Warnings()[0], ngrok.ErrOffline)
+}
@@ -0,0 +1,55 @@
+//go:build !darwin && !windows
+
+package ngrokkeyring
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+)
+
+// The Secret Service, through the secret-tool command from libsecret.
+type osStore struct{}
+
+func (osStore) get(service, account string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "lookup", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		// secret-tool fails without explanation when nothing matches.
+		var exit *exec.ExitError
+		if errors.As(err, &exit) && stderr.Len() == 0 {
+			return "", ErrNotFound
+		}
+		return "", secretToolError(err, &stderr)
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (osStore) set(service, account, token string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "store", "--label=ngrok authtoken for "+service, "service", service, "account", account)
+	cmd.Stdin = strings.NewReader(token)
+	cmd.Stderr = &stderr
+	return secretToolError(cmd.Run(), &stderr)
+}
+
+func (s osStore) delete(service, account string) error {
+	// secret-tool succeeds whether or not anything was cleared.
+	if _, err := s.get(service, account); err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.Command("secret-tool", "clear", "service", service, "account", account)
+	cmd.Stderr = &stderr
+	return secretToolError(cmd.Run(), &stderr)
+}
+
+func secretToolError(err error, stderr *bytes.Buffer) error {
+	if err == nil || stderr.Len() == 0 {
+		return err
+	}
+	return errors.New("secret-tool: " + strings.TrimSpace(stderr.String()))
+}
@@ -0,0 +1,447 @@
+// Package ngrokmux serves multiple application protocols from a single ngrok
+// tunnel by sniffing the first bytes of each connection and routing it to a
+// protocol-specific [net.Listener].
+//
+// This is useful for tcp:// and tls:// tunnels, where one public address can
+// front, for example, both an HTTP server and an SSH server:
+//
+//	mux := ngrokmux.New(tun)
+//	httpL := mux.Listen(ngrokmux.HTTP1)
+//	sshL := mux.Listen(ngrokmux.SSH)
+//	go http.Serve(httpL, handler)
+//	go sshServer.Serve(sshL)
+//	err := mux.Serve()
+//
+// For http:// and https:// tunnels, a [PathMux] instead routes requests to
+// handlers and listeners by path prefix.
+package ngrokmux
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok"
+)
+
+// Protocol is an application protocol that can be detected by a [Mux].
+type Protocol int
+
+// The protocols recognized by a [Mux].
+const (
+	Unknown Protocol = iota
+	// HTTP/1.x, detected by a request line with a standard method.
+	HTTP1
+	// HTTP/2 with prior knowledge, detected by the client connection preface.
+	HTTP2
+	// TLS, detected by a ClientHello record.
+	TLS
+	// SSH, detected by the client's identification string.
+	SSH
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case HTTP1:
+		return "http/1"
+	case HTTP2:
+		return "h2"
+	case TLS:
+		return "tls"
+	case SSH:
+		return "ssh"
+	}
+	return "unknown"
+}
+
+// The default time allowed for a client to send enough data to identify its
+// protocol.
+const DefaultSniffTimeout = 10 * time.Second
+
+// ErrMuxClosed is returned from Accept on a listener created by a [Mux] once
+// the mux or the listener has been closed.
+var ErrMuxClosed = errors.New("ngrokmux: listener closed")
+
+// Mux demultiplexes the connections accepted from a [net.Listener], typically
+// an [ngrok.Tunnel], into listeners for each protocol.
+type Mux struct {
+	root net.Listener
+
+	// The time allowed for a client to send enough data to identify its
+	// protocol. Defaults to DefaultSniffTimeout.
+	SniffTimeout time.Duration
+
+	mu        sync.Mutex
+	protocols map[Protocol]*listener
+	names     map[string]*listener
+	fallback  *listener
+	closed    bool
+}
+
+// New creates a [Mux] for the connections accepted from l. Call
+// [Mux.Serve] to begin routing them.
+func New(l net.Listener) *Mux {
+	return &Mux{
+		root:      l,
+		protocols: make(map[Protocol]*listener),
+		names:     make(map[string]*listener),
+	}
+}
+
+// Listen returns a listener for connections using the given protocol. Calling
+// Listen again for the same protocol replaces the previous listener.
+func (m *Mux) Listen(proto Protocol) net.Listener {
+	return m.register(func(l *listener) {
+		m.protocols[proto] = l
+	})
+}
+
+// ListenServerName returns a listener for TLS connections whose ClientHello
+// requests the given server name (SNI). It takes precedence over a listener
+// for the [TLS] protocol.
+func (m *Mux) ListenServerName(serverName string) net.Listener {
+	return m.register(func(l *listener) {
+		m.names[serverName] = l
+	})
+}
+
+// Default returns a listener for connections that don't match any other
+// listener. Without one, unmatched connections are closed.
+func (m *Mux) Default() net.Listener {
+	return m.register(func(l *listener) {
+		m.fallback = l
+	})
+}
+
+func (m *Mux) register(set func(*listener)) net.Listener {
+	l := &listener{
+		mux:   m,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		l.closeOnce.Do(func() { close(l.done) })
+	}
+	set(l)
+	return l
+}
+
+// Serve accepts connections from the underlying listener and routes them
+// until it fails, returning its error. Connections are sniffed concurrently,
+// so a slow client never delays others.
+func (m *Mux) Serve() error {
+	for {
+		conn, err := m.root.Accept()
+		if err != nil {
+			m.closeListeners()
+			return err
+		}
+		go m.route(conn)
+	}
+}
+
+// Close closes the underlying listener and all of the listeners created by
+// the mux.
+func (m *Mux) Close() error {
+	m.closeListeners()
+	return m.root.Close()
+}
+
+func (m *Mux) closeListeners() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, l := range m.protocols {
+		l.shutdown()
+	}
+	for _, l := range m.names {
+		l.shutdown()
+	}
+	if m.fallback != nil {
+		m.fallback.shutdown()
+	}
+}
+
+func (m *Mux) route(conn net.Conn) {
+	timeout := m.SniffTimeout
+	if timeout == 0 {
+		timeout = DefaultSniffTimeout
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	s := &sniffer{conn: conn}
+	proto, serverName := s.sniff()
+	_ = conn.SetReadDeadline(time.Time{})
+
+	c := &Conn{
+		Conn:       conn,
+		reader:     io.MultiReader(bytes.NewReader(s.buf), conn),
+		protocol:   proto,
+		serverName: serverName,
+	}
+
+	m.mu.Lock()
+	l := m.names[serverName]
+	if l == nil || serverName == "" {
+		l = m.protocols[proto]
+	}
+	if l == nil {
+		l = m.fallback
+	}
+	m.mu.Unlock()
+
+	if l == nil || !l.deliver(c) {
+		conn.Close()
+	}
+}
+
+// Conn is a connection routed by a [Mux]. The bytes read while sniffing are
+// replayed to the first reads from the connection.
+//
+// If the underlying connection came from an ngrok [ngrok.Tunnel], its
+// metadata remains available via the [ngrok.Conn] methods.
+type Conn struct {
+	net.Conn
+	reader     io.Reader
+	protocol   Protocol
+	serverName string
+}
+
+// compile-time check that we're implementing the proper interface
+var _ ngrok.Conn = &Conn{}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// Protocol returns the application protocol detected for the connection.
+func (c *Conn) Protocol() Protocol {
+	return c.protocol
+}
+
+// ServerName returns the server name requested by a TLS connection, if any.
+func (c *Conn) ServerName() string {
+	return c.serverName
+}
+
+// Unwrap returns the underlying connection.
+func (c *Conn) Unwrap() net.Conn {
+	return c.Conn
+}
+
+// Proto returns the tunnel protocol of the underlying ngrok connection.
+func (c *Conn) Proto() string {
+	if nc, ok := c.Conn.(ngrok.Conn); ok {
+		return nc.Proto()
+	}
+	return ""
+}
+
+// EdgeType returns the edge type of the underlying ngrok connection.
+func (c *Conn) EdgeType() ngrok.EdgeType {
+	if nc, ok := c.Conn.(ngrok.Conn); ok {
+		return nc.EdgeType()
+	}
+	return ngrok.EdgeTypeUndefined
+}
+
+// PassthroughTLS returns whether the underlying ngrok connection contains an
+// end-to-end TLS connection.
+func (c *Conn) PassthroughTLS() bool {
+	if nc, ok := c.Conn.(ngrok.Conn); ok {
+		return nc.PassthroughTLS()
+	}
+	return false
+}
+
+// Context returns the context of the underlying ngrok connection, or
+// [context.Background] if it didn't come from a [ngrok.Tunnel].
+func (c *Conn) Context() context.Context {
+	if nc, ok := c.Conn.(ngrok.Conn); ok {
+		return nc.Context()
+	}
+	return context.Background()
+}
+
+// TLSConnectionState returns the state of the TLS connection terminated by
+// the agent for the underlying ngrok connection, if any.
+func (c *Conn) TLSConnectionState() (*tls.ConnectionState, error) {
+	if nc, ok := c.Conn.(ngrok.Conn); ok {
+		return nc.TLSConnectionState()
+	}
+	return nil, nil
+}
+
+type listener struct {
+	mux       *Mux
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, ErrMuxClosed
+	}
+}
+
+// Close stops the listener from receiving connections. Matching connections
+// are routed to the default listener, if any, or closed.
+func (l *listener) Close() error {
+	l.mux.mu.Lock()
+	defer l.mux.mu.Unlock()
+	for proto, other := range l.mux.protocols {
+		if other == l {
+			delete(l.mux.protocols, proto)
+		}
+	}
+	for name, other := range l.mux.names {
+		if other == l {
+			delete(l.mux.names, name)
+		}
+	}
+	if l.mux.fallback == l {
+		l.mux.fallback = nil
+	}
+	l.shutdown()
+	return nil
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.mux.root.Addr()
+}
+
+func (l *listener) shutdown() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+// Hand off a connection, returning false if the listener was closed.
+func (l *listener) deliver(conn net.Conn) bool {
+	select {
+	case l.conns <- conn:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+// The prefixes used to identify protocols other than TLS.
+var prefixes = []struct {
+	prefix   string
+	protocol Protocol
+}{
+	{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", HTTP2},
+	{"SSH-", SSH},
+	{"GET ", HTTP1},
+	{"HEAD ", HTTP1},
+	{"POST ", HTTP1},
+	{"PUT ", HTTP1},
+	{"DELETE ", HTTP1},
+	{"CONNECT ", HTTP1},
+	{"OPTIONS ", HTTP1},
+	{"TRACE ", HTTP1},
+	{"PATCH ", HTTP1},
+}
+
+// Reads from a connection, recording everything read so that it can be
+// replayed.
+type sniffer struct {
+	conn net.Conn
+	buf  []byte
+	pos  int
+}
+
+// Read at least one more byte into the buffer.
+func (s *sniffer) more() error {
+	var chunk [512]byte
+	n, err := s.conn.Read(chunk[:])
+	s.buf = append(s.buf, chunk[:n]...)
+	if n > 0 {
+		return nil
+	}
+	if err == nil {
+		err = io.ErrNoProgress
+	}
+	return err
+}
+
+// Read sequentially through the recorded bytes, reading more as needed.
+func (s *sniffer) Read(p []byte) (int, error) {
+	if s.pos == len(s.buf) {
+		if err := s.more(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, s.buf[s.pos:])
+	s.pos += n
+	return n, nil
+}
+
+func (s *sniffer) sniff() (Protocol, string) {
+	if err := s.more(); err != nil {
+		return Unknown, ""
+	}
+
+	if s.buf[0] == 0x16 {
+		serverName, ok := s.clientHello()
+		if ok {
+			return TLS, serverName
+		}
+		return Unknown, ""
+	}
+
+	for {
+		ambiguous := false
+		for _, p := range prefixes {
+			switch {
+			case bytes.HasPrefix(s.buf, []byte(p.prefix)):
+				return p.protocol, ""
+			case bytes.HasPrefix([]byte(p.prefix), s.buf):
+				ambiguous = true
+			}
+		}
+		if !ambiguous {
+			return Unknown, ""
+		}
+		if err := s.more(); err != nil {
+			return Unknown, ""
+		}
+	}
+}
+
+var errSniffed = errors.New("client hello read")
+
+// Parse a TLS ClientHello from the connection and return the server name
+// it requests.
+func (s *sniffer) clientHello() (string, bool) {
+	var hello *tls.ClientHelloInfo
+	_ = tls.Server(&readOnlyConn{Conn: s.conn, r: s}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = info
+			return nil, errSniffed
+		},
+	}).Handshake()
+	if hello == nil {
+		return "", false
+	}
+	return hello.ServerName, true
+}
+
+// Lets crypto/tls read a ClientHello without writing anything back.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readOnlyConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *readOnlyConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
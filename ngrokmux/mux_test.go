@@ -0,0 +1,145 @@
+package ngrokmux
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testMux(t *testing.T) (*Mux, string) {
+	root, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	mux := New(root)
+	mux.SniffTimeout = time.Second
+	t.Cleanup(func() { mux.Close() })
+	return mux, root.Addr().String()
+}
+
+func acceptOne(t *testing.T, l net.Listener) *Conn {
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	select {
+	case conn := <-accepted:
+		return conn.(*Conn)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for connection")
+		return nil
+	}
+}
+
+func TestMuxPrefixes(t *testing.T) {
+	mux, addr := testMux(t)
+	listeners := map[Protocol]net.Listener{
+		HTTP1: mux.Listen(HTTP1),
+		HTTP2: mux.Listen(HTTP2),
+		SSH:   mux.Listen(SSH),
+	}
+	fallback := mux.Default()
+	go func() { _ = mux.Serve() }()
+
+	cases := []struct {
+		payload  string
+		protocol Protocol
+		l        net.Listener
+	}{
+		{"GET / HTTP/1.1\r\nHost: example.com\r\n\r\n", HTTP1, listeners[HTTP1]},
+		{"PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", HTTP2, listeners[HTTP2]},
+		{"SSH-2.0-OpenSSH_9.0\r\n", SSH, listeners[SSH]},
+		{"hello there\n", Unknown, fallback},
+	}
+
+	for _, tc := range cases {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		_, err = client.Write([]byte(tc.payload))
+		require.NoError(t, err)
+
+		conn := acceptOne(t, tc.l)
+		require.Equal(t, tc.protocol, conn.Protocol())
+
+		// The sniffed bytes are replayed.
+		buf := make([]byte, len(tc.payload))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, tc.payload, string(buf))
+
+		conn.Close()
+		client.Close()
+	}
+}
+
+func TestMuxServerName(t *testing.T) {
+	mux, addr := testMux(t)
+	tlsL := mux.Listen(TLS)
+	apiL := mux.ListenServerName("api.example.com")
+	go func() { _ = mux.Serve() }()
+
+	for _, tc := range []struct {
+		serverName string
+		l          net.Listener
+	}{
+		{"api.example.com", apiL},
+		{"www.example.com", tlsL},
+	} {
+		client, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		go func(serverName string) {
+			_ = tls.Client(client, &tls.Config{ServerName: serverName}).Handshake()
+		}(tc.serverName)
+
+		conn := acceptOne(t, tc.l)
+		require.Equal(t, TLS, conn.Protocol())
+		require.Equal(t, tc.serverName, conn.ServerName())
+
+		// The ClientHello is replayed, so a real TLS server sees it first.
+		var hello *tls.ClientHelloInfo
+		_ = tls.Server(conn, &tls.Config{
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				hello = info
+				return nil, io.EOF
+			},
+		}).Handshake()
+		require.NotNil(t, hello)
+		require.Equal(t, tc.serverName, hello.ServerName)
+
+		conn.Close()
+		client.Close()
+	}
+}
+
+func TestMuxUnmatchedClosed(t *testing.T) {
+	mux, addr := testMux(t)
+	mux.Listen(HTTP1)
+	go func() { _ = mux.Serve() }()
+
+	client, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("SSH-2.0-test\r\n"))
+	require.NoError(t, err)
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = client.Read(make([]byte, 1))
+	require.ErrorIs(t, err, io.EOF)
+}
+
+func TestMuxClose(t *testing.T) {
+	mux, _ := testMux(t)
+	l := mux.Listen(HTTP1)
+	done := make(chan error, 1)
+	go func() { done <- mux.Serve() }()
+
+	require.NoError(t, mux.Close())
+	require.Error(t, <-done)
+	_, err := l.Accept()
+	require.ErrorIs(t, err, ErrMuxClosed)
+}
@@ -0,0 +1,270 @@
+package ngrokmux
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/netip"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// PathMux shares a single HTTP endpoint between several subsystems of one
+// application, each of which claims the requests under some path prefixes,
+// without a central router that knows about all of them:
+//
+//	mux := ngrokmux.NewPathMux(tun)
+//	mux.Handle("/api", apiHandler)
+//	go wsServer.Serve(mux.Listen("/ws"))
+//	err := mux.Serve()
+//
+// A prefix claims the requests for its path and the paths beneath it, so
+// "/api" claims "/api" and "/api/users" but not "/apiary". The longest
+// matching prefix wins, and "/" claims everything not claimed otherwise.
+// Requests that no prefix claims get a 404 response. Requests for paths that
+// aren't canonical, such as "/ws/../api" or "/api//users", are redirected to
+// the canonical path, as [http.ServeMux] does, so that they can't reach a
+// prefix's handler without being routed by it. Paths are otherwise passed on
+// unchanged; use [http.StripPrefix] to remove the prefix.
+type PathMux struct {
+	root   net.Listener
+	server *http.Server
+
+	mu        sync.Mutex
+	routes    map[string]http.Handler
+	listeners map[string]*pathListener
+	closed    bool
+}
+
+// NewPathMux creates a [PathMux] for the HTTP requests on the connections
+// accepted from l. Call [PathMux.Serve] to begin routing them, or serve the
+// PathMux as the handler of an HTTP server of your own.
+func NewPathMux(l net.Listener) *PathMux {
+	m := &PathMux{
+		root:      l,
+		routes:    make(map[string]http.Handler),
+		listeners: make(map[string]*pathListener),
+	}
+	m.server = &http.Server{Handler: m}
+	return m
+}
+
+// Normalize a path prefix, so that "/api" and "/api/" are the same claim.
+func cleanPrefix(prefix string) string {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	return strings.TrimRight(prefix, "/")
+}
+
+// Handle routes the requests under prefix to h. It panics if the prefix has
+// already been claimed, as [http.ServeMux] does for patterns.
+func (m *PathMux) Handle(prefix string, h http.Handler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claim(cleanPrefix(prefix), h)
+}
+
+func (m *PathMux) claim(prefix string, h http.Handler) {
+	if _, ok := m.routes[prefix]; ok {
+		if prefix == "" {
+			prefix = "/"
+		}
+		panic(fmt.Sprintf("ngrokmux: path prefix %q is already claimed", prefix))
+	}
+	m.routes[prefix] = h
+}
+
+// Listen returns a listener for the requests under prefix, for subsystems
+// that serve HTTP themselves. Each request is proxied to the listener over a
+// connection of its own, held in memory, whose RemoteAddr is the client's
+// address. It panics if the prefix has already been claimed. Closing the
+// listener releases the prefix.
+func (m *PathMux) Listen(prefix string) net.Listener {
+	prefix = cleanPrefix(prefix)
+	l := &pathListener{
+		mux:    m,
+		prefix: prefix,
+		conns:  make(chan net.Conn),
+		done:   make(chan struct{}),
+	}
+	proxy := &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme, r.URL.Host = "http", "ngrokmux.invalid"
+			// Pass the request on as it arrived.
+			r.Header["X-Forwarded-For"] = nil
+		},
+		Transport: &http.Transport{
+			DialContext:       l.dial,
+			DisableKeepAlives: true,
+		},
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.claim(prefix, proxy)
+	m.listeners[prefix] = l
+	if m.closed {
+		l.shutdown()
+	}
+	return l
+}
+
+// Return the canonical form of a path, eliminating . and .. elements and
+// repeated slashes, but keeping a trailing slash, as http.ServeMux does.
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	np := path.Clean(p)
+	if p[len(p)-1] == '/' && np != "/" {
+		np += "/"
+	}
+	return np
+}
+
+// Find the handler of the longest prefix claiming a path.
+func (m *PathMux) match(path string) http.Handler {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for prefix := strings.TrimRight(path, "/"); ; {
+		if h, ok := m.routes[prefix]; ok {
+			return h
+		}
+		i := strings.LastIndexByte(prefix, '/')
+		if i < 0 {
+			return nil
+		}
+		prefix = prefix[:i]
+	}
+}
+
+// ServeHTTP routes a request to the handler or listener that claimed its
+// path.
+func (m *PathMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		if p := cleanPath(r.URL.Path); p != r.URL.Path {
+			u := &url.URL{Path: p, RawQuery: r.URL.RawQuery}
+			http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+			return
+		}
+	}
+	h := m.match(r.URL.Path)
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ctx := context.WithValue(r.Context(), remoteAddrKey{}, r.RemoteAddr)
+	h.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Serve serves HTTP on the underlying listener until it fails, returning its
+// error, or [http.ErrServerClosed] once the mux is closed.
+func (m *PathMux) Serve() error {
+	err := m.server.Serve(m.root)
+	m.closeListeners()
+	return err
+}
+
+// Close closes the underlying listener, the connections being served, and
+// all of the listeners created by the mux.
+func (m *PathMux) Close() error {
+	m.closeListeners()
+	if err := m.server.Close(); err != nil {
+		return err
+	}
+	// Close the listener even if the mux was never served.
+	_ = m.root.Close()
+	return nil
+}
+
+func (m *PathMux) closeListeners() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	for _, l := range m.listeners {
+		l.shutdown()
+	}
+}
+
+// The context key of the address of the client that sent a request.
+type remoteAddrKey struct{}
+
+type pathListener struct {
+	mux       *PathMux
+	prefix    string
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *pathListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, ErrMuxClosed
+	}
+}
+
+// Close stops the listener from receiving requests, and releases its prefix.
+func (l *pathListener) Close() error {
+	l.mux.mu.Lock()
+	defer l.mux.mu.Unlock()
+	if l.mux.listeners[l.prefix] == l {
+		delete(l.mux.listeners, l.prefix)
+		delete(l.mux.routes, l.prefix)
+	}
+	l.shutdown()
+	return nil
+}
+
+func (l *pathListener) Addr() net.Addr {
+	return l.mux.root.Addr()
+}
+
+func (l *pathListener) shutdown() {
+	l.closeOnce.Do(func() { close(l.done) })
+}
+
+// Connect the proxy to the listener over an in-memory connection.
+func (l *pathListener) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	client, server := net.Pipe()
+	conn := &pathConn{Conn: server}
+	if remote, ok := ctx.Value(remoteAddrKey{}).(string); ok {
+		if addr, err := netip.ParseAddrPort(remote); err == nil {
+			conn.remote = net.TCPAddrFromAddrPort(addr)
+		}
+	}
+	err := ErrMuxClosed
+	select {
+	case l.conns <- conn:
+		return client, nil
+	case <-l.done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	client.Close()
+	server.Close()
+	return nil, err
+}
+
+// A connection carrying a request to a listener created by a [PathMux].
+type pathConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *pathConn) RemoteAddr() net.Addr {
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
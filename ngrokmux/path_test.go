@@ -0,0 +1,99 @@
+package ngrokmux
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testPathMux(t *testing.T) (*PathMux, string) {
+	root, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	mux := NewPathMux(root)
+	t.Cleanup(func() { mux.Close() })
+	return mux, "http://" + root.Addr().String()
+}
+
+func get(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
+
+func TestPathMux(t *testing.T) {
+	mux, base := testPathMux(t)
+	named := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+		})
+	}
+	mux.Handle("/api/", named("api"))
+	mux.Handle("/api/v2", named("v2"))
+
+	ws := mux.Listen("/ws")
+	go func() {
+		_ = http.Serve(ws, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "ws %s from %s", r.URL.Path, r.RemoteAddr)
+		}))
+	}()
+	go func() { _ = mux.Serve() }()
+
+	for path, expected := range map[string]string{
+		"/api":          "api /api",
+		"/api/users":    "api /api/users",
+		"/api/v2/users": "v2 /api/v2/users",
+		"/api/v20":      "api /api/v20",
+	} {
+		status, body := get(t, base+path)
+		require.Equal(t, http.StatusOK, status, path)
+		require.Equal(t, expected, body, path)
+	}
+
+	// Paths that aren't canonical are redirected before they're routed.
+	noRedirect := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	for path, expected := range map[string]string{
+		"/ws/../api/v2?q=1": "/api/v2?q=1",
+		"/api//users/":      "/api/users/",
+		"/api/./v2":         "/api/v2",
+	} {
+		resp, err := noRedirect.Get(base + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusMovedPermanently, resp.StatusCode, path)
+		require.Equal(t, expected, resp.Header.Get("Location"), path)
+	}
+	status, body := get(t, base+"/ws/../api/v2")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "v2 /api/v2", body)
+
+	// Unclaimed paths, including those merely sharing a prefix's characters.
+	status, _ = get(t, base+"/apiary")
+	require.Equal(t, http.StatusNotFound, status)
+
+	// Requests reach the listener with the client's address.
+	status, body = get(t, base+"/ws/chat")
+	require.Equal(t, http.StatusOK, status)
+	require.Regexp(t, `^ws /ws/chat from 127\.0\.0\.1:\d+$`, body)
+
+	require.Panics(t, func() { mux.Handle("/ws/", named("other")) })
+
+	// Closing the listener releases its prefix to the catch-all.
+	require.NoError(t, ws.Close())
+	mux.Handle("/", named("root"))
+	status, body = get(t, base+"/ws/chat")
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "root /ws/chat", body)
+
+	require.NoError(t, mux.Close())
+	_, err := mux.Listen("/late").Accept()
+	require.ErrorIs(t, err, ErrMuxClosed)
+}
@@ -0,0 +1,263 @@
+// Package ngrokpcap writes the traffic seen by an [ngrok.ConnTap] to a
+// pcap-ng file, so that it can be inspected with Wireshark or tcpdump while
+// debugging an incident:
+//
+//	f, err := os.Create("ngrok.pcapng")
+//	w, err := ngrokpcap.NewWriter(f)
+//	sess, err := ngrok.Connect(ctx, ngrok.WithConnTap(w.Tap))
+//	...
+//	w.Close()
+//
+// The tap doesn't see the network packets the bytes arrived in, so the
+// packets are synthesized: each endpoint is given a TCP flow between
+// made-up IPv4 addresses, which opens with a handshake the first time the
+// endpoint sees traffic. The bytes of concurrent connections to the same
+// endpoint are interleaved within its flow.
+package ngrokpcap
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok"
+)
+
+const (
+	blockSectionHeader  = 0x0A0D0D0A
+	blockInterfaceDesc  = 0x00000001
+	blockEnhancedPacket = 0x00000006
+	byteOrderMagic      = 0x1A2B3C4D
+	// Packets are raw IPv4, without a link layer header.
+	linkTypeRaw = 101
+
+	ipHeaderLen  = 20
+	tcpHeaderLen = 20
+	maxPayload   = 65535 - ipHeaderLen - tcpHeaderLen
+
+	tcpFIN = 0x01
+	tcpSYN = 0x02
+	tcpPSH = 0x08
+	tcpACK = 0x10
+
+	serverPort = 443
+)
+
+// Option configures a [Writer].
+type Option func(*Writer)
+
+// WithSnapLen limits how many bytes of each packet are written, sampling the
+// start of each rather than recording all of the traffic. By default, whole
+// packets are written.
+func WithSnapLen(n int) Option {
+	return func(w *Writer) {
+		w.snapLen = n
+	}
+}
+
+// Writer writes synthetic packets to a pcap-ng file. It's safe for
+// concurrent use.
+type Writer struct {
+	snapLen int
+
+	mu    sync.Mutex
+	w     io.Writer
+	err   error
+	flows map[string]*flow
+	now   func() time.Time
+}
+
+// A synthetic TCP flow for an endpoint.
+type flow struct {
+	client    [4]byte
+	server    [4]byte
+	port      uint16
+	clientSeq uint32
+	serverSeq uint32
+	ipID      uint16
+}
+
+// NewWriter creates a Writer, and writes the headers of the file.
+func NewWriter(w io.Writer, opts ...Option) (*Writer, error) {
+	pw := &Writer{
+		w:     w,
+		flows: make(map[string]*flow),
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(pw)
+	}
+	if pw.snapLen <= 0 || pw.snapLen > 65535 {
+		pw.snapLen = 65535
+	}
+
+	var shb []byte
+	shb = binary.LittleEndian.AppendUint32(shb, byteOrderMagic)
+	shb = binary.LittleEndian.AppendUint16(shb, 1) // major version
+	shb = binary.LittleEndian.AppendUint16(shb, 0) // minor version
+	// The length of the section is unknown.
+	shb = binary.LittleEndian.AppendUint64(shb, ^uint64(0))
+	if err := pw.writeBlock(blockSectionHeader, shb); err != nil {
+		return nil, err
+	}
+
+	var idb []byte
+	idb = binary.LittleEndian.AppendUint16(idb, linkTypeRaw)
+	idb = binary.LittleEndian.AppendUint16(idb, 0) // reserved
+	idb = binary.LittleEndian.AppendUint32(idb, uint32(pw.snapLen))
+	if err := pw.writeBlock(blockInterfaceDesc, idb); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// Tap records bytes seen by an [ngrok.ConnTap]. Pass it to
+// [ngrok.WithConnTap]. Errors writing the file are reported by Close.
+func (w *Writer) Tap(dir ngrok.Direction, endpointID string, b []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	f, ok := w.flows[endpointID]
+	if !ok {
+		f = w.newFlow()
+		w.flows[endpointID] = f
+		w.handshake(f)
+	}
+	for len(b) > 0 && w.err == nil {
+		chunk := b[:min(len(b), maxPayload)]
+		b = b[len(chunk):]
+		w.packet(f, dir == ngrok.DirectionInbound, tcpPSH|tcpACK, chunk)
+	}
+}
+
+// Close finishes each flow with a FIN, and returns the first error writing
+// the file, if any. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id, f := range w.flows {
+		w.packet(f, true, tcpFIN|tcpACK, nil)
+		w.packet(f, false, tcpFIN|tcpACK, nil)
+		delete(w.flows, id)
+	}
+	return w.err
+}
+
+// Allocate addresses for a new flow: clients in 10.0.0.0/16 and servers in
+// 10.1.0.0/16, numbered in the order the endpoints are seen.
+func (w *Writer) newFlow() *flow {
+	n := len(w.flows) + 1
+	return &flow{
+		client:    [4]byte{10, 0, byte(n >> 8), byte(n)},
+		server:    [4]byte{10, 1, byte(n >> 8), byte(n)},
+		port:      uint16(49152 + n%16384),
+		clientSeq: 1000,
+		serverSeq: 5000,
+	}
+}
+
+func (w *Writer) handshake(f *flow) {
+	w.packet(f, true, tcpSYN, nil)
+	w.packet(f, false, tcpSYN|tcpACK, nil)
+	w.packet(f, true, tcpACK, nil)
+}
+
+// Write a TCP segment from the client if fromClient is set, or else from the
+// server, advancing the sequence numbers of the flow.
+func (w *Writer) packet(f *flow, fromClient bool, flags byte, payload []byte) {
+	if w.err != nil {
+		return
+	}
+	src, dst := f.client, f.server
+	srcPort, dstPort := f.port, uint16(serverPort)
+	seq, ack := &f.clientSeq, &f.serverSeq
+	if !fromClient {
+		src, dst = dst, src
+		srcPort, dstPort = dstPort, srcPort
+		seq, ack = ack, seq
+	}
+	f.ipID++
+
+	total := ipHeaderLen + tcpHeaderLen + len(payload)
+	pkt := make([]byte, total)
+
+	ip := pkt[:ipHeaderLen]
+	ip[0] = 0x45 // IPv4, 5 word header
+	binary.BigEndian.PutUint16(ip[2:], uint16(total))
+	binary.BigEndian.PutUint16(ip[4:], f.ipID)
+	ip[6] = 0x40 // don't fragment
+	ip[8] = 64   // TTL
+	ip[9] = 6    // TCP
+	copy(ip[12:16], src[:])
+	copy(ip[16:20], dst[:])
+	binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+	tcp := pkt[ipHeaderLen:]
+	binary.BigEndian.PutUint16(tcp[0:], srcPort)
+	binary.BigEndian.PutUint16(tcp[2:], dstPort)
+	binary.BigEndian.PutUint32(tcp[4:], *seq)
+	if flags&tcpACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:], *ack)
+	}
+	tcp[12] = tcpHeaderLen / 4 << 4
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:], 65535) // window
+	copy(tcp[tcpHeaderLen:], payload)
+	binary.BigEndian.PutUint16(tcp[16:], checksum(tcp, pseudoHeaderSum(src, dst, len(tcp))))
+
+	*seq += uint32(len(payload))
+	if flags&(tcpSYN|tcpFIN) != 0 {
+		*seq++
+	}
+
+	captured := pkt[:min(len(pkt), w.snapLen)]
+	ts := uint64(w.now().UnixMicro())
+	var epb []byte
+	epb = binary.LittleEndian.AppendUint32(epb, 0) // interface
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts>>32))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(ts))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(captured)))
+	epb = binary.LittleEndian.AppendUint32(epb, uint32(len(pkt)))
+	epb = append(epb, captured...)
+	w.err = w.writeBlock(blockEnhancedPacket, epb)
+}
+
+// Write a block with the given body, padded to a multiple of four bytes.
+func (w *Writer) writeBlock(typ uint32, body []byte) error {
+	padded := (len(body) + 3) &^ 3
+	length := uint32(12 + padded)
+	block := make([]byte, 0, length)
+	block = binary.LittleEndian.AppendUint32(block, typ)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = append(block, make([]byte, padded-len(body))...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	_, err := w.w.Write(block)
+	return err
+}
+
+func pseudoHeaderSum(src, dst [4]byte, tcpLen int) uint32 {
+	var sum uint32
+	for i := 0; i < 4; i += 2 {
+		sum += uint32(src[i])<<8 | uint32(src[i+1])
+		sum += uint32(dst[i])<<8 | uint32(dst[i+1])
+	}
+	return sum + 6 + uint32(tcpLen)
+}
+
+// The internet checksum of b, starting from a partial sum.
+func checksum(b []byte, sum uint32) uint16 {
+	for ; len(b) >= 2; b = b[2:] {
+		sum += uint32(b[0])<<8 | uint32(b[1])
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,92 @@
+package ngrokpcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok"
+)
+
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, data []byte) []block {
+	var blocks []block
+	for len(data) > 0 {
+		require.GreaterOrEqual(t, len(data), 12)
+		typ := binary.LittleEndian.Uint32(data)
+		length := binary.LittleEndian.Uint32(data[4:])
+		require.Zero(t, length%4)
+		require.Equal(t, length, binary.LittleEndian.Uint32(data[length-4:]))
+		blocks = append(blocks, block{typ, data[8 : length-4]})
+		data = data[length:]
+	}
+	return blocks
+}
+
+// The packet in an enhanced packet block.
+func packetOf(t *testing.T, b block) []byte {
+	require.EqualValues(t, blockEnhancedPacket, b.typ)
+	captured := binary.LittleEndian.Uint32(b.body[12:])
+	pkt := b.body[20 : 20+captured]
+	require.Zero(t, checksum(pkt[:ipHeaderLen], 0), "IP checksum")
+	return pkt
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+
+	w.Tap(ngrok.DirectionInbound, "tun_1", []byte("GET / HTTP/1.1\r\n\r\n"))
+	w.Tap(ngrok.DirectionOutbound, "tun_1", []byte("HTTP/1.1 200 OK\r\n\r\n"))
+	w.Tap(ngrok.DirectionInbound, "tun_2", []byte("hello"))
+	require.NoError(t, w.Close())
+
+	blocks := readBlocks(t, buf.Bytes())
+	require.EqualValues(t, blockSectionHeader, blocks[0].typ)
+	require.EqualValues(t, byteOrderMagic, binary.LittleEndian.Uint32(blocks[0].body))
+	require.EqualValues(t, blockInterfaceDesc, blocks[1].typ)
+	require.EqualValues(t, linkTypeRaw, binary.LittleEndian.Uint16(blocks[1].body))
+
+	// A handshake and two segments for the first endpoint, a handshake
+	// and one segment for the second, and two FINs for each.
+	packets := blocks[2:]
+	require.Len(t, packets, 5+4+4)
+
+	request := packetOf(t, packets[3])
+	tcp := request[ipHeaderLen:]
+	require.Equal(t, []byte{10, 0, 0, 1}, request[12:16])
+	require.EqualValues(t, serverPort, binary.BigEndian.Uint16(tcp[2:]))
+	require.Equal(t, "GET / HTTP/1.1\r\n\r\n", string(tcp[tcpHeaderLen:]))
+	require.Zero(t, checksum(tcp, pseudoHeaderSum([4]byte(request[12:16]), [4]byte(request[16:20]), len(tcp))), "TCP checksum")
+
+	response := packetOf(t, packets[4])
+	tcp = response[ipHeaderLen:]
+	require.Equal(t, []byte{10, 1, 0, 1}, response[12:16])
+	require.Equal(t, "HTTP/1.1 200 OK\r\n\r\n", string(tcp[tcpHeaderLen:]))
+	// The response acknowledges the request.
+	requestSeq := binary.BigEndian.Uint32(request[ipHeaderLen+4:])
+	require.Equal(t, requestSeq+uint32(len("GET / HTTP/1.1\r\n\r\n")), binary.BigEndian.Uint32(tcp[8:]))
+
+	other := packetOf(t, packets[8])
+	require.Equal(t, []byte{10, 0, 0, 2}, other[12:16])
+}
+
+func TestWriterSnapLen(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, WithSnapLen(48))
+	require.NoError(t, err)
+	w.Tap(ngrok.DirectionInbound, "tun_1", bytes.Repeat([]byte("x"), 100))
+	require.NoError(t, w.Close())
+
+	blocks := readBlocks(t, buf.Bytes())
+	data := blocks[5].body
+	require.EqualValues(t, 48, binary.LittleEndian.Uint32(data[12:]))
+	require.EqualValues(t, ipHeaderLen+tcpHeaderLen+100, binary.LittleEndian.Uint32(data[16:]))
+}
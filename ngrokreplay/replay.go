@@ -0,0 +1,237 @@
+// Package ngrokreplay captures the HTTP requests that arrive through a tunnel
+// and re-issues them to the local upstream service on demand, like the
+// "Replay" button of the ngrok agent's inspection interface. It's meant for
+// development, e.g. re-sending a webhook while iterating on its handler
+// without triggering it again from the sender.
+//
+// Serve the tunnel through the replayer's proxy to forward requests to the
+// upstream service and capture them on the way:
+//
+//	r := ngrokreplay.New(upstream)
+//	handler := r.Proxy()
+//	fwd, err := sess.ListenAndHandleHTTP(ctx, config.HTTPEndpoint(), &handler)
+//	...
+//	for _, req := range r.Requests() {
+//		resp, err := r.Replay(ctx, req.ID)
+//	}
+//
+// Or wrap an application's own handler with [Replayer.Capture].
+package ngrokreplay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBodySize = 1 << 20
+	defaultMaxRequests = 100
+)
+
+// ErrNotFound is returned when replaying a request that was never captured,
+// or that has since been discarded to make room for newer ones.
+var ErrNotFound = errors.New("request not found")
+
+// ErrTruncated is returned when replaying a request whose body was larger
+// than the capture limit, since it can't be sent again as it was received.
+var ErrTruncated = errors.New("request body was truncated when captured")
+
+// Option configures a [Replayer].
+type Option func(*Replayer)
+
+// WithMaxBodySize limits how much of each request body is captured. Requests
+// with larger bodies are still captured, but can't be replayed. It defaults
+// to 1MiB, which is also used in place of a negative limit.
+func WithMaxBodySize(n int64) Option {
+	return func(r *Replayer) {
+		r.maxBodySize = n
+	}
+}
+
+// WithMaxRequests limits how many requests are kept, discarding the oldest
+// to make room for new ones. It defaults to 100.
+func WithMaxRequests(n int) Option {
+	return func(r *Replayer) {
+		r.maxRequests = n
+	}
+}
+
+// WithHTTPClient sets the client that replayed requests are sent with. It
+// defaults to [http.DefaultClient].
+func WithHTTPClient(client *http.Client) Option {
+	return func(r *Replayer) {
+		r.client = client
+	}
+}
+
+// Request is a captured HTTP request.
+type Request struct {
+	// ID identifies the request for [Replayer.Replay].
+	ID string
+	// When the request was received.
+	Received time.Time
+	Method   string
+	// The path and query of the request.
+	URL    *url.URL
+	Host   string
+	Header http.Header
+	// The captured body, up to the size limit.
+	Body []byte
+	// Whether the body was larger than the size limit.
+	Truncated bool
+}
+
+// Replayer captures requests and replays them to an upstream service. It's
+// safe for concurrent use.
+type Replayer struct {
+	upstream    *url.URL
+	client      *http.Client
+	maxBodySize int64
+	maxRequests int
+
+	mu       sync.Mutex
+	nextID   uint64
+	requests []*Request
+}
+
+// New creates a Replayer that replays requests to the upstream service.
+func New(upstream *url.URL, opts ...Option) *Replayer {
+	r := &Replayer{
+		upstream:    upstream,
+		client:      http.DefaultClient,
+		maxBodySize: defaultMaxBodySize,
+		maxRequests: defaultMaxRequests,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.maxRequests < 1 {
+		r.maxRequests = defaultMaxRequests
+	}
+	if r.maxBodySize < 0 {
+		r.maxBodySize = defaultMaxBodySize
+	}
+	// Leave room to read one byte past the limit, to detect truncation.
+	r.maxBodySize = min(r.maxBodySize, math.MaxInt64-1)
+	return r
+}
+
+// Capture wraps a handler so that the requests it serves are captured. The
+// handler receives the whole body, however much of it is captured.
+func (r *Replayer) Capture(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		r.capture(req)
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Proxy returns a handler that captures requests and forwards them to the
+// upstream service.
+func (r *Replayer) Proxy() http.Handler {
+	return r.Capture(httputil.NewSingleHostReverseProxy(r.upstream))
+}
+
+// Requests returns the captured requests, oldest first.
+func (r *Replayer) Requests() []*Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*Request(nil), r.requests...)
+}
+
+// Get returns a captured request by its ID.
+func (r *Replayer) Get(id string) (*Request, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, req := range r.requests {
+		if req.ID == id {
+			return req, true
+		}
+	}
+	return nil, false
+}
+
+// Replay sends a captured request to the upstream service again, and returns
+// its response. The caller must close the response body.
+func (r *Replayer) Replay(ctx context.Context, id string) (*http.Response, error) {
+	captured, ok := r.Get(id)
+	if !ok {
+		return nil, fmt.Errorf("replay %s: %w", id, ErrNotFound)
+	}
+	if captured.Truncated {
+		return nil, fmt.Errorf("replay %s: %w", id, ErrTruncated)
+	}
+
+	target := *r.upstream
+	target.Path = singleJoiningSlash(r.upstream.Path, captured.URL.Path)
+	target.RawQuery = captured.URL.RawQuery
+	req, err := http.NewRequestWithContext(ctx, captured.Method, target.String(), bytes.NewReader(captured.Body))
+	if err != nil {
+		return nil, fmt.Errorf("replay %s: %w", id, err)
+	}
+	req.Header = captured.Header.Clone()
+	req.Host = captured.Host
+	return r.client.Do(req)
+}
+
+// Record a request, leaving its body readable in full.
+func (r *Replayer) capture(req *http.Request) {
+	captured := &Request{
+		Received: time.Now(),
+		Method:   req.Method,
+		URL:      &url.URL{Path: req.URL.Path, RawPath: req.URL.RawPath, RawQuery: req.URL.RawQuery},
+		Host:     req.Host,
+		Header:   req.Header.Clone(),
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(req.Body, r.maxBodySize+1))
+		captured.Body = body
+		if err != nil || int64(len(body)) > r.maxBodySize {
+			captured.Body = body[:min(int64(len(body)), r.maxBodySize)]
+			captured.Truncated = true
+		}
+		req.Body = readCloser{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nextID++
+	captured.ID = strconv.FormatUint(r.nextID, 10)
+	if len(r.requests) >= r.maxRequests {
+		r.requests[0] = nil
+		r.requests = r.requests[1:]
+	}
+	r.requests = append(r.requests, captured)
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func singleJoiningSlash(a, b string) string {
+	switch {
+	case a == "" || a == "/":
+		return b
+	case b == "":
+		return a
+	}
+	aslash := a[len(a)-1] == '/'
+	bslash := b[0] == '/'
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,117 @@
+package ngrokreplay
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// An upstream that records the requests it receives.
+type upstream struct {
+	mu     sync.Mutex
+	bodies []string
+	paths  []string
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.bodies = append(u.bodies, string(body))
+	u.paths = append(u.paths, r.URL.RequestURI())
+	_, _ = io.WriteString(w, "ok")
+}
+
+func newUpstream(t *testing.T) (*upstream, *url.URL) {
+	u := &upstream{}
+	srv := httptest.NewServer(u)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	return u, target
+}
+
+func TestReplay(t *testing.T) {
+	up, target := newUpstream(t)
+	r := New(target)
+	front := httptest.NewServer(r.Proxy())
+	defer front.Close()
+
+	resp, err := http.Post(front.URL+"/hook?attempt=1", "application/json", strings.NewReader(`{"event":"push"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	reqs := r.Requests()
+	require.Len(t, reqs, 1)
+	require.Equal(t, http.MethodPost, reqs[0].Method)
+	require.Equal(t, "application/json", reqs[0].Header.Get("Content-Type"))
+	require.False(t, reqs[0].Truncated)
+
+	resp, err = r.Replay(context.Background(), reqs[0].ID)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	up.mu.Lock()
+	defer up.mu.Unlock()
+	require.Equal(t, []string{`{"event":"push"}`, `{"event":"push"}`}, up.bodies)
+	require.Equal(t, []string{"/hook?attempt=1", "/hook?attempt=1"}, up.paths)
+	// Replays aren't captured again.
+	require.Len(t, r.Requests(), 1)
+}
+
+func TestReplayLimits(t *testing.T) {
+	up, target := newUpstream(t)
+	r := New(target, WithMaxBodySize(4), WithMaxRequests(2))
+	front := httptest.NewServer(r.Proxy())
+	defer front.Close()
+
+	for _, body := range []string{"one", "two", "three!"} {
+		resp, err := http.Post(front.URL, "text/plain", strings.NewReader(body))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// The upstream receives whole bodies, whatever is captured.
+	up.mu.Lock()
+	require.Equal(t, []string{"one", "two", "three!"}, up.bodies)
+	up.mu.Unlock()
+
+	reqs := r.Requests()
+	require.Len(t, reqs, 2)
+	require.Equal(t, "two", string(reqs[0].Body))
+	require.True(t, reqs[1].Truncated)
+	require.Equal(t, "thre", string(reqs[1].Body))
+
+	_, err := r.Replay(context.Background(), reqs[1].ID)
+	require.ErrorIs(t, err, ErrTruncated)
+	_, err = r.Replay(context.Background(), "1")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestReplayBodySizeBounds(t *testing.T) {
+	_, target := newUpstream(t)
+	for _, size := range []int64{-1, math.MaxInt64} {
+		r := New(target, WithMaxBodySize(size))
+		front := httptest.NewServer(r.Capture(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			_, _ = io.Copy(io.Discard, req.Body)
+		})))
+		resp, err := http.Post(front.URL, "text/plain", strings.NewReader("body"))
+		require.NoError(t, err)
+		resp.Body.Close()
+		front.Close()
+
+		reqs := r.Requests()
+		require.Len(t, reqs, 1)
+		require.False(t, reqs[0].Truncated, "%d", size)
+		require.Equal(t, "body", string(reqs[0].Body))
+	}
+}
@@ -0,0 +1,111 @@
+package ngroktest
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/log"
+)
+
+// How long SetupSession and SetupListener wait for the ngrok service.
+const setupTimeout = 30 * time.Second
+
+// SkipUnlessOnline skips the test unless the NGROK_TEST_ONLINE or
+// NGROK_TEST_ALL environment variable is set, so that integration tests
+// against the ngrok service only run when asked to. Online tests authenticate
+// with the authtoken in NGROK_AUTHTOKEN, and fail if it's missing.
+func SkipUnlessOnline(t testing.TB) {
+	t.Helper()
+	if os.Getenv("NGROK_TEST_ONLINE") == "" && os.Getenv("NGROK_TEST_ALL") == "" {
+		t.Skip("Skipping online test; set NGROK_TEST_ONLINE to run it")
+	}
+	if os.Getenv("NGROK_AUTHTOKEN") == "" {
+		t.Fatal("Online tests require an authtoken in NGROK_AUTHTOKEN")
+	}
+}
+
+// SetupSession connects a session to the ngrok service for an online test,
+// skipping the test as [SkipUnlessOnline] does. The session authenticates
+// with NGROK_AUTHTOKEN, logs to the test's log, and is closed when the test
+// finishes.
+func SetupSession(t testing.TB, opts ...ngrok.ConnectOption) ngrok.Session {
+	t.Helper()
+	SkipUnlessOnline(t)
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	opts = append([]ngrok.ConnectOption{ngrok.WithAuthtokenFromEnv(), ngrok.WithLogger(testLogger{t})}, opts...)
+	sess, err := ngrok.Connect(ctx, opts...)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = sess.Close() })
+	return sess
+}
+
+// SetupListener starts a tunnel on the session, which is closed when the
+// test finishes, so that endpoints don't outlive the tests that start them.
+func SetupListener(t testing.TB, sess ngrok.Session, cfg config.Tunnel) ngrok.Tunnel {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), setupTimeout)
+	defer cancel()
+	tun, err := sess.Listen(ctx, cfg)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = tun.Close() })
+	return tun
+}
+
+// SyncPoint lets a test wait for code running in another goroutine, such as
+// a handler serving a tunnel's connections, to reach a point. The zero value
+// is ready to use.
+type SyncPoint struct {
+	once sync.Once
+	mu   sync.Mutex
+	ch   chan struct{}
+}
+
+func (s *SyncPoint) done() chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ch == nil {
+		s.ch = make(chan struct{})
+	}
+	return s.ch
+}
+
+// Reached marks the point as reached, releasing any goroutines waiting for
+// it. Calls after the first do nothing.
+func (s *SyncPoint) Reached() {
+	s.once.Do(func() { close(s.done()) })
+}
+
+// Wait waits for the point to be reached, failing the test if it isn't
+// within the timeout.
+func (s *SyncPoint) Wait(t testing.TB, timeout time.Duration) {
+	t.Helper()
+	select {
+	case <-s.done():
+	case <-time.After(timeout):
+		t.Fatalf("sync point not reached within %v", timeout)
+	}
+}
+
+// Logs the session's messages to the test's log.
+type testLogger struct {
+	t testing.TB
+}
+
+func (tl testLogger) Log(_ context.Context, level log.LogLevel, msg string, data map[string]interface{}) {
+	lvl, err := log.StringFromLogLevel(level)
+	if err != nil {
+		lvl = "UKWN"
+	}
+	tl.t.Logf("%s [%s] %s %v", time.Now().Format(time.RFC3339), strings.ToUpper(lvl), msg, data)
+}
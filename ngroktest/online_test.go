@@ -0,0 +1,55 @@
+package ngroktest
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestSkipUnlessOnline(t *testing.T) {
+	var skipped bool
+	t.Run("offline", func(t *testing.T) {
+		t.Setenv("NGROK_TEST_ONLINE", "")
+		t.Setenv("NGROK_TEST_ALL", "")
+		defer func() { skipped = t.Skipped() }()
+		SetupSession(t)
+		t.Error("session set up offline")
+	})
+	require.True(t, skipped)
+}
+
+func TestSyncPoint(t *testing.T) {
+	var sp SyncPoint
+	go func() {
+		sp.Reached()
+		sp.Reached()
+	}()
+	sp.Wait(t, time.Second)
+	sp.Wait(t, time.Second)
+}
+
+func TestSetupListener(t *testing.T) {
+	sess := SetupSession(t)
+	tun := SetupListener(t, sess, config.HTTPEndpoint())
+
+	var served SyncPoint
+	go func() {
+		_ = http.Serve(tun, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			served.Reached()
+			_, _ = io.WriteString(w, "ok")
+		}))
+	}()
+
+	resp, err := http.Get(tun.URL())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(body))
+	served.Wait(t, time.Second)
+}
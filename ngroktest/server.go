@@ -0,0 +1,539 @@
+// Package ngroktest provides an in-process fake of the ngrok service, so that
+// applications can test their use of sessions and tunnels offline.
+//
+// Sessions connect to the fake over in-memory connections when they are
+// started with the options returned by [Server.ConnectOptions]:
+//
+//	srv := ngroktest.NewServer()
+//	defer srv.Close()
+//	sess, err := ngrok.Connect(ctx, srv.ConnectOptions()...)
+//
+// The fake accepts any authtoken and binds any endpoint it's asked to. It
+// can also send the commands that the ngrok service sends to agents, such as
+// [Server.Stop], so that the handlers for them can be tested.
+//
+// Tests that need the real ngrok service can use [SetupSession] and
+// [SetupListener] instead, which skip the test unless NGROK_TEST_ONLINE is
+// set, and clean up the session and its endpoints when the test finishes.
+package ngroktest
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/muxado/v2"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+	"golang.ngrok.com/ngrok/rawsession"
+)
+
+// The address that sessions believe they're connecting to.
+const serverAddr = "connect.ngrok.test:443"
+
+// Server is a fake ngrok service.
+type Server struct {
+	tlsConfig *tls.Config
+	ca        *x509.CertPool
+
+	mu       sync.Mutex
+	closed   bool
+	sessions []*session
+	seq      int
+	account  Account
+	handlers map[rawsession.StreamType]func(net.Conn)
+}
+
+// Account describes the account the fake reports to sessions as they
+// connect. See [Server.SetAccount].
+type Account struct {
+	Name            string
+	Plan            string
+	Banner          string
+	SessionDuration time.Duration
+	// The deprecation of the connecting client's version, if any.
+	Deprecation *ngrok.AgentVersionDeprecated
+	// The number of sessions the account may run at once, and of endpoints
+	// each session may start, or zero for no limit. Sessions and endpoints
+	// beyond them are refused with the errors the ngrok service returns.
+	MaxSessions  int
+	MaxEndpoints int
+}
+
+// A session connected to the fake.
+type session struct {
+	srv *Server
+	mux *muxado.Heartbeat
+
+	mu      sync.Mutex
+	authed  bool
+	auth    proto.AuthExtra
+	tunnels map[string]struct{}
+}
+
+// NewServer starts a fake ngrok service. It panics if it can't generate a
+// certificate to serve sessions with.
+func NewServer() *Server {
+	cert, ca, err := selfSignedCert(serverAddr)
+	if err != nil {
+		panic(fmt.Sprintf("ngroktest: failed to generate certificate: %v", err))
+	}
+	return &Server{
+		tlsConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		ca:        ca,
+	}
+}
+
+// ConnectOptions returns the options that connect a session to the fake
+// instead of the ngrok service. Add them to the application's options when
+// calling [ngrok.Connect].
+func (s *Server) ConnectOptions() []ngrok.ConnectOption {
+	return []ngrok.ConnectOption{
+		ngrok.WithServer(serverAddr),
+		ngrok.WithCA(s.ca),
+		ngrok.WithDialer(dialer{s}),
+		ngrok.WithAuthtoken("ngroktest"),
+	}
+}
+
+// RequireClientCertificates makes the fake require sessions that connect
+// afterwards to present a client certificate issued by one of the CAs in
+// pool, as connect endpoints that authenticate agents with mutual TLS do.
+// See [ngrok.WithAgentClientCertificate].
+func (s *Server) RequireClientCertificates(pool *x509.CertPool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tlsConfig := s.tlsConfig.Clone()
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	s.tlsConfig = tlsConfig
+}
+
+// Close disconnects all sessions. Sessions that try to reconnect afterwards
+// fail to.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	sessions := s.sessions
+	s.sessions = nil
+	s.mu.Unlock()
+
+	for _, sess := range sessions {
+		_ = sess.mux.Close()
+	}
+	return nil
+}
+
+// SetAccount sets the account reported to sessions as they connect from now
+// on, so that applications can test how they handle deprecation warnings and
+// plan details.
+func (s *Server) SetAccount(acct Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.account = acct
+}
+
+// HandleStream sets the handler for the streams of a custom type that sessions
+// open with [rawsession.Open]. The stream is closed when the handler returns.
+// Streams of types without a handler are closed straight away.
+func (s *Server) HandleStream(typ rawsession.StreamType, handler func(conn net.Conn)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.handlers == nil {
+		s.handlers = make(map[rawsession.StreamType]func(net.Conn))
+	}
+	s.handlers[typ] = handler
+}
+
+// Stop asks the connected sessions to stop, as the ngrok dashboard and API
+// can. It returns once they have responded, with the errors returned by
+// their stop handlers or the error they report for having none.
+func (s *Server) Stop(ctx context.Context) error {
+	return s.command(ctx, proto.StopReq, &proto.Stop{}, func(extra proto.AuthExtra) *string {
+		return extra.StopUnsupportedError
+	})
+}
+
+// Restart asks the connected sessions to restart. See [Server.Stop].
+func (s *Server) Restart(ctx context.Context) error {
+	return s.command(ctx, proto.RestartReq, &proto.Restart{}, func(extra proto.AuthExtra) *string {
+		return extra.RestartUnsupportedError
+	})
+}
+
+// Update asks the connected sessions to update to the given version, or the
+// latest if it's empty. See [Server.Stop].
+func (s *Server) Update(ctx context.Context, version string, permitMajorVersion bool) error {
+	req := &proto.Update{Version: version, PermitMajorVersion: permitMajorVersion}
+	return s.command(ctx, proto.UpdateReq, req, func(extra proto.AuthExtra) *string {
+		return extra.UpdateUnsupportedError
+	})
+}
+
+// StopTunnel closes the tunnel with the given ID, as the ngrok service does
+// when, for example, its endpoint is removed. The tunnel's Accept method
+// returns an [ngrok.Error] with the given message and error code.
+func (s *Server) StopTunnel(ctx context.Context, tunnelID, message, errorCode string) error {
+	sess := s.tunnelSession(tunnelID)
+	if sess == nil {
+		return fmt.Errorf("no tunnel with ID %q", tunnelID)
+	}
+	sess.removeTunnel(tunnelID)
+	// Agents don't respond to this command.
+	req := &proto.StopTunnel{ClientID: tunnelID, Message: message, ErrorCode: errorCode}
+	return sess.send(ctx, proto.StopTunnelReq, req, nil)
+}
+
+// Send a command to all sessions, skipping the ones that reported that they
+// don't support it.
+func (s *Server) command(ctx context.Context, reqType proto.ReqType, req any, unsupported func(proto.AuthExtra) *string) error {
+	s.mu.Lock()
+	sessions := append([]*session(nil), s.sessions...)
+	s.mu.Unlock()
+
+	var errs []error
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		msg := unsupported(sess.auth)
+		sess.mu.Unlock()
+		if msg != nil && *msg != "" {
+			errs = append(errs, errors.New(*msg))
+			continue
+		}
+		// All of the command responses have the same shape.
+		var resp proto.StopResp
+		if err := sess.send(ctx, reqType, req, &resp); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if resp.Error != "" {
+			errs = append(errs, errors.New(resp.Error))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Server) tunnelSession(id string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sess := range s.sessions {
+		sess.mu.Lock()
+		_, ok := sess.tunnels[id]
+		sess.mu.Unlock()
+		if ok {
+			return sess
+		}
+	}
+	return nil
+}
+
+func (s *Server) nextID(prefix string) string {
+	return fmt.Sprintf("%s_%d", prefix, s.next())
+}
+
+func (s *Server) next() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	return s.seq
+}
+
+// Connects sessions to the fake over in-memory connections.
+type dialer struct {
+	srv *Server
+}
+
+func (d dialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.srv.mu.Lock()
+	closed := d.srv.closed
+	d.srv.mu.Unlock()
+	if closed {
+		return nil, errors.New("ngroktest: server closed")
+	}
+
+	client, server := net.Pipe()
+	go d.srv.serve(server)
+	return client, nil
+}
+
+// Serve a session's connection until it closes.
+func (s *Server) serve(conn net.Conn) {
+	s.mu.Lock()
+	tlsConfig := s.tlsConfig
+	s.mu.Unlock()
+	mux := muxado.Server(tls.Server(conn, tlsConfig), nil)
+	// The heartbeat responds to the session's heartbeats as it accepts
+	// streams, but doesn't send any of its own until started.
+	heart := muxado.NewHeartbeat(muxado.NewTypedStreamSession(mux), func(time.Duration, bool) {}, muxado.NewHeartbeatConfig())
+	sess := &session{srv: s, mux: heart, tunnels: make(map[string]struct{})}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		_ = heart.Close()
+		return
+	}
+	s.sessions = append(s.sessions, sess)
+	s.mu.Unlock()
+
+	defer s.removeSession(sess)
+	for {
+		stream, err := heart.AcceptTypedStream()
+		if err != nil {
+			return
+		}
+		go sess.handle(stream)
+	}
+}
+
+func (s *Server) removeSession(sess *session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, other := range s.sessions {
+		if other == sess {
+			s.sessions = append(s.sessions[:i], s.sessions[i+1:]...)
+			return
+		}
+	}
+}
+
+// Respond to a request from the session.
+func (sess *session) handle(stream muxado.TypedStream) {
+	defer stream.Close()
+	dec := json.NewDecoder(stream)
+	enc := json.NewEncoder(stream)
+
+	switch proto.ReqType(stream.StreamType()) {
+	case proto.AuthReq:
+		var req proto.Auth
+		if dec.Decode(&req) != nil {
+			return
+		}
+		sess.srv.mu.Lock()
+		acct := sess.srv.account
+		sess.srv.mu.Unlock()
+		if acct.MaxSessions > 0 && sess.srv.authedSessions() >= acct.MaxSessions {
+			_ = enc.Encode(&proto.AuthResp{
+				Error: fmt.Sprintf("Your account is limited to %d simultaneous ngrok agent sessions.\n\nERR_NGROK_108", acct.MaxSessions),
+			})
+			return
+		}
+		sess.mu.Lock()
+		sess.authed = true
+		sess.auth = req.Extra
+		sess.mu.Unlock()
+		id := req.ClientID
+		if id == "" {
+			id = sess.srv.nextID("sess")
+		}
+		version := proto.Version[0]
+		if len(req.Version) > 0 {
+			version = req.Version[0]
+		}
+		_ = enc.Encode(&proto.AuthResp{
+			Version:  version,
+			ClientID: id,
+			Extra: proto.AuthRespExtra{
+				Version:            "ngroktest",
+				Region:             "test",
+				Cookie:             id,
+				AccountName:        acct.Name,
+				PlanName:           acct.Plan,
+				Banner:             acct.Banner,
+				SessionDuration:    int64(acct.SessionDuration / time.Second),
+				DeprecationWarning: (*proto.AgentVersionDeprecated)(acct.Deprecation),
+			},
+		})
+	case proto.BindReq:
+		var req proto.Bind
+		if dec.Decode(&req) != nil {
+			return
+		}
+		sess.srv.mu.Lock()
+		maxEndpoints := sess.srv.account.MaxEndpoints
+		sess.srv.mu.Unlock()
+		if maxEndpoints > 0 && sess.tunnelCount() >= maxEndpoints {
+			_ = enc.Encode(&proto.BindResp{
+				Error: fmt.Sprintf("Your account may not run more than %d endpoints over a single ngrok agent session.\n\nERR_NGROK_324", maxEndpoints),
+			})
+			return
+		}
+		id := req.ClientID
+		if id == "" {
+			id = sess.srv.nextID("tun")
+		}
+		sess.addTunnel(id)
+		_ = enc.Encode(&proto.BindResp{
+			ClientID: id,
+			URL:      sess.srv.bindURL(req.Proto, req.Opts),
+			Proto:    req.Proto,
+			Opts:     req.Opts,
+			Extra:    proto.BindRespExtra{Token: id},
+		})
+	case proto.StartTunnelWithLabelReq:
+		var req proto.StartTunnelWithLabel
+		if dec.Decode(&req) != nil {
+			return
+		}
+		id := sess.srv.nextID("tun")
+		sess.addTunnel(id)
+		_ = enc.Encode(&proto.StartTunnelWithLabelResp{ID: id})
+	case proto.UnbindReq:
+		var req proto.Unbind
+		if dec.Decode(&req) != nil {
+			return
+		}
+		sess.removeTunnel(req.ClientID)
+		_ = enc.Encode(&proto.UnbindResp{})
+	case proto.SrvInfoReq:
+		_ = enc.Encode(&proto.SrvInfoResp{Region: "test"})
+	default:
+		sess.srv.mu.Lock()
+		handler := sess.srv.handlers[rawsession.StreamType(stream.StreamType())]
+		sess.srv.mu.Unlock()
+		if handler != nil {
+			handler(stream)
+		}
+	}
+}
+
+// Pick the URL for a bind: the one requested, if any, or a made up one.
+func (s *Server) bindURL(protocol string, opts any) string {
+	var requested struct {
+		URL    string
+		Domain string
+		Addr   string
+	}
+	if buf, err := json.Marshal(opts); err == nil {
+		_ = json.Unmarshal(buf, &requested)
+	}
+	if requested.URL != "" {
+		return requested.URL
+	}
+
+	scheme := protocol
+	if scheme == "http" {
+		scheme = "https"
+	}
+	host := requested.Domain
+	switch {
+	case requested.Addr != "":
+		host = requested.Addr
+	case host != "":
+	case protocol == "tcp":
+		host = fmt.Sprintf("0.tcp.ngrok.test:%d", 10000+s.next())
+	default:
+		host = fmt.Sprintf("%d.ngrok.test", s.next())
+	}
+	return (&url.URL{Scheme: scheme, Host: host}).String()
+}
+
+func (sess *session) addTunnel(id string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.tunnels[id] = struct{}{}
+}
+
+func (sess *session) tunnelCount() int {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return len(sess.tunnels)
+}
+
+// The number of connected sessions that have authenticated.
+func (s *Server) authedSessions() int {
+	s.mu.Lock()
+	sessions := append([]*session(nil), s.sessions...)
+	s.mu.Unlock()
+	n := 0
+	for _, sess := range sessions {
+		sess.mu.Lock()
+		if sess.authed {
+			n++
+		}
+		sess.mu.Unlock()
+	}
+	return n
+}
+
+func (sess *session) removeTunnel(id string) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	delete(sess.tunnels, id)
+}
+
+// Send a request to the session, and wait for its response if resp isn't nil.
+func (sess *session) send(ctx context.Context, reqType proto.ReqType, req, resp any) error {
+	stream, err := sess.mux.OpenTypedStream(muxado.StreamType(reqType))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := json.NewEncoder(stream).Encode(req); err != nil {
+		return err
+	}
+	if resp == nil {
+		return nil
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- json.NewDecoder(stream).Decode(resp) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Generate a certificate for the host of addr, and a pool that trusts it.
+func selfSignedCert(addr string) (tls.Certificate, *x509.CertPool, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: host},
+		DNSNames:              []string{host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}, pool, nil
+}
@@ -0,0 +1,281 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.ngrok.com/muxado/v2"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+var errOfflineUnsupported = errors.New("not supported while listening locally")
+
+// WithOfflineFallback makes [Connect] return a [Session] that listens on the
+// local address rather than failing when no authtoken is configured, or when
+// the ngrok service can't be reached. This lets the same code run on a
+// development machine without ngrok connectivity. Errors from the ngrok
+// service refusing the session, such as for an invalid authtoken, are still
+// returned.
+//
+// Each Listen on the session binds a plain TCP listener on localAddr, and
+// each Tunnel's URL reports the local address, e.g. "http://127.0.0.1:8080".
+// ListenAndForward forwards connections from the local listener to the
+// upstream service as it would from ngrok. Use a port of 0 to listen on a
+// free port for each tunnel. The reason the session is offline is reported by
+// [Session.Warnings]. The session doesn't try to connect to ngrok again.
+//
+// Connect retries failures to reach ngrok until its context is done, so bound
+// it with [WithConnectTimeout] or [WithMaxConnectAttempts] to fall back.
+func WithOfflineFallback(localAddr string) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.OfflineFallback = localAddr
+	}
+}
+
+// Whether Connect failed because the ngrok service couldn't be reached, as
+// opposed to refusing the session, e.g. for an invalid authtoken. Only the
+// former falls back to listening locally, so that a mistyped or revoked
+// authtoken isn't mistaken for being offline.
+func unreachable(err error) bool {
+	var authErr errAuthFailed
+	if errors.As(err, &authErr) && authErr.Remote {
+		return false
+	}
+	var ngrokErr Error
+	if errors.As(err, &ngrokErr) && ngrokErr.ErrorCode() != "" {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, errSessionDial{}) || errors.Is(err, errConnectTimeout{}) ||
+		errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// Start a session that listens locally, since it couldn't connect to ngrok.
+func connectOffline(cfg connectConfig, reason error) Session {
+	logger := cfg.logger()
+	logger.Warn("ngrok is unavailable, listening locally instead", "addr", cfg.OfflineFallback, "err", reason)
+
+	session := newSession(&cfg)
+	session.setInner(&sessionInner{
+		Session: &offlineSession{
+			addr:    cfg.OfflineFallback,
+			reason:  reason,
+			tunnels: make(map[string]*offlineTunnel),
+		},
+		Logger: logger,
+	})
+	session.markOpen()
+	if cfg.LeakDetection {
+		return watchSession(session, logger)
+	}
+	return session
+}
+
+// Report whether the session is listening locally, rather than connected to
+// ngrok.
+func (s *sessionImpl) isOffline() bool {
+	inner := s.inner()
+	if inner == nil {
+		return false
+	}
+	_, ok := inner.Session.(*offlineSession)
+	return ok
+}
+
+// A tunnel_client.Session whose tunnels are local listeners.
+type offlineSession struct {
+	addr   string
+	reason error
+
+	mu      sync.Mutex
+	closed  bool
+	nextID  atomic.Uint64
+	tunnels map[string]*offlineTunnel
+}
+
+func (s *offlineSession) Auth(proto.AuthExtra) (proto.AuthResp, error) {
+	return proto.AuthResp{}, errOfflineUnsupported
+}
+
+func (s *offlineSession) listen(protocol string, opts any, metadata string, labels map[string]string, forwardsTo, forwardsProto string) (tunnel_client.Tunnel, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrSessionClosing
+	}
+
+	l, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	scheme := "tcp"
+	switch protocol {
+	case "http", "https":
+		scheme = "http"
+	case "tls":
+		scheme = "tls"
+	}
+	t := &offlineTunnel{
+		Listener: l,
+		sess:     s,
+		id:       fmt.Sprintf("offline_%d", s.nextID.Add(1)),
+		proto:    protocol,
+		bind: &tunnel_client.RemoteBindConfig{
+			URL:         scheme + "://" + l.Addr().String(),
+			ConfigProto: protocol,
+			Opts:        opts,
+			Metadata:    metadata,
+			Labels:      labels,
+		},
+		forwardsTo:    forwardsTo,
+		forwardsProto: forwardsProto,
+	}
+	if tlsOpts, ok := opts.(*proto.TLSEndpoint); ok {
+		t.passthrough = tlsOpts.TLSTermination == nil
+	}
+	s.tunnels[t.id] = t
+	return t, nil
+}
+
+func (s *offlineSession) Listen(protocol string, opts any, extra proto.BindExtra, id string, forwardsTo string, forwardsProto string) (tunnel_client.Tunnel, error) {
+	return s.listen(protocol, opts, extra.Metadata, nil, forwardsTo, forwardsProto)
+}
+
+func (s *offlineSession) ListenLabel(labels map[string]string, metadata string, forwardsTo string, forwardsProto string) (tunnel_client.Tunnel, error) {
+	return s.listen("", nil, metadata, labels, forwardsTo, forwardsProto)
+}
+
+func (s *offlineSession) ListenHTTP(opts *proto.HTTPEndpoint, extra proto.BindExtra, forwardsTo string, forwardsProto string) (tunnel_client.Tunnel, error) {
+	return s.Listen("http", opts, extra, "", forwardsTo, forwardsProto)
+}
+
+func (s *offlineSession) ListenHTTPS(opts *proto.HTTPEndpoint, extra proto.BindExtra, forwardsTo string, forwardsProto string) (tunnel_client.Tunnel, error) {
+	return s.Listen("https", opts, extra, "", forwardsTo, forwardsProto)
+}
+
+func (s *offlineSession) ListenTCP(opts *proto.TCPEndpoint, extra proto.BindExtra, forwardsTo string) (tunnel_client.Tunnel, error) {
+	return s.Listen("tcp", opts, extra, "", forwardsTo, "")
+}
+
+func (s *offlineSession) ListenTLS(opts *proto.TLSEndpoint, extra proto.BindExtra, forwardsTo string) (tunnel_client.Tunnel, error) {
+	return s.Listen("tls", opts, extra, "", forwardsTo, "")
+}
+
+func (s *offlineSession) SrvInfo() (proto.SrvInfoResp, error) {
+	return proto.SrvInfoResp{}, errOfflineUnsupported
+}
+
+func (s *offlineSession) OpenStream(muxado.StreamType) (net.Conn, error) {
+	return nil, errOfflineUnsupported
+}
+
+func (s *offlineSession) Heartbeat() (time.Duration, error) {
+	return 0, errOfflineUnsupported
+}
+
+// Latency never reports, since there's no connection to measure.
+func (s *offlineSession) Latency() <-chan time.Duration {
+	return nil
+}
+
+func (s *offlineSession) CloseTunnel(clientID string, err error) error {
+	s.mu.Lock()
+	t, ok := s.tunnels[clientID]
+	s.mu.Unlock()
+	if !ok {
+		return proto.StringError("no listener found for client id " + clientID)
+	}
+	return t.Close()
+}
+
+func (s *offlineSession) Reconnect() error {
+	return errOfflineUnsupported
+}
+
+func (s *offlineSession) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	tunnels := s.tunnels
+	s.tunnels = map[string]*offlineTunnel{}
+	s.mu.Unlock()
+	for _, t := range tunnels {
+		_ = t.Listener.Close()
+	}
+	return nil
+}
+
+func (s *offlineSession) CloseWithContext(context.Context) error {
+	return s.Close()
+}
+
+// A tunnel_client.Tunnel that accepts connections from a local listener.
+type offlineTunnel struct {
+	net.Listener
+	sess *offlineSession
+
+	id            string
+	proto         string
+	passthrough   bool
+	bind          *tunnel_client.RemoteBindConfig
+	forwardsTo    string
+	forwardsProto string
+}
+
+func (t *offlineTunnel) Accept() (*tunnel_client.ProxyConn, error) {
+	conn, err := t.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &tunnel_client.ProxyConn{
+		Header: proto.ProxyHeader{
+			ID:             t.id,
+			ClientAddr:     conn.RemoteAddr().String(),
+			Proto:          t.proto,
+			PassthroughTLS: t.passthrough,
+		},
+		Conn:     conn,
+		Received: time.Now(),
+	}, nil
+}
+
+func (t *offlineTunnel) Addr() net.Addr {
+	return t.bind
+}
+
+func (t *offlineTunnel) Close() error {
+	t.sess.mu.Lock()
+	delete(t.sess.tunnels, t.id)
+	t.sess.mu.Unlock()
+	return t.Listener.Close()
+}
+
+func (t *offlineTunnel) RemoteBindConfig() *tunnel_client.RemoteBindConfig {
+	return t.bind
+}
+
+func (t *offlineTunnel) ID() string {
+	return t.id
+}
+
+func (t *offlineTunnel) ForwardsTo() string {
+	return t.forwardsTo
+}
+
+func (t *offlineTunnel) ForwardsProto() string {
+	return t.forwardsProto
+}
+
+func (t *offlineTunnel) Pause() error {
+	return errOfflineUnsupported
+}
+
+func (t *offlineTunnel) Resume() error {
+	return errOfflineUnsupported
+}
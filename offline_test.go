@@ -0,0 +1,108 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestOfflineFallbackForward(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "hello from upstream")
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	warnings := sess.Warnings()
+	require.Len(t, warnings, 1)
+	require.ErrorIs(t, warnings[0], errOffline{})
+
+	fwd, err := sess.ListenAndForward(ctx, upstreamURL, config.HTTPEndpoint())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(fwd.URL(), "http://127.0.0.1:"), fwd.URL())
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(fwd.URL())
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, "hello from upstream", string(body))
+
+	require.NoError(t, fwd.Close())
+	_, err = client.Get(fwd.URL())
+	require.Error(t, err)
+}
+
+func TestOfflineFallbackUnreachable(t *testing.T) {
+	// A port that nothing listens on.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	serverAddr := closed.Addr().String()
+	closed.Close()
+
+	ctx := context.Background()
+	opts := []ConnectOption{
+		WithAuthtoken("token"),
+		WithServer(serverAddr),
+		WithMaxConnectAttempts(1),
+	}
+	_, err = Connect(ctx, opts...)
+	require.Error(t, err)
+
+	sess, err := Connect(ctx, append(opts, WithOfflineFallback("127.0.0.1:0"))...)
+	require.NoError(t, err)
+	defer sess.Close()
+	require.ErrorIs(t, sess.Warnings()[0], errConnectAttempts{})
+
+	tun, err := sess.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+	require.True(t, strings.HasPrefix(tun.URL(), "tcp://127.0.0.1:"), tun.URL())
+
+	addr := strings.TrimPrefix(tun.URL(), "tcp://")
+	go func() {
+		conn, err := net.Dial("tcp", addr)
+		if err == nil {
+			_, _ = conn.Write([]byte("ping"))
+			conn.Close()
+		}
+	}()
+	conn, err := tun.Accept()
+	require.NoError(t, err)
+	buf, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(buf))
+
+	// Closing the session closes its tunnels.
+	require.NoError(t, sess.Close())
+	_, err = tun.Accept()
+	require.True(t, errors.Is(err, net.ErrClosed), err)
+}
+
+func TestOfflineFallbackOnlyWhenUnreachable(t *testing.T) {
+	dialErr := errSessionDial{"connect.ngrok-agent.com:443", errors.New("connection refused")}
+	require.True(t, unreachable(errConnectAttempts{1, dialErr}))
+	require.True(t, unreachable(errConnectTimeout{time.Second, context.DeadlineExceeded}))
+
+	refused := errAuthFailed{true, ngrokError{"The authentication you specified is invalid.", "ERR_NGROK_107"}}
+	require.False(t, unreachable(errConnectAttempts{1, refused}))
+	require.False(t, unreachable(ngrokError{"Your account is limited to 1 simultaneous ngrok agent sessions.", "ERR_NGROK_108"}))
+	require.False(t, unreachable(errAuthFailed{true, errors.New("invalid authtoken")}))
+}
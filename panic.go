@@ -0,0 +1,34 @@
+package ngrok
+
+// PanicHandler is called with the value and stack trace of a panic recovered
+// from one of a [Session]'s goroutines.
+type PanicHandler func(recovered any, stack []byte)
+
+// WithPanicHandler configures a function to be called when one of the
+// goroutines run by the [Session] panics, such as to report the crash to an
+// error tracker.
+//
+// Panics in the goroutines that accept and forward connections, and in those
+// that maintain the connection to the ngrok service, are recovered whether or
+// not a handler is configured, and logged as errors. A panicked connection to
+// the ngrok service is closed and reconnected, a panicked forwarded connection
+// is closed, and a panicked [Forwarder] stops with an error matching
+// [ErrPanic]. The process carries on either way.
+func WithPanicHandler(handler PanicHandler) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.PanicHandler = handler
+	}
+}
+
+// Returns a function that logs recovered panics and passes them on to the
+// configured handler.
+func (cfg *connectConfig) panicReporter() func(recovered any, stack []byte) {
+	logger := cfg.logger()
+	handler := cfg.PanicHandler
+	return func(recovered any, stack []byte) {
+		logger.Error("recovered from panic", "panic", recovered, "stack", string(stack))
+		if handler != nil {
+			handler(recovered, stack)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestPanicHandler(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = io.WriteString(conn, "hello")
+			conn.Close()
+		}
+	}()
+
+	// The first connection panics while dialing the upstream.
+	var dials atomic.Int32
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if dials.Add(1) == 1 {
+			panic("boom")
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+
+	panics := make(chan any, 1)
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"), WithPanicHandler(func(recovered any, stack []byte) {
+		require.Contains(t, string(stack), "TestPanicHandler")
+		panics <- recovered
+	}))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	target := &url.URL{Scheme: "tcp", Host: upstream.Addr().String()}
+	fwd, err := sess.ListenAndForward(ctx, target, config.TCPEndpoint(config.WithUpstreamDialer(dialer)))
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	// The panicked connection is closed, and the forwarder carries on.
+	conn := dialTunnel(t, fwd)
+	require.Equal(t, "boom", <-panics)
+	greeting, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Empty(t, greeting)
+
+	greeting, err = io.ReadAll(dialTunnel(t, fwd))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(greeting))
+}
+
+func TestGuardPanic(t *testing.T) {
+	var reported any
+	c := &goroutineCount{onPanic: func(recovered any, _ []byte) { reported = recovered }}
+	err := c.guard(func() error { panic("boom") })()
+	require.ErrorIs(t, err, ErrPanic)
+	require.Equal(t, "boom", reported)
+}
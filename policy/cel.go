@@ -0,0 +1,510 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ExpressionError describes a syntax error in a CEL expression, at the
+// position it was found.
+type ExpressionError struct {
+	// The expression that failed to parse.
+	Expression string
+	// The position of the error. Line and Column count from 1, and Column
+	// counts characters rather than bytes.
+	Offset int
+	Line   int
+	Column int
+	// A description of the error, e.g. "expected ')'".
+	Message string
+}
+
+// Error formats the error like the ngrok service does, followed by the line
+// of the expression it's on and a marker under the position.
+func (e *ExpressionError) Error() string {
+	lines := strings.Split(e.Expression, "\n")
+	line := lines[e.Line-1]
+	return fmt.Sprintf("%d:%d: %s\n | %s\n | %s^", e.Line, e.Column, e.Message, line, strings.Repeat(".", e.Column-1))
+}
+
+// ValidateExpression checks the syntax of a CEL expression, as used in the
+// expressions of traffic policy rules. It doesn't check that the variables
+// and functions it uses exist, or that its types are consistent, which is
+// left to the ngrok service.
+func ValidateExpression(expr string) error {
+	p := &celParser{lex: celLexer{src: expr}}
+	p.next()
+	if p.tok.kind == tokEOF {
+		return p.errorf(p.tok, "expression is empty")
+	}
+	p.expr()
+	if p.err == nil && p.tok.kind != tokEOF {
+		p.errorf(p.tok, "unexpected %s", p.tok)
+	}
+	if p.err != nil {
+		return p.err
+	}
+	return nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokLiteral
+	tokOperator
+	tokError
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokIdent:
+		return fmt.Sprintf("identifier %q", t.text)
+	case tokLiteral:
+		return fmt.Sprintf("literal %s", t.text)
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// Words that CEL reserves, and which can't be used as identifiers.
+var celReserved = map[string]bool{
+	"as": true, "break": true, "const": true, "continue": true, "else": true,
+	"for": true, "function": true, "if": true, "import": true, "let": true,
+	"loop": true, "package": true, "namespace": true, "return": true,
+	"var": true, "void": true,
+}
+
+// The operators and punctuation of CEL, longest first.
+var celOperators = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "+", "-", "*", "/", "%", "!", "?", ":",
+	".", ",", "(", ")", "[", "]", "{", "}",
+}
+
+// Splits an expression into tokens. Malformed tokens are returned with the
+// tokError kind and a description of the problem as their text.
+type celLexer struct {
+	src string
+	pos int
+}
+
+func (l *celLexer) next() token {
+	l.skipSpace()
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}
+	}
+	rest := l.src[l.pos:]
+	c := rest[0]
+
+	switch {
+	case isStringStart(rest):
+		return l.string()
+	case c >= '0' && c <= '9', c == '.' && len(rest) > 1 && rest[1] >= '0' && rest[1] <= '9':
+		return l.number()
+	case c == '_' || isLetter(c):
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		word := l.src[start:l.pos]
+		switch word {
+		case "true", "false", "null":
+			return token{kind: tokLiteral, text: word, pos: start}
+		case "in":
+			return token{kind: tokOperator, text: word, pos: start}
+		}
+		if celReserved[word] {
+			return token{kind: tokError, text: fmt.Sprintf("reserved word %q can't be used as an identifier", word), pos: start}
+		}
+		return token{kind: tokIdent, text: word, pos: start}
+	}
+	for _, op := range celOperators {
+		if strings.HasPrefix(rest, op) {
+			l.pos += len(op)
+			return token{kind: tokOperator, text: op, pos: start}
+		}
+	}
+	r, size := utf8.DecodeRuneInString(rest)
+	l.pos += size
+	if c == '=' || c == '&' || c == '|' {
+		return token{kind: tokError, text: fmt.Sprintf("unexpected '%c', did you mean '%c%c'?", r, r, r), pos: start}
+	}
+	return token{kind: tokError, text: fmt.Sprintf("unexpected character %q", r), pos: start}
+}
+
+func (l *celLexer) skipSpace() {
+	for l.pos < len(l.src) {
+		switch {
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.ContainsRune(" \t\r\n\f", rune(l.src[l.pos])):
+			l.pos++
+		default:
+			return
+		}
+	}
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+func isDigit(c byte) bool  { return c >= '0' && c <= '9' }
+func isHex(c byte) bool    { return isDigit(c) || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F' }
+
+// Whether s starts with a string or bytes literal, including its raw and
+// bytes prefixes.
+func isStringStart(s string) bool {
+	for i := 0; i < len(s) && i < 3; i++ {
+		switch s[i] {
+		case '"', '\'':
+			return true
+		case 'r', 'R', 'b', 'B':
+			continue
+		}
+		return false
+	}
+	return false
+}
+
+func (l *celLexer) string() token {
+	start := l.pos
+	raw := false
+	for l.src[l.pos] != '"' && l.src[l.pos] != '\'' {
+		if l.src[l.pos] == 'r' || l.src[l.pos] == 'R' {
+			raw = true
+		}
+		l.pos++
+	}
+	quote := l.src[l.pos : l.pos+1]
+	if strings.HasPrefix(l.src[l.pos:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	l.pos += len(quote)
+	for {
+		if l.pos >= len(l.src) {
+			return token{kind: tokError, text: "unterminated string", pos: start}
+		}
+		if strings.HasPrefix(l.src[l.pos:], quote) {
+			l.pos += len(quote)
+			return token{kind: tokLiteral, text: l.src[start:l.pos], pos: start}
+		}
+		switch c := l.src[l.pos]; {
+		case c == '\n' && len(quote) == 1:
+			return token{kind: tokError, text: "unterminated string", pos: start}
+		case c == '\\' && !raw:
+			escPos := l.pos
+			if msg := l.escape(); msg != "" {
+				return token{kind: tokError, text: msg, pos: escPos}
+			}
+		default:
+			l.pos++
+		}
+	}
+}
+
+// Consume an escape sequence in a string, returning a description of it if
+// it's invalid.
+func (l *celLexer) escape() string {
+	l.pos++ // the backslash
+	if l.pos >= len(l.src) {
+		return "unterminated escape sequence"
+	}
+	c, size := utf8.DecodeRuneInString(l.src[l.pos:])
+	l.pos += size
+	digits, valid := 0, isHex
+	switch c {
+	case 'a', 'b', 'f', 'n', 'r', 't', 'v', '\\', '\'', '"', '`', '?':
+		return ""
+	case 'x', 'X':
+		digits = 2
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	case '0', '1', '2', '3':
+		digits, valid = 2, func(c byte) bool { return c >= '0' && c <= '7' }
+	default:
+		return fmt.Sprintf("invalid escape sequence '\\%c'", c)
+	}
+	for i := 0; i < digits; i++ {
+		if l.pos >= len(l.src) || !valid(l.src[l.pos]) {
+			return fmt.Sprintf("invalid escape sequence '\\%c'", c)
+		}
+		l.pos++
+	}
+	return ""
+}
+
+func (l *celLexer) number() token {
+	start := l.pos
+	digits := func(valid func(byte) bool) int {
+		n := 0
+		for l.pos < len(l.src) && valid(l.src[l.pos]) {
+			l.pos++
+			n++
+		}
+		return n
+	}
+	float := false
+	if strings.HasPrefix(l.src[l.pos:], "0x") || strings.HasPrefix(l.src[l.pos:], "0X") {
+		l.pos += 2
+		if digits(isHex) == 0 {
+			return token{kind: tokError, text: "invalid hexadecimal literal", pos: start}
+		}
+	} else {
+		digits(isDigit)
+		// A dot followed by a letter is a member access on an integer.
+		if l.pos+1 < len(l.src) && l.src[l.pos] == '.' && isDigit(l.src[l.pos+1]) {
+			float = true
+			l.pos++
+			digits(isDigit)
+		}
+		if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+			float = true
+			l.pos++
+			if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+				l.pos++
+			}
+			if digits(isDigit) == 0 {
+				return token{kind: tokError, text: "invalid exponent", pos: start}
+			}
+		}
+	}
+	if !float && l.pos < len(l.src) && (l.src[l.pos] == 'u' || l.src[l.pos] == 'U') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && (isLetter(l.src[l.pos]) || l.src[l.pos] == '_') {
+		return token{kind: tokError, text: fmt.Sprintf("invalid number %q", l.src[start:l.pos+1]), pos: start}
+	}
+	return token{kind: tokLiteral, text: l.src[start:l.pos], pos: start}
+}
+
+// A recursive descent parser for the CEL grammar, which only reports the
+// first error it finds.
+type celParser struct {
+	lex celLexer
+	tok token
+	err *ExpressionError
+}
+
+func (p *celParser) next() {
+	if p.err != nil {
+		p.tok = token{kind: tokEOF, pos: p.tok.pos}
+		return
+	}
+	p.tok = p.lex.next()
+	if p.tok.kind == tokError {
+		p.errorf(p.tok, "%s", p.tok.text)
+	}
+}
+
+func (p *celParser) errorf(at token, format string, args ...any) error {
+	if p.err != nil {
+		return p.err
+	}
+	src := p.lex.src
+	line := strings.Count(src[:at.pos], "\n") + 1
+	lineStart := strings.LastIndex(src[:at.pos], "\n") + 1
+	p.err = &ExpressionError{
+		Expression: src,
+		Offset:     at.pos,
+		Line:       line,
+		Column:     utf8.RuneCountInString(src[lineStart:at.pos]) + 1,
+		Message:    fmt.Sprintf(format, args...),
+	}
+	p.tok = token{kind: tokEOF, pos: at.pos}
+	return p.err
+}
+
+func (p *celParser) is(op string) bool {
+	return p.tok.kind == tokOperator && p.tok.text == op
+}
+
+// Consume the operator op, or report that it was expected.
+func (p *celParser) expect(op string) {
+	if !p.is(op) {
+		p.errorf(p.tok, "expected '%s', found %s", op, p.tok)
+		return
+	}
+	p.next()
+}
+
+// Expr = ConditionalOr ["?" ConditionalOr ":" Expr]
+func (p *celParser) expr() {
+	p.binary(0)
+	if p.is("?") {
+		p.next()
+		p.binary(0)
+		p.expect(":")
+		p.expr()
+	}
+}
+
+// The binary operators, from the loosest binding to the tightest.
+var celPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"<", "<=", ">=", ">", "==", "!=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *celParser) binary(level int) {
+	if level == len(celPrecedence) {
+		p.unary()
+		return
+	}
+	p.binary(level + 1)
+	for p.tok.kind == tokOperator && contains(celPrecedence[level], p.tok.text) {
+		p.next()
+		p.binary(level + 1)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Unary = Member | "!" {"!"} Member | "-" {"-"} Member
+func (p *celParser) unary() {
+	for p.is("!") || p.is("-") {
+		p.next()
+	}
+	p.member()
+}
+
+// Member = Primary | Member "." SELECTOR ["(" [ExprList] ")"] | Member "[" Expr "]"
+func (p *celParser) member() {
+	// Whether the member so far is a qualified name, which may be followed
+	// by the fields of a message to construct.
+	qualified := p.primary()
+	for p.err == nil {
+		switch {
+		case p.is("."):
+			p.next()
+			if p.tok.kind != tokIdent {
+				p.errorf(p.tok, "expected a field name after '.', found %s", p.tok)
+				return
+			}
+			p.next()
+			if p.is("(") {
+				p.next()
+				p.list(")")
+				qualified = false
+			}
+		case p.is("["):
+			p.next()
+			p.expr()
+			p.expect("]")
+			qualified = false
+		case p.is("{") && qualified:
+			p.next()
+			p.fields()
+			qualified = false
+		default:
+			return
+		}
+	}
+}
+
+// Parse a primary expression, returning whether it's an identifier.
+func (p *celParser) primary() bool {
+	tok := p.tok
+	switch {
+	case p.is("."):
+		p.next()
+		if p.tok.kind != tokIdent {
+			p.errorf(p.tok, "expected an identifier after '.', found %s", p.tok)
+			return false
+		}
+		return p.ident()
+	case tok.kind == tokIdent:
+		return p.ident()
+	case tok.kind == tokLiteral:
+		p.next()
+	case p.is("("):
+		p.next()
+		p.expr()
+		p.expect(")")
+	case p.is("["):
+		p.next()
+		p.list("]")
+	case p.is("{"):
+		p.next()
+		p.entries()
+	default:
+		p.errorf(tok, "unexpected %s", tok)
+	}
+	return false
+}
+
+// IDENT ["(" [ExprList] ")"]
+func (p *celParser) ident() bool {
+	p.next()
+	if p.is("(") {
+		p.next()
+		p.list(")")
+		return false
+	}
+	return true
+}
+
+// Parse a comma separated list of expressions, with an optional trailing
+// comma, up to and including the closing operator.
+func (p *celParser) list(closing string) {
+	for p.err == nil && !p.is(closing) {
+		p.expr()
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	p.expect(closing)
+}
+
+// MapInits = Expr ":" Expr {"," Expr ":" Expr}
+func (p *celParser) entries() {
+	for p.err == nil && !p.is("}") {
+		p.expr()
+		p.expect(":")
+		p.expr()
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	p.expect("}")
+}
+
+// FieldInits = SELECTOR ":" Expr {"," SELECTOR ":" Expr}
+func (p *celParser) fields() {
+	for p.err == nil && !p.is("}") {
+		if p.tok.kind != tokIdent {
+			p.errorf(p.tok, "expected a field name, found %s", p.tok)
+			return
+		}
+		p.next()
+		p.expect(":")
+		p.expr()
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	p.expect("}")
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Validate checks the syntax of the expressions of the policy's rules. See
+// [ValidateExpression].
+func (p Policy) Validate() error {
+	return errors.Join(
+		validateRules("inbound", p.Inbound),
+		validateRules("outbound", p.Outbound),
+	)
+}
+
+// Validate checks the syntax of the rule's expressions. See
+// [ValidateExpression].
+func (r Rule) Validate() error {
+	return errors.Join(r.validate("")...)
+}
+
+// Check the rule's expressions, naming each that's invalid after prefix.
+func (r Rule) validate(prefix string) []error {
+	var errs []error
+	for i, expr := range r.Expressions {
+		if err := ValidateExpression(expr); err != nil {
+			errs = append(errs, fmt.Errorf("%sexpressions[%d]: %w", prefix, i, err))
+		}
+	}
+	return errs
+}
+
+// ValidateTrafficPolicy checks the syntax of the expressions of the rules in
+// a traffic policy written as a json or yaml string, in any of its phases,
+// e.g. on_http_request. The errors name the rule and expression they're for,
+// and wrap an [*ExpressionError] for the position within it.
+func ValidateTrafficPolicy(input string) error {
+	var doc map[string]any
+	if err := yaml.Unmarshal([]byte(input), &doc); err != nil {
+		return fmt.Errorf("invalid traffic policy: %w", err)
+	}
+	phases := make([]string, 0, len(doc))
+	for phase := range doc {
+		phases = append(phases, phase)
+	}
+	sort.Strings(phases)
+
+	var errs []error
+	for _, phase := range phases {
+		items, _ := doc[phase].([]any)
+		rules := make([]Rule, 0, len(items))
+		for _, item := range items {
+			fields, _ := item.(map[any]any)
+			var rule Rule
+			rule.Name, _ = fields["name"].(string)
+			exprs, _ := fields["expressions"].([]any)
+			for _, expr := range exprs {
+				s, _ := expr.(string)
+				rule.Expressions = append(rule.Expressions, s)
+			}
+			rules = append(rules, rule)
+		}
+		errs = append(errs, validateRules(phase, rules))
+	}
+	return errors.Join(errs...)
+}
+
+func validateRules(phase string, rules []Rule) error {
+	var errs []error
+	for i, rule := range rules {
+		prefix := fmt.Sprintf("%s[%d] ", phase, i)
+		if rule.Name != "" {
+			prefix = fmt.Sprintf("%s[%d] (%q) ", phase, i, rule.Name)
+		}
+		errs = append(errs, rule.validate(prefix)...)
+	}
+	return errors.Join(errs...)
+}
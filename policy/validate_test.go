@@ -0,0 +1,87 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateExpression(t *testing.T) {
+	valid := []string{
+		"req.Method == 'PUT'",
+		"'foo' in req.Headers",
+		"res.StatusCode <= 0 || res.StatusCode >= 300",
+		"!(conn.client_ip in ['10.0.0.1', \"10.0.0.2\"]) && size(req.headers['x-id']) > 0",
+		"req.url.path.startsWith('/api/') ? 1u : -2.5e3",
+		"has(req.headers.foo) && {'a': 1, 'b': [0x1F, .5,]}['a'] == 1",
+		"req.body == b'\\x00\\377' || r'\\d+'.matches(req.url.path) // trailing comment",
+		"google.protobuf.Duration{seconds: 5}",
+		"'''multi\nline''' != \"\"",
+		".root.field % 2 == 0",
+	}
+	for _, expr := range valid {
+		require.NoError(t, ValidateExpression(expr), expr)
+	}
+
+	invalid := []struct {
+		expr    string
+		line    int
+		column  int
+		message string
+	}{
+		{"", 1, 1, "expression is empty"},
+		{"req.Method = 'PUT'", 1, 12, "unexpected '=', did you mean '=='?"},
+		{"req.Method == 'PUT", 1, 15, "unterminated string"},
+		{"(req.Method == 'PUT'", 1, 21, "expected ')', found end of expression"},
+		{"req.Method ==", 1, 14, "unexpected end of expression"},
+		{"req. == 1", 1, 6, "expected a field name after '.', found '=='"},
+		{"size(req.headers) 1", 1, 19, "unexpected literal 1"},
+		{"req.Method in ['GET',\n  'PUT' 'POST']", 2, 9, "expected ']', found literal 'POST'"},
+		{"'caf\\é'", 1, 5, "invalid escape sequence '\\é'"},
+		{"let x = 1", 1, 1, "reserved word \"let\" can't be used as an identifier"},
+		{"12abc", 1, 1, "invalid number \"12a\""},
+		{"'é' == req.x @ 1", 1, 14, "unexpected character '@'"},
+	}
+	for _, tc := range invalid {
+		err := ValidateExpression(tc.expr)
+		var exprErr *ExpressionError
+		require.True(t, errors.As(err, &exprErr), "%q: %v", tc.expr, err)
+		require.Equal(t, tc.message, exprErr.Message, tc.expr)
+		require.Equal(t, tc.line, exprErr.Line, tc.expr)
+		require.Equal(t, tc.column, exprErr.Column, tc.expr)
+	}
+}
+
+func TestExpressionErrorString(t *testing.T) {
+	err := ValidateExpression("req.Method = 'PUT'")
+	require.EqualError(t, err, "1:12: unexpected '=', did you mean '=='?\n | req.Method = 'PUT'\n | ...........^")
+}
+
+func TestValidateTrafficPolicy(t *testing.T) {
+	err := ValidateTrafficPolicy(`
+on_http_request:
+  - name: ok
+    expressions: ["req.method == 'GET'"]
+    actions: [{type: deny}]
+  - name: broken
+    expressions:
+      - "req.method == 'GET'"
+      - "req.url.path.startsWith('/api'"
+    actions: [{type: deny}]
+on_http_response:
+  - expressions: ["res.status_code >"]
+    actions: [{type: deny}]
+`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `on_http_request[1] ("broken") expressions[1]: 1:31: expected ')'`)
+	require.Contains(t, err.Error(), `on_http_response[0] expressions[0]: 1:18: unexpected end of expression`)
+	var exprErr *ExpressionError
+	require.True(t, errors.As(err, &exprErr))
+
+	require.NoError(t, ValidateTrafficPolicy(`{"on_tcp_connect": [{"expressions": ["conn.client_ip != '1.1.1.1'"], "actions": [{"type": "deny"}]}]}`))
+	require.Error(t, ValidateTrafficPolicy("{not yaml"))
+
+	p := Policy{Outbound: []Rule{{Name: "r", Expressions: []string{"res.StatusCode >= 300", "res.StatusCode <="}}}}
+	require.EqualError(t, p.Validate(), "outbound[0] (\"r\") expressions[1]: 1:18: unexpected end of expression\n | res.StatusCode <=\n | .................^")
+}
@@ -0,0 +1,210 @@
+package ngrok
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.ngrok.com/ngrok/clock"
+)
+
+// The default interval between the health checks configured with
+// [config.WithPoolHealthCheck].
+const defaultHealthInterval = 10 * time.Second
+
+// EventPoolMembersChanged is emitted when a tunnel with pooling enabled (see
+// [config.WithAllowsPooling]) joins or leaves the pool for its URL. Besides
+// starting and closing, a tunnel leaves its pool while its health check (see
+// [config.WithPoolHealthCheck]) fails and rejoins once it passes.
+//
+// The ngrok service does not report pool members belonging to other sessions,
+// so Members only counts the tunnels in this session.
+type EventPoolMembersChanged struct {
+	baseEvent
+	// The tunnel that joined or left the pool. Its ID identifies it within
+	// the pool.
+	Tunnel Tunnel
+	// The URL shared by the members of the pool.
+	URL string
+	// Whether the tunnel joined, rather than left, the pool.
+	Joined bool
+	// The number of members of the pool in this session after the change.
+	Members int
+	// The health check error that made the tunnel leave the pool, if any.
+	Error error
+}
+
+// Record a newly started tunnel.
+func (s *sessionImpl) addTunnel(t *tunnelImpl) {
+	s.tunnelsMu.Lock()
+	defer s.tunnelsMu.Unlock()
+	if s.tunnels == nil {
+		s.tunnels = make(map[*tunnelImpl]struct{})
+	}
+	s.tunnels[t] = struct{}{}
+	if t.pooled {
+		s.emitPoolChange(t, true, nil)
+	}
+}
+
+// Forget a closed tunnel.
+func (s *sessionImpl) removeTunnel(t *tunnelImpl) {
+	s.tunnelsMu.Lock()
+	defer s.tunnelsMu.Unlock()
+	if _, ok := s.tunnels[t]; !ok {
+		return
+	}
+	delete(s.tunnels, t)
+	if t.pooled && !t.health.paused.Load() {
+		s.emitPoolChange(t, false, nil)
+	}
+}
+
+// Must be called with the tunnels lock held.
+func (s *sessionImpl) emitPoolChange(t *tunnelImpl, joined bool, err error) {
+	url := t.URL()
+	members := 0
+	for other := range s.tunnels {
+		if other.pooled && !other.health.paused.Load() && other.URL() == url {
+			members++
+		}
+	}
+	s.events.emit(&EventPoolMembersChanged{
+		baseEvent: newBaseEvent(EventTypePoolMembersChanged),
+		Tunnel:    t,
+		URL:       url,
+		Joined:    joined,
+		Members:   members,
+		Error:     err,
+	})
+}
+
+// Count the connections accepted by each member of each pool in the session,
+// keyed by URL and then tunnel ID.
+func (s *sessionImpl) poolStats() map[string]map[string]uint64 {
+	s.tunnelsMu.Lock()
+	defer s.tunnelsMu.Unlock()
+	var pools map[string]map[string]uint64
+	for t := range s.tunnels {
+		if !t.pooled {
+			continue
+		}
+		if pools == nil {
+			pools = make(map[string]map[string]uint64)
+		}
+		url := t.URL()
+		if pools[url] == nil {
+			pools[url] = make(map[string]uint64)
+		}
+		pools[url][t.ID()] = t.connSeq.Load()
+	}
+	return pools
+}
+
+// Tracks whether a pooled tunnel has left its pool because its health check
+// failed.
+type poolHealth struct {
+	paused   atomic.Bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Run the health check every interval until the tunnel closes, pausing the
+// tunnel while it fails.
+func (t *tunnelImpl) startHealthCheck(clk clock.Clock, check func(context.Context) error, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	h := &t.health
+	h.stop = make(chan struct{})
+	t.goroutines.spawn(func() {
+		timer := clk.NewTimer(interval)
+		defer timer.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-timer.C():
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := check(ctx)
+			cancel()
+			select {
+			case <-h.stop:
+				return
+			default:
+			}
+			t.setHealth(err)
+			timer.Reset(interval)
+		}
+	})
+}
+
+// Call the health check every interval until it passes, for tunnels that
+// only join their pool once healthy.
+func (s *sessionImpl) awaitHealthy(ctx context.Context, check func(context.Context) error, interval time.Duration) error {
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, interval)
+		err := check(checkCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		s.inner().Logger.Info("health check failed, waiting to join pool", "wait", interval, "err", err)
+		timer := s.clock().NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errNotHealthy{err}
+		case <-timer.C():
+		}
+	}
+}
+
+func (t *tunnelImpl) stopHealthCheck() {
+	h := &t.health
+	if h.stop != nil {
+		h.stopOnce.Do(func() { close(h.stop) })
+	}
+}
+
+// Leave or rejoin the pool if the outcome of the health check changed.
+func (t *tunnelImpl) setHealth(err error) {
+	h := &t.health
+	switch {
+	case err != nil && !h.paused.Load():
+		t.setLastError(err)
+		if perr := t.Tunnel.Pause(); perr != nil {
+			t.log(func(l *slog.Logger) { l.Warn("failed to leave pool", "clientid", t.ID(), "err", perr) })
+			return
+		}
+		t.log(func(l *slog.Logger) { l.Info("health check failed, left pool", "clientid", t.ID(), "err", err) })
+		t.poolHealthChanged(false, err)
+	case err == nil && h.paused.Load():
+		if rerr := t.Tunnel.Resume(); rerr != nil {
+			t.log(func(l *slog.Logger) { l.Warn("failed to rejoin pool", "clientid", t.ID(), "err", rerr) })
+			return
+		}
+		t.log(func(l *slog.Logger) { l.Info("health check passed, rejoined pool", "clientid", t.ID()) })
+		t.poolHealthChanged(true, nil)
+	}
+}
+
+func (t *tunnelImpl) poolHealthChanged(joined bool, err error) {
+	s, ok := t.Sess.(*sessionImpl)
+	if !ok {
+		t.health.paused.Store(!joined)
+		return
+	}
+	s.tunnelsMu.Lock()
+	defer s.tunnelsMu.Unlock()
+	t.health.paused.Store(!joined)
+	if _, ok := s.tunnels[t]; ok {
+		s.emitPoolChange(t, joined, err)
+	}
+}
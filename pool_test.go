@@ -0,0 +1,136 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/clock"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func TestPoolMembers(t *testing.T) {
+	rec := &eventRecorder{}
+	sess := &sessionImpl{events: newEventDispatcher([]EventHandler{rec.handle})}
+
+	newMember := func(id string) (*tunnelImpl, *urlTunnel) {
+		q := &urlTunnel{queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 4)}}
+		tun := &tunnelImpl{Sess: sess, Tunnel: &idTunnel{q, id}, pooled: true, events: sess.events}
+		sess.addTunnel(tun)
+		return tun, q
+	}
+	first, firstQ := newMember("tun_1")
+	newMember("tun_2")
+	sess.addTunnel(&tunnelImpl{Sess: sess, Tunnel: &idTunnel{&urlTunnel{}, "tun_3"}})
+
+	accept := func(tun *tunnelImpl, q *urlTunnel) {
+		local, _ := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+		_, err := tun.Accept()
+		require.NoError(t, err)
+	}
+	accept(first, firstQ)
+	accept(first, firstQ)
+
+	require.Equal(t, map[string]map[string]uint64{
+		"https://example.ngrok.app": {"tun_1": 2, "tun_2": 0},
+	}, sess.Stats().Pools)
+
+	require.NoError(t, first.Close())
+
+	rec.waitFor(t, 5)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var changes []*EventPoolMembersChanged
+	for _, ev := range rec.events {
+		if change, ok := ev.(*EventPoolMembersChanged); ok {
+			changes = append(changes, change)
+		}
+	}
+	require.Len(t, changes, 3)
+	require.True(t, changes[0].Joined)
+	require.Equal(t, 1, changes[0].Members)
+	require.True(t, changes[1].Joined)
+	require.Equal(t, 2, changes[1].Members)
+	require.False(t, changes[2].Joined)
+	require.Equal(t, 1, changes[2].Members)
+	require.Equal(t, "https://example.ngrok.app", changes[2].URL)
+	require.Equal(t, "tun_1", changes[2].Tunnel.ID())
+}
+
+// Overrides the ID of a test tunnel.
+type idTunnel struct {
+	*urlTunnel
+	id string
+}
+
+func (t *idTunnel) ID() string {
+	return t.id
+}
+
+// A test tunnel that counts the requests to leave and rejoin its pool.
+type pausableTunnel struct {
+	*idTunnel
+	pauses, resumes atomic.Int32
+}
+
+func (t *pausableTunnel) Pause() error {
+	t.pauses.Add(1)
+	return nil
+}
+
+func (t *pausableTunnel) Resume() error {
+	t.resumes.Add(1)
+	return nil
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	rec := &eventRecorder{}
+	sess := &sessionImpl{events: newEventDispatcher([]EventHandler{rec.handle})}
+	raw := &pausableTunnel{idTunnel: &idTunnel{&urlTunnel{}, "tun_1"}}
+	tun := &tunnelImpl{Sess: sess, Tunnel: raw, pooled: true, events: sess.events}
+	sess.addTunnel(tun)
+
+	unhealthy := errors.New("upstream down")
+	var healthErr atomic.Pointer[error]
+	healthErr.Store(&unhealthy)
+	clk := clock.NewFake(time.Now())
+	tun.startHealthCheck(clk, func(context.Context) error { return *healthErr.Load() }, time.Minute)
+
+	// The checks run as the clock passes each interval.
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute - time.Nanosecond)
+	require.Zero(t, raw.pauses.Load())
+	clk.Advance(time.Nanosecond)
+	require.Eventually(t, func() bool { return raw.pauses.Load() == 1 }, time.Second, time.Millisecond)
+	var healthy error
+	healthErr.Store(&healthy)
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+	require.Eventually(t, func() bool { return raw.resumes.Load() == 1 }, time.Second, time.Millisecond)
+
+	require.NoError(t, tun.Close())
+	rec.waitFor(t, 5)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	var changes []*EventPoolMembersChanged
+	for _, ev := range rec.events {
+		if change, ok := ev.(*EventPoolMembersChanged); ok {
+			changes = append(changes, change)
+		}
+	}
+	require.Len(t, changes, 4)
+	require.False(t, changes[1].Joined)
+	require.Equal(t, 0, changes[1].Members)
+	require.ErrorIs(t, changes[1].Error, unhealthy)
+	require.True(t, changes[2].Joined)
+	require.Equal(t, 1, changes[2].Members)
+	require.NoError(t, changes[2].Error)
+	require.False(t, changes[3].Joined)
+	require.Equal(t, int32(1), raw.pauses.Load())
+}
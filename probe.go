@@ -0,0 +1,148 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long each connect URL is given to complete its probe.
+const connectProbeTimeout = 5 * time.Second
+
+// The ngrok regions probed by [ProbeConnectURLs] and [WithAutoRegion].
+var connectRegions = []string{"us", "us-cal-1", "eu", "ap", "au", "sa", "jp", "in"}
+
+func regionServerAddr(region string) string {
+	return fmt.Sprintf("connect.%s.ngrok-agent.com:443", region)
+}
+
+// ConnectProbe is the result of probing a connect URL with
+// [ProbeConnectURLs].
+type ConnectProbe struct {
+	// The address that was probed, as host:port. Pass it to [WithServer] to
+	// connect through it.
+	Addr string
+	// The time taken to connect over TCP and complete a TLS handshake with
+	// the ngrok service.
+	Latency time.Duration
+	// The error that made the probe fail, if any.
+	Err error
+}
+
+// ProbeConnectURLs measures the time taken to connect to each of the given
+// ngrok connect URLs over TCP and complete a TLS handshake, so that the
+// fastest one for the environment can be picked and persisted, e.g. with
+// [WithServer]. URLs may be given as host:port, as a host alone to use port
+// 443, or with a scheme like tls://host:port. If none are given, the
+// connect URLs of all of the ngrok regions are probed.
+//
+// The results are ordered from lowest to highest latency, with failed probes
+// last.
+func ProbeConnectURLs(ctx context.Context, urls ...string) []ConnectProbe {
+	addrs := make([]string, 0, len(urls))
+	for _, u := range urls {
+		addrs = append(addrs, connectAddr(u))
+	}
+	if len(addrs) == 0 {
+		for _, region := range connectRegions {
+			addrs = append(addrs, regionServerAddr(region))
+		}
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(defaultCACert)
+	return probeConnectAddrs(ctx, newHappyEyeballsDialer(IPPreferenceDefault, nil), pool, addrs)
+}
+
+// Normalize a connect URL to a host:port address.
+func connectAddr(u string) string {
+	if strings.Contains(u, "://") {
+		if parsed, err := url.Parse(u); err == nil {
+			u = parsed.Host
+		}
+	}
+	if _, _, err := net.SplitHostPort(u); err != nil {
+		return net.JoinHostPort(u, "443")
+	}
+	return u
+}
+
+func probeConnectAddrs(ctx context.Context, dialer Dialer, pool *x509.CertPool, addrs []string) []ConnectProbe {
+	probes := make([]ConnectProbe, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func(probe *ConnectProbe, addr string) {
+			defer wg.Done()
+			probe.Addr = addr
+			probe.Latency, probe.Err = probeConnectAddr(ctx, dialer, pool, addr)
+		}(&probes[i], addr)
+	}
+	wg.Wait()
+
+	sort.SliceStable(probes, func(a, b int) bool {
+		if (probes[a].Err == nil) != (probes[b].Err == nil) {
+			return probes[a].Err == nil
+		}
+		return probes[a].Err == nil && probes[a].Latency < probes[b].Latency
+	})
+	return probes
+}
+
+func probeConnectAddr(ctx context.Context, dialer Dialer, pool *x509.CertPool, addr string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, connectProbeTimeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	host, _, _ := net.SplitHostPort(addr)
+	tlsConn := tls.Client(conn, &tls.Config{
+		RootCAs:    pool,
+		ServerName: host,
+		MinVersion: tls.VersionTLS12,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// WithAutoRegion probes the ngrok regions when the [Session] first connects,
+// as [ProbeConnectURLs] does, and connects to the one with the lowest
+// latency. Unlike the default server, which picks a region by its own
+// measure, this picks the one that's fastest from the network the
+// application runs in, through any proxy it's configured with.
+//
+// It has no effect if a server was chosen with [WithServer] or [WithRegion],
+// and falls back to the default server if no region can be reached.
+func WithAutoRegion() ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.AutoRegion = true
+	}
+}
+
+// Pick the region with the lowest latency, returning false if none can be
+// reached.
+func pickRegion(ctx context.Context, dialer Dialer, pool *x509.CertPool) (region, addr string, ok bool) {
+	addrs := make([]string, len(connectRegions))
+	regions := make(map[string]string, len(connectRegions))
+	for i, region := range connectRegions {
+		addrs[i] = regionServerAddr(region)
+		regions[addrs[i]] = region
+	}
+	probes := probeConnectAddrs(ctx, dialer, pool, addrs)
+	if probes[0].Err != nil {
+		return "", "", false
+	}
+	return regions[probes[0].Addr], probes[0].Addr, true
+}
@@ -0,0 +1,60 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectAddr(t *testing.T) {
+	require.Equal(t, "connect.eu.ngrok-agent.com:443", connectAddr("connect.eu.ngrok-agent.com"))
+	require.Equal(t, "connect.eu.ngrok-agent.com:443", connectAddr("tls://connect.eu.ngrok-agent.com:443"))
+	require.Equal(t, "localhost:4443", connectAddr("localhost:4443"))
+}
+
+func TestProbeConnectAddrs(t *testing.T) {
+	cert := testCert(t, "localhost")
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_ = conn.(*tls.Conn).Handshake()
+				conn.Close()
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// A port that nothing listens on.
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.Leaf)
+	untrusted := x509.NewCertPool()
+
+	dialer := newHappyEyeballsDialer(IPPreferenceDefault, nil)
+	probes := probeConnectAddrs(context.Background(), dialer, pool, []string{closedAddr, "localhost:" + port})
+	require.Len(t, probes, 2)
+	require.Equal(t, "localhost:"+port, probes[0].Addr)
+	require.NoError(t, probes[0].Err)
+	require.Positive(t, probes[0].Latency)
+	require.Equal(t, closedAddr, probes[1].Addr)
+	require.Error(t, probes[1].Err)
+
+	// The probe verifies the server like the session would.
+	probes = probeConnectAddrs(context.Background(), dialer, untrusted, []string{"localhost:" + port})
+	require.Error(t, probes[0].Err)
+}
@@ -0,0 +1,180 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/proxy"
+)
+
+// ProxyDialer establishes connections to the ngrok service through an
+// outbound proxy.
+//
+// Implement this to support proxies that need more than the built-in
+// handling, such as challenge-response (NTLM or Negotiate) authentication.
+type ProxyDialer interface {
+	// DialProxy connects to address on the named network through the proxy.
+	// The forward dialer should be used to reach the proxy itself.
+	DialProxy(ctx context.Context, forward Dialer, network, address string) (net.Conn, error)
+}
+
+// Adapts a [ProxyDialer] to the [Dialer] interface.
+type proxyDialerAdapter struct {
+	proxy   ProxyDialer
+	forward Dialer
+}
+
+func (d *proxyDialerAdapter) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *proxyDialerAdapter) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.proxy.DialProxy(ctx, d.forward, network, address)
+}
+
+// Construct a [Dialer] for the provided proxy URL. HTTP and HTTPS proxies are
+// handled with CONNECT requests; everything else is deferred to
+// [golang.org/x/net/proxy].
+func newProxyURLDialer(u *url.URL, forward Dialer, headers http.Header, tlsConfig *tls.Config) (Dialer, error) {
+	switch u.Scheme {
+	case "http", "https":
+		return &httpConnectDialer{
+			proxyURL:  u,
+			forward:   forward,
+			headers:   headers,
+			tlsConfig: tlsConfig,
+		}, nil
+	}
+
+	proxied, err := proxy.FromURL(u, forward)
+	if err != nil {
+		return nil, err
+	}
+	if dialer, ok := proxied.(Dialer); ok {
+		return dialer, nil
+	}
+	return &contextlessDialer{proxied}, nil
+}
+
+// Adapts a [proxy.Dialer] without context support to the [Dialer] interface.
+type contextlessDialer struct {
+	proxy.Dialer
+}
+
+func (d *contextlessDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.Dial(network, address)
+}
+
+// A [Dialer] that tunnels connections through an HTTP or HTTPS proxy using
+// CONNECT requests.
+type httpConnectDialer struct {
+	proxyURL *url.URL
+	forward  Dialer
+	// Additional headers sent with each CONNECT request.
+	headers http.Header
+	// The TLS configuration for connecting to https:// proxies.
+	tlsConfig *tls.Config
+}
+
+func (d *httpConnectDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d *httpConnectDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	proxyAddr := d.proxyURL.Host
+	if d.proxyURL.Port() == "" {
+		port := "80"
+		if d.proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.proxyURL.Hostname(), port)
+	}
+
+	conn, err := d.forward.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure we don't block past the context while talking to the proxy.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	conn, err = d.connect(ctx, conn, address)
+	if !stop() {
+		if conn != nil {
+			conn.Close()
+		}
+		return nil, ctx.Err()
+	}
+	return conn, err
+}
+
+// Perform the CONNECT handshake over a fresh connection to the proxy.
+func (d *httpConnectDialer) connect(ctx context.Context, conn net.Conn, address string) (net.Conn, error) {
+	if d.proxyURL.Scheme == "https" {
+		tlsConfig := &tls.Config{}
+		if d.tlsConfig != nil {
+			tlsConfig = d.tlsConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = d.proxyURL.Hostname()
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: d.headers.Clone(),
+	}
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	if user := d.proxyURL.User; user != nil && req.Header.Get("Proxy-Authorization") == "" {
+		password, _ := user.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errProxyConnect{Addr: address, Status: resp.Status}
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: br}, nil
+	}
+	return conn, nil
+}
+
+// A [net.Conn] that first drains data already read into a buffer.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,106 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Start a minimal CONNECT proxy that records the requests it receives and
+// responds with the given status. Accepted connections are echoed back.
+func testConnectProxy(t *testing.T, status int) (*url.URL, <-chan *http.Request) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				reqs <- req
+				resp := &http.Response{StatusCode: status, ProtoMajor: 1, ProtoMinor: 1}
+				if err := resp.Write(conn); err != nil || status != http.StatusOK {
+					return
+				}
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}, reqs
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	u, reqs := testConnectProxy(t, http.StatusOK)
+	u.User = url.UserPassword("user", "pass")
+
+	dialer, err := newProxyURLDialer(u, &net.Dialer{}, http.Header{"X-Custom": {"value"}}, nil)
+	require.NoError(t, err)
+
+	conn, err := dialer.DialContext(context.Background(), "tcp", "connect.example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := <-reqs
+	require.Equal(t, http.MethodConnect, req.Method)
+	require.Equal(t, "connect.example.com:443", req.Host)
+	require.Equal(t, "value", req.Header.Get("X-Custom"))
+	user, pass, ok := (&http.Request{Header: http.Header{
+		"Authorization": req.Header["Proxy-Authorization"],
+	}}).BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "user", user)
+	require.Equal(t, "pass", pass)
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf))
+}
+
+func TestHTTPConnectProxyRefused(t *testing.T) {
+	u, _ := testConnectProxy(t, http.StatusProxyAuthRequired)
+
+	dialer, err := newProxyURLDialer(u, &net.Dialer{}, nil, nil)
+	require.NoError(t, err)
+
+	_, err = dialer.DialContext(context.Background(), "tcp", "connect.example.com:443")
+	require.ErrorIs(t, err, errProxyConnect{})
+}
+
+type recordingProxyDialer struct {
+	address string
+}
+
+func (d *recordingProxyDialer) DialProxy(ctx context.Context, forward Dialer, network, address string) (net.Conn, error) {
+	d.address = address
+	local, _ := net.Pipe()
+	return local, nil
+}
+
+func TestProxyDialerAdapter(t *testing.T) {
+	proxy := &recordingProxyDialer{}
+	dialer := &proxyDialerAdapter{proxy: proxy, forward: &net.Dialer{}}
+
+	conn, err := dialer.Dial("tcp", "connect.example.com:443")
+	require.NoError(t, err)
+	conn.Close()
+	require.Equal(t, "connect.example.com:443", proxy.address)
+}
@@ -0,0 +1,31 @@
+package ngrok
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.ngrok.com/muxado/v2"
+
+	"golang.ngrok.com/ngrok/internal/rawstream"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+func init() {
+	rawstream.Open = openRawStream
+}
+
+// Open a stream outside the ngrok protocol on the session's current
+// connection, for the rawsession package.
+func openRawStream(sess any, typ muxado.StreamType) (net.Conn, error) {
+	s, _ := sess.(Session)
+	impl, err := unwrapSession(s, "rawsession.Open")
+	if err != nil {
+		return nil, err
+	}
+	conn, err := impl.inner().Session.OpenStream(typ)
+	if errors.Is(err, tunnel_client.ErrSessionNotReady) || errors.Is(err, errOfflineUnsupported) {
+		return nil, fmt.Errorf("%w: %w", rawstream.ErrNotConnected, err)
+	}
+	return conn, err
+}
@@ -0,0 +1,111 @@
+// Package rawsession opens streams of custom types on the connection of an
+// ngrok session, for integrations that exchange their own messages with an
+// ngrok deployment, such as RPCs specific to an enterprise installation.
+// Streams are multiplexed over the session's connection alongside the tunnels
+// and the ngrok protocol's own requests.
+//
+// Most applications have no use for this package, and the ngrok service
+// refuses streams of types it doesn't know. It exists so that the few that do
+// don't have to fork the session implementation, and guards the session's own
+// traffic from them:
+//
+//   - Stream types below [MinStreamType] are reserved for the ngrok protocol
+//     and can't be opened.
+//   - Writes to the streams yield to the session's requests and heartbeats, as
+//     those of proxied connections do, so that a busy stream can't get the
+//     session taken for dead.
+//   - Streams are opened on the session's current connection, and closed if it
+//     drops. They aren't reopened when the session reconnects.
+package rawsession
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"golang.ngrok.com/muxado/v2"
+
+	"golang.ngrok.com/ngrok"
+	"golang.ngrok.com/ngrok/internal/rawstream"
+)
+
+// StreamType identifies the kind of a stream to the ngrok service, which uses
+// it to decide how to handle the stream.
+type StreamType uint32
+
+const (
+	// MinStreamType is the lowest stream type that may be opened. Lower types
+	// are reserved for the ngrok protocol.
+	MinStreamType StreamType = 1 << 16
+	// MaxStreamType is the highest stream type that may be opened. The type
+	// above it carries the session's heartbeats.
+	MaxStreamType StreamType = 1<<32 - 2
+)
+
+var (
+	// ErrReservedStreamType is returned when opening a stream of a type that
+	// is outside of [MinStreamType] and [MaxStreamType].
+	ErrReservedStreamType = errors.New("stream type is reserved for the ngrok protocol")
+	// ErrNotConnected is returned when the session has no connection to the
+	// ngrok service to open a stream on, because it's reconnecting or it fell
+	// back to listening locally.
+	ErrNotConnected = rawstream.ErrNotConnected
+)
+
+// Open opens a stream of the given type on the session's current connection
+// to the ngrok service. The session must have been returned by
+// [ngrok.Connect] or [ngrok.SharedTransport.Connect], or else Open fails with
+// [ngrok.ErrUnsupportedSession]. The context bounds opening the stream, but not its use
+// afterwards.
+func Open(ctx context.Context, sess ngrok.Session, typ StreamType) (net.Conn, error) {
+	if typ < MinStreamType || typ > MaxStreamType {
+		return nil, fmt.Errorf("%w: %d", ErrReservedStreamType, typ)
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		conn, err := rawstream.Open(sess, muxado.StreamType(typ))
+		opened <- result{conn, err}
+	}()
+
+	select {
+	case res := <-opened:
+		return res.conn, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-opened; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Call makes a request on a new stream of the given type the way the ngrok
+// protocol's own requests are made: req is encoded as JSON, and a single JSON
+// response is decoded into resp. The stream is closed when the call returns,
+// or when the context is done.
+func Call(ctx context.Context, sess ngrok.Session, typ StreamType, req, resp any) error {
+	conn, err := Open(ctx, sess, typ)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = json.NewEncoder(conn).Encode(req)
+	if err == nil {
+		err = json.NewDecoder(conn).Decode(resp)
+	}
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
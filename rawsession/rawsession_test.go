@@ -0,0 +1,38 @@
+package rawsession
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok"
+)
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+	sess, err := ngrok.Connect(ctx, ngrok.WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	for _, typ := range []StreamType{0, 3, MinStreamType - 1, MaxStreamType + 1} {
+		_, err := Open(ctx, sess, typ)
+		require.ErrorIs(t, err, ErrReservedStreamType, "%d", typ)
+	}
+
+	_, err = Open(ctx, sess, MinStreamType)
+	require.ErrorIs(t, err, ErrNotConnected)
+}
+
+func TestOpenSharedSession(t *testing.T) {
+	ctx := context.Background()
+	sess, err := ngrok.NewSharedTransport(ngrok.WithOfflineFallback("127.0.0.1:0")).Connect(ctx)
+	require.NoError(t, err)
+	defer sess.Close()
+
+	_, err = Open(ctx, sess, MinStreamType)
+	require.ErrorIs(t, err, ErrNotConnected)
+
+	_, err = Open(ctx, struct{ ngrok.Session }{sess}, MinStreamType)
+	require.ErrorIs(t, err, ngrok.ErrUnsupportedSession)
+}
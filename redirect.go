@@ -0,0 +1,36 @@
+package ngrok
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// Bind the plain-HTTP variant of an https tunnel, on the domain it was bound
+// to, and serve redirects to it from there.
+func (s *sessionImpl) listenRedirect(ctx context.Context, t *tunnelImpl) error {
+	u, err := url.Parse(t.URL())
+	if err != nil {
+		return errListen{err}
+	}
+	redirect, err := s.listen(ctx, config.HTTPEndpoint(
+		config.WithDomain(u.Host),
+		config.WithScheme(config.SchemeHTTP),
+	))
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: http.HandlerFunc(redirectToHTTPS)}
+	redirect.server = server
+	s.goroutines.spawn(func() { _ = server.Serve(serverListener{redirect}) })
+	t.redirect = redirect
+	return nil
+}
+
+// Redirect a request to the same URL with the https scheme. A 308 preserves
+// the request's method and body, unlike a 301.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "https://"+r.Host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+}
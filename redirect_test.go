@@ -0,0 +1,18 @@
+package ngrok
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "http://example.ngrok.app/path?q=1", nil)
+	rec := httptest.NewRecorder()
+	redirectToHTTPS(rec, req)
+
+	require.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	require.Equal(t, "https://example.ngrok.app/path?q=1", rec.Header().Get("Location"))
+}
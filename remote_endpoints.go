@@ -0,0 +1,137 @@
+package ngrok
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// WithAPIKey configures an [ngrok API] key for the session to use for
+// operations that the agent protocol doesn't support, such as
+// [Session].RemoteEndpoints. Note that this is an API key, not an authtoken.
+//
+// [ngrok API]: https://ngrok.com/docs/api
+func WithAPIKey(apiKey string) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.APIKey = apiKey
+	}
+}
+
+// RemoteEndpoint is an endpoint on the ngrok account, as reported by the ngrok
+// API. It may belong to this session, another agent, or no agent at all.
+type RemoteEndpoint struct {
+	// The unique ID of the endpoint.
+	ID string
+	// The URL of the endpoint.
+	URL string
+	// The protocol of the endpoint, e.g. "https" or "tcp".
+	Proto string
+	// How the endpoint was created: "ephemeral" or "agent" for endpoints
+	// started by agents, "cloud" for cloud endpoints, or "edge".
+	Type string
+	// The metadata of the endpoint.
+	Metadata string
+	// The ID of the tunnel serving the endpoint, if any.
+	TunnelID string
+	// The ID of the agent session serving the endpoint, if any.
+	TunnelSessionID string
+	// When the endpoint was created.
+	CreatedAt time.Time
+
+	api *apiClient
+	// Whether the endpoint is served by the session that listed it.
+	ownSession bool
+}
+
+// Disconnect takes the endpoint offline. Endpoints that aren't served by an
+// agent, such as cloud endpoints, are deleted.
+//
+// The ngrok API can't take a single endpoint of an agent offline, so an
+// endpoint served by an agent is disconnected by stopping the whole agent
+// session serving it, which takes all of that session's other endpoints
+// offline too. This suits cleaning up the endpoints of orphaned agents. To
+// avoid stopping itself, along with the tenants of a [SharedTransport], a
+// session refuses to disconnect the endpoints it serves, returning an error
+// matching [ErrDisconnectOwnSession]; close their tunnels instead.
+func (e *RemoteEndpoint) Disconnect(ctx context.Context) error {
+	if e.ownSession {
+		return errDisconnectOwnSession{e.ID}
+	}
+	if e.TunnelSessionID != "" {
+		return e.api.do(ctx, http.MethodPost, "/tunnel_sessions/"+url.PathEscape(e.TunnelSessionID)+"/stop", struct{}{}, nil)
+	}
+	return e.api.do(ctx, http.MethodDelete, "/endpoints/"+url.PathEscape(e.ID), nil, nil)
+}
+
+// The parts of an endpoint resource used by RemoteEndpoint.
+type apiEndpoint struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	PublicURL string    `json:"public_url"`
+	Proto     string    `json:"proto"`
+	Type      string    `json:"type"`
+	Metadata  string    `json:"metadata"`
+	CreatedAt time.Time `json:"created_at"`
+	Tunnel    *struct {
+		ID string `json:"id"`
+	} `json:"tunnel"`
+	TunnelSession *struct {
+		ID string `json:"id"`
+	} `json:"tunnel_session"`
+}
+
+func (s *sessionImpl) RemoteEndpoints(ctx context.Context) ([]*RemoteEndpoint, error) {
+	if s.api == nil {
+		return nil, errMissingAPIKey{}
+	}
+
+	// The tunnels of this session, to find the agent session serving them.
+	own := make(map[string]bool)
+	s.tunnelsMu.Lock()
+	for t := range s.tunnels {
+		own[t.ID()] = true
+	}
+	s.tunnelsMu.Unlock()
+	ownSessions := make(map[string]bool)
+
+	var endpoints []*RemoteEndpoint
+	for page := "/endpoints"; page != ""; {
+		var resp struct {
+			Endpoints   []apiEndpoint `json:"endpoints"`
+			NextPageURI string        `json:"next_page_uri"`
+		}
+		if err := s.api.do(ctx, http.MethodGet, page, nil, &resp); err != nil {
+			return nil, errRemoteEndpoints{err}
+		}
+		for _, ep := range resp.Endpoints {
+			endpoint := &RemoteEndpoint{
+				ID:        ep.ID,
+				URL:       ep.URL,
+				Proto:     ep.Proto,
+				Type:      ep.Type,
+				Metadata:  ep.Metadata,
+				CreatedAt: ep.CreatedAt,
+				api:       s.api,
+			}
+			if endpoint.URL == "" {
+				endpoint.URL = ep.PublicURL
+			}
+			if ep.Tunnel != nil {
+				endpoint.TunnelID = ep.Tunnel.ID
+			}
+			if ep.TunnelSession != nil {
+				endpoint.TunnelSessionID = ep.TunnelSession.ID
+				if own[endpoint.TunnelID] {
+					ownSessions[endpoint.TunnelSessionID] = true
+				}
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		page = resp.NextPageURI
+	}
+	for _, endpoint := range endpoints {
+		endpoint.ownSession = ownSessions[endpoint.TunnelSessionID]
+	}
+	return endpoints, nil
+}
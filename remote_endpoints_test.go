@@ -0,0 +1,107 @@
+package ngrok
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteEndpoints(t *testing.T) {
+	var calls []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		calls = append(calls, r.Method+" "+r.URL.RequestURI())
+		switch r.URL.RequestURI() {
+		case "/endpoints":
+			_, _ = w.Write([]byte(`{"endpoints":[{"id":"ep_1","public_url":"https://a.ngrok.app","proto":"https","type":"ephemeral",` +
+				`"tunnel":{"id":"tn_1"},"tunnel_session":{"id":"ts_1"}}],"next_page_uri":"` + srv.URL + `/endpoints?before_id=ep_1"}`))
+		case "/endpoints?before_id=ep_1":
+			_, _ = w.Write([]byte(`{"endpoints":[{"id":"ep_2","url":"https://b.ngrok.app","proto":"https","type":"cloud"}],"next_page_uri":null}`))
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	sess := &sessionImpl{}
+	_, err := sess.RemoteEndpoints(context.Background())
+	require.ErrorIs(t, err, errMissingAPIKey{})
+
+	sess.api = newAPIClient("key")
+	sess.api.baseURL = srv.URL
+	endpoints, err := sess.RemoteEndpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 2)
+	require.Equal(t, "https://a.ngrok.app", endpoints[0].URL)
+	require.Equal(t, "tn_1", endpoints[0].TunnelID)
+	require.Equal(t, "ts_1", endpoints[0].TunnelSessionID)
+	require.Equal(t, "https://b.ngrok.app", endpoints[1].URL)
+	require.Equal(t, "cloud", endpoints[1].Type)
+
+	require.NoError(t, endpoints[0].Disconnect(context.Background()))
+	require.NoError(t, endpoints[1].Disconnect(context.Background()))
+	require.Equal(t, []string{
+		"GET /endpoints",
+		"GET /endpoints?before_id=ep_1",
+		"POST /tunnel_sessions/ts_1/stop",
+		"DELETE /endpoints/ep_2",
+	}, calls)
+}
+
+func TestRemoteEndpointsForeignNextPage(t *testing.T) {
+	leaked := make(chan string, 1)
+	foreign := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked <- r.Header.Get("Authorization")
+	}))
+	defer foreign.Close()
+
+	for _, next := range []string{foreign.URL + "/endpoints", "//" + foreign.Listener.Addr().String() + "/endpoints"} {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"endpoints":[],"next_page_uri":"` + next + `"}`))
+		}))
+		sess := &sessionImpl{api: newAPIClient("key")}
+		sess.api.baseURL = srv.URL
+		_, err := sess.RemoteEndpoints(context.Background())
+		srv.Close()
+		require.ErrorIs(t, err, ErrRemoteEndpoints, next)
+		require.ErrorContains(t, err, "refusing to send the API key", next)
+	}
+	require.Empty(t, leaked)
+}
+
+func TestRemoteEndpointsOwnSession(t *testing.T) {
+	var calls []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.RequestURI())
+		if r.URL.Path == "/endpoints" {
+			_, _ = w.Write([]byte(`{"endpoints":[` +
+				`{"id":"ep_1","tunnel":{"id":"tn_mine"},"tunnel_session":{"id":"ts_mine"}},` +
+				`{"id":"ep_2","tunnel":{"id":"tn_other"},"tunnel_session":{"id":"ts_mine"}},` +
+				`{"id":"ep_3","tunnel":{"id":"tn_3"},"tunnel_session":{"id":"ts_orphan"}}]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	sess := &sessionImpl{
+		api:     newAPIClient("key"),
+		tunnels: map[*tunnelImpl]struct{}{{Tunnel: &idTunnel{id: "tn_mine"}}: {}},
+	}
+	sess.api.baseURL = srv.URL
+	endpoints, err := sess.RemoteEndpoints(context.Background())
+	require.NoError(t, err)
+	require.Len(t, endpoints, 3)
+
+	// Every endpoint of the session's own agent session is refused, since
+	// disconnecting any of them would stop it.
+	for _, ep := range endpoints[:2] {
+		require.ErrorIs(t, ep.Disconnect(context.Background()), ErrDisconnectOwnSession)
+	}
+	require.NoError(t, endpoints[2].Disconnect(context.Background()))
+	require.Equal(t, []string{"GET /endpoints", "POST /tunnel_sessions/ts_orphan/stop"}, calls)
+}
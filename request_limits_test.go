@@ -0,0 +1,87 @@
+package ngrok
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestRequestLimits(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+	fwd, err := sess.ListenAndForward(ctx, target, config.HTTPEndpoint(config.WithRequestLimits(config.RequestLimits{
+		MaxHeaderBytes:    4 << 10,
+		MaxBodyBytes:      16,
+		ReadHeaderTimeout: 100 * time.Millisecond,
+	})))
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	post := func(body io.Reader) (int, string) {
+		resp, err := client.Post(fwd.URL(), "text/plain", body)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		respBody, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(respBody)
+	}
+
+	status, body := post(strings.NewReader("small"))
+	require.Equal(t, http.StatusOK, status)
+	require.Equal(t, "small", body)
+
+	// Too large by its declared length.
+	status, _ = post(strings.NewReader(strings.Repeat("x", 17)))
+	require.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	// Too large once it's read, without a declared length.
+	status, _ = post(io.MultiReader(strings.NewReader(strings.Repeat("x", 64))))
+	require.Equal(t, http.StatusRequestEntityTooLarge, status)
+
+	// Headers past the limit, plus the slack net/http allows. Sending no
+	// more than that leaves nothing unread that would reset the connection.
+	conn := dialTunnel(t, fwd)
+	head := "GET / HTTP/1.1\r\nX-Large: "
+	_, err = io.WriteString(conn, head+strings.Repeat("x", 8<<10-len(head)))
+	require.NoError(t, err)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusRequestHeaderFieldsTooLarge, resp.StatusCode)
+
+	// A client that never finishes its headers is disconnected.
+	conn = dialTunnel(t, fwd)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\n")
+	require.NoError(t, err)
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = http.ReadResponse(bufio.NewReader(conn), nil)
+	require.Error(t, err)
+	var netErr net.Error
+	require.False(t, errors.As(err, &netErr) && netErr.Timeout(), "connection was left open")
+}
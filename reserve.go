@@ -0,0 +1,222 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	neturl "net/url"
+	"strings"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// The ngrok error code returned when starting a tunnel with a domain that
+// isn't reserved on the account.
+const errCodeDomainNotReserved = "ERR_NGROK_320"
+
+// DomainReserver reserves domains on an ngrok account. It is used by
+// [WithAutoReserveDomain] to reserve domains on demand.
+type DomainReserver interface {
+	// ReserveDomain reserves the fully-qualified domain name.
+	ReserveDomain(ctx context.Context, domain string) error
+}
+
+// WithAutoReserveDomain configures the session to reserve domains with the
+// provided [DomainReserver] when a tunnel is started with a domain that isn't
+// yet reserved on the account, rather than failing. The tunnel is started
+// again once the domain is reserved.
+//
+// Only the domains of HTTP and TLS endpoints are reserved. TCP endpoints that
+// request an address that isn't reserved still fail, since the ngrok API
+// assigns reserved TCP addresses rather than reserving a requested one.
+//
+// Use [NewAPIDomainReserver] to reserve domains with the ngrok API.
+func WithAutoReserveDomain(reserver DomainReserver) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.DomainReserver = reserver
+	}
+}
+
+// NewAPIDomainReserver returns a [DomainReserver] that reserves domains with
+// the [ngrok API] using the provided API key. Note that this is an API key,
+// not an authtoken.
+//
+// [ngrok API]: https://ngrok.com/docs/api/resources/reserved-domains
+func NewAPIDomainReserver(apiKey string) DomainReserver {
+	return &apiDomainReserver{newAPIClient(apiKey)}
+}
+
+type apiDomainReserver struct {
+	*apiClient
+}
+
+func (r *apiDomainReserver) ReserveDomain(ctx context.Context, domain string) error {
+	return r.do(ctx, http.MethodPost, "/reserved_domains", map[string]string{"domain": domain}, nil)
+}
+
+// Get the domain requested by a tunnel's bind options, if any.
+func requestedDomain(opts any) string {
+	switch opts := opts.(type) {
+	case *proto.HTTPEndpoint:
+		return opts.Domain
+	case *proto.TLSEndpoint:
+		return opts.Domain
+	}
+	return ""
+}
+
+// Get the traffic policy of a tunnel's bind options, if any.
+func requestedTrafficPolicy(opts any) string {
+	switch opts := opts.(type) {
+	case *proto.HTTPEndpoint:
+		return opts.TrafficPolicy
+	case *proto.TLSEndpoint:
+		return opts.TrafficPolicy
+	case *proto.TCPEndpoint:
+		return opts.TrafficPolicy
+	}
+	return ""
+}
+
+// Whether the ngrok service refused to bind an endpoint, as opposed to the
+// request failing to reach it.
+func isBindRefused(err error) bool {
+	var nerr Error
+	return errors.As(err, &nerr)
+}
+
+// Copy a tunnel's bind options, requesting a different URL. The empty string
+// requests a random URL.
+func withURL(opts any, url string) any {
+	switch opts := opts.(type) {
+	case *proto.HTTPEndpoint:
+		out := *opts
+		out.URL, out.Domain, out.Hostname, out.Subdomain = url, "", "", ""
+		return &out
+	case *proto.TLSEndpoint:
+		out := *opts
+		out.URL, out.Domain, out.Hostname, out.Subdomain = url, "", "", ""
+		return &out
+	case *proto.TCPEndpoint:
+		out := *opts
+		out.URL, out.Addr = url, ""
+		return &out
+	}
+	return opts
+}
+
+func isDomainNotReserved(err error) bool {
+	var nerr Error
+	return errors.As(err, &nerr) && nerr.ErrorCode() == errCodeDomainNotReserved
+}
+
+// Identify the URL requested by a tunnel's bind options, so that tunnels
+// bound to the same one can be found. Returns the empty string for tunnels
+// that get a random URL.
+func bindKey(tunnelProto string, opts any) string {
+	var url, host string
+	switch opts := opts.(type) {
+	case *proto.HTTPEndpoint:
+		url, host = opts.URL, firstNonEmpty(opts.Domain, opts.Hostname, opts.Subdomain)
+	case *proto.TLSEndpoint:
+		url, host = opts.URL, firstNonEmpty(opts.Domain, opts.Hostname, opts.Subdomain)
+	case *proto.TCPEndpoint:
+		url, host = opts.URL, opts.Addr
+	}
+	if url != "" {
+		host = url
+		if parsed, err := neturl.Parse(url); err == nil && parsed.Host != "" {
+			host = parsed.Host
+		}
+	}
+	if host == "" {
+		return ""
+	}
+	return tunnelProto + "://" + strings.ToLower(host)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// A bind in progress, which holds its key until it finishes.
+type pendingBind struct {
+	pooled bool
+	done   chan struct{}
+}
+
+// Reserve the key of a tunnel about to be bound, so that a Listen for the
+// same URL that's made meanwhile waits for this one to finish rather than
+// binding it as well. Returns instead the tunnel already bound to the key, if
+// it conflicts, or the context's error if it's done while waiting for another
+// bind. Release must be called once the tunnel has been added to the session,
+// or failed to bind.
+func (s *sessionImpl) reserveBind(ctx context.Context, key string, pooled bool) (existing *tunnelImpl, release func(), err error) {
+	if key == "" {
+		return nil, func() {}, nil
+	}
+	s.tunnelsMu.Lock()
+	for {
+		if t := s.boundTunnel(key, pooled); t != nil {
+			s.tunnelsMu.Unlock()
+			return t, nil, nil
+		}
+		var pending *pendingBind
+		for _, b := range s.binds[key] {
+			if !(pooled && b.pooled) {
+				pending = b
+				break
+			}
+		}
+		if pending == nil {
+			break
+		}
+		s.tunnelsMu.Unlock()
+		select {
+		case <-pending.done:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+		s.tunnelsMu.Lock()
+	}
+	bind := &pendingBind{pooled: pooled, done: make(chan struct{})}
+	if s.binds == nil {
+		s.binds = make(map[string][]*pendingBind)
+	}
+	s.binds[key] = append(s.binds[key], bind)
+	s.tunnelsMu.Unlock()
+
+	return nil, func() {
+		s.tunnelsMu.Lock()
+		binds := s.binds[key]
+		for i, b := range binds {
+			if b == bind {
+				binds = append(binds[:i], binds[i+1:]...)
+				break
+			}
+		}
+		if len(binds) == 0 {
+			delete(s.binds, key)
+		} else {
+			s.binds[key] = binds
+		}
+		s.tunnelsMu.Unlock()
+		close(bind.done)
+	}, nil
+}
+
+// Find the session's tunnel bound to the same URL as one that's about to be
+// started, unless both allow pooling. The tunnels lock must be held.
+func (s *sessionImpl) boundTunnel(key string, pooled bool) *tunnelImpl {
+	for t := range s.tunnels {
+		if t.bindKey == key && !(pooled && t.pooled) && !t.closed.Load() {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package ngrok
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+func TestAPIDomainReserver(t *testing.T) {
+	var domain string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/reserved_domains", r.URL.Path)
+		require.Equal(t, "Bearer key", r.Header.Get("Authorization"))
+		require.Equal(t, "2", r.Header.Get("Ngrok-Version"))
+
+		var body map[string]string
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		if body["domain"] == "taken.ngrok.app" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error_code":"ERR_NGROK_413","status_code":400,"msg":"domain is taken"}`))
+			return
+		}
+		domain = body["domain"]
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	reserver := NewAPIDomainReserver("key").(*apiDomainReserver)
+	reserver.baseURL = srv.URL
+
+	require.NoError(t, reserver.ReserveDomain(context.Background(), "mine.ngrok.app"))
+	require.Equal(t, "mine.ngrok.app", domain)
+
+	err := reserver.ReserveDomain(context.Background(), "taken.ngrok.app")
+	var nerr Error
+	require.ErrorAs(t, err, &nerr)
+	require.Equal(t, "ERR_NGROK_413", nerr.ErrorCode())
+	require.Equal(t, "domain is taken", nerr.Msg())
+}
+
+func TestDomainNotReserved(t *testing.T) {
+	require.True(t, isDomainNotReserved(errListen{proto.StringError("domain not reserved\n\nERR_NGROK_320")}))
+	require.False(t, isDomainNotReserved(errListen{proto.StringError("other\n\nERR_NGROK_321")}))
+
+	tunnelCfg := config.HTTPEndpoint(config.WithDomain("mine.ngrok.app")).(tunnelConfigPrivate)
+	require.Equal(t, "mine.ngrok.app", requestedDomain(tunnelCfg.Opts()))
+	tunnelCfg = config.TCPEndpoint().(tunnelConfigPrivate)
+	require.Equal(t, "", requestedDomain(tunnelCfg.Opts()))
+}
+
+// A client session that only binds the URLs it's told are free, recording
+// each URL or domain requested.
+type bindingSession struct {
+	tunnel_client.Session
+	free      map[string]bool
+	requested []string
+	err       error
+}
+
+func (s *bindingSession) Listen(_ string, opts any, _ proto.BindExtra, _ string, _ string, _ string) (tunnel_client.Tunnel, error) {
+	endpoint := opts.(*proto.HTTPEndpoint)
+	s.requested = append(s.requested, endpoint.URL+endpoint.Domain)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if !s.free[endpoint.URL+endpoint.Domain] {
+		return nil, proto.StringError("endpoint is already online\n\nERR_NGROK_334")
+	}
+	return &urlTunnel{}, nil
+}
+
+func TestURLFallback(t *testing.T) {
+	bind := &bindingSession{free: map[string]bool{"": true}}
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{Session: bind, Logger: slog.New(discardHandler{})})
+
+	cfg := config.HTTPEndpoint(
+		config.WithDomain("mine.ngrok.app"),
+		config.WithURLFallback("https://backup.ngrok.app", ""),
+	)
+	tun, err := sess.listen(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NotNil(t, tun)
+	require.Equal(t, []string{"mine.ngrok.app", "https://backup.ngrok.app", ""}, bind.requested)
+
+	// Errors that don't come from the ngrok service aren't retried.
+	bind = &bindingSession{err: errors.New("session closed")}
+	sess.setInner(&sessionInner{Session: bind, Logger: slog.New(discardHandler{})})
+	_, err = sess.listen(context.Background(), cfg)
+	require.Error(t, err)
+	require.Equal(t, []string{"mine.ngrok.app"}, bind.requested)
+}
+
+func TestURLFallbackAlreadyBound(t *testing.T) {
+	bind := &bindingSession{free: map[string]bool{"https://backup.ngrok.app": true, "": true}}
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{Session: bind, Logger: slog.New(discardHandler{})})
+	_, err := sess.listen(context.Background(), config.HTTPEndpoint(config.WithURL("https://backup.ngrok.app")))
+	require.NoError(t, err)
+	bind.requested = nil
+
+	// A fallback URL the session already serves is skipped without asking
+	// the ngrok service for it.
+	cfg := config.HTTPEndpoint(
+		config.WithDomain("mine.ngrok.app"),
+		config.WithURLFallback("https://backup.ngrok.app", ""),
+	)
+	tun, err := sess.listen(context.Background(), cfg)
+	require.NoError(t, err)
+	require.NotNil(t, tun)
+	require.Equal(t, []string{"mine.ngrok.app", ""}, bind.requested)
+
+	// And reported as such if it's the last.
+	bind.requested = nil
+	_, err = sess.listen(context.Background(), config.HTTPEndpoint(
+		config.WithDomain("mine.ngrok.app"),
+		config.WithURLFallback("https://backup.ngrok.app"),
+	))
+	require.ErrorIs(t, err, ErrEndpointAlreadyBound)
+	require.Equal(t, []string{"mine.ngrok.app"}, bind.requested)
+	require.Empty(t, sess.binds)
+}
+
+func TestBindKey(t *testing.T) {
+	require.Equal(t, "http://app.ngrok.test", bindKey("http", &proto.HTTPEndpoint{Domain: "App.ngrok.test"}))
+	require.Equal(t, "http://app.ngrok.test", bindKey("http", &proto.HTTPEndpoint{URL: "https://app.ngrok.test"}))
+	require.Equal(t, "tls://app.ngrok.test", bindKey("tls", &proto.TLSEndpoint{Hostname: "app.ngrok.test"}))
+	require.Equal(t, "tcp://1.tcp.ngrok.test:20000", bindKey("tcp", &proto.TCPEndpoint{URL: "tcp://1.tcp.ngrok.test:20000"}))
+	require.Empty(t, bindKey("http", &proto.HTTPEndpoint{}))
+	require.Empty(t, bindKey("tcp", &proto.TCPEndpoint{}))
+}
@@ -0,0 +1,100 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long an upstream address that failed to connect is skipped, unless no
+// other addresses are available.
+const roundRobinFailureCooldown = 10 * time.Second
+
+// Dials hostnames by rotating through the addresses they resolve to for
+// successive connections. Addresses that recently failed are tried last.
+type roundRobinDialer struct {
+	dial   func(ctx context.Context, network, address string) (net.Conn, error)
+	lookup func(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	next atomic.Uint64
+
+	mu     sync.Mutex
+	failed map[string]time.Time
+}
+
+func newRoundRobinDialer(dial func(ctx context.Context, network, address string) (net.Conn, error), resolver *net.Resolver) *roundRobinDialer {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return &roundRobinDialer{
+		dial:   dial,
+		lookup: resolver.LookupIPAddr,
+		failed: make(map[string]time.Time),
+	}
+}
+
+func (d *roundRobinDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || network == "unix" || net.ParseIP(host) != nil {
+		return d.dial(ctx, network, address)
+	}
+
+	addrs, err := d.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for _, ip := range d.order(addrs) {
+		addr := net.JoinHostPort(ip.String(), port)
+		conn, err := d.dial(ctx, network, addr)
+		if err == nil {
+			d.markHealthy(addr)
+			return conn, nil
+		}
+		errs = append(errs, err)
+		d.markFailed(addr)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Rotate the addresses to start from the next one in turn, then move any
+// that recently failed to the end.
+func (d *roundRobinDialer) order(addrs []net.IPAddr) []net.IPAddr {
+	start := int(d.next.Add(1)-1) % len(addrs)
+	rotated := append(append([]net.IPAddr{}, addrs[start:]...), addrs[:start]...)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	healthy := make([]net.IPAddr, 0, len(rotated))
+	var failed []net.IPAddr
+	for _, ip := range rotated {
+		at, ok := d.failed[ip.String()]
+		if ok && time.Since(at) < roundRobinFailureCooldown {
+			failed = append(failed, ip)
+		} else {
+			healthy = append(healthy, ip)
+		}
+	}
+	return append(healthy, failed...)
+}
+
+func (d *roundRobinDialer) markFailed(addr string) {
+	host, _, _ := net.SplitHostPort(addr)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failed[host] = time.Now()
+}
+
+func (d *roundRobinDialer) markHealthy(addr string) {
+	host, _, _ := net.SplitHostPort(addr)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.failed, host)
+}
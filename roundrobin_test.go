@@ -0,0 +1,57 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundRobinDialer(t *testing.T) {
+	var dialed []string
+	down := map[string]bool{}
+	d := newRoundRobinDialer(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = append(dialed, address)
+		if down[address] {
+			return nil, errors.New("connection refused")
+		}
+		local, _ := net.Pipe()
+		return local, nil
+	}, nil)
+	d.lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		require.Equal(t, "backend.internal", host)
+		return []net.IPAddr{
+			{IP: net.ParseIP("10.0.0.1")},
+			{IP: net.ParseIP("10.0.0.2")},
+			{IP: net.ParseIP("10.0.0.3")},
+		}, nil
+	}
+
+	dial := func() {
+		conn, err := d.DialContext(context.Background(), "tcp", "backend.internal:80")
+		require.NoError(t, err)
+		conn.Close()
+	}
+
+	for i := 0; i < 4; i++ {
+		dial()
+	}
+	require.Equal(t, []string{"10.0.0.1:80", "10.0.0.2:80", "10.0.0.3:80", "10.0.0.1:80"}, dialed)
+
+	// A failed address is skipped over on the next attempt and then avoided.
+	down["10.0.0.2:80"] = true
+	dialed = nil
+	dial()
+	dial()
+	dial()
+	require.Equal(t, []string{"10.0.0.2:80", "10.0.0.3:80", "10.0.0.3:80", "10.0.0.1:80"}, dialed)
+
+	// Addresses are passed through untouched.
+	dialed = nil
+	dial2, err := d.DialContext(context.Background(), "tcp", "10.0.0.9:80")
+	require.NoError(t, err)
+	dial2.Close()
+	require.Equal(t, []string{"10.0.0.9:80"}, dialed)
+}
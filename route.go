@@ -0,0 +1,255 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// Find the route for a host or server name. Exact names take precedence over
+// wildcards like "*.example.com", which match a single leading label.
+func matchRoute[T any](routes map[string]T, name string) (T, bool) {
+	var zero T
+	if name == "" {
+		return zero, false
+	}
+	name = strings.ToLower(name)
+	if route, ok := routes[name]; ok {
+		return route, true
+	}
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if route, ok := routes["*."+parent]; ok {
+			return route, true
+		}
+	}
+	return zero, false
+}
+
+// Build a handler that proxies each request to the upstream routed to by its
+// Host header, or to the forwarding URL if no route matches. Failures to
+// connect to upstreams are reported to onError, if set.
+func newHostRouter(logger *slog.Logger, fwdURL *url.URL, opts upstream.Options, onError func(*url.URL, error)) http.Handler {
+	proxies := make(map[string]http.Handler, len(opts.HostRoutes))
+	for host, target := range opts.HostRoutes {
+		proxies[host] = newUpstreamProxy(logger, target, opts, onError)
+	}
+	fallback := newUpstreamProxy(logger, fwdURL, opts, onError)
+	selected := &selectedProxies{proxies: map[string]*selectedProxy{}}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Selector != nil {
+			if target := opts.Selector(r); target != "" {
+				proxy, err := selected.get(target, func(u *url.URL) http.Handler {
+					return newUpstreamProxy(logger, u, opts, onError)
+				})
+				if err != nil {
+					logger.Warn("upstream selector chose an invalid url", "url", target, "error", err)
+					http.Error(w, "invalid upstream", http.StatusBadGateway)
+					return
+				}
+				proxy.ServeHTTP(w, r)
+				return
+			}
+		}
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		proxy, ok := matchRoute(proxies, host)
+		if !ok {
+			proxy = fallback
+		}
+		proxy.ServeHTTP(w, r)
+	})
+}
+
+// The most proxies kept for the upstreams chosen by an upstream selector.
+// Selectors choosing among more upstreams still work, but the least recently
+// chosen upstreams' proxies are rebuilt, and their connections remade.
+const maxSelectedProxies = 64
+
+// The proxies to the upstreams most recently chosen by an upstream selector,
+// by URL.
+type selectedProxies struct {
+	mu      sync.Mutex
+	proxies map[string]*selectedProxy
+	// Incremented each time a proxy is chosen, to find the least recent.
+	uses uint64
+}
+
+type selectedProxy struct {
+	handler  http.Handler
+	lastUsed uint64
+}
+
+// Get the proxy to an upstream URL, building it the first time it's chosen,
+// and discarding the least recently chosen proxy to make room if need be.
+func (p *selectedProxies) get(target string, build func(*url.URL) http.Handler) (http.Handler, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.uses++
+	if proxy, ok := p.proxies[target]; ok {
+		proxy.lastUsed = p.uses
+		return proxy.handler, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" && !isUnix(u.Scheme) {
+		return nil, fmt.Errorf("no host in upstream url %q", target)
+	}
+	if len(p.proxies) >= maxSelectedProxies {
+		p.evict()
+	}
+	proxy := &selectedProxy{handler: build(u), lastUsed: p.uses}
+	p.proxies[target] = proxy
+	return proxy.handler, nil
+}
+
+// Discard the least recently chosen proxy, closing its idle connections.
+// Requests it's still serving are unaffected. The lock must be held.
+func (p *selectedProxies) evict() {
+	var oldest string
+	for target, proxy := range p.proxies {
+		if oldest == "" || proxy.lastUsed < p.proxies[oldest].lastUsed {
+			oldest = target
+		}
+	}
+	if rp, ok := p.proxies[oldest].handler.(*httputil.ReverseProxy); ok {
+		if closer, ok := rp.Transport.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+	delete(p.proxies, oldest)
+}
+
+// Build a reverse proxy to a single upstream. The Host header of the original
+// request is preserved.
+func newUpstreamProxy(logger *slog.Logger, target *url.URL, opts upstream.Options, onError func(*url.URL, error)) http.Handler {
+	dial := upstreamDial(opts)
+
+	proxyURL := *target
+	transport := &http.Transport{
+		DialContext:       dial,
+		ForceAttemptHTTP2: true,
+	}
+	switch {
+	case isUnix(target.Scheme):
+		path := target.Path
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dial(ctx, "unix", path)
+		}
+		proxyURL = url.URL{Scheme: "http", Host: "localhost"}
+	case usesTLS(target.Scheme):
+		proxyURL.Scheme = "https"
+		transport.TLSClientConfig = upstreamTLSConfig(opts, target.Hostname())
+	default:
+		proxyURL.Scheme = "http"
+	}
+
+	return &httputil.ReverseProxy{
+		Rewrite: func(r *httputil.ProxyRequest) {
+			r.SetURL(&proxyURL)
+			r.Out.Host = r.In.Host
+			setForwardedHeaders(r, opts.ForwardedHeaders)
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			logger.Warn("failed to connect to backend url", "url", target, "error", err)
+			if onError != nil {
+				onError(target, err)
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			fmt.Fprintf(w, "failed to connect to backend: %s", err.Error())
+		},
+	}
+}
+
+// The context key of the tunnel connection that a request proxied by
+// [forwardHTTP] arrived on.
+type tunnelConnKey struct{}
+
+// The entry added to the Via header of proxied requests.
+func viaEntry(r *http.Request) string {
+	if r.ProtoMajor >= 2 {
+		return fmt.Sprintf("%d ngrok-go", r.ProtoMajor)
+	}
+	return fmt.Sprintf("%d.%d ngrok-go", r.ProtoMajor, r.ProtoMinor)
+}
+
+// Set the Via and X-Forwarded-* headers of a proxied request. The client
+// address and protocol come from the proxy header of the tunnel connection the
+// request arrived on, if known.
+func setForwardedHeaders(r *httputil.ProxyRequest, mode upstream.ForwardedHeadersMode) {
+	if mode == upstream.ForwardedHeadersNone {
+		return
+	}
+
+	clientIP := r.In.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+	proto := "http"
+	if r.In.TLS != nil {
+		proto = "https"
+	}
+	if conn, ok := r.In.Context().Value(tunnelConnKey{}).(Conn); ok && conn.Proto() != "" {
+		proto = conn.Proto()
+	}
+
+	forwardedFor, host, via := clientIP, r.In.Host, viaEntry(r.In)
+	// ReverseProxy removes these from the outgoing request before Rewrite
+	// is called, but leaves Via.
+	r.Out.Header.Del("Via")
+	if mode == upstream.ForwardedHeadersAppend {
+		if prior := r.In.Header.Values("X-Forwarded-For"); len(prior) > 0 {
+			forwardedFor = strings.Join(prior, ", ") + ", " + forwardedFor
+		}
+		if prior := r.In.Header.Values("Via"); len(prior) > 0 {
+			via = strings.Join(prior, ", ") + ", " + via
+		}
+		if prior := r.In.Header.Get("X-Forwarded-Host"); prior != "" {
+			host = prior
+		}
+		if prior := r.In.Header.Get("X-Forwarded-Proto"); prior != "" {
+			proto = prior
+		}
+	}
+	r.Out.Header.Set("X-Forwarded-For", forwardedFor)
+	r.Out.Header.Set("X-Forwarded-Host", host)
+	r.Out.Header.Set("X-Forwarded-Proto", proto)
+	r.Out.Header.Set("Via", via)
+}
+
+// Whether any limits are set on the requests proxied to HTTP upstreams.
+func limitsRequests(opts upstream.Options) bool {
+	return opts.MaxHeaderBytes > 0 || opts.MaxBodyBytes > 0 || opts.ReadHeaderTimeout > 0 || opts.ReadTimeout > 0
+}
+
+// Reject requests with bodies larger than max, whether their length is
+// declared up front or only found while they're proxied.
+func limitRequestBody(next http.Handler, max int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		next.ServeHTTP(w, r)
+	})
+}
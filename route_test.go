@@ -0,0 +1,216 @@
+package ngrok
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func TestMatchRoute(t *testing.T) {
+	routes := map[string]string{
+		"api.example.com": "localhost:8443",
+		"*.example.com":   "localhost:9443",
+	}
+	for serverName, expected := range map[string]string{
+		"api.example.com":      "localhost:8443",
+		"API.Example.com":      "localhost:8443",
+		"www.example.com":      "localhost:9443",
+		"a.b.example.com":      "",
+		"example.com":          "",
+		"":                     "",
+		"www.example.com.evil": "",
+	} {
+		addr, ok := matchRoute(routes, serverName)
+		require.Equal(t, expected != "", ok, serverName)
+		require.Equal(t, expected, addr, serverName)
+	}
+}
+
+func TestHostRouter(t *testing.T) {
+	backend := func(name string) *url.URL {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, r.Host)
+		}))
+		t.Cleanup(srv.Close)
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		return u
+	}
+
+	opts := upstream.Options{HostRoutes: map[string]*url.URL{
+		"api.example.ngrok.app":  backend("api"),
+		"*.example.ngrok.app":    backend("wildcard"),
+		"down.example.ngrok.app": {Scheme: "http", Host: "127.0.0.1:1"},
+	}}
+	frontend := httptest.NewServer(newHostRouter(slog.New(discardHandler{}), backend("fallback"), opts, nil))
+	defer frontend.Close()
+
+	get := func(host string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, frontend.URL, nil)
+		require.NoError(t, err)
+		req.Host = host
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	for host, expected := range map[string]string{
+		"api.example.ngrok.app":     "api api.example.ngrok.app",
+		"API.example.ngrok.app:443": "api API.example.ngrok.app:443",
+		"www.example.ngrok.app":     "wildcard www.example.ngrok.app",
+		"other.ngrok.app":           "fallback other.ngrok.app",
+	} {
+		status, body := get(host)
+		require.Equal(t, http.StatusOK, status, host)
+		require.Equal(t, expected, body)
+	}
+
+	status, body := get("down.example.ngrok.app")
+	require.Equal(t, http.StatusBadGateway, status)
+	require.Contains(t, body, "failed to connect to backend")
+}
+
+func TestUpstreamSelector(t *testing.T) {
+	backend := func(name string) *url.URL {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "%s %s", name, r.URL.Path)
+		}))
+		t.Cleanup(srv.Close)
+		u, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+		return u
+	}
+
+	canary := backend("canary")
+	opts := upstream.Options{
+		HostRoutes: map[string]*url.URL{"api.example.ngrok.app": backend("api")},
+		Selector: func(r *http.Request) string {
+			switch {
+			case r.Header.Get("X-Canary") == "1":
+				return canary.String()
+			case r.URL.Path == "/invalid":
+				return "http://%zz"
+			}
+			return ""
+		},
+	}
+	frontend := httptest.NewServer(newHostRouter(slog.New(discardHandler{}), backend("stable"), opts, nil))
+	defer frontend.Close()
+
+	get := func(host, path string, canary bool) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, frontend.URL+path, nil)
+		require.NoError(t, err)
+		req.Host = host
+		if canary {
+			req.Header.Set("X-Canary", "1")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	// The selector takes precedence over host routes, and falls back to
+	// them when it doesn't choose.
+	for _, tc := range []struct {
+		host     string
+		canary   bool
+		expected string
+	}{
+		{"app.ngrok.app", false, "stable /a"},
+		{"app.ngrok.app", true, "canary /a"},
+		{"api.example.ngrok.app", false, "api /a"},
+		{"api.example.ngrok.app", true, "canary /a"},
+	} {
+		status, body := get(tc.host, "/a", tc.canary)
+		require.Equal(t, http.StatusOK, status)
+		require.Equal(t, tc.expected, body)
+	}
+
+	status, _ := get("app.ngrok.app", "/invalid", false)
+	require.Equal(t, http.StatusBadGateway, status)
+}
+
+func TestForwardedHeaders(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+	}))
+	defer backend.Close()
+	target, err := url.Parse(backend.URL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	get := func(mode config.ForwardedHeadersMode) http.Header {
+		fwd, err := sess.ListenAndForward(ctx, target, config.HTTPEndpoint(config.WithForwardedHeaders(mode)))
+		require.NoError(t, err)
+		defer fwd.Close()
+
+		req, err := http.NewRequest(http.MethodGet, fwd.URL(), nil)
+		require.NoError(t, err)
+		req.Host = "app.example.com"
+		req.Header.Set("X-Forwarded-For", "203.0.113.7")
+		req.Header.Set("X-Forwarded-Proto", "http")
+		req.Header.Set("Via", "1.1 edge")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		return <-headers
+	}
+
+	h := get(config.ForwardedHeadersReplace)
+	require.Equal(t, "127.0.0.1", h.Get("X-Forwarded-For"))
+	// The endpoint's protocol, not the one the client claimed.
+	require.Equal(t, "https", h.Get("X-Forwarded-Proto"))
+	require.Equal(t, "app.example.com", h.Get("X-Forwarded-Host"))
+	require.Equal(t, []string{"1.1 ngrok-go"}, h.Values("Via"))
+
+	h = get(config.ForwardedHeadersAppend)
+	require.Equal(t, "203.0.113.7, 127.0.0.1", h.Get("X-Forwarded-For"))
+	require.Equal(t, "http", h.Get("X-Forwarded-Proto"))
+	require.Equal(t, "app.example.com", h.Get("X-Forwarded-Host"))
+	require.Equal(t, []string{"1.1 edge, 1.1 ngrok-go"}, h.Values("Via"))
+}
+
+func TestSelectedProxiesBounded(t *testing.T) {
+	selected := &selectedProxies{proxies: map[string]*selectedProxy{}}
+	built := 0
+	build := func(*url.URL) http.Handler {
+		built++
+		return http.NotFoundHandler()
+	}
+	target := func(i int) string { return fmt.Sprintf("http://upstream-%d", i) }
+
+	for i := 0; i < maxSelectedProxies; i++ {
+		_, err := selected.get(target(i), build)
+		require.NoError(t, err)
+	}
+	// Choosing the first again makes the second the least recently chosen.
+	_, err := selected.get(target(0), build)
+	require.NoError(t, err)
+	_, err = selected.get(target(maxSelectedProxies), build)
+	require.NoError(t, err)
+	require.Len(t, selected.proxies, maxSelectedProxies)
+	require.Contains(t, selected.proxies, target(0))
+	require.NotContains(t, selected.proxies, target(1))
+	require.Equal(t, maxSelectedProxies+1, built)
+}
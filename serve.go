@@ -0,0 +1,69 @@
+package ngrok
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"sync"
+)
+
+// ServeConns accepts connections from the tunnel and calls handler for each
+// in its own goroutine, running at most workers handlers at a time. While
+// every handler is busy, no more connections are accepted, and new ones wait
+// at the ngrok service. A handler that panics has its connection closed and
+// the panic logged to the session's logger, without stopping the others.
+// Handlers are responsible for closing their connections otherwise.
+//
+// ServeConns returns once the tunnel stops accepting connections and every
+// handler has returned. When the context is done, the tunnel is closed and
+// the context's error is returned.
+func ServeConns(ctx context.Context, tun Tunnel, workers int, handler func(net.Conn)) error {
+	if workers < 1 {
+		return fmt.Errorf("invalid number of workers: %d", workers)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	stop := context.AfterFunc(ctx, func() { _ = tun.Close() })
+	defer stop()
+
+	slots := make(chan struct{}, workers)
+	for {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		conn, err := tun.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			defer recoverHandler(tun, conn)
+			handler(conn)
+		}()
+	}
+}
+
+// Close the connection of a handler that panicked, and log the panic.
+func recoverHandler(tun Tunnel, conn net.Conn) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	_ = conn.Close()
+	if l, ok := tun.(interface{ log(func(*slog.Logger)) }); ok {
+		l.log(func(l *slog.Logger) {
+			l.Error("connection handler panicked", "clientid", tun.ID(), "panic", r, "stack", string(debug.Stack()))
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func listenOffline(t *testing.T) Tunnel {
+	t.Helper()
+	sess, err := Connect(context.Background(), WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = sess.Close() })
+	tun, err := sess.Listen(context.Background(), config.TCPEndpoint())
+	require.NoError(t, err)
+	return tun
+}
+
+func dialTunnel(t *testing.T, tun TunnelInfo) net.Conn {
+	t.Helper()
+	u, err := url.Parse(tun.URL())
+	require.NoError(t, err)
+	conn, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestConcurrentAccept(t *testing.T) {
+	tun := listenOffline(t)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn, err := tun.Accept()
+			if err == nil {
+				conn.Close()
+			}
+			errs <- err
+		}()
+	}
+
+	dialTunnel(t, tun)
+	require.NoError(t, <-errs)
+
+	require.NoError(t, tun.Close())
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.Error(t, err)
+	}
+}
+
+func TestServeConns(t *testing.T) {
+	tun := listenOffline(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		running atomic.Int32
+		handled = make(chan struct{}, 3)
+		release = make(chan struct{})
+	)
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConns(ctx, tun, 2, func(conn net.Conn) {
+			defer conn.Close()
+			running.Add(1)
+			defer running.Add(-1)
+			handled <- struct{}{}
+			<-release
+		})
+	}()
+
+	for i := 0; i < 3; i++ {
+		dialTunnel(t, tun)
+	}
+	<-handled
+	<-handled
+	select {
+	case <-handled:
+		t.Fatal("more handlers ran than workers")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.EqualValues(t, 2, running.Load())
+
+	close(release)
+	<-handled
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
+
+func TestServeConnsRecoversPanics(t *testing.T) {
+	tun := listenOffline(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls atomic.Int32
+	handled := make(chan struct{}, 2)
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeConns(ctx, tun, 1, func(conn net.Conn) {
+			defer func() { handled <- struct{}{} }()
+			if calls.Add(1) == 1 {
+				panic("boom")
+			}
+			conn.Close()
+		})
+	}()
+
+	first := dialTunnel(t, tun)
+	<-handled
+	// The panicking handler's connection was closed.
+	_, err := first.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	dialTunnel(t, tun)
+	<-handled
+
+	require.NoError(t, tun.Close())
+	require.Error(t, <-done)
+}
+
+func TestServeTLS(t *testing.T) {
+	failed := make(chan *EventTLSHandshakeFailed, 1)
+	sess, err := Connect(context.Background(), WithOfflineFallback("127.0.0.1:0"), WithEventHandler(func(ev Event) {
+		if ev, ok := ev.(*EventTLSHandshakeFailed); ok {
+			failed <- ev
+		}
+	}))
+	require.NoError(t, err)
+	defer sess.Close()
+	tun, err := sess.Listen(context.Background(), config.TLSEndpoint())
+	require.NoError(t, err)
+
+	cert := testCert(t, "a.example")
+	roots := x509.NewCertPool()
+	roots.AddCert(cert.Leaf)
+	getCertificate := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if hello.ServerName != "a.example" {
+			return nil, errors.New("unknown server name")
+		}
+		return &cert, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeTLS(ctx, tun, getCertificate, func(conn *tls.Conn) {
+			defer conn.Close()
+			_, _ = io.WriteString(conn, conn.ConnectionState().ServerName)
+		})
+	}()
+
+	client := tls.Client(dialTunnel(t, tun), &tls.Config{ServerName: "a.example", RootCAs: roots})
+	body, err := io.ReadAll(client)
+	require.NoError(t, err)
+	require.Equal(t, "a.example", string(body))
+
+	// Clients asking for names without a certificate fail the handshake.
+	client = tls.Client(dialTunnel(t, tun), &tls.Config{ServerName: "b.example", RootCAs: roots})
+	require.Error(t, client.Handshake())
+	ev := <-failed
+	require.Equal(t, "b.example", ev.ServerName)
+	require.Error(t, ev.Error)
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
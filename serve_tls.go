@@ -0,0 +1,92 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// The time allowed for a client to complete the TLS handshake with
+// [ServeTLS].
+const tlsHandshakeTimeout = 10 * time.Second
+
+// EventTLSHandshakeFailed is emitted when a client's TLS handshake with
+// [ServeTLS] fails, such as when no certificate is available for the server
+// name it requested, or it doesn't trust the one it was offered.
+type EventTLSHandshakeFailed struct {
+	baseEvent
+	Tunnel Tunnel
+	// The address of the client.
+	RemoteAddr net.Addr
+	// The server name requested by the client, if it got that far.
+	ServerName string
+	Error      error
+}
+
+// ServeTLS terminates TLS on the connections of a tunnel carrying end-to-end
+// TLS, such as a config.TLSEndpoint without TLS termination options, and
+// calls handler with each in its own goroutine once its handshake completes.
+// The certificate for each connection is returned by getCertificate, which is
+// passed the server name the client requested, so that one tunnel can serve
+// many domains. Handshakes are done in the background, so a slow client
+// doesn't hold up the others. Those that fail have their connection closed
+// and are reported with an [EventTLSHandshakeFailed], and handlers that panic
+// are recovered as by [ServeConns]. Handlers are responsible for closing
+// their connections otherwise.
+//
+// ServeTLS returns once the tunnel stops accepting connections and every
+// handler has returned. When the context is done, the tunnel is closed and
+// the context's error is returned.
+func ServeTLS(ctx context.Context, tun Tunnel, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), handler func(*tls.Conn)) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	stop := context.AfterFunc(ctx, func() { _ = tun.Close() })
+	defer stop()
+
+	tlsConfig := &tls.Config{GetCertificate: getCertificate}
+	for {
+		conn, err := tun.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tlsConn := tls.Server(conn, tlsConfig)
+			hsCtx, cancel := context.WithTimeout(ctx, tlsHandshakeTimeout)
+			err := tlsConn.HandshakeContext(hsCtx)
+			cancel()
+			if err != nil {
+				_ = conn.Close()
+				if t, ok := tun.(interface {
+					tlsHandshakeFailed(net.Conn, string, error)
+				}); ok {
+					t.tlsHandshakeFailed(conn, tlsConn.ConnectionState().ServerName, err)
+				}
+				return
+			}
+			defer recoverHandler(tun, tlsConn)
+			handler(tlsConn)
+		}()
+	}
+}
+
+func (t *tunnelImpl) tlsHandshakeFailed(conn net.Conn, serverName string, err error) {
+	t.log(func(l *slog.Logger) {
+		l.Debug("tls handshake failed", "clientid", t.Tunnel.ID(), "remote_addr", conn.RemoteAddr(), "server_name", serverName, "err", err)
+	})
+	t.events.emit(&EventTLSHandshakeFailed{
+		baseEvent:  newBaseEvent(EventTypeTLSHandshakeFailed),
+		Tunnel:     t,
+		RemoteAddr: conn.RemoteAddr(),
+		ServerName: serverName,
+		Error:      err,
+	})
+}
@@ -0,0 +1,46 @@
+package ngrok
+
+import (
+	"context"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// ServerInfo describes the ngrok server that a [Session] is connected to.
+type ServerInfo struct {
+	// The region the server is in.
+	Region string
+	// The version of the server.
+	Version string
+	// The address the session dialed to reach the server, such as
+	// "connect.us.ngrok-agent.com:443".
+	ConnectAddress string
+}
+
+func (s *sessionImpl) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	inner := s.inner()
+	type result struct {
+		resp proto.SrvInfoResp
+		err  error
+	}
+	// Buffered so the request can finish after the context is done.
+	done := make(chan result, 1)
+	go func() {
+		resp, err := inner.SrvInfo()
+		done <- result{resp, err}
+	}()
+
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return ServerInfo{}, res.err
+		}
+		return ServerInfo{
+			Region:         res.resp.Region,
+			Version:        inner.ServerVersion,
+			ConnectAddress: inner.ConnectAddress,
+		}, nil
+	case <-ctx.Done():
+		return ServerInfo{}, ctx.Err()
+	}
+}
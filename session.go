@@ -7,6 +7,8 @@ import (
 	_ "embed" // nolint
 	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
@@ -14,20 +16,23 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/inconshreveable/log15/v3"
 	"go.uber.org/multierr"
-	"golang.org/x/net/proxy"
 	"golang.org/x/sync/errgroup"
 
+	"golang.ngrok.com/ngrok/clock"
 	"golang.ngrok.com/ngrok/config"
 
 	"golang.ngrok.com/muxado/v2"
+	"golang.ngrok.com/muxado/v2/frame"
 	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
 	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+	"golang.ngrok.com/ngrok/internal/upstream"
 	"golang.ngrok.com/ngrok/log"
+	"golang.ngrok.com/ngrok/policy"
 )
 
 // The ngrok library version.
@@ -52,22 +57,97 @@ type Session interface {
 	// Warnings returns a list of warnings generated for the session on connect/auth
 	Warnings() []error
 
+	// AccountName returns the name of the ngrok account the session is
+	// authenticated as.
+	AccountName() string
+
+	// PlanName returns the name of the account's plan.
+	PlanName() string
+
+	// Banner returns the message the ngrok service asked the agent to show
+	// when it connected, if any.
+	Banner() string
+
+	// SessionDuration returns how long the ngrok service lets the session
+	// stay connected, or zero if it set no limit.
+	SessionDuration() time.Duration
+
+	// Deprecation returns the ngrok service's warning that this client
+	// version is deprecated, if any. It's also included in Warnings.
+	Deprecation() *AgentVersionDeprecated
+
+	// ServerInfo asks the ngrok service which region the session is
+	// connected to, and reports it along with the server's version and the
+	// address the session dialed to reach it.
+	ServerInfo(ctx context.Context) (ServerInfo, error)
+
 	// ListenAndForward creates a new Tunnel which will listen for new inbound
 	// connections. Connections on this tunnel are automatically forwarded to
-	// the provided URL.
+	// the provided URL. Use a unix:// URL to forward to a unix domain socket.
 	ListenAndForward(ctx context.Context, backend *url.URL, cfg config.Tunnel) (Forwarder, error)
 
 	// ListenAndServeHTTP creates a new Tunnel to serve as a backend for an HTTP server. Connections will be
-	// forwarded to the provided HTTP server.
+	// forwarded to a copy of the provided HTTP server, which is configured
+	// with config.WithHTTPServerSettings and shut down along with the
+	// provided server, leaving the provided server itself unchanged. If the
+	// tunnel was configured with config.WithAppProtocol("http2"), the copy's
+	// handler is wrapped to serve the HTTP/2 connections from the edge.
 	ListenAndServeHTTP(ctx context.Context, cfg config.Tunnel, server *http.Server) (Forwarder, error)
 
 	// ListenAndHandleHTTP creates a new Tunnel to serve as a backend for an HTTP handler. Connections will be
 	// forwarded to a new HTTP server and handled by the provided HTTP handler.
 	ListenAndHandleHTTP(ctx context.Context, cfg config.Tunnel, handler *http.Handler) (Forwarder, error)
 
+	// Stats returns a snapshot of the usage of the session across all of its
+	// tunnels.
+	Stats() SessionStats
+
+	// StatusReport returns a snapshot of the session and its tunnels. Each
+	// Tunnel also encodes its own status as JSON.
+	StatusReport() StatusReport
+
+	// RecentLogs returns the session's most recent log records, oldest
+	// first, if it was connected with [WithLogBuffer].
+	RecentLogs() []LogRecord
+
+	// AddEventHandler registers a handler for the events emitted by the
+	// session from now on, preceded by the events kept with
+	// [WithEventReplay].
+	AddEventHandler(handler EventHandler) EventSubscription
+
+	// RemoveEventHandler unregisters a handler added with AddEventHandler.
+	// Once it returns, the handler isn't called again, except by a call
+	// that's already in progress.
+	RemoveEventHandler(sub EventSubscription)
+
+	// Events returns a channel that receives the events emitted by the
+	// session, as AddEventHandler would, until the context is done, after
+	// which the channel is closed. The channel must be received from
+	// promptly, since the session's other handlers wait on it.
+	Events(ctx context.Context) <-chan Event
+
+	// ActiveResources returns counts of what the session is holding open.
+	// See also [WithLeakDetection].
+	ActiveResources() Resources
+
+	// RemoteEndpoints lists all of the endpoints on the ngrok account, not
+	// just those started by this session, so that orphaned endpoints can be
+	// found and disconnected. It requires an API key configured with
+	// [WithAPIKey].
+	RemoteEndpoints(ctx context.Context) ([]*RemoteEndpoint, error)
+
 	// Close ends the ngrok session. All Tunnel objects created by Listen
-	// on this session will be closed.
+	// on this session will be closed. Requests still in flight, such as
+	// Listen or a Tunnel's Close, fail with an error matching
+	// [ErrSessionClosing].
 	Close() error
+
+	// CloseWithContext ends the ngrok session like Close, but first lets
+	// the requests in flight finish, and afterwards waits for the session's
+	// internal goroutines to exit. This suits processes that check for
+	// leaked goroutines. If the context expires first, the session is
+	// closed regardless and the context's error is returned.
+	CloseWithContext(ctx context.Context) error
 }
 
 //go:embed assets/ngrok.ca.crt
@@ -141,6 +221,8 @@ func generateUserAgent(cs []clientInfo) string {
 type connectConfig struct {
 	// Your ngrok Authtoken.
 	Authtoken proto.ObfuscatedString
+	// Looks up the authtoken when connecting, if set.
+	AuthtokenProvider func(context.Context) (string, error)
 	// The address of the ngrok server to connect to.
 	// Defaults to `connect.ngrok-agent.com:443`
 	ServerAddr string
@@ -148,10 +230,25 @@ type connectConfig struct {
 	AdditionalServerAddrs []string
 	// Enable using multiple session legs
 	EnableMultiLeg bool
+	// The region chosen with WithRegion, if any.
+	Region string
+	// Whether to fail over across the connect addresses returned by the
+	// ngrok service when reconnecting.
+	ConnectFailover bool
+	// Whether to connect to the region with the lowest measured latency.
+	AutoRegion bool
+	// The local address to listen on when the session can't connect.
+	OfflineFallback string
+	// Reports changes to the host's network, after which the session checks
+	// its connection.
+	NetworkMonitor NetworkMonitor
 	// The [tls.Config] used when connecting to the ngrok server
 	TLSConfigCustomizer func(*tls.Config)
 	// The [x509.CertPool] used to authenticate the ngrok server certificate.
 	CAPool *x509.CertPool
+	// The certificates presented to the ngrok server to authenticate the
+	// agent.
+	ClientCertificates []tls.Certificate
 
 	// The [Dialer] used to establish the initial TCP connection to the ngrok
 	// server.
@@ -161,8 +258,21 @@ type connectConfig struct {
 
 	// The URL of a proxy to use when making the TCP connection to the ngrok
 	// server.
-	// Any proxy supported by [golang.org/x/net/proxy] may be used.
+	// HTTP and HTTPS proxies are supported via CONNECT requests, and any
+	// other proxy supported by [golang.org/x/net/proxy] may be used.
 	ProxyURL *url.URL
+	// Additional headers to send with CONNECT requests to HTTP(S) proxies.
+	ProxyHeaders http.Header
+	// The [tls.Config] used when connecting to HTTPS proxies.
+	ProxyTLSConfig *tls.Config
+	// A custom [ProxyDialer].
+	// If set, takes precedence over the ProxyURL setting.
+	ProxyDialer ProxyDialer
+
+	// The address family to try first when connecting to dual-stack hosts.
+	IPPreference IPPreference
+	// The resolver used to look up the ngrok service and upstream hosts.
+	Resolver *net.Resolver
 
 	// Opaque metadata string to be associated with the session.
 	// Viewable from the ngrok dashboard or API.
@@ -179,6 +289,61 @@ type connectConfig struct {
 	// heartbeat is determined to mean the connection is dead.
 	HeartbeatTolerance time.Duration
 
+	// How long, and up to how many bytes, to hold frames written to the
+	// ngrok service so they can be written together.
+	CoalesceWindow time.Duration
+	CoalesceSize   int
+
+	// Flow control tuning for the connection to the ngrok service.
+	Tuning SessionTuning
+
+	// Called with the panics recovered from the session's goroutines.
+	PanicHandler PanicHandler
+
+	// ConnectTimeout bounds the time [Connect] will spend establishing the
+	// initial session. Zero means no bound beyond the provided context.
+	ConnectTimeout time.Duration
+	// MaxConnectAttempts bounds the number of failed attempts [Connect] will
+	// make to establish the initial session. Zero means unlimited.
+	MaxConnectAttempts int
+	// Whether to wait for capacity when the account's limits are reached.
+	WaitForCapacity bool
+
+	// Reconnect tuning for the session.
+	ReconnectOptions tunnel_client.ReconnectOptions
+
+	// Handlers for the events emitted by the session.
+	EventHandlers []EventHandler
+	// How many of the most recent events to send to handlers added later.
+	EventReplay int
+
+	// Soft limits on the usage of the session.
+	Budget Budget
+
+	// Used to reserve domains that tunnels request if they aren't already.
+	DomainReserver DomainReserver
+
+	// Evaluated for each connection before it is accepted.
+	AcceptPolicy AcceptPolicy
+
+	// Called with the bytes read from and written to each connection.
+	ConnTap ConnTap
+
+	// Called in order with each connection before it is accepted.
+	ConnInterceptors []ConnInterceptor
+
+	// Warn about sessions and tunnels that are garbage collected without
+	// being closed.
+	LeakDetection bool
+
+	// Where to write a trace of the protocol messages, and whether to include
+	// muxado frames in it.
+	TraceWriter io.Writer
+	TraceFrames bool
+
+	// The ngrok API key for operations the agent protocol doesn't support.
+	APIKey string
+
 	ConnectHandler    SessionConnectHandler
 	DisconnectHandler SessionDisconnectHandler
 	HeartbeatHandler  SessionHeartbeatHandler
@@ -193,6 +358,10 @@ type connectConfig struct {
 
 	// The logger for the session to use.
 	Logger log.Logger
+	// The options the session was connected with, for support bundles.
+	bundleConfig bundleConfig
+	// Set by WithLogBuffer.
+	logBuffer *logBuffer
 }
 
 // WithMetadata configures the opaque, machine-readable metadata string for this
@@ -223,7 +392,7 @@ func WithClientInfo(clientType, version string, comments ...string) ConnectOptio
 
 // WithDialer configures the session to use the provided [Dialer] when
 // establishing a connection to the ngrok service. This option will cause
-// [WithProxyURL] to be ignored.
+// [WithProxyURL] and [WithProxyDialer] to be ignored.
 func WithDialer(dialer Dialer) ConnectOption {
 	return func(cfg *connectConfig) {
 		cfg.Dialer = dialer
@@ -231,8 +400,9 @@ func WithDialer(dialer Dialer) ConnectOption {
 }
 
 // WithProxyURL configures the session to connect to ngrok through an outbound
-// HTTP or SOCKS5 proxy. This parameter is ignored if you override the dialer
-// with [WithDialer].
+// HTTP, HTTPS, or SOCKS5 proxy. Credentials in the URL's userinfo are used for
+// basic (HTTP) or username/password (SOCKS5) authentication. This parameter is
+// ignored if you override the dialer with [WithDialer] or [WithProxyDialer].
 //
 // See the [proxy url parameter in the ngrok docs] for additional details.
 //
@@ -243,6 +413,63 @@ func WithProxyURL(url *url.URL) ConnectOption {
 	}
 }
 
+// WithProxyHeaders configures additional headers to send with the CONNECT
+// request when connecting through an HTTP or HTTPS proxy set with
+// [WithProxyURL]. A Proxy-Authorization header set here takes precedence over
+// credentials in the proxy URL.
+func WithProxyHeaders(headers http.Header) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ProxyHeaders = headers
+	}
+}
+
+// WithProxyTLSConfig configures the [tls.Config] used when connecting to an
+// HTTPS proxy set with [WithProxyURL].
+func WithProxyTLSConfig(tlsConfig *tls.Config) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ProxyTLSConfig = tlsConfig
+	}
+}
+
+// WithProxyDialer configures the session to connect to ngrok through the
+// provided [ProxyDialer]. This option will cause [WithProxyURL] to be ignored,
+// and is itself ignored if you override the dialer with [WithDialer].
+func WithProxyDialer(proxy ProxyDialer) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ProxyDialer = proxy
+	}
+}
+
+// WithIPPreference configures which address family is tried first when
+// connecting to hosts with both IPv4 and IPv6 addresses. This applies both to
+// the connection to the ngrok service and to the upstream connections made by
+// [Session.ListenAndForward]. Attempts to the other family still begin shortly
+// after, as described in RFC 8305.
+//
+// This is ignored for the ngrok service connection if you override the dialer
+// with [WithDialer].
+func WithIPPreference(pref IPPreference) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.IPPreference = pref
+	}
+}
+
+// WithResolver configures the resolver used to look up host names, in place
+// of the system resolver. This applies both to the ngrok service address and
+// to the upstream hosts connected to by [Session.ListenAndForward], so that
+// environments with split-horizon DNS or DNS-over-HTTPS requirements can
+// control name resolution. Use [config.WithUpstreamResolver] to resolve a
+// single tunnel's upstream hosts differently.
+//
+// This is ignored for the ngrok service connection if you override the dialer
+// with [WithDialer]. When connecting through a proxy, it's only used to look
+// up the proxy itself.
+func WithResolver(resolver *net.Resolver) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.Resolver = resolver
+	}
+}
+
 // WithAuthtoken configures the session to authenticate with the provided
 // authtoken. You can [find your existing authtoken] or [create a new one] in the ngrok dashboard.
 //
@@ -263,6 +490,17 @@ func WithAuthtokenFromEnv() ConnectOption {
 	return WithAuthtoken(os.Getenv("NGROK_AUTHTOKEN"))
 }
 
+// WithAuthtokenProvider configures the session to authenticate with the
+// authtoken returned by provider, which is called once by [Connect]. Use it
+// to read the authtoken from a secret store, such as the OS keychain with the
+// ngrokkeyring package. If provider fails, so does Connect, unless a fallback
+// was configured with [WithOfflineFallback].
+func WithAuthtokenProvider(provider func(ctx context.Context) (string, error)) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.AuthtokenProvider = provider
+	}
+}
+
 // WithRegion configures the session to connect to a specific ngrok region.
 // If unspecified, ngrok will connect to the fastest region, which is usually what you want.
 // The [full list of ngrok regions] can be found in the ngrok documentation.
@@ -274,6 +512,7 @@ func WithAuthtokenFromEnv() ConnectOption {
 func WithRegion(region string) ConnectOption {
 	return func(cfg *connectConfig) {
 		if region != "" {
+			cfg.Region = region
 			cfg.ServerAddr = fmt.Sprintf("connect.%s.ngrok-agent.com:443", region)
 		}
 	}
@@ -306,6 +545,8 @@ func WithAdditionalServers(addrs []string) ConnectOption {
 }
 
 // WithMultiLeg as true allows connecting to the ngrok service on secondary legs.
+// The state of each leg is reported in [SupervisorStatus].Legs, and by
+// [EventLegConnected] and [EventLegDisconnected].
 //
 // See [WithAdditionalServers] if connecting to a custom agent ingress.
 func WithMultiLeg(enable bool) ConnectOption {
@@ -323,6 +564,20 @@ func WithTLSConfig(tlsCustomizer func(*tls.Config)) ConnectOption {
 	}
 }
 
+// WithAgentClientCertificate presents a client certificate to the ngrok
+// service while establishing the session, for connect endpoints that
+// authenticate agents with mutual TLS, such as those of custom agent ingress
+// deployments set up with [WithServer]. It may be given more than once to
+// offer several certificates, of which the one matching the CAs the server
+// accepts is presented. Certificates can also be set with [WithTLSConfig],
+// for instance to reload them as they're renewed with
+// [tls.Config].GetClientCertificate.
+func WithAgentClientCertificate(cert tls.Certificate) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ClientCertificates = append(cfg.ClientCertificates, cert)
+	}
+}
+
 // WithCA configures the CAs used to validate the TLS certificate returned by
 // the ngrok service while establishing the session. Use this option only if
 // you are connecting through a man-in-the-middle or deep packet inspection
@@ -339,6 +594,8 @@ func WithCA(pool *x509.CertPool) ConnectOption {
 
 // WithHeartbeatTolerance configures the duration to wait for a response to a heartbeat
 // before assuming the session connection is dead and attempting to reconnect.
+// Each heartbeat interval that passes without a response before then is
+// reported as an [EventHeartbeatMissed].
 //
 // See the [heartbeat_tolerance parameter in the ngrok docs] for additional details.
 //
@@ -361,6 +618,63 @@ func WithHeartbeatInterval(interval time.Duration) ConnectOption {
 	}
 }
 
+// WithConnectTimeout configures the maximum amount of time that [Connect] will
+// spend trying to establish the initial session with the ngrok service. If the
+// session has not been established once the timeout elapses, [Connect] gives
+// up and returns an error wrapping [context.DeadlineExceeded] along with the
+// errors encountered on each attempt.
+//
+// The timeout applies only to the initial connection. Once [Connect] returns
+// successfully, the [Session] continues to reconnect across network failures
+// for as long as the provided context is valid.
+func WithConnectTimeout(timeout time.Duration) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ConnectTimeout = timeout
+	}
+}
+
+// WithMaxConnectAttempts configures the maximum number of failed attempts that
+// [Connect] will make while trying to establish the initial session with the
+// ngrok service. Once the limit is reached, [Connect] gives up and returns an
+// error wrapping the errors encountered on each attempt.
+//
+// This is useful to fail fast on errors that are unlikely to resolve
+// themselves, such as an invalid authtoken.
+func WithMaxConnectAttempts(attempts int) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.MaxConnectAttempts = attempts
+	}
+}
+
+// WithReconnectBackoff configures the wait between attempts to reconnect to
+// the ngrok service. The wait begins at min and grows by factor with each
+// consecutive failure, up to max. If jitter is set, each wait is randomized
+// between min and the computed value to avoid synchronized reconnect storms.
+//
+// Zero values keep the defaults of 500ms, 30s, and a factor of 2.
+func WithReconnectBackoff(min, max time.Duration, factor float64, jitter bool) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ReconnectOptions.Backoff = tunnel_client.Backoff{
+			Min:    min,
+			Max:    max,
+			Factor: factor,
+			Jitter: jitter,
+		}
+	}
+}
+
+// WithReconnectNotify configures a function to call each time an attempt to
+// (re)connect to the ngrok service fails. It receives the number of
+// consecutive failed attempts, the error encountered, and how long the session
+// will wait before trying again.
+//
+// The function is called from the reconnect loop and should return promptly.
+func WithReconnectNotify(notify func(attempt int, err error, next time.Duration)) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ReconnectOptions.Notify = notify
+	}
+}
+
 // WithLogger configures a logger to receive log messages from the [Session]. The
 // log subpackage contains adapters for both [logrus] and [zap].
 //
@@ -514,23 +828,46 @@ func WithUpdateCommandDisabled(err string) ConnectOption {
 // an error that will not be retried. Customize session connection behavior
 // with [ConnectOption] arguments.
 func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
-	logger := log15.New()
-	logger.SetHandler(log15.DiscardHandler())
-
 	cfg := connectConfig{}
 	for _, o := range opts {
 		o(&cfg)
 	}
+	cfg.bundleConfig = newBundleConfig(&cfg)
 
-	if cfg.Logger != nil {
-		logger = toLog15(cfg.Logger)
+	if cfg.AuthtokenProvider != nil {
+		token, err := cfg.AuthtokenProvider(ctx)
+		if err != nil {
+			err = fmt.Errorf("failed to get authtoken: %w", err)
+			if cfg.OfflineFallback == "" {
+				return nil, err
+			}
+			return connectOffline(cfg, err), nil
+		}
+		cfg.Authtoken = proto.ObfuscatedString(token)
+	}
+
+	if cfg.OfflineFallback == "" {
+		return connect(ctx, cfg)
+	}
+	if cfg.Authtoken == "" {
+		return connectOffline(cfg, errors.New("no authtoken configured")), nil
 	}
+	sess, err := connect(ctx, cfg)
+	if err != nil && ctx.Err() == nil && unreachable(err) {
+		return connectOffline(cfg, err), nil
+	}
+	return sess, err
+}
+
+func connect(ctx context.Context, cfg connectConfig) (Session, error) {
+	logger := cfg.logger()
 
 	if cfg.CAPool == nil {
 		cfg.CAPool = x509.NewCertPool()
 		cfg.CAPool.AppendCertsFromPEM(defaultCACert)
 	}
 
+	autoRegion := cfg.AutoRegion && cfg.ServerAddr == ""
 	if cfg.ServerAddr == "" {
 		cfg.ServerAddr = defaultServer
 	}
@@ -540,19 +877,30 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 	if cfg.Dialer != nil {
 		dialer = cfg.Dialer
 	} else {
-		netDialer := &net.Dialer{}
+		netDialer := newHappyEyeballsDialer(cfg.IPPreference, cfg.Resolver)
 
-		if cfg.ProxyURL != nil {
-			proxied, err := proxy.FromURL(cfg.ProxyURL, netDialer)
+		switch {
+		case cfg.ProxyDialer != nil:
+			dialer = &proxyDialerAdapter{proxy: cfg.ProxyDialer, forward: netDialer}
+		case cfg.ProxyURL != nil:
+			proxied, err := newProxyURLDialer(cfg.ProxyURL, netDialer, cfg.ProxyHeaders, cfg.ProxyTLSConfig)
 			if err != nil {
 				return nil, errProxyInit{cfg.ProxyURL, err}
 			}
-			dialer = proxied.(Dialer)
-		} else {
+			dialer = proxied
+		default:
 			dialer = netDialer
 		}
 	}
 
+	if autoRegion {
+		if region, addr, ok := pickRegion(ctx, dialer, cfg.CAPool); ok {
+			logger.Debug("picked region with the lowest latency", "region", region, "server", addr)
+			cfg.Region = region
+			cfg.ServerAddr = addr
+		}
+	}
+
 	heartbeatConfig := muxado.NewHeartbeatConfig()
 	if cfg.HeartbeatTolerance != 0 {
 		heartbeatConfig.Tolerance = cfg.HeartbeatTolerance
@@ -561,7 +909,7 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 		heartbeatConfig.Interval = cfg.HeartbeatInterval
 	}
 
-	session := new(sessionImpl)
+	session := newSession(&cfg)
 
 	stateChanges := make(chan error, 32)
 
@@ -573,29 +921,66 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 		updateHandler:  cfg.UpdateHandler,
 	}
 
+	var tracer *tunnel_client.Tracer
+	if cfg.TraceWriter != nil {
+		tracer = tunnel_client.NewTracer(cfg.TraceWriter, cfg.TraceFrames)
+	}
+
+	var failover *connectFailover
+	if cfg.ConnectFailover {
+		failover = &connectFailover{dialer: dialer, region: cfg.Region}
+	}
+
+	// The address each leg dialed most recently, for its ServerInfo.
+	var (
+		dialedMu sync.Mutex
+		dialed   = map[uint32]string{}
+	)
+	// Whether the agent presents a client certificate to the ngrok service.
+	var mutualTLS atomic.Bool
+
 	rawDialer := func(legNumber uint32) (tunnel_client.RawSession, error) {
 		serverAddr := cfg.ServerAddr
 		if legNumber > 0 && len(cfg.AdditionalServerAddrs) >= int(legNumber) {
 			serverAddr = cfg.AdditionalServerAddrs[legNumber-1]
 		}
+		var (
+			conn net.Conn
+			err  error
+		)
+		if failover != nil && legNumber == 0 {
+			conn, serverAddr, err = failover.dial(ctx, serverAddr)
+			if err != nil {
+				return nil, err
+			}
+		} else if conn, err = dialer.DialContext(ctx, "tcp", serverAddr); err != nil {
+			return nil, errSessionDial{serverAddr, err}
+		}
+		dialedMu.Lock()
+		dialed[legNumber] = serverAddr
+		dialedMu.Unlock()
+
 		tlsConfig := &tls.Config{
-			RootCAs:    cfg.CAPool,
-			ServerName: strings.Split(serverAddr, ":")[0],
-			MinVersion: tls.VersionTLS12,
+			RootCAs:      cfg.CAPool,
+			ServerName:   strings.Split(serverAddr, ":")[0],
+			MinVersion:   tls.VersionTLS12,
+			Certificates: cfg.ClientCertificates,
 		}
 		if cfg.TLSConfigCustomizer != nil {
 			cfg.TLSConfigCustomizer(tlsConfig)
 		}
-
-		conn, err := dialer.DialContext(ctx, "tcp", serverAddr)
-		if err != nil {
-			return nil, errSessionDial{serverAddr, err}
-		}
+		mutualTLS.Store(len(tlsConfig.Certificates) > 0 || tlsConfig.GetClientCertificate != nil)
 
 		conn = tls.Client(conn, tlsConfig)
+		if cfg.CoalesceWindow > 0 {
+			conn = newCoalescingConn(conn, cfg.CoalesceWindow, cfg.CoalesceSize)
+		}
 
-		sess := muxado.Client(conn, &muxado.Config{})
-		return tunnel_client.NewRawSession(logger, sess, heartbeatConfig, callbackHandler), nil
+		newFramer := func(r io.Reader, w io.Writer) frame.Framer {
+			return tracer.TraceFrames(session.mux.newFramer(r, w))
+		}
+		sess := muxado.Client(conn, cfg.Tuning.muxadoConfig(newFramer))
+		return tunnel_client.NewTracedRawSession(logger, sess, heartbeatConfig, callbackHandler, tracer, cfg.ReconnectOptions.Clock), nil
 	}
 
 	empty := ""
@@ -646,13 +1031,14 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 
 	reconnect := func(sess tunnel_client.Session, raw tunnel_client.RawSession, legNumber uint32) (int, error) {
 		auth.LegNumber = legNumber
+		auth.MutualTLS = mutualTLS.Load()
 		resp, err := sess.Auth(auth)
 		if err != nil {
 			remote := false
 			if resp.Error != "" {
 				remote = true
 			}
-			return 0, errAuthFailed{remote, err}
+			return 0, errAuthFailed{remote, limitExceeded(err)}
 		}
 
 		if resp.Extra.DeprecationWarning != nil {
@@ -684,6 +1070,9 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 			ConnectAddresses:   resp.Extra.ConnectAddresses,
 			Logger:             logger,
 		}
+		dialedMu.Lock()
+		sessionInner.ConnectAddress = dialed[legNumber]
+		dialedMu.Unlock()
 
 		if legNumber == 0 {
 			session.setInner(sessionInner)
@@ -693,7 +1082,7 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 			// plumb a session with the proper region to the heartbeatHandler
 			heartbeatSession := new(sessionImpl)
 			heartbeatSession.setInner(sessionInner)
-			go func() {
+			session.goroutines.spawn(func() {
 				// use the raw latency channel in case this is a multi-leg session
 				beats := raw.Latency()
 				for {
@@ -707,11 +1096,48 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 						cfg.HeartbeatHandler(ctx, heartbeatSession, latency)
 					}
 				}
-			}()
+			})
 		}
 
+		if session.events != nil {
+			session.goroutines.spawn(func() {
+				for miss := range raw.Misses() {
+					session.conn.heartbeatMissed()
+					session.events.emit(&EventHeartbeatMissed{
+						baseEvent:          newBaseEvent(EventTypeHeartbeatMissed),
+						Session:            session,
+						Leg:                int(legNumber),
+						Missed:             miss.Missed,
+						SinceLastHeartbeat: miss.Since,
+						Remaining:          miss.Remaining,
+					})
+				}
+			})
+		}
+		// Each leg's latency is recorded for its LegStatus, whether or not
+		// anything is listening for the events.
+		session.goroutines.spawn(func() {
+			for beat := range raw.Beats() {
+				session.legs.heartbeat(legNumber, beat.Latency)
+				jitter := session.conn.heartbeat(beat.Latency)
+				session.events.emit(&EventHeartbeatReceived{
+					baseEvent: newBaseEvent(EventTypeHeartbeatReceived),
+					Session:   session,
+					Leg:       int(legNumber),
+					Latency:   beat.Latency,
+					Missed:    beat.Missed,
+					Remaining: beat.Remaining,
+					Jitter:    jitter,
+				})
+			}
+		})
+
 		auth.Cookie = resp.Extra.Cookie
 
+		if failover != nil && legNumber == 0 {
+			failover.update(resp.Extra.ConnectAddresses)
+		}
+
 		// store any connect server addresses for use in subsequent legs
 		if cfg.EnableMultiLeg && legNumber == 0 && len(resp.Extra.ConnectAddresses) > 1 {
 			overrideAdditionalServers := len(cfg.AdditionalServerAddrs) == 0
@@ -736,7 +1162,15 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 		return desiredLegs, nil
 	}
 
-	sess := tunnel_client.NewReconnectingSession(logger, rawDialer, stateChanges, reconnect)
+	cfg.ReconnectOptions.OnPanic = session.goroutines.onPanic
+	cfg.ReconnectOptions.OnURLChange = session.urlChanged
+	cfg.ReconnectOptions.OnLegChange = func(leg uint32, connected bool, err error) {
+		dialedMu.Lock()
+		addr := dialed[leg]
+		dialedMu.Unlock()
+		session.legChanged(leg, addr, connected, err)
+	}
+	sess := tunnel_client.NewReconnectingSession(logger, rawDialer, stateChanges, reconnect, cfg.ReconnectOptions)
 	// allow consumers to .Close() the session before a successful connect
 	session.setInner(&sessionInner{
 		Session: sess,
@@ -744,9 +1178,11 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 
 	// performs one "pump" of the session update channel
 	// returns true if there are more updates to handle
-	runSessionHandlers := func() (bool, error) {
+	runSessionHandlers := func(ctx context.Context) (bool, error) {
 		select {
 		case <-ctx.Done():
+			session.emitDisconnected(ctx.Err())
+			session.emitDisconnected(nil)
 			if cfg.DisconnectHandler != nil {
 				cfg.DisconnectHandler(ctx, session, ctx.Err())
 				logger.Info("no more state changes")
@@ -757,6 +1193,7 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 		case err, ok := <-stateChanges:
 			switch {
 			case !ok: // session has given up on reconnecting
+				session.emitDisconnected(nil)
 				if cfg.DisconnectHandler != nil {
 					logger.Info("no more state changes")
 					cfg.DisconnectHandler(ctx, session, nil)
@@ -764,11 +1201,25 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 				sess.Close()
 				return false, nil
 			case err != nil: // session encountered an error
+				session.emitDisconnected(err)
 				if cfg.DisconnectHandler != nil {
 					cfg.DisconnectHandler(ctx, session, err)
 				}
 				return true, err
 			case err == nil: // session connected successfully
+				session.conn.set(true, nil)
+				session.events.emit(&EventSessionConnected{
+					baseEvent: newBaseEvent(EventTypeSessionConnected),
+					Session:   session,
+				})
+				if inner := session.inner(); inner.DeprecationWarning != nil || inner.Banner != "" {
+					session.events.emit(&EventAgentWarning{
+						baseEvent:   newBaseEvent(EventTypeAgentWarning),
+						Session:     session,
+						Deprecation: session.Deprecation(),
+						Banner:      inner.Banner,
+					})
+				}
 				if cfg.ConnectHandler != nil {
 					cfg.ConnectHandler(ctx, session)
 				}
@@ -779,31 +1230,175 @@ func Connect(ctx context.Context, opts ...ConnectOption) (Session, error) {
 		panic("inexhaustive case match when handling session state change")
 	}
 
-	var errs error
+	// bound the initial connection separately from the session lifetime
+	connectCtx := ctx
+	if cfg.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		connectCtx, cancel = context.WithTimeout(ctx, cfg.ConnectTimeout)
+		defer cancel()
+	}
+
+	var (
+		errs     error
+		attempts int
+	)
 	for again := true; again; {
 		var err error
-		again, err = runSessionHandlers()
+		again, err = runSessionHandlers(connectCtx)
 		switch {
 		case again && err == nil: // successfully connected, move to goroutine and return
 			again = false
 		case again && err != nil: // error on reconnect
 			errs = multierr.Append(errs, err)
+			if cfg.WaitForCapacity && errors.Is(err, ErrLimitExceeded) {
+				continue
+			}
+			attempts++
+			if cfg.MaxConnectAttempts > 0 && attempts >= cfg.MaxConnectAttempts {
+				session.emitDisconnected(nil)
+				if cfg.DisconnectHandler != nil {
+					logger.Info("no more state changes")
+					cfg.DisconnectHandler(ctx, session, nil)
+				}
+				sess.Close()
+				return nil, errConnectAttempts{attempts, errs}
+			}
 		case !again: // gave up trying to reconnect
 			errs = multierr.Append(errs, err)
+			if ctx.Err() == nil && connectCtx.Err() != nil {
+				return nil, errConnectTimeout{cfg.ConnectTimeout, errs}
+			}
 			return nil, errs
 		}
 	}
 
-	go func() {
-		for again := true; again; again, _ = runSessionHandlers() {
+	session.markOpen()
+	session.handlersDone = make(chan struct{})
+	session.goroutines.spawn(func() {
+		defer close(session.handlersDone)
+		defer session.markClosed()
+		for again := true; again; again, _ = runSessionHandlers(ctx) {
 		}
-	}()
+	})
 
+	if cfg.NetworkMonitor != nil {
+		session.watchNetworkChanges(cfg.NetworkMonitor, logger)
+	}
+
+	if cfg.LeakDetection {
+		return watchSession(session, logger), nil
+	}
 	return session, nil
 }
 
+// The logger configured for the session, or one that discards its output.
+func (cfg *connectConfig) logger() *slog.Logger {
+	var logger *slog.Logger
+	if cfg.Logger != nil {
+		logger = toSlog(cfg.Logger)
+	} else {
+		logger = slog.New(discardHandler{})
+	}
+	handlers := teeHandler{logger.Handler()}
+	if cfg.logBuffer != nil {
+		handlers = append(handlers, cfg.logBuffer.handler())
+	}
+	if len(handlers) > 1 {
+		logger = slog.New(handlers)
+	}
+	return logger
+}
+
+// A session that's yet to be connected.
+func newSession(cfg *connectConfig) *sessionImpl {
+	session := &sessionImpl{
+		upstreamDialer:   newHappyEyeballsDialer(cfg.IPPreference, cfg.Resolver),
+		events:           newEventDispatcher(cfg.EventHandlers),
+		domainReserver:   cfg.DomainReserver,
+		acceptPolicy:     cfg.AcceptPolicy,
+		connTap:          cfg.ConnTap,
+		clk:              cfg.ReconnectOptions.Clock,
+		connInterceptors: cfg.ConnInterceptors,
+		logBuffer:        cfg.logBuffer,
+		bundleConfig:     cfg.bundleConfig,
+	}
+	if cfg.WaitForCapacity {
+		session.capacityWaitMax = maxCapacityWait
+		if backoffMax := cfg.ReconnectOptions.Backoff.Max; backoffMax > 0 {
+			session.capacityWaitMax = backoffMax
+		}
+	}
+	session.events.replay = cfg.EventReplay
+	session.goroutines.onPanic = cfg.panicReporter()
+	if cfg.APIKey != "" {
+		session.api = newAPIClient(cfg.APIKey)
+	}
+	session.acct = &sessionAccounting{
+		budget:  cfg.Budget,
+		session: session,
+		events:  session.events,
+	}
+	return session
+}
+
 type sessionImpl struct {
 	raw atomic.Pointer[sessionInner]
+
+	// The default dialer for upstream connections made by ListenAndForward.
+	upstreamDialer Dialer
+	events         *eventDispatcher
+	acct           *sessionAccounting
+	domainReserver DomainReserver
+	acceptPolicy   AcceptPolicy
+	connTap        ConnTap
+	// Intercept the connections accepted from the session's tunnels.
+	connInterceptors []ConnInterceptor
+	// The clock that times the session, if not the system's.
+	clk clock.Clock
+	// The client for the ngrok API, if an API key was provided.
+	api *apiClient
+
+	// Goroutines started for the session, and whether it has been closed.
+	goroutines goroutineCount
+	closed     atomic.Bool
+	// Canceled once the session closes.
+	life lifetime
+	// Counters for the frames exchanged with the ngrok service.
+	mux muxStats
+	// Whether the session is connected to the ngrok service.
+	conn connectionState
+	// The state of each of the session's legs.
+	legs legStates
+
+	// Closed once the session has stopped handling state changes.
+	handlersDone chan struct{}
+	// The options the session was connected with, for support bundles.
+	bundleConfig bundleConfig
+	// The records kept for RecentLogs and support bundles.
+	logBuffer *logBuffer
+	// The longest wait between attempts to start a tunnel when the
+	// account's endpoint limit is reached, or zero to fail instead.
+	capacityWaitMax time.Duration
+
+	tunnelsMu sync.Mutex
+	tunnels   map[*tunnelImpl]struct{}
+	// The binds in progress, by the key of the URL they're binding.
+	binds map[string][]*pendingBind
+}
+
+// Find the session returned by Connect behind sess, looking through the
+// wrappers added by leak detection and SharedTransport. The op names the
+// caller in the error returned for other implementations of Session.
+func unwrapSession(sess Session, op string) (*sessionImpl, error) {
+	switch s := sess.(type) {
+	case *sessionImpl:
+		return s, nil
+	case *leakCheckedSession:
+		return s.sessionImpl, nil
+	case *sharedSession:
+		return unwrapSession(s.conn, op)
+	}
+	return nil, errUnsupportedSession{op}
 }
 
 type sessionInner struct {
@@ -819,8 +1414,10 @@ type sessionInner struct {
 	SessionDuration    int64
 	DeprecationWarning *proto.AgentVersionDeprecated
 	ConnectAddresses   []proto.ConnectAddress
+	// The address that was dialed to connect the session.
+	ConnectAddress string
 
-	Logger log15.Logger
+	Logger *slog.Logger
 }
 
 func (s *sessionImpl) inner() *sessionInner {
@@ -831,58 +1428,250 @@ func (s *sessionImpl) setInner(raw *sessionInner) {
 	s.raw.Store(raw)
 }
 
+func (s *sessionImpl) Stats() SessionStats {
+	var stats SessionStats
+	if s.acct != nil {
+		stats = s.acct.snapshot()
+	}
+	stats.Pools = s.poolStats()
+	stats.Mux = s.mux.snapshot()
+	return stats
+}
+
+func (s *sessionImpl) emitDisconnected(err error) {
+	s.conn.set(false, err)
+	if err != nil {
+		s.tunnelsMu.Lock()
+		for t := range s.tunnels {
+			t.setLastError(err)
+		}
+		s.tunnelsMu.Unlock()
+	}
+	s.events.emit(&EventSessionDisconnected{
+		baseEvent: newBaseEvent(EventTypeSessionDisconnected),
+		Session:   s,
+		Error:     err,
+	})
+}
+
+// Handle a tunnel that was assigned a different URL when it was bound again
+// after a reconnect. Returns the error to close it with, if it requires a
+// stable URL.
+func (s *sessionImpl) urlChanged(tun tunnel_client.Tunnel, oldURL, newURL string) error {
+	s.tunnelsMu.Lock()
+	var impl *tunnelImpl
+	for t := range s.tunnels {
+		if t.Tunnel == tun {
+			impl = t
+			break
+		}
+	}
+	s.tunnelsMu.Unlock()
+	if impl == nil {
+		return nil
+	}
+
+	if impl.stableURL {
+		return errURLChanged{Old: oldURL, New: newURL}
+	}
+	impl.log(func(l *slog.Logger) {
+		l.Warn("endpoint URL changed after reconnecting", "clientid", tun.ID(), "old", oldURL, "new", newURL)
+	})
+	s.events.emit(&EventEndpointURLChanged{
+		baseEvent: newBaseEvent(EventTypeEndpointURLChanged),
+		Tunnel:    impl,
+		OldURL:    oldURL,
+		NewURL:    newURL,
+	})
+	return nil
+}
+
 func (s *sessionImpl) closeTunnel(clientID string, err error) error {
 	return s.inner().CloseTunnel(clientID, err)
 }
 
 func (s *sessionImpl) Close() error {
+	s.markClosed()
 	return s.inner().Close()
 }
 
+func (s *sessionImpl) CloseWithContext(ctx context.Context) error {
+	s.markClosed()
+	err := s.inner().CloseWithContext(ctx)
+	if s.handlersDone != nil {
+		select {
+		case <-s.handlersDone:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if waitErr := s.events.wait(ctx); waitErr != nil {
+		return waitErr
+	}
+	return err
+}
+
+func (s *sessionImpl) AddEventHandler(handler EventHandler) EventSubscription {
+	return EventSubscription{sub: s.events.addHandler(handler)}
+}
+
+func (s *sessionImpl) RemoveEventHandler(sub EventSubscription) {
+	if sub.sub != nil {
+		s.events.removeHandler(sub.sub)
+	}
+}
+
+func (s *sessionImpl) Events(ctx context.Context) <-chan Event {
+	return s.events.channel(ctx)
+}
+
 func (s *sessionImpl) Warnings() []error {
+	var warnings []error
+	if offline, ok := s.inner().Session.(*offlineSession); ok {
+		warnings = append(warnings, errOffline{offline.reason})
+	}
 	deprecated := s.inner().DeprecationWarning
 	if deprecated != nil {
-		return []error{(*AgentVersionDeprecated)(deprecated)}
+		warnings = append(warnings, (*AgentVersionDeprecated)(deprecated))
 	}
-	return nil
+	return warnings
 }
 
 func (s *sessionImpl) Listen(ctx context.Context, cfg config.Tunnel) (Tunnel, error) {
-	var (
-		tunnel tunnel_client.Tunnel
-		err    error
-	)
+	impl, err := s.listen(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return impl, nil
+}
+
+func (s *sessionImpl) listen(ctx context.Context, cfg config.Tunnel) (*tunnelImpl, error) {
 	tunnelCfg, ok := cfg.(tunnelConfigPrivate)
 	if !ok {
 		return nil, errors.New("invalid tunnel config")
 	}
+	cidrs, err := newCIDRFilter(tunnelCfg.LocalCIDRRestrictions())
+	if err != nil {
+		return nil, errListen{err}
+	}
 
 	extra := tunnelCfg.Extra()
-	if tunnelCfg.Proto() != "" {
-		tunnel, err = s.inner().Listen(tunnelCfg.Proto(), tunnelCfg.Opts(), extra, tunnelCfg.ForwardsTo(), tunnelCfg.ForwardsProto())
-	} else {
-		tunnel, err = s.inner().ListenLabel(tunnelCfg.Labels(), extra.Metadata, tunnelCfg.ForwardsTo(), tunnelCfg.ForwardsProto())
+	opts := tunnelCfg.Opts()
+	// Report mistakes in the policy's expressions with their positions,
+	// which the ngrok service's errors lack.
+	if tp := requestedTrafficPolicy(opts); tp != "" {
+		if err := policy.ValidateTrafficPolicy(tp); err != nil {
+			return nil, errListen{err}
+		}
+	}
+	existing, release, err := s.reserveBind(ctx, bindKey(tunnelCfg.Proto(), opts), extra.AllowsPooling)
+	if err != nil {
+		return nil, errListen{err}
+	}
+	if existing != nil {
+		if tunnelCfg.ReuseExisting() {
+			return existing, nil
+		}
+		return nil, errListen{&EndpointAlreadyBoundError{URL: existing.URL(), Tunnel: existing}}
+	}
+	// Hold the URL until the tunnel is added to the session below, including
+	// while waiting for it to be ready. Falling back to another URL trades
+	// it for that one's.
+	defer func() { release() }()
+	if upstreamOpts := tunnelCfg.UpstreamOptions(); extra.AllowsPooling && upstreamOpts.HealthGate && upstreamOpts.HealthCheck != nil {
+		if err := s.awaitHealthy(ctx, upstreamOpts.HealthCheck, upstreamOpts.HealthInterval); err != nil {
+			return nil, errListen{err}
+		}
+	}
+	listen := func() (tunnel_client.Tunnel, error) {
+		if tunnelCfg.Proto() != "" {
+			return s.inner().Listen(tunnelCfg.Proto(), opts, extra, tunnelCfg.Identity(), tunnelCfg.ForwardsTo(), tunnelCfg.ForwardsProto())
+		}
+		return s.inner().ListenLabel(tunnelCfg.Labels(), extra.Metadata, tunnelCfg.ForwardsTo(), tunnelCfg.ForwardsProto())
+	}
+	if s.capacityWaitMax > 0 {
+		listen = s.waitForCapacity(ctx, listen)
+	}
+
+	tunnel, err := listen()
+	if domain := requestedDomain(opts); err != nil && domain != "" && s.domainReserver != nil && isDomainNotReserved(err) {
+		if rerr := s.domainReserver.ReserveDomain(ctx, domain); rerr != nil {
+			return nil, errListen{errReserveDomain{domain, rerr}}
+		}
+		tunnel, err = listen()
+	}
+	for _, fallback := range tunnelCfg.URLFallbacks() {
+		var bound *EndpointAlreadyBoundError
+		if err == nil || !(isBindRefused(err) || errors.As(err, &bound)) {
+			break
+		}
+		s.inner().Logger.Info("failed to bind endpoint, trying fallback URL", "url", fallback, "err", err)
+		fallbackOpts := withURL(opts, fallback)
+		existing, fallbackRelease, rerr := s.reserveBind(ctx, bindKey(tunnelCfg.Proto(), fallbackOpts), extra.AllowsPooling)
+		if rerr != nil {
+			return nil, errListen{rerr}
+		}
+		if existing != nil {
+			// Another of the session's tunnels has the fallback URL.
+			err = &EndpointAlreadyBoundError{URL: existing.URL(), Tunnel: existing}
+			continue
+		}
+		release()
+		release = fallbackRelease
+		opts = fallbackOpts
+		tunnel, err = listen()
 	}
 
 	impl := &tunnelImpl{
-		Sess:   s,
-		Tunnel: tunnel,
+		Sess:         s,
+		Tunnel:       tunnel,
+		events:       s.events,
+		acct:         s.acct,
+		pooled:       extra.AllowsPooling,
+		bindKey:      bindKey(tunnelCfg.Proto(), opts),
+		policy:       s.acceptPolicy,
+		goroutines:   &s.goroutines,
+		agentTLS:     tunnelCfg.AgentTLSConfig(),
+		challenges:   tunnelCfg.TLSALPNChallenges(),
+		stableURL:    tunnelCfg.StableURL(),
+		started:      time.Now(),
+		tap:          s.connTap,
+		interceptors: s.connInterceptors,
+		cidrs:        cidrs,
+	}
+	perSecond, burst := tunnelCfg.AcceptRateLimit()
+	impl.limiter = newAcceptLimiter(s.clock(), perSecond, burst)
+	if err == nil {
+		s.events.emit(&EventTunnelStarted{
+			baseEvent: newBaseEvent(EventTypeTunnelStarted),
+			Tunnel:    impl,
+		})
+		s.addTunnel(impl)
+		if opts := tunnelCfg.UpstreamOptions(); impl.pooled && opts.HealthCheck != nil {
+			impl.startHealthCheck(s.clock(), opts.HealthCheck, opts.HealthInterval)
+		}
 	}
 
 	// Legacy support for passing HTTP server via config options.
 	// TODO: Remove this after we feel HTTP options via config have been deprecated.
 	if serverCfg, ok := cfg.(interface{ HTTPServer() *http.Server }); ok {
-		server := serverCfg.HTTPServer()
-		if server != nil {
-			go func() { _ = server.Serve(impl) }()
+		if server := serverCfg.HTTPServer(); server != nil {
+			server = tunnelServer(server, impl.ForwardsProto())
+			s.goroutines.spawn(func() { _ = server.Serve(serverListener{impl}) })
 			impl.server = server
 		}
 	}
 
-	if err == nil {
-		return impl, nil
+	if err != nil {
+		return nil, errListen{limitExceeded(err)}
+	}
+	if redirectCfg, ok := cfg.(interface{ RedirectsHTTP() bool }); ok && redirectCfg.RedirectsHTTP() {
+		if err := s.listenRedirect(ctx, impl); err != nil {
+			_ = impl.Close()
+			return nil, err
+		}
 	}
-	return nil, errListen{err}
+	return impl, nil
 }
 
 func (s *sessionImpl) ListenAndForward(ctx context.Context, url *url.URL, cfg config.Tunnel) (Forwarder, error) {
@@ -894,30 +1683,49 @@ func (s *sessionImpl) ListenAndForward(ctx context.Context, url *url.URL, cfg co
 	// Set 'Forwards To'
 	tunnelCfg.WithForwardsTo(url)
 
-	tun, err := s.Listen(ctx, cfg)
+	opts := tunnelCfg.UpstreamOptions()
+	if err := loadUpstreamCAs(&opts); err != nil {
+		return nil, err
+	}
+
+	tun, err := s.listen(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
-	return forwardTunnel(ctx, tun, url), nil
+	if opts.Dial == nil {
+		if dialer, ok := s.upstreamDialer.(*happyEyeballsDialer); ok {
+			if opts.Resolver == nil {
+				opts.Resolver = dialer.resolver
+			}
+			opts.Dial = dialer.withUpstreamOptions(opts).DialContext
+		} else if s.upstreamDialer != nil {
+			opts.Dial = s.upstreamDialer.DialContext
+		}
+	}
+
+	return forwardTunnel(ctx, tun, url, opts), nil
 }
 
 func (s *sessionImpl) ListenAndServeHTTP(ctx context.Context, cfg config.Tunnel, server *http.Server) (Forwarder, error) {
-	tun, err := s.Listen(ctx, cfg)
+	tun, err := s.listen(ctx, cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	mainGroup, _ := errgroup.WithContext(ctx)
 	if server != nil {
-		// Store server ref to close when tunnel closes
-		impl, _ := tun.(*tunnelImpl)
-
 		// Check if tunnel is already serving an HTTP server
 		// TODO: Remove this once we feel HTTP options via config have been deprecated.
-		if impl.server == nil {
-			mainGroup.Go(func() error { return server.Serve(tun) })
-			impl.server = server
+		if tun.server == nil {
+			var settings upstream.HTTPServer
+			if tunnelCfg, ok := cfg.(tunnelConfigPrivate); ok {
+				settings = tunnelCfg.UpstreamOptions().HTTPServer
+			}
+			server := copyServerFor(server, tun.ForwardsProto(), settings, s.inner().Logger.With("clientid", tun.ID()))
+			mainGroup.Go(func() error { return server.Serve(serverListener{tun}) })
+			// Store server ref to close when tunnel closes
+			tun.server = server
 		} else {
 			// Inform end user that they're using a deprecated option.
 			s.inner().Logger.Warn("Tunnel is serving an HTTP server via HTTP options. This has been deprecated. Please use Session.ListenAndServeHTTP instead.")
@@ -931,7 +1739,7 @@ func (s *sessionImpl) ListenAndServeHTTP(ctx context.Context, cfg config.Tunnel,
 }
 
 func (s *sessionImpl) ListenAndHandleHTTP(ctx context.Context, cfg config.Tunnel, handler *http.Handler) (Forwarder, error) {
-	return s.ListenAndServeHTTP(ctx, cfg, &http.Server{Handler: *handler})
+	return s.ListenAndServeHTTP(ctx, cfg, handlerServer(handler))
 }
 
 // The rest of the `sessionImpl` methods are non-public, but can be
@@ -956,8 +1764,11 @@ func (s *sessionImpl) PlanName() string {
 func (s *sessionImpl) Banner() string {
 	return s.inner().Banner
 }
-func (s *sessionImpl) SessionDuration() int64 {
-	return s.inner().SessionDuration
+func (s *sessionImpl) SessionDuration() time.Duration {
+	return time.Duration(s.inner().SessionDuration) * time.Second
+}
+func (s *sessionImpl) Deprecation() *AgentVersionDeprecated {
+	return (*AgentVersionDeprecated)(s.inner().DeprecationWarning)
 }
 func (s *sessionImpl) Region() string {
 	return s.inner().Region
@@ -977,7 +1788,7 @@ func (s *sessionImpl) ConnectAddresses() []struct{ Region, ServerAddr string } {
 }
 
 type remoteCallbackHandler struct {
-	log15.Logger
+	*slog.Logger
 	sess           *sessionImpl
 	stopHandler    ServerCommandHandler
 	restartHandler ServerCommandHandler
@@ -1,7 +1,10 @@
 package ngrok
 
 import (
+	"context"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -20,3 +23,47 @@ func TestUserAgent(t *testing.T) {
 	}).ToUserAgent()
 	require.Equal(t, "agent-official-go/3.2.1 ({\"ProxyType\": \"socks5\", \"ConfigVersion\": \"2\"})", s)
 }
+
+func TestConnectMaxAttempts(t *testing.T) {
+	// Grab a free port and close it so that every dial is refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	_, err = Connect(context.Background(),
+		WithServer(addr),
+		WithMaxConnectAttempts(2),
+	)
+	require.ErrorIs(t, err, errConnectAttempts{})
+	require.ErrorIs(t, err, errSessionDial{})
+
+	var attemptsErr errConnectAttempts
+	require.ErrorAs(t, err, &attemptsErr)
+	require.Equal(t, 2, attemptsErr.Attempts)
+}
+
+func TestConnectTimeout(t *testing.T) {
+	// Accept connections but never respond, so that the TLS handshake hangs.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	start := time.Now()
+	_, err = Connect(context.Background(),
+		WithServer(l.Addr().String()),
+		WithConnectTimeout(100*time.Millisecond),
+	)
+	require.ErrorIs(t, err, errConnectTimeout{})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
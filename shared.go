@@ -0,0 +1,350 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// SharedTransport lets many sessions in one process, such as one per tenant
+// of a multi-tenant application, share a single connection to the ngrok
+// service, to save the file descriptors and TLS state of a connection each.
+// The ngrok service authenticates connections rather than sessions, so the
+// sessions share the transport's [ConnectOption]s, including the authtoken.
+//
+// Each session returned by [SharedTransport.Connect] owns the tunnels started
+// on it, and closing it closes only those. The connection is made by the
+// first Connect, and closed along with the last session. Everything else,
+// including events, stats and status reports, describes the shared
+// connection, and so covers the tunnels of every session sharing it.
+//
+// SharedTransport is experimental, and may change or be removed.
+type SharedTransport struct {
+	opts []ConnectOption
+
+	mu   sync.Mutex
+	conn *sharedConn
+	// Closed once the connection being made by a Connect is made or fails.
+	dialing chan struct{}
+}
+
+// A connection of a [SharedTransport], and the number of sessions using it.
+type sharedConn struct {
+	sess Session
+	refs int
+}
+
+// NewSharedTransport creates a [SharedTransport] that connects with opts.
+func NewSharedTransport(opts ...ConnectOption) *SharedTransport {
+	return &SharedTransport{opts: opts}
+}
+
+// Connect returns a new session sharing the transport's connection, first
+// connecting it if no other session is open, or if the connection was closed
+// without them, such as by a stop command from the ngrok service. Sessions
+// still open on a closed connection keep it until they're closed.
+func (st *SharedTransport) Connect(ctx context.Context) (Session, error) {
+	st.mu.Lock()
+	for st.conn == nil || sessionClosed(st.conn.sess) {
+		st.conn = nil
+		if dialing := st.dialing; dialing != nil {
+			// Wait for the connection another Connect is making.
+			st.mu.Unlock()
+			select {
+			case <-dialing:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			st.mu.Lock()
+			continue
+		}
+		dialing := make(chan struct{})
+		st.dialing = dialing
+		st.mu.Unlock()
+		sess, err := Connect(ctx, st.opts...)
+		st.mu.Lock()
+		st.dialing = nil
+		close(dialing)
+		if err != nil {
+			st.mu.Unlock()
+			return nil, err
+		}
+		st.conn = &sharedConn{sess: sess}
+	}
+	conn := st.conn
+	conn.refs++
+	st.mu.Unlock()
+
+	sessCtx, cancel := context.WithCancel(context.Background())
+	return &sharedSession{
+		conn:      conn.sess,
+		shared:    conn,
+		transport: st,
+		ctx:       sessCtx,
+		cancel:    cancel,
+		closers:   make(map[closer]struct{}),
+		subs:      make(map[EventSubscription]struct{}),
+	}, nil
+}
+
+// Report whether a session has been closed, including by the ngrok service.
+func sessionClosed(sess Session) bool {
+	impl, err := unwrapSession(sess, "")
+	return err == nil && impl.closed.Load()
+}
+
+// Release a session's reference to a connection, closing it if it was the
+// last.
+func (st *SharedTransport) release(ctx context.Context, conn *sharedConn) error {
+	st.mu.Lock()
+	conn.refs--
+	if conn.refs > 0 {
+		st.mu.Unlock()
+		return nil
+	}
+	if st.conn == conn {
+		st.conn = nil
+	}
+	st.mu.Unlock()
+	return conn.sess.CloseWithContext(ctx)
+}
+
+// A tunnel or forwarder started on a shared session.
+type closer interface {
+	CloseWithContext(context.Context) error
+}
+
+// A session sharing the connection of a [SharedTransport]. It forwards every
+// Session method to the connection explicitly, so that none bypasses the
+// tracking of what the session owns.
+type sharedSession struct {
+	conn      Session
+	shared    *sharedConn
+	transport *SharedTransport
+
+	// Done once the session is closed.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	closers map[closer]struct{}
+	subs    map[EventSubscription]struct{}
+	closed  bool
+}
+
+var errSharedSessionClosed = errors.New("session closed")
+
+// Record a tunnel or forwarder to close along with the session.
+func (s *sharedSession) own(c closer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		_ = c.CloseWithContext(context.Background())
+		return errSharedSessionClosed
+	}
+	// Forget those that were closed in the meantime.
+	for owned := range s.closers {
+		if isClosed(owned) {
+			delete(s.closers, owned)
+		}
+	}
+	s.closers[c] = struct{}{}
+	return nil
+}
+
+// Report whether the session has been closed.
+func (s *sharedSession) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// Report whether a tunnel or forwarder has been closed.
+func isClosed(c closer) bool {
+	switch c := c.(type) {
+	case *tunnelImpl:
+		return c.closed.Load()
+	case *leakCheckedTunnel:
+		return c.closed.Load()
+	case *forwarder:
+		return isClosed(c.Tunnel)
+	case *leakCheckedForwarder:
+		return isClosed(c.Forwarder)
+	}
+	return false
+}
+
+func (s *sharedSession) Listen(ctx context.Context, cfg config.Tunnel) (Tunnel, error) {
+	if s.isClosed() {
+		return nil, errSharedSessionClosed
+	}
+	tun, err := s.conn.Listen(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.own(tun); err != nil {
+		return nil, err
+	}
+	return tun, nil
+}
+
+func (s *sharedSession) ListenAndForward(ctx context.Context, backend *url.URL, cfg config.Tunnel) (Forwarder, error) {
+	if s.isClosed() {
+		return nil, errSharedSessionClosed
+	}
+	fwd, err := s.conn.ListenAndForward(ctx, backend, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.own(fwd); err != nil {
+		return nil, err
+	}
+	return fwd, nil
+}
+
+func (s *sharedSession) ListenAndServeHTTP(ctx context.Context, cfg config.Tunnel, server *http.Server) (Forwarder, error) {
+	if s.isClosed() {
+		return nil, errSharedSessionClosed
+	}
+	fwd, err := s.conn.ListenAndServeHTTP(ctx, cfg, server)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.own(fwd); err != nil {
+		return nil, err
+	}
+	return fwd, nil
+}
+
+func (s *sharedSession) ListenAndHandleHTTP(ctx context.Context, cfg config.Tunnel, handler *http.Handler) (Forwarder, error) {
+	return s.ListenAndServeHTTP(ctx, cfg, handlerServer(handler))
+}
+
+func (s *sharedSession) Warnings() []error {
+	return s.conn.Warnings()
+}
+
+func (s *sharedSession) AccountName() string {
+	return s.conn.AccountName()
+}
+
+func (s *sharedSession) PlanName() string {
+	return s.conn.PlanName()
+}
+
+func (s *sharedSession) Banner() string {
+	return s.conn.Banner()
+}
+
+func (s *sharedSession) SessionDuration() time.Duration {
+	return s.conn.SessionDuration()
+}
+
+func (s *sharedSession) Deprecation() *AgentVersionDeprecated {
+	return s.conn.Deprecation()
+}
+
+func (s *sharedSession) ServerInfo(ctx context.Context) (ServerInfo, error) {
+	if s.isClosed() {
+		return ServerInfo{}, errSharedSessionClosed
+	}
+	return s.conn.ServerInfo(ctx)
+}
+
+func (s *sharedSession) Stats() SessionStats {
+	return s.conn.Stats()
+}
+
+func (s *sharedSession) StatusReport() StatusReport {
+	return s.conn.StatusReport()
+}
+
+func (s *sharedSession) RecentLogs() []LogRecord {
+	return s.conn.RecentLogs()
+}
+
+// AddEventHandler registers the handler with the connection until it's
+// removed or the session is closed.
+func (s *sharedSession) AddEventHandler(handler EventHandler) EventSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return EventSubscription{}
+	}
+	sub := s.conn.AddEventHandler(handler)
+	s.subs[sub] = struct{}{}
+	return sub
+}
+
+func (s *sharedSession) RemoveEventHandler(sub EventSubscription) {
+	s.mu.Lock()
+	_, ok := s.subs[sub]
+	delete(s.subs, sub)
+	s.mu.Unlock()
+	if ok {
+		s.conn.RemoveEventHandler(sub)
+	}
+}
+
+// Events delivers the connection's events until the context is done or the
+// session is closed.
+func (s *sharedSession) Events(ctx context.Context) <-chan Event {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.ctx, cancel)
+	context.AfterFunc(ctx, func() { stop() })
+	return s.conn.Events(ctx)
+}
+
+func (s *sharedSession) ActiveResources() Resources {
+	return s.conn.ActiveResources()
+}
+
+func (s *sharedSession) RemoteEndpoints(ctx context.Context) ([]*RemoteEndpoint, error) {
+	if s.isClosed() {
+		return nil, errSharedSessionClosed
+	}
+	return s.conn.RemoteEndpoints(ctx)
+}
+
+func (s *sharedSession) Close() error {
+	return s.CloseWithContext(context.Background())
+}
+
+// CloseWithContext closes the session's tunnels and event subscriptions, and
+// the shared connection if no other session is using it. Tunnels that were
+// already closed are skipped, so only the error from closing the connection
+// is returned.
+func (s *sharedSession) CloseWithContext(ctx context.Context) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return errSharedSessionClosed
+	}
+	s.closed = true
+	closers, subs := s.closers, s.subs
+	s.closers, s.subs = nil, nil
+	s.mu.Unlock()
+	s.cancel()
+
+	for sub := range subs {
+		s.conn.RemoveEventHandler(sub)
+	}
+	var wg sync.WaitGroup
+	for c := range closers {
+		if isClosed(c) {
+			continue
+		}
+		wg.Add(1)
+		go func(c closer) {
+			defer wg.Done()
+			_ = c.CloseWithContext(ctx)
+		}(c)
+	}
+	wg.Wait()
+	return s.transport.release(ctx, s.shared)
+}
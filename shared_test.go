@@ -0,0 +1,180 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestSharedTransport(t *testing.T) {
+	ctx := context.Background()
+	st := NewSharedTransport(WithOfflineFallback("127.0.0.1:0"))
+
+	a, err := st.Connect(ctx)
+	require.NoError(t, err)
+	b, err := st.Connect(ctx)
+	require.NoError(t, err)
+	conn := a.(*sharedSession).conn
+	require.Equal(t, conn, b.(*sharedSession).conn)
+
+	tunA, err := a.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+	tunB, err := b.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+	closedEarly, err := b.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+	require.NoError(t, closedEarly.Close())
+
+	// Closing a session closes only its own tunnels.
+	require.NoError(t, a.Close())
+	_, err = tunA.Accept()
+	require.Error(t, err)
+	require.ErrorIs(t, a.Close(), errSharedSessionClosed)
+	_, err = a.Listen(ctx, config.TCPEndpoint())
+	require.Error(t, err)
+
+	dialTunnel(t, tunB)
+	accepted, err := tunB.Accept()
+	require.NoError(t, err)
+	accepted.Close()
+	impl, err := unwrapSession(conn, "test")
+	require.NoError(t, err)
+	require.False(t, impl.closed.Load())
+
+	// The connection is closed along with the last session.
+	require.NoError(t, b.Close())
+	_, err = tunB.Accept()
+	require.Error(t, err)
+	require.True(t, impl.closed.Load())
+
+	// And made again by the next.
+	c, err := st.Connect(ctx)
+	require.NoError(t, err)
+	defer c.Close()
+	require.NotEqual(t, conn, c.(*sharedSession).conn)
+}
+
+func TestSharedSessionClose(t *testing.T) {
+	ctx := context.Background()
+	st := NewSharedTransport(WithOfflineFallback("127.0.0.1:0"))
+
+	a, err := st.Connect(ctx)
+	require.NoError(t, err)
+	b, err := st.Connect(ctx)
+	require.NoError(t, err)
+	defer b.Close()
+	impl, err := unwrapSession(a, "test")
+	require.NoError(t, err)
+
+	// A nil handler serves the default mux, as it does for http.Server.
+	fwd, err := a.ListenAndHandleHTTP(ctx, config.HTTPEndpoint(), nil)
+	require.NoError(t, err)
+
+	a.AddEventHandler(func(Event) {})
+	events := a.Events(ctx)
+	require.NoError(t, a.Close())
+
+	// Closing the session ends its subscriptions and forwarders, though
+	// the connection stays up for b.
+	for range events {
+	}
+	require.Eventually(t, func() bool {
+		impl.events.mu.Lock()
+		defer impl.events.mu.Unlock()
+		return len(impl.events.handlers) == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Error(t, fwd.Wait())
+	require.False(t, impl.closed.Load())
+
+	require.Equal(t, EventSubscription{}, a.AddEventHandler(func(Event) {}))
+	_, err = a.ServerInfo(ctx)
+	require.ErrorIs(t, err, errSharedSessionClosed)
+	_, err = a.ListenAndHandleHTTP(ctx, config.HTTPEndpoint(), nil)
+	require.ErrorIs(t, err, errSharedSessionClosed)
+}
+
+func TestSharedTransportReconnect(t *testing.T) {
+	ctx := context.Background()
+	st := NewSharedTransport(WithOfflineFallback("127.0.0.1:0"))
+
+	a, err := st.Connect(ctx)
+	require.NoError(t, err)
+	// Close the connection out from under the session, as a stop handler would.
+	stale := a.(*sharedSession).conn
+	require.NoError(t, stale.Close())
+
+	b, err := st.Connect(ctx)
+	require.NoError(t, err)
+	defer b.Close()
+	conn := b.(*sharedSession).conn
+	require.NotEqual(t, stale, conn)
+
+	// Closing the session on the old connection leaves the new one open.
+	_ = a.Close()
+	impl, err := unwrapSession(conn, "test")
+	require.NoError(t, err)
+	require.False(t, impl.closed.Load())
+	_, err = b.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+}
+
+// A dialer that fails once released.
+type blockedDialer struct {
+	release chan struct{}
+}
+
+func (d blockedDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, address)
+}
+
+func (d blockedDialer) DialContext(context.Context, string, string) (net.Conn, error) {
+	<-d.release
+	return nil, errors.New("unreachable")
+}
+
+func TestSharedTransportConnectConcurrently(t *testing.T) {
+	dialer := blockedDialer{release: make(chan struct{})}
+	st := NewSharedTransport(
+		WithAuthtoken("token"),
+		WithDialer(dialer),
+		WithMaxConnectAttempts(1),
+		WithOfflineFallback("127.0.0.1:0"),
+	)
+
+	first := make(chan Session, 1)
+	go func() {
+		sess, err := st.Connect(context.Background())
+		require.NoError(t, err)
+		first <- sess
+	}()
+	require.Eventually(t, func() bool {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		return st.dialing != nil
+	}, time.Second, time.Millisecond)
+
+	// Another Connect waits for the connection being made, without holding
+	// up the transport.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := st.Connect(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	second := make(chan Session, 1)
+	go func() {
+		sess, err := st.Connect(context.Background())
+		require.NoError(t, err)
+		second <- sess
+	}()
+	close(dialer.release)
+	a, b := <-first, <-second
+	defer a.Close()
+	defer b.Close()
+	require.Equal(t, a.(*sharedSession).conn, b.(*sharedSession).conn)
+}
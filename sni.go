@@ -0,0 +1,85 @@
+package ngrok
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"time"
+)
+
+// The time allowed for a client to send its ClientHello when forwarding is
+// routed by server name.
+const sniTimeout = 10 * time.Second
+
+// Read the ClientHello from a connection carrying end-to-end TLS and pick the
+// upstream for the server name it requests. The returned connection replays
+// the bytes that were read, so the upstream still sees the full handshake.
+// The forwarding URL is used when no route matches.
+func routeSNI(conn Conn, fwdURL *url.URL, routes map[string]string) (Conn, *url.URL, string) {
+	rec := &recordingReader{r: conn}
+	_ = conn.SetReadDeadline(time.Now().Add(sniTimeout))
+	serverName := peekServerName(conn, rec)
+	_ = conn.SetReadDeadline(time.Time{})
+
+	replay := &replayConn{
+		Conn: conn,
+		r:    io.MultiReader(bytes.NewReader(rec.buf), conn),
+	}
+
+	addr, ok := matchRoute(routes, serverName)
+	if !ok {
+		return replay, fwdURL, serverName
+	}
+	routed := *fwdURL
+	routed.Host = addr
+	return replay, &routed, serverName
+}
+
+var errClientHelloRead = errors.New("client hello read")
+
+// Parse a ClientHello from r and return the server name it requests, if any.
+func peekServerName(conn net.Conn, r io.Reader) string {
+	var serverName string
+	_ = tls.Server(&readOnlyConn{Conn: conn, r: r}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = info.ServerName
+			return nil, errClientHelloRead
+		},
+	}).Handshake()
+	return serverName
+}
+
+// Records everything read from the underlying reader.
+type recordingReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	return n, err
+}
+
+// Lets crypto/tls read a ClientHello without writing anything back.
+type readOnlyConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *readOnlyConn) Read(p []byte) (int, error)  { return c.r.Read(p) }
+func (c *readOnlyConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+
+// A tunnel connection whose first reads replay bytes that were already
+// consumed from it.
+type replayConn struct {
+	Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
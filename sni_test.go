@@ -0,0 +1,44 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouteSNI(t *testing.T) {
+	fwdURL := &url.URL{Scheme: "tls", Host: "localhost:443"}
+	routes := map[string]string{"api.example.com": "localhost:8443"}
+
+	for serverName, expected := range map[string]string{
+		"api.example.com": "localhost:8443",
+		"www.example.com": "localhost:443",
+	} {
+		tunnelConn, clientSide := testTunnelConn(t, "tls")
+		go func(serverName string) {
+			_ = tls.Client(clientSide, &tls.Config{ServerName: serverName}).Handshake()
+		}(serverName)
+
+		conn, target, name := routeSNI(tunnelConn, fwdURL, routes)
+		require.Equal(t, serverName, name)
+		require.Equal(t, expected, target.Host)
+		require.Equal(t, "tls", target.Scheme)
+
+		// The ClientHello is replayed to the upstream.
+		var hello *tls.ClientHelloInfo
+		_ = tls.Server(conn, &tls.Config{
+			GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+				hello = info
+				return nil, io.EOF
+			},
+		}).Handshake()
+		require.NotNil(t, hello)
+		require.Equal(t, serverName, hello.ServerName)
+		clientSide.Close()
+	}
+
+	require.Equal(t, "localhost:443", fwdURL.Host)
+}
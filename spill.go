@@ -0,0 +1,209 @@
+package ngrok
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+)
+
+// The most data buffered in memory for a connection with a spill buffer
+// before the rest is written to disk.
+const spillMemoryBytes = 64 << 10
+
+// The size of the reads from a connection with a spill buffer.
+const spillChunkBytes = 32 << 10
+
+// A forwarded connection whose incoming data is read as fast as it arrives,
+// and buffered for the upstream in memory and then on disk.
+type spillConn struct {
+	Conn
+	buf *spillBuffer
+}
+
+// Buffer the data read from conn in a temporary file in dir, up to maxBytes
+// beyond what's held in memory. The goroutine reading from conn is counted
+// in goroutines.
+func newSpillConn(conn Conn, dir string, maxBytes int64, goroutines *goroutineCount) *spillConn {
+	buf := newSpillBuffer(dir, maxBytes)
+	goroutines.spawn(func() {
+		_, err := io.CopyBuffer(buf, conn, make([]byte, spillChunkBytes))
+		buf.closeWrite(err)
+	})
+	return &spillConn{Conn: conn, buf: buf}
+}
+
+func (c *spillConn) Read(p []byte) (int, error) {
+	return c.buf.Read(p)
+}
+
+func (c *spillConn) Close() error {
+	c.buf.close()
+	return c.Conn.Close()
+}
+
+var errSpillClosed = errors.New("spill buffer closed")
+
+// A pipe that holds what's written to it until it's read, in memory and then
+// in a temporary file. Writes block while it's full. Reads and writes are each
+// serialized, so that the file can be read and written without holding mu,
+// leaving the other side free to proceed meanwhile.
+type spillBuffer struct {
+	dir string
+	max int64
+
+	readMu  sync.Mutex
+	writeMu sync.Mutex
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	mem  bytes.Buffer
+	// The temporary file, created when memory runs out, and the offsets of
+	// the next read and write in it. Everything in the file was written after
+	// everything in memory.
+	file         *os.File
+	fileR, fileW int64
+	// Whether a write to the file is in progress at fileW, in which case the
+	// offsets mustn't be reset.
+	writing   bool
+	writeErr  error
+	writeDone bool
+	closed    bool
+}
+
+func newSpillBuffer(dir string, maxBytes int64) *spillBuffer {
+	b := &spillBuffer{dir: dir, max: max(maxBytes, 0)}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// The number of bytes waiting to be read. The lock must be held.
+func (b *spillBuffer) size() int64 {
+	return int64(b.mem.Len()) + b.fileW - b.fileR
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	b.writeMu.Lock()
+	defer b.writeMu.Unlock()
+	written := 0
+	for len(p) > 0 {
+		n, err := b.writeChunk(p)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Write as much of p as there's room for, waiting for some room first.
+func (b *spillBuffer) writeChunk(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.closed && b.size() >= spillMemoryBytes+b.max {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return 0, errSpillClosed
+	}
+	chunk := p[:min(int64(len(p)), spillMemoryBytes+b.max-b.size())]
+	if b.fileW == b.fileR && b.mem.Len()+len(chunk) <= spillMemoryBytes {
+		b.mem.Write(chunk)
+		b.cond.Broadcast()
+		return len(chunk), nil
+	}
+
+	if b.file == nil {
+		b.mu.Unlock()
+		file, err := os.CreateTemp(b.dir, "ngrok-spill-*")
+		b.mu.Lock()
+		if err != nil {
+			return 0, err
+		}
+		if b.closed {
+			_ = file.Close()
+			_ = os.Remove(file.Name())
+			return 0, errSpillClosed
+		}
+		b.file = file
+	}
+	file, off := b.file, b.fileW
+	b.writing = true
+	b.mu.Unlock()
+	n, err := file.WriteAt(chunk, off)
+	b.mu.Lock()
+	b.writing = false
+	if b.closed {
+		return 0, errSpillClosed
+	}
+	b.fileW += int64(n)
+	b.cond.Broadcast()
+	return n, err
+}
+
+func (b *spillBuffer) Read(p []byte) (int, error) {
+	b.readMu.Lock()
+	defer b.readMu.Unlock()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for !b.closed && !b.writeDone && b.size() == 0 {
+		b.cond.Wait()
+	}
+	switch {
+	case b.closed:
+		return 0, errSpillClosed
+	case b.size() == 0 && b.writeErr != nil:
+		return 0, b.writeErr
+	case b.size() == 0:
+		return 0, io.EOF
+	}
+
+	if b.mem.Len() > 0 {
+		n, err := b.mem.Read(p)
+		b.cond.Broadcast()
+		return n, err
+	}
+
+	file, off := b.file, b.fileR
+	p = p[:min(int64(len(p)), b.fileW-b.fileR)]
+	b.mu.Unlock()
+	n, err := file.ReadAt(p, off)
+	b.mu.Lock()
+	if b.closed {
+		return 0, errSpillClosed
+	}
+	b.fileR += int64(n)
+	if b.fileR == b.fileW && !b.writing {
+		// Start over at the beginning of the file once it's drained.
+		b.fileR, b.fileW = 0, 0
+	}
+	b.cond.Broadcast()
+	return n, err
+}
+
+// Record that nothing more will be written, because of err if it isn't nil.
+func (b *spillBuffer) closeWrite(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.writeDone = true
+	b.writeErr = err
+	b.cond.Broadcast()
+}
+
+// Discard the buffered data and remove the temporary file.
+func (b *spillBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.mem.Reset()
+	if b.file != nil {
+		_ = b.file.Close()
+		_ = os.Remove(b.file.Name())
+	}
+	b.cond.Broadcast()
+}
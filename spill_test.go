@@ -0,0 +1,125 @@
+package ngrok
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestSpillBuffer(t *testing.T) {
+	dir := t.TempDir()
+	buf := newSpillBuffer(dir, 4*spillMemoryBytes)
+	data := make([]byte, 3*spillMemoryBytes)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	// What doesn't fit in memory is written to disk.
+	n, err := buf.Write(data)
+	require.NoError(t, err)
+	require.Equal(t, len(data), n)
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// Writes block once the buffer is full, until it's read.
+	wrote := make(chan error, 1)
+	go func() {
+		_, err := buf.Write(data)
+		wrote <- err
+	}()
+	select {
+	case <-wrote:
+		t.Fatal("write to a full buffer didn't block")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(buf, got)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, got))
+	require.NoError(t, <-wrote)
+
+	// It's read back in order, and reports the end once it's drained.
+	buf.closeWrite(nil)
+	got, err = io.ReadAll(buf)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, got))
+
+	// Closing it removes the file.
+	buf.close()
+	files, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, files)
+	_, err = buf.Write(data)
+	require.ErrorIs(t, err, errSpillClosed)
+}
+
+func TestSpillBufferConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	buf := newSpillBuffer(dir, spillMemoryBytes)
+	defer buf.close()
+	data := make([]byte, 16*spillMemoryBytes)
+	_, err := rand.Read(data)
+	require.NoError(t, err)
+
+	// Reads and writes of the file overlap, as it's drained and refilled.
+	go func() {
+		for p := data; len(p) > 0; {
+			n := min(len(p), 7919)
+			_, err := buf.Write(p[:n])
+			if err != nil {
+				buf.closeWrite(err)
+				return
+			}
+			p = p[n:]
+		}
+		buf.closeWrite(nil)
+	}()
+	var got bytes.Buffer
+	_, err = io.CopyBuffer(&got, buf, make([]byte, 5003))
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, got.Bytes()))
+}
+
+func TestForwardWithSpillBuffer(t *testing.T) {
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+	fwd, err := sess.ListenAndForward(ctx, &url.URL{Scheme: "tcp", Host: upstream.Addr().String()},
+		config.TCPEndpoint(config.WithSpillBuffer(t.TempDir(), 1<<20)))
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	conn := dialTunnel(t, fwd)
+	data := make([]byte, 4*spillMemoryBytes)
+	_, err = rand.Read(data)
+	require.NoError(t, err)
+	go func() { _, _ = conn.Write(data) }()
+	got := make([]byte, len(data))
+	_, err = io.ReadFull(conn, got)
+	require.NoError(t, err)
+	require.True(t, bytes.Equal(data, got))
+}
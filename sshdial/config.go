@@ -0,0 +1,107 @@
+package sshdial
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+)
+
+// The settings from an SSH config that apply to connecting to a jump host.
+type hostConfig struct {
+	hostName       string
+	user           string
+	port           string
+	identityFiles  []string
+	knownHostsFile string
+}
+
+// The Host sections of an SSH config, in order. Settings outside of any Host
+// section apply to every host.
+type sshConfig []configSection
+
+type configSection struct {
+	patterns []string
+	settings [][2]string
+}
+
+func readConfig(file string) (sshConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg := sshConfig{{patterns: []string{"*"}}}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, " \t=")
+		if i < 0 {
+			continue
+		}
+		key := strings.ToLower(line[:i])
+		value := strings.Trim(strings.TrimLeft(line[i:], " \t="), `"`)
+		if key == "host" {
+			cfg = append(cfg, configSection{patterns: strings.Fields(value)})
+			continue
+		}
+		last := &cfg[len(cfg)-1]
+		last.settings = append(last.settings, [2]string{key, value})
+	}
+	return cfg, scanner.Err()
+}
+
+// Resolve the settings for a host. As with ssh, the first value found for
+// each setting wins, except for identity files, which accumulate.
+func (cfg sshConfig) lookup(host string) hostConfig {
+	var hc hostConfig
+	for _, section := range cfg {
+		if !section.matches(host) {
+			continue
+		}
+		for _, kv := range section.settings {
+			key, value := kv[0], kv[1]
+			switch key {
+			case "hostname":
+				if hc.hostName == "" {
+					hc.hostName = strings.ReplaceAll(value, "%h", host)
+				}
+			case "user":
+				if hc.user == "" {
+					hc.user = value
+				}
+			case "port":
+				if hc.port == "" {
+					hc.port = value
+				}
+			case "identityfile":
+				hc.identityFiles = append(hc.identityFiles, value)
+			case "userknownhostsfile":
+				if files := strings.Fields(value); hc.knownHostsFile == "" && len(files) > 0 {
+					hc.knownHostsFile = files[0]
+				}
+			}
+		}
+	}
+	return hc
+}
+
+// Whether a host matches the section's patterns, any of which may be negated
+// with a leading "!".
+func (s configSection) matches(host string) bool {
+	matched := false
+	for _, pattern := range s.patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if ok, _ := path.Match(strings.TrimPrefix(pattern, "!"), host); ok {
+			if negated {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
@@ -0,0 +1,340 @@
+// Package sshdial connects to upstream services through an SSH jump host, for
+// exposing services that are only reachable from a bastion. A [Dialer] plugs
+// into [golang.ngrok.com/ngrok/config.WithUpstreamDialer]:
+//
+//	d, err := sshdial.New("bastion.example.com")
+//	defer d.Close()
+//	fwd, err := sess.ListenAndForward(ctx, upstreamURL,
+//		config.HTTPEndpoint(config.WithUpstreamDialer(d.DialContext)))
+//
+// The jump host is resolved through ~/.ssh/config as the ssh command would,
+// so it may be a Host alias. Its host key is checked against
+// ~/.ssh/known_hosts, and the client authenticates with the keys offered by
+// the SSH agent and the identity files configured for the host.
+package sshdial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const defaultTimeout = 15 * time.Second
+
+// Option configures a [Dialer].
+type Option func(*options)
+
+type options struct {
+	user            string
+	port            string
+	signers         []ssh.Signer
+	password        string
+	hostKeyCallback ssh.HostKeyCallback
+	configPath      string
+	knownHostsPath  string
+	timeout         time.Duration
+}
+
+// WithUser sets the user to log in to the jump host as, overriding the
+// user from the address and the SSH config.
+func WithUser(user string) Option {
+	return func(opts *options) {
+		opts.user = user
+	}
+}
+
+// WithPort sets the port of the jump host, overriding the port from the
+// address and the SSH config.
+func WithPort(port int) Option {
+	return func(opts *options) {
+		opts.port = strconv.Itoa(port)
+	}
+}
+
+// WithSigners authenticates with the given keys, instead of those of the SSH
+// agent and the configured identity files.
+func WithSigners(signers ...ssh.Signer) Option {
+	return func(opts *options) {
+		opts.signers = append(opts.signers, signers...)
+	}
+}
+
+// WithPassword authenticates with a password, in addition to any keys.
+func WithPassword(password string) Option {
+	return func(opts *options) {
+		opts.password = password
+	}
+}
+
+// WithHostKeyCallback sets how the jump host's key is verified, instead of
+// checking it against the known hosts file.
+func WithHostKeyCallback(callback ssh.HostKeyCallback) Option {
+	return func(opts *options) {
+		opts.hostKeyCallback = callback
+	}
+}
+
+// WithSSHConfig reads the SSH config from path rather than ~/.ssh/config. An
+// empty path disables the SSH config.
+func WithSSHConfig(path string) Option {
+	return func(opts *options) {
+		opts.configPath = path
+	}
+}
+
+// WithKnownHosts reads the known hosts from path rather than the SSH config's
+// UserKnownHostsFile or ~/.ssh/known_hosts.
+func WithKnownHosts(path string) Option {
+	return func(opts *options) {
+		opts.knownHostsPath = path
+	}
+}
+
+// WithTimeout limits how long connecting to the jump host may take. It
+// defaults to 15 seconds.
+func WithTimeout(d time.Duration) Option {
+	return func(opts *options) {
+		opts.timeout = d
+	}
+}
+
+// Dialer dials upstream services through an SSH jump host. It connects to the
+// jump host on the first dial, and reconnects if the connection is lost. It's
+// safe for concurrent use.
+type Dialer struct {
+	addr   string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+	closed bool
+}
+
+// New creates a Dialer for the jump host at [user@]host[:port], which may be
+// a Host alias from the SSH config.
+func New(jumpHost string, opts ...Option) (*Dialer, error) {
+	home, _ := os.UserHomeDir()
+	o := options{timeout: defaultTimeout}
+	var defaultKnownHosts string
+	if home != "" {
+		o.configPath = filepath.Join(home, ".ssh", "config")
+		defaultKnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	user, host, port := splitJumpHost(jumpHost)
+	var hostCfg hostConfig
+	if o.configPath != "" {
+		cfg, err := readConfig(o.configPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("sshdial: %w", err)
+		}
+		hostCfg = cfg.lookup(host)
+	}
+	if hostCfg.hostName != "" {
+		host = hostCfg.hostName
+	}
+	user = firstNonEmpty(o.user, user, hostCfg.user, os.Getenv("USER"))
+	port = firstNonEmpty(o.port, port, hostCfg.port, "22")
+
+	hostKeyCallback := o.hostKeyCallback
+	if hostKeyCallback == nil {
+		knownHostsPath := firstNonEmpty(o.knownHostsPath, hostCfg.knownHostsFile, defaultKnownHosts)
+		if knownHostsPath == "" {
+			return nil, errors.New("sshdial: no known hosts file to verify the jump host with")
+		}
+		var err error
+		hostKeyCallback, err = knownhosts.New(expandHome(knownHostsPath, home))
+		if err != nil {
+			return nil, fmt.Errorf("sshdial: %w", err)
+		}
+	}
+
+	var auth []ssh.AuthMethod
+	if len(o.signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(o.signers...))
+	} else {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			auth = append(auth, ssh.PublicKeysCallback(agentSigners(sock)))
+		}
+		if signers := loadIdentities(hostCfg.identityFiles, home); len(signers) > 0 {
+			auth = append(auth, ssh.PublicKeys(signers...))
+		}
+	}
+	if o.password != "" {
+		auth = append(auth, ssh.Password(o.password))
+	}
+
+	return &Dialer{
+		addr: net.JoinHostPort(host, port),
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auth,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         o.timeout,
+		},
+	}, nil
+}
+
+// DialContext connects to the address from the jump host. Pass it to
+// config.WithUpstreamDialer.
+func (d *Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	client, err := d.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		conn, err := client.Dial(network, address)
+		done <- result{conn, err}
+	}()
+	select {
+	case res := <-done:
+		if res.err != nil {
+			return nil, fmt.Errorf("sshdial: dial %s via %s: %w", address, d.addr, res.err)
+		}
+		return res.conn, nil
+	case <-ctx.Done():
+		go func() {
+			if res := <-done; res.conn != nil {
+				res.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Close disconnects from the jump host. Connections already dialed through
+// it are closed.
+func (d *Dialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	if d.client == nil {
+		return nil
+	}
+	err := d.client.Close()
+	d.client = nil
+	return err
+}
+
+// Return the connection to the jump host, connecting if there isn't one.
+func (d *Dialer) connect(ctx context.Context) (*ssh.Client, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return nil, net.ErrClosed
+	}
+	if d.client != nil {
+		return d.client, nil
+	}
+
+	conn, err := (&net.Dialer{Timeout: d.config.Timeout}).DialContext(ctx, "tcp", d.addr)
+	if err != nil {
+		return nil, fmt.Errorf("sshdial: connect to jump host: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	} else if d.config.Timeout > 0 {
+		_ = conn.SetDeadline(time.Now().Add(d.config.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.addr, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("sshdial: connect to jump host: %w", err)
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	client := ssh.NewClient(c, chans, reqs)
+	d.client = client
+	// Forget the connection once it's lost, so the next dial reconnects.
+	go func() {
+		_ = client.Wait()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.client == client {
+			d.client = nil
+		}
+	}()
+	return client, nil
+}
+
+func agentSigners(sock string) func() ([]ssh.Signer, error) {
+	return func() ([]ssh.Signer, error) {
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, err
+		}
+		// The signers use the connection to sign, which happens before
+		// authentication completes, so it's left to the garbage collector.
+		return agent.NewClient(conn).Signers()
+	}
+}
+
+// Load the private keys from the identity files, or the default ones if none
+// are configured. Keys that can't be read, or are encrypted, are skipped.
+func loadIdentities(files []string, home string) []ssh.Signer {
+	if len(files) == 0 && home != "" {
+		for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+			files = append(files, filepath.Join(home, ".ssh", name))
+		}
+	}
+	var signers []ssh.Signer
+	for _, file := range files {
+		pem, err := os.ReadFile(expandHome(file, home))
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			continue
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+// Split [user@]host[:port].
+func splitJumpHost(s string) (user, host, port string) {
+	if at := strings.LastIndex(s, "@"); at >= 0 {
+		user, s = s[:at], s[at+1:]
+	}
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		return user, h, p
+	}
+	return user, s, ""
+}
+
+func expandHome(path, home string) string {
+	if home != "" && (path == "~" || strings.HasPrefix(path, "~/")) {
+		return filepath.Join(home, path[1:])
+	}
+	return path
+}
+
+func firstNonEmpty(strs ...string) string {
+	for _, s := range strs {
+		if s != "" {
+			return s
+		}
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package sshdial
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+// Start an SSH server that forwards direct-tcpip channels, accepting the
+// client key.
+func jumpHost(t *testing.T, clientKey ssh.PublicKey) (addr string, hostKey ssh.PublicKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "jump" && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return l.Addr().String(), signer.PublicKey()
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newCh := range chans {
+		if newCh.ChannelType() != "direct-tcpip" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+		data := newCh.ExtraData()
+		hostLen := binary.BigEndian.Uint32(data)
+		host := string(data[4 : 4+hostLen])
+		port := binary.BigEndian.Uint32(data[4+hostLen:])
+		target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10)))
+		if err != nil {
+			_ = newCh.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := newCh.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			_, _ = io.Copy(ch, target)
+			ch.Close()
+		}()
+		go func() {
+			_, _ = io.Copy(target, ch)
+			target.Close()
+		}()
+	}
+}
+
+func TestDialThroughJumpHost(t *testing.T) {
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	require.NoError(t, err)
+	addr, hostKey := jumpHost(t, clientSigner.PublicKey())
+
+	// An upstream only the jump host would reach in practice.
+	upstream, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer upstream.Close()
+	go func() {
+		conn, err := upstream.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.WriteString(conn, "hello from upstream")
+	}()
+
+	d, err := New("jump@"+addr,
+		WithSSHConfig(""),
+		WithSigners(clientSigner),
+		WithHostKeyCallback(ssh.FixedHostKey(hostKey)),
+	)
+	require.NoError(t, err)
+	defer d.Close()
+
+	conn, err := d.DialContext(context.Background(), "tcp", upstream.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	greeting, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "hello from upstream", string(greeting))
+
+	require.NoError(t, d.Close())
+	_, err = d.DialContext(context.Background(), "tcp", upstream.Addr().String())
+	require.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestRejectsUnknownHostKey(t *testing.T) {
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	require.NoError(t, err)
+	addr, _ := jumpHost(t, clientSigner.PublicKey())
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHosts, nil, 0o600))
+	d, err := New("jump@"+addr, WithSSHConfig(""), WithSigners(clientSigner), WithKnownHosts(knownHosts))
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = d.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	require.ErrorContains(t, err, "key is unknown")
+}
+
+func TestKnownHostsPrecedence(t *testing.T) {
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	require.NoError(t, err)
+	addr, _ := jumpHost(t, clientSigner.PublicKey())
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config")
+	missing := filepath.Join(dir, "missing_known_hosts")
+	require.NoError(t, os.WriteFile(config, []byte("UserKnownHostsFile "+missing+"\n"), 0o600))
+
+	// The config's known hosts file is used when no option overrides it.
+	_, err = New("jump@"+addr, WithSSHConfig(config), WithSigners(clientSigner))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// An explicit known hosts file takes precedence over the config.
+	knownHosts := filepath.Join(dir, "known_hosts")
+	require.NoError(t, os.WriteFile(knownHosts, nil, 0o600))
+	d, err := New("jump@"+addr, WithSSHConfig(config), WithSigners(clientSigner), WithKnownHosts(knownHosts))
+	require.NoError(t, err)
+	defer d.Close()
+
+	_, err = d.DialContext(context.Background(), "tcp", "127.0.0.1:1")
+	require.ErrorContains(t, err, "key is unknown")
+}
+
+func TestSSHConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, os.WriteFile(path, []byte(`
+IdentityFile ~/.ssh/global
+
+Host bastion
+	HostName bastion.internal.example.com
+	Port=2222
+	IdentityFile ~/.ssh/bastion
+	User ops
+
+Host *.example.com !secret.example.com
+	User web
+
+Host *
+	User default
+`), 0o600))
+	cfg, err := readConfig(path)
+	require.NoError(t, err)
+
+	bastion := cfg.lookup("bastion")
+	require.Equal(t, "bastion.internal.example.com", bastion.hostName)
+	require.Equal(t, "2222", bastion.port)
+	require.Equal(t, "ops", bastion.user)
+	// Settings outside of a Host section apply to every host.
+	require.Equal(t, []string{"~/.ssh/global", "~/.ssh/bastion"}, bastion.identityFiles)
+
+	require.Equal(t, "web", cfg.lookup("www.example.com").user)
+	require.Equal(t, "default", cfg.lookup("secret.example.com").user)
+}
+
+func TestSplitJumpHost(t *testing.T) {
+	user, host, port := splitJumpHost("ops@bastion:2222")
+	require.Equal(t, []string{"ops", "bastion", "2222"}, []string{user, host, port})
+	user, host, port = splitJumpHost("bastion")
+	require.Equal(t, []string{"", "bastion", ""}, []string{user, host, port})
+}
@@ -0,0 +1,117 @@
+package ngrok
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// If the application takes longer than this to accept a connection after it
+// arrives from the ngrok service, a warning is logged.
+const slowAcceptThreshold = time.Second
+
+// TunnelStats is a snapshot of the counters tracked for a [Tunnel].
+type TunnelStats struct {
+	// The number of connections accepted by the application.
+	Accepted uint64
+	// The number of connections rejected by the [AcceptPolicy] or by CIDR
+	// restrictions enforced with config.WithCIDRsEnforcedLocally.
+	Rejected uint64
+	// The number of connections closed for arriving faster than the rate
+	// limit set with config.WithAcceptRateLimit.
+	Throttled uint64
+	// The time between the most recent connection arriving from the ngrok
+	// service and the application's Accept call returning it.
+	AcceptLatency time.Duration
+	// The mean accept latency across all accepted connections.
+	MeanAcceptLatency time.Duration
+	// The largest accept latency observed.
+	MaxAcceptLatency time.Duration
+	// The number of accepted connections that have not yet been closed.
+	OpenConnections int64
+	// The largest number of connections open at once.
+	MaxOpenConnections int64
+}
+
+// SessionStats is a snapshot of the usage tracked for a [Session] across all
+// of its tunnels.
+type SessionStats struct {
+	// The total number of connections accepted.
+	Connections uint64
+	// The total number of bytes read from accepted connections.
+	BytesRead int64
+	// The total number of bytes written to accepted connections.
+	BytesWritten int64
+	// The number of accepted connections that have not yet been closed.
+	OpenConnections int64
+	// The largest number of connections open at once across all tunnels.
+	MaxOpenConnections int64
+	// The largest number of goroutines observed in the process, sampled as
+	// connections are accepted and when stats are collected.
+	MaxGoroutines int64
+	// The largest number of events waiting to be delivered to handlers.
+	MaxQueuedEvents int64
+	// The number of connections accepted by each open tunnel with pooling
+	// enabled, keyed by URL and then by tunnel ID.
+	Pools map[string]map[string]uint64
+	// The frames exchanged with the ngrok service.
+	Mux MuxStats
+}
+
+// The live counters backing [TunnelStats].
+type tunnelStats struct {
+	accepted           atomic.Uint64
+	acceptLatency      atomic.Int64
+	totalAcceptLatency atomic.Int64
+	maxAcceptLatency   atomic.Int64
+	open               atomic.Int64
+	maxOpen            atomic.Int64
+	rejected           atomic.Uint64
+	throttled          atomic.Uint64
+}
+
+func (s *tunnelStats) recordAccept(latency time.Duration) {
+	s.accepted.Add(1)
+	s.acceptLatency.Store(int64(latency))
+	s.totalAcceptLatency.Add(int64(latency))
+	storeMax(&s.maxAcceptLatency, int64(latency))
+}
+
+func (s *tunnelStats) connOpened() {
+	storeMax(&s.maxOpen, s.open.Add(1))
+}
+
+func (s *tunnelStats) connClosed() {
+	s.open.Add(-1)
+}
+
+func (s *tunnelStats) snapshot() TunnelStats {
+	stats := TunnelStats{
+		Accepted:           s.accepted.Load(),
+		Rejected:           s.rejected.Load(),
+		Throttled:          s.throttled.Load(),
+		AcceptLatency:      time.Duration(s.acceptLatency.Load()),
+		MaxAcceptLatency:   time.Duration(s.maxAcceptLatency.Load()),
+		OpenConnections:    s.open.Load(),
+		MaxOpenConnections: s.maxOpen.Load(),
+	}
+	if stats.Accepted > 0 {
+		stats.MeanAcceptLatency = time.Duration(s.totalAcceptLatency.Load() / int64(stats.Accepted))
+	}
+	return stats
+}
+
+// Record the current number of goroutines in a high-water mark.
+func sampleGoroutines(mark *atomic.Int64) {
+	storeMax(mark, int64(runtime.NumGoroutine()))
+}
+
+// Atomically raise a high-water mark to val if it's larger.
+func storeMax(mark *atomic.Int64, val int64) {
+	for {
+		cur := mark.Load()
+		if val <= cur || mark.CompareAndSwap(cur, val) {
+			return
+		}
+	}
+}
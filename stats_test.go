@@ -0,0 +1,100 @@
+package ngrok
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// A client tunnel that hands out pre-built proxy connections.
+type queuedTunnel struct {
+	tunnel_client.Tunnel
+	conns chan *tunnel_client.ProxyConn
+}
+
+func (q *queuedTunnel) Accept() (*tunnel_client.ProxyConn, error) {
+	conn, ok := <-q.conns
+	if !ok {
+		return nil, io.EOF
+	}
+	return conn, nil
+}
+
+func (q *queuedTunnel) ID() string {
+	return "test"
+}
+
+func (q *queuedTunnel) Close() error {
+	return nil
+}
+
+func TestAcceptLatencyStats(t *testing.T) {
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 2)}
+	tun := &tunnelImpl{Tunnel: q}
+
+	require.Equal(t, TunnelStats{}, tun.Stats())
+
+	now := time.Now()
+	for _, age := range []time.Duration{3 * time.Second, time.Second} {
+		local, _ := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local, Received: now.Add(-age)}
+	}
+
+	for i := 0; i < 2; i++ {
+		conn, err := tun.Accept()
+		require.NoError(t, err)
+		require.NotNil(t, conn)
+	}
+
+	stats := tun.Stats()
+	require.Equal(t, uint64(2), stats.Accepted)
+	require.GreaterOrEqual(t, stats.AcceptLatency, time.Second)
+	require.Less(t, stats.AcceptLatency, stats.MaxAcceptLatency)
+	require.GreaterOrEqual(t, stats.MaxAcceptLatency, 3*time.Second)
+	require.GreaterOrEqual(t, stats.MeanAcceptLatency, 2*time.Second)
+	require.Less(t, stats.MeanAcceptLatency, stats.MaxAcceptLatency)
+}
+
+func TestOpenConnectionHighWaterMark(t *testing.T) {
+	acct := &sessionAccounting{}
+	q := &queuedTunnel{conns: make(chan *tunnel_client.ProxyConn, 3)}
+	tun := &tunnelImpl{Tunnel: q, acct: acct}
+
+	var conns []net.Conn
+	for i := 0; i < 3; i++ {
+		local, _ := net.Pipe()
+		q.conns <- &tunnel_client.ProxyConn{Conn: local}
+		conn, err := tun.Accept()
+		require.NoError(t, err)
+		conns = append(conns, conn)
+	}
+	for _, conn := range conns[:2] {
+		require.NoError(t, conn.Close())
+	}
+	// Closing again doesn't count twice.
+	require.NoError(t, conns[0].Close())
+
+	stats := tun.Stats()
+	require.Equal(t, int64(1), stats.OpenConnections)
+	require.Equal(t, int64(3), stats.MaxOpenConnections)
+
+	sessStats := acct.snapshot()
+	require.Equal(t, int64(1), sessStats.OpenConnections)
+	require.Equal(t, int64(3), sessStats.MaxOpenConnections)
+	require.Positive(t, sessStats.MaxGoroutines)
+}
+
+func TestMaxQueuedEvents(t *testing.T) {
+	block := make(chan struct{})
+	d := newEventDispatcher([]EventHandler{func(Event) { <-block }})
+	for i := 0; i < 5; i++ {
+		d.emit(&EventSessionConnected{})
+	}
+	require.GreaterOrEqual(t, d.maxQueued(), int64(4))
+	close(block)
+}
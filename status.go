@@ -0,0 +1,213 @@
+package ngrok
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/tunnel/proto"
+)
+
+// StatusReport is a snapshot of a [Session] and the tunnels it owns, for
+// dumping into support tickets or serving from admin endpoints. Its JSON
+// encoding is stable, and roughly matches what `ngrok api endpoints list`
+// shows for the same endpoints.
+type StatusReport struct {
+	// When the report was taken.
+	GeneratedAt time.Time `json:"generated_at"`
+	// The ID of the session assigned by the ngrok service.
+	SessionID string `json:"session_id"`
+	// The region the session is connected to.
+	Region string `json:"region,omitempty"`
+	// The state of the session's connection to the ngrok service.
+	Connection SupervisorStatus `json:"connection"`
+	// The session's open tunnels, in the order they were started.
+	Tunnels []TunnelStatus `json:"tunnels"`
+}
+
+// TunnelStatus is a snapshot of a [Tunnel], which is also its JSON encoding.
+type TunnelStatus struct {
+	ID         string            `json:"id"`
+	URL        string            `json:"url,omitempty"`
+	Proto      string            `json:"proto,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Metadata   string            `json:"metadata,omitempty"`
+	ForwardsTo string            `json:"forwards_to,omitempty"`
+	// The SHA-256 of the tunnel's traffic policy, in hex, for comparing
+	// policies without exposing them.
+	PolicyHash string `json:"policy_hash,omitempty"`
+	// Whether the tunnel allows pooling with others sharing its URL.
+	Pooled bool `json:"pooled"`
+	// Whether the tunnel is bound at the ngrok service, which pooled tunnels
+	// aren't while they're failing their health check.
+	Bound bool `json:"bound"`
+	// The state of the tunnel's endpoint, and the last error that took it
+	// offline.
+	State     TunnelState `json:"state"`
+	LastError string      `json:"last_error,omitempty"`
+	StartedAt time.Time   `json:"started_at"`
+	// The time since the tunnel started, in seconds.
+	UptimeSeconds      float64 `json:"uptime_seconds"`
+	Accepted           uint64  `json:"accepted"`
+	Rejected           uint64  `json:"rejected"`
+	Throttled          uint64  `json:"throttled"`
+	OpenConnections    int64   `json:"open_connections"`
+	MaxOpenConnections int64   `json:"max_open_connections"`
+}
+
+func (s *sessionImpl) StatusReport() StatusReport {
+	report := StatusReport{
+		GeneratedAt: time.Now(),
+		SessionID:   s.ClientID(),
+		Region:      s.Region(),
+		Connection:  s.status(),
+		Tunnels:     []TunnelStatus{},
+	}
+	s.tunnelsMu.Lock()
+	for t := range s.tunnels {
+		report.Tunnels = append(report.Tunnels, t.status())
+	}
+	s.tunnelsMu.Unlock()
+	sort.Slice(report.Tunnels, func(i, j int) bool {
+		a, b := report.Tunnels[i], report.Tunnels[j]
+		if !a.StartedAt.Equal(b.StartedAt) {
+			return a.StartedAt.Before(b.StartedAt)
+		}
+		return a.ID < b.ID
+	})
+	return report
+}
+
+// The state of the session's connection and the binding of its tunnels.
+func (s *sessionImpl) status() SupervisorStatus {
+	status := s.conn.snapshot()
+	status.Closed = s.closed.Load()
+	status.Legs = s.legs.snapshot()
+
+	s.tunnelsMu.Lock()
+	defer s.tunnelsMu.Unlock()
+	status.Endpoints = len(s.tunnels)
+	for t := range s.tunnels {
+		if t.health.paused.Load() {
+			status.UnboundEndpoints++
+		}
+	}
+	return status
+}
+
+// MarshalJSON encodes the tunnel as its [TunnelStatus].
+func (t *tunnelImpl) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.status())
+}
+
+func (t *tunnelImpl) status() TunnelStatus {
+	stats := t.Stats()
+	status := TunnelStatus{
+		ID:                 t.ID(),
+		URL:                t.URL(),
+		Proto:              t.Proto(),
+		Labels:             t.Labels(),
+		Metadata:           t.Metadata(),
+		ForwardsTo:         t.ForwardsTo(),
+		PolicyHash:         policyHash(t.Tunnel.RemoteBindConfig().Opts),
+		Pooled:             t.pooled,
+		Bound:              !t.health.paused.Load(),
+		State:              t.State(),
+		StartedAt:          t.started,
+		Accepted:           stats.Accepted,
+		Rejected:           stats.Rejected,
+		Throttled:          stats.Throttled,
+		OpenConnections:    stats.OpenConnections,
+		MaxOpenConnections: stats.MaxOpenConnections,
+	}
+	if err := t.LastError(); err != nil {
+		status.LastError = err.Error()
+	}
+	if !t.started.IsZero() {
+		status.UptimeSeconds = time.Since(t.started).Seconds()
+	}
+	return status
+}
+
+// TunnelState is the state of a [Tunnel]'s endpoint.
+type TunnelState int
+
+const (
+	// The endpoint isn't bound at the ngrok service, and is waiting to be
+	// bound again, such as a pooled endpoint that's failing its health
+	// check.
+	TunnelBinding TunnelState = iota
+	// The endpoint is bound, and connections to it are being accepted.
+	TunnelOnline
+	// The session has lost its connection to the ngrok service. The
+	// endpoint is bound again once it reconnects.
+	TunnelReconnecting
+	// The tunnel has been closed, by the application or the ngrok service.
+	TunnelClosed
+)
+
+func (s TunnelState) String() string {
+	switch s {
+	case TunnelBinding:
+		return "binding"
+	case TunnelOnline:
+		return "online"
+	case TunnelReconnecting:
+		return "reconnecting"
+	case TunnelClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+func (s TunnelState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (t *tunnelImpl) State() TunnelState {
+	if t.closed.Load() {
+		return TunnelClosed
+	}
+	if s, ok := t.Sess.(*sessionImpl); ok && !s.isOffline() && !s.conn.snapshot().Connected {
+		return TunnelReconnecting
+	}
+	if t.health.paused.Load() {
+		return TunnelBinding
+	}
+	return TunnelOnline
+}
+
+// Wraps the last error so it can be stored atomically.
+type tunnelError struct {
+	err error
+}
+
+func (t *tunnelImpl) LastError() error {
+	if e := t.lastErr.Load(); e != nil {
+		return e.err
+	}
+	return nil
+}
+
+func (t *tunnelImpl) setLastError(err error) {
+	t.lastErr.Store(&tunnelError{err})
+}
+
+func policyHash(opts any) string {
+	var policy string
+	switch opts := opts.(type) {
+	case *proto.HTTPEndpoint:
+		policy = opts.TrafficPolicy
+	case *proto.TCPEndpoint:
+		policy = opts.TrafficPolicy
+	case *proto.TLSEndpoint:
+		policy = opts.TrafficPolicy
+	}
+	if policy == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(policy))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package ngrok
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestStatusReport(t *testing.T) {
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	policy := `{"on_http_request":[{"actions":[{"type":"deny"}]}]}`
+	web, err := sess.Listen(ctx, config.HTTPEndpoint(config.WithTrafficPolicy(policy), config.WithMetadata("web")))
+	require.NoError(t, err)
+	db, err := sess.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+
+	report := sess.StatusReport()
+	require.Equal(t, 2, report.Connection.Endpoints)
+	require.Len(t, report.Tunnels, 2)
+	require.Equal(t, web.ID(), report.Tunnels[0].ID)
+	require.Equal(t, db.ID(), report.Tunnels[1].ID)
+	require.Equal(t, "web", report.Tunnels[0].Metadata)
+	require.Len(t, report.Tunnels[0].PolicyHash, 64)
+	require.Empty(t, report.Tunnels[1].PolicyHash)
+	require.True(t, report.Tunnels[0].Bound)
+	require.Equal(t, TunnelOnline, report.Tunnels[0].State)
+	require.Empty(t, report.Tunnels[0].LastError)
+
+	encoded, err := json.Marshal(web)
+	require.NoError(t, err)
+	var status map[string]any
+	require.NoError(t, json.Unmarshal(encoded, &status))
+	require.Equal(t, web.ID(), status["id"])
+	require.Equal(t, web.URL(), status["url"])
+	require.Equal(t, report.Tunnels[0].PolicyHash, status["policy_hash"])
+	require.Contains(t, status, "uptime_seconds")
+	require.Equal(t, "online", status["state"])
+
+	require.NoError(t, db.Close())
+	require.Equal(t, TunnelClosed, db.State())
+	require.NoError(t, db.LastError())
+}
@@ -0,0 +1,322 @@
+package ngrok
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Supervisor reports on the health of a [Session] for process supervisors
+// such as Kubernetes, and lets them force it to reconnect. Its handlers are
+// meant to be mounted in the application's existing [http.ServeMux], e.g.
+//
+//	sup, err := ngrok.NewSupervisor(sess)
+//	mux.Handle("/healthz", sup.LivenessHandler())
+//	mux.Handle("/readyz", sup.ReadinessHandler())
+type Supervisor struct {
+	sess *sessionImpl
+	opts supervisorOpts
+}
+
+type supervisorOpts struct {
+	minEndpoints int
+	gracePeriod  time.Duration
+}
+
+// SupervisorOption configures a [Supervisor].
+type SupervisorOption func(*supervisorOpts)
+
+// WithMinEndpoints requires the session to have at least n endpoints open to
+// be ready, so that endpoints that were stopped by the ngrok service count
+// against readiness.
+func WithMinEndpoints(n int) SupervisorOption {
+	return func(opts *supervisorOpts) {
+		opts.minEndpoints = n
+	}
+}
+
+// WithLivenessGracePeriod fails the liveness check once the session has been
+// disconnected from the ngrok service for longer than d, so that a process
+// that can't reconnect gets restarted. By default, the session is live for
+// as long as it's trying to reconnect.
+func WithLivenessGracePeriod(d time.Duration) SupervisorOption {
+	return func(opts *supervisorOpts) {
+		opts.gracePeriod = d
+	}
+}
+
+// SupervisorStatus is a snapshot of the state of a supervised [Session].
+type SupervisorStatus struct {
+	// Whether the session is connected to the ngrok service.
+	Connected bool `json:"connected"`
+	// When the session last connected or disconnected.
+	Since time.Time `json:"since"`
+	// The number of times the session has connected, including the first.
+	Connects uint64 `json:"connects"`
+	// The number of times the session has reconnected after losing its
+	// connection, or being made to with [Supervisor.Reconnect].
+	Reconnects uint64 `json:"reconnects"`
+	// The error the session last disconnected with, if any.
+	LastError string `json:"last_error,omitempty"`
+	// The number of endpoints open on the session.
+	Endpoints int `json:"endpoints"`
+	// The number of open endpoints not currently bound at the ngrok service,
+	// such as pooled endpoints that failed their health check.
+	UnboundEndpoints int `json:"unbound_endpoints"`
+	// Whether the session has been closed, and won't connect again.
+	Closed bool `json:"closed"`
+	// The health of the session's connection, summarizing the above and its
+	// heartbeats.
+	Health SessionHealth `json:"health"`
+	// The state of each of the session's legs, which there are more than
+	// one of with [WithMultiLeg].
+	Legs []LegStatus `json:"legs,omitempty"`
+}
+
+// SessionHealth summarizes the state of a session's connection to the ngrok
+// service.
+type SessionHealth int
+
+const (
+	// The session is connected and the ngrok service is responding to its
+	// heartbeats.
+	SessionHealthy SessionHealth = iota
+	// The session is connected, but the ngrok service hasn't responded to
+	// its most recent heartbeats. See [EventHeartbeatMissed].
+	SessionDegraded
+	// The session isn't connected. Unless it's been closed, it's trying to
+	// reconnect.
+	SessionReconnecting
+)
+
+func (h SessionHealth) String() string {
+	switch h {
+	case SessionHealthy:
+		return "healthy"
+	case SessionDegraded:
+		return "degraded"
+	case SessionReconnecting:
+		return "reconnecting"
+	}
+	return "unknown"
+}
+
+func (h SessionHealth) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// NewSupervisor creates a [Supervisor] for a session returned by [Connect],
+// or one from a [SharedTransport].
+func NewSupervisor(sess Session, opts ...SupervisorOption) (*Supervisor, error) {
+	impl, err := unwrapSession(sess, "NewSupervisor")
+	if err != nil {
+		return nil, err
+	}
+	s := &Supervisor{sess: impl}
+	for _, opt := range opts {
+		opt(&s.opts)
+	}
+	return s, nil
+}
+
+// Status returns the current state of the session.
+func (s *Supervisor) Status() SupervisorStatus {
+	return s.sess.status()
+}
+
+// Live returns an error if the session has been closed, or has been
+// disconnected for longer than the grace period set with
+// [WithLivenessGracePeriod].
+func (s *Supervisor) Live() error {
+	return s.live(s.Status())
+}
+
+func (s *Supervisor) live(status SupervisorStatus) error {
+	switch {
+	case status.Closed:
+		return errors.New("session closed")
+	case !status.Connected && s.opts.gracePeriod > 0 && time.Since(status.Since) > s.opts.gracePeriod:
+		return errors.New("session disconnected for longer than " + s.opts.gracePeriod.String())
+	}
+	return nil
+}
+
+// Ready returns an error unless the session is connected and all of its
+// endpoints are bound.
+func (s *Supervisor) Ready() error {
+	return s.ready(s.Status())
+}
+
+func (s *Supervisor) ready(status SupervisorStatus) error {
+	switch {
+	case status.Closed:
+		return errors.New("session closed")
+	case !status.Connected:
+		return errors.New("session not connected")
+	case status.UnboundEndpoints > 0:
+		return errors.New("not all endpoints are bound")
+	case status.Endpoints < s.opts.minEndpoints:
+		return errors.New("too few endpoints are open")
+	}
+	return nil
+}
+
+// LivenessHandler returns a handler that responds with the session's status
+// as JSON, with a 503 status code if [Supervisor.Live] fails.
+func (s *Supervisor) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := s.Status()
+		writeStatus(w, status, s.live(status))
+	})
+}
+
+// ReadinessHandler returns a handler that responds with the session's status
+// as JSON, with a 503 status code if [Supervisor.Ready] fails.
+func (s *Supervisor) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := s.Status()
+		writeStatus(w, status, s.ready(status))
+	})
+}
+
+func writeStatus(w http.ResponseWriter, status SupervisorStatus, err error) {
+	body := struct {
+		SupervisorStatus
+		Error string `json:"error,omitempty"`
+	}{SupervisorStatus: status}
+	code := http.StatusOK
+	if err != nil {
+		body.Error = err.Error()
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// Reconnect drops the session's connection to the ngrok service, so that it
+// reconnects and binds its endpoints again. It returns once the connection is
+// dropped, without waiting for the session to reconnect.
+func (s *Supervisor) Reconnect() error {
+	if s.sess.closed.Load() {
+		return errors.New("session closed")
+	}
+	return s.sess.inner().Reconnect()
+}
+
+// Whether a session is connected to the ngrok service, as reported to the
+// Supervisor and followed by ListenAsLeader.
+type connectionState struct {
+	mu        sync.Mutex
+	connected bool
+	since     time.Time
+	connects  uint64
+	lastErr   error
+	// The latency of the last heartbeat response, and the jitter between
+	// consecutive ones.
+	latency time.Duration
+	jitter  time.Duration
+	// Whether a heartbeat has been missed since the last response.
+	missing bool
+	// Closed and replaced when the session connects or disconnects.
+	changed chan struct{}
+}
+
+// Wait for the session to be connected, or for ctx to be done.
+func (c *connectionState) waitConnected(ctx context.Context) error {
+	return c.waitFor(ctx, true)
+}
+
+// Wait for the session to be disconnected, or for ctx to be done.
+func (c *connectionState) waitDisconnected(ctx context.Context) error {
+	return c.waitFor(ctx, false)
+}
+
+func (c *connectionState) waitFor(ctx context.Context, connected bool) error {
+	for {
+		c.mu.Lock()
+		if c.connected == connected {
+			c.mu.Unlock()
+			return nil
+		}
+		if c.changed == nil {
+			c.changed = make(chan struct{})
+		}
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (c *connectionState) set(connected bool, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if connected {
+		c.connects++
+	}
+	if err != nil {
+		c.lastErr = err
+	}
+	if c.connected != connected || c.since.IsZero() {
+		c.since = time.Now()
+	}
+	c.connected = connected
+	c.missing = false
+	if c.changed != nil {
+		close(c.changed)
+		c.changed = nil
+	}
+}
+
+func (c *connectionState) snapshot() SupervisorStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	status := SupervisorStatus{
+		Connected: c.connected,
+		Since:     c.since,
+		Connects:  c.connects,
+	}
+	if c.connects > 0 {
+		status.Reconnects = c.connects - 1
+	}
+	if c.lastErr != nil {
+		status.LastError = c.lastErr.Error()
+	}
+	switch {
+	case !c.connected:
+		status.Health = SessionReconnecting
+	case c.missing:
+		status.Health = SessionDegraded
+	}
+	return status
+}
+
+// Record a heartbeat response, and return the updated jitter.
+func (c *connectionState) heartbeat(latency time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.latency > 0 {
+		diff := latency - c.latency
+		if diff < 0 {
+			diff = -diff
+		}
+		c.jitter += (diff - c.jitter) / 16
+	}
+	c.latency = latency
+	c.missing = false
+	return c.jitter
+}
+
+func (c *connectionState) heartbeatMissed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.missing = true
+}
@@ -0,0 +1,128 @@
+package ngrok
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	tunnel_client "golang.ngrok.com/ngrok/internal/tunnel/client"
+)
+
+// A client session that records forced reconnects.
+type reconnectCounter struct {
+	tunnel_client.Session
+	reconnects int
+}
+
+func (s *reconnectCounter) Reconnect() error {
+	s.reconnects++
+	return nil
+}
+
+func probe(t *testing.T, h http.Handler) (int, map[string]any) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return rec.Code, body
+}
+
+func TestSupervisor(t *testing.T) {
+	client := &reconnectCounter{}
+	sess := &sessionImpl{}
+	sess.setInner(&sessionInner{Session: client})
+	sup, err := NewSupervisor(sess, WithMinEndpoints(1), WithLivenessGracePeriod(time.Hour))
+	require.NoError(t, err)
+
+	code, body := probe(t, sup.ReadinessHandler())
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "session not connected", body["error"])
+
+	sess.conn.set(true, nil)
+	require.EqualError(t, sup.Ready(), "too few endpoints are open")
+	tun := &tunnelImpl{Sess: sess}
+	sess.addTunnel(tun)
+	require.NoError(t, sup.Ready())
+
+	// Pooled endpoints that left the pool aren't bound.
+	tun.health.paused.Store(true)
+	require.EqualError(t, sup.Ready(), "not all endpoints are bound")
+	tun.health.paused.Store(false)
+
+	sess.conn.set(false, errors.New("connection reset"))
+	sess.conn.set(true, nil)
+	code, body = probe(t, sup.ReadinessHandler())
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, float64(1), body["reconnects"])
+	require.Equal(t, "connection reset", body["last_error"])
+	require.Equal(t, float64(1), body["endpoints"])
+
+	require.NoError(t, sup.Reconnect())
+	require.Equal(t, 1, client.reconnects)
+
+	code, _ = probe(t, sup.LivenessHandler())
+	require.Equal(t, http.StatusOK, code)
+	sess.closed.Store(true)
+	code, body = probe(t, sup.LivenessHandler())
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, "session closed", body["error"])
+	require.Error(t, sup.Reconnect())
+}
+
+func TestSupervisorLivenessGracePeriod(t *testing.T) {
+	sess := &sessionImpl{}
+	sup, err := NewSupervisor(sess, WithLivenessGracePeriod(time.Millisecond))
+	require.NoError(t, err)
+
+	sess.conn.set(false, errors.New("connection reset"))
+	require.Eventually(t, func() bool { return sup.Live() != nil }, time.Second, time.Millisecond)
+	sess.conn.set(true, nil)
+	require.NoError(t, sup.Live())
+}
+
+func TestSupervisorHealth(t *testing.T) {
+	sess := &sessionImpl{}
+	sup, err := NewSupervisor(sess)
+	require.NoError(t, err)
+	require.Equal(t, SessionReconnecting, sup.Status().Health)
+
+	sess.conn.set(true, nil)
+	require.Equal(t, SessionHealthy, sup.Status().Health)
+
+	sess.conn.heartbeatMissed()
+	_, body := probe(t, sup.ReadinessHandler())
+	require.Equal(t, "degraded", body["health"])
+
+	// The jitter tracks the variation between consecutive latencies.
+	require.Equal(t, time.Duration(0), sess.conn.heartbeat(10*time.Millisecond))
+	require.Equal(t, SessionHealthy, sup.Status().Health)
+	require.Equal(t, time.Millisecond, sess.conn.heartbeat(26*time.Millisecond))
+	require.Equal(t, 1937500*time.Nanosecond, sess.conn.heartbeat(10*time.Millisecond))
+
+	sess.conn.heartbeatMissed()
+	sess.conn.set(false, errors.New("connection reset"))
+	require.Equal(t, SessionReconnecting, sup.Status().Health)
+	sess.conn.set(true, nil)
+	require.Equal(t, SessionHealthy, sup.Status().Health)
+}
+
+func TestSupervisorSessions(t *testing.T) {
+	impl := &sessionImpl{}
+	for _, sess := range []Session{
+		impl,
+		&leakCheckedSession{sessionImpl: impl},
+		&sharedSession{conn: &leakCheckedSession{sessionImpl: impl}},
+	} {
+		sup, err := NewSupervisor(sess)
+		require.NoError(t, err)
+		require.Same(t, impl, sup.sess)
+	}
+
+	_, err := NewSupervisor(struct{ Session }{impl})
+	require.ErrorIs(t, err, ErrUnsupportedSession)
+}
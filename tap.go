@@ -0,0 +1,39 @@
+package ngrok
+
+// Direction is the direction in which bytes flow through a [Conn].
+type Direction int
+
+const (
+	// DirectionInbound is data from the client, read by the application.
+	DirectionInbound Direction = iota + 1
+	// DirectionOutbound is data written by the application to the client.
+	DirectionOutbound
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionInbound:
+		return "inbound"
+	case DirectionOutbound:
+		return "outbound"
+	}
+	return "unknown"
+}
+
+// ConnTap is the callback type for [WithConnTap]. The bytes are only valid
+// for the duration of the call, so a tap that keeps them must copy them.
+type ConnTap func(dir Direction, endpointID string, b []byte)
+
+// WithConnTap configures a function which is called with the bytes read from
+// and written to every connection accepted from the session's tunnels,
+// including those forwarded by ListenAndForward. For tunnels that terminate
+// TLS in the agent, the tap sees the decrypted bytes. It's meant for
+// debugging incidents, e.g. with [golang.ngrok.com/ngrok/ngrokpcap] to
+// inspect the traffic in Wireshark.
+//
+// The tap is called synchronously, so a slow tap slows the connections.
+func WithConnTap(tap ConnTap) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.ConnTap = tap
+	}
+}
@@ -0,0 +1,64 @@
+package ngrok
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+func TestConnTap(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		tapped = map[Direction]string{}
+		ids    = map[string]bool{}
+	)
+	tap := func(dir Direction, endpointID string, b []byte) {
+		mu.Lock()
+		defer mu.Unlock()
+		tapped[dir] += string(b)
+		ids[endpointID] = true
+	}
+
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"), WithConnTap(tap))
+	require.NoError(t, err)
+	defer sess.Close()
+	tun, err := sess.Listen(ctx, config.TCPEndpoint())
+	require.NoError(t, err)
+
+	go func() {
+		conn, err := tun.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err == nil {
+			_, _ = conn.Write([]byte("pong"))
+		}
+	}()
+
+	u, err := url.Parse(tun.URL())
+	require.NoError(t, err)
+	client, err := net.Dial("tcp", u.Host)
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(client, reply)
+	require.NoError(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, "ping", tapped[DirectionInbound])
+	require.Equal(t, "pong", tapped[DirectionOutbound])
+	require.Equal(t, map[string]bool{tun.ID(): true}, ids)
+}
@@ -0,0 +1,28 @@
+package ngrok
+
+import "io"
+
+// WithProtocolTrace writes a trace of the protocol messages the [Session]
+// exchanges with the ngrok service to w, one JSON object per line. It
+// includes the Auth and Bind requests and their responses, the commands sent
+// by the service, and the header of each proxied connection, which makes it
+// possible to debug failures like a tunnel that won't bind. Authtokens and
+// other credentials in the messages are hidden, but the trace still
+// describes the application's endpoints in detail and should be handled with
+// care.
+//
+// Writes to w are serialized, and block the session while they're made.
+func WithProtocolTrace(w io.Writer) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.TraceWriter = w
+	}
+}
+
+// WithFrameTrace adds a summary of every muxado frame sent and received to
+// the trace configured with [WithProtocolTrace]: its type, stream, length and
+// flags. This is verbose, since every chunk of proxied data is a frame.
+func WithFrameTrace(enable bool) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.TraceFrames = enable
+	}
+}
@@ -0,0 +1,39 @@
+package ngrok
+
+import (
+	"io"
+
+	"golang.ngrok.com/muxado/v2"
+	"golang.ngrok.com/muxado/v2/frame"
+)
+
+// SessionTuning adjusts the flow control of the multiplexed connection to the
+// ngrok service. Zero fields keep their defaults.
+type SessionTuning struct {
+	// The most unread data buffered for each stream before the sender has to
+	// wait. Raising it lets a single connection move more data per round
+	// trip on high latency links, at the cost of memory for each open
+	// connection. It defaults to 256KiB.
+	MaxWindowSize uint32
+	// How many streams opened by the ngrok service are queued before the
+	// session stops accepting them. It defaults to 128.
+	AcceptBacklog uint32
+}
+
+// WithSessionTuning adjusts the flow control of the session's connection to
+// the ngrok service, for high throughput uses where the defaults limit how
+// fast each connection can move data.
+func WithSessionTuning(tuning SessionTuning) ConnectOption {
+	return func(cfg *connectConfig) {
+		cfg.Tuning = tuning
+	}
+}
+
+// The muxado configuration for a session with this tuning.
+func (t SessionTuning) muxadoConfig(newFramer func(io.Reader, io.Writer) frame.Framer) *muxado.Config {
+	return &muxado.Config{
+		MaxWindowSize: t.MaxWindowSize,
+		AcceptBacklog: t.AcceptBacklog,
+		NewFramer:     newFramer,
+	}
+}
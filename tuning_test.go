@@ -0,0 +1,44 @@
+package ngrok
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/muxado/v2"
+	"golang.ngrok.com/muxado/v2/frame"
+)
+
+func TestSessionTuningWindow(t *testing.T) {
+	// Write more than the default window to a stream nobody reads, which only
+	// completes if the window was raised.
+	write := func(tuning SessionTuning) bool {
+		clientConn, serverConn := net.Pipe()
+		client := muxado.Client(clientConn, tuning.muxadoConfig(frame.NewFramer))
+		server := muxado.Server(serverConn, tuning.muxadoConfig(frame.NewFramer))
+		defer client.Close()
+		defer server.Close()
+		go func() { _, _ = server.Accept() }()
+
+		stream, err := client.Open()
+		require.NoError(t, err)
+		done := make(chan error, 1)
+		go func() {
+			_, err := stream.Write(bytes.Repeat([]byte{'x'}, 512<<10))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			require.NoError(t, err)
+			return true
+		case <-time.After(200 * time.Millisecond):
+			return false
+		}
+	}
+
+	require.False(t, write(SessionTuning{}))
+	require.True(t, write(SessionTuning{MaxWindowSize: 1 << 20}))
+}
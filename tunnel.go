@@ -2,9 +2,14 @@ package ngrok
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.ngrok.com/ngrok/config"
@@ -18,6 +23,10 @@ import (
 type Tunnel interface {
 	// Every Tunnel is a net.Listener. It can be plugged into any existing
 	// code that expects a net.Listener seamlessly without any changes.
+	// Accept is safe to call from multiple goroutines, each of which
+	// receives a different connection, and all of which return an error
+	// once the tunnel closes. See [ServeConns] for a bounded pool of
+	// handlers.
 	net.Listener
 
 	// Information associated with the tunnel
@@ -37,6 +46,14 @@ type Tunnel interface {
 	// Session returns the tunnel's parent Session object that it
 	// was started on.
 	Session() Session
+
+	// ServeHTTP serves the HTTP requests arriving on the tunnel with handler
+	// until ctx is done or the tunnel closes, and waits for the requests in
+	// progress to finish before returning. Tunnels declared with
+	// config.WithAppProtocol("http2") are served HTTP/2, as the edge speaks
+	// it. Once ctx is done, the tunnel is closed and ctx's error returned.
+	// ServeHTTP returns nil if the tunnel was closed otherwise.
+	ServeHTTP(ctx context.Context, handler http.Handler) error
 }
 
 // TunnelInfo implementations contain metadata about a [Tunnel].
@@ -58,6 +75,33 @@ type TunnelInfo interface {
 	// URL returns the tunnel endpoint's URL.
 	// Labeled tunnels will return the empty string.
 	URL() string
+	// Stats returns a snapshot of the counters tracked for the tunnel.
+	Stats() TunnelStats
+	// Identity returns the ID and token the ngrok service assigned to the
+	// tunnel's endpoint, which can be persisted and passed back with
+	// config.WithIdentity to start the same endpoint after a restart.
+	Identity() EndpointIdentity
+	// State returns whether the tunnel's endpoint is online, waiting to be
+	// bound or reconnected, or closed.
+	State() TunnelState
+	// LastError returns the most recent error that took the tunnel's
+	// endpoint offline, such as the session losing its connection or the
+	// ngrok service stopping the tunnel, or nil if there hasn't been one.
+	// It isn't cleared once the endpoint is back online.
+	LastError() error
+	// DomainStatus looks up the DNS setup of the endpoint's domain with the
+	// ngrok API, including the CNAME record a custom domain needs and
+	// whether it's in place. It requires an API key configured with
+	// [WithAPIKey]. See also [WaitForDomain].
+	DomainStatus(ctx context.Context) (*DomainStatus, error)
+}
+
+// EndpointIdentity identifies an endpoint across sessions. The token is a
+// credential for the endpoint, and should be stored as carefully as an
+// authtoken. Labeled tunnels have no token.
+type EndpointIdentity struct {
+	ID    string `json:"id"`
+	Token string `json:"token,omitempty"`
 }
 
 // Listen creates a new [Tunnel] after connecting a new [Session]. This is a
@@ -137,30 +181,127 @@ func ListenAndServeHTTP(ctx context.Context, server *http.Server, tunnelConfig c
 // If an error is encountered during [Session].ListenAndHandleHTTP, the [Session]
 // object that was created will be closed automatically.
 func ListenAndHandleHTTP(ctx context.Context, handler *http.Handler, tunnelConfig config.Tunnel, connectOpts ...ConnectOption) (Forwarder, error) {
-	return ListenAndServeHTTP(ctx, &http.Server{Handler: *handler}, tunnelConfig, connectOpts...)
+	return ListenAndServeHTTP(ctx, handlerServer(handler), tunnelConfig, connectOpts...)
 }
 
 type tunnelImpl struct {
 	Sess   Session
 	Tunnel tunnel_client.Tunnel
 	server *http.Server
+	stats  tunnelStats
+	// The number of connections accepted, used to assign connection IDs.
+	connSeq atomic.Uint64
+	// When the tunnel was started.
+	started time.Time
+
+	events     *eventDispatcher
+	eventState tunnelEvents
+	acct       *sessionAccounting
+	// Whether the tunnel allows pooling with others sharing its URL.
+	pooled bool
+	// Identifies the URL the tunnel requested, if any.
+	bindKey string
+
+	policy AcceptPolicy
+	pump   policyPump
+	tap    ConnTap
+
+	serverClosing atomic.Bool
+	closed        atomic.Bool
+	health        poolHealth
+	// Whether the tunnel was closed by the application, rather than by an
+	// error.
+	closing atomic.Bool
+	// The last error that took the tunnel offline.
+	lastErr atomic.Pointer[tunnelError]
+	// Canceled once the tunnel closes, or once its connections are drained
+	// if drains is set.
+	life   lifetime
+	drains bool
+	// Canceled as soon as the tunnel closes, so that the goroutines handing
+	// out its connections stop, and close the connections they hold.
+	stopped lifetime
+	// The configuration for terminating TLS in the agent, if any.
+	agentTLS *tls.Config
+	// Answers TLS-ALPN-01 challenges before connections are accepted.
+	challenges func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+	// Whether the tunnel is closed rather than let its URL change.
+	stableURL bool
+	// Limits the rate at which connections are accepted, if set.
+	limiter *acceptLimiter
+	// CIDR restrictions enforced on accepted connections, if any.
+	cidrs *cidrFilter
+	// Intercept the connections before they're accepted.
+	interceptors []ConnInterceptor
+	intercepts   interceptPump
+	// The plain-HTTP tunnel redirecting to this one, if any.
+	redirect *tunnelImpl
+	// Counts the goroutines started for the tunnel's session.
+	goroutines *goroutineCount
 }
 
 func (t *tunnelImpl) Accept() (net.Conn, error) {
-	conn, err := t.Tunnel.Accept()
+	if len(t.interceptors) > 0 {
+		return t.acceptIntercepted()
+	}
+	return t.acceptConn()
+}
+
+// Accept the next connection allowed by the accept policy.
+func (t *tunnelImpl) acceptConn() (net.Conn, error) {
+	conn, err := t.acceptAllowed()
 	if err != nil {
-		err = errAcceptFailed{Inner: err}
-		if s, ok := t.Sess.(*sessionImpl); ok {
-			if si := s.inner(); si != nil {
-				si.Logger.Info(err.Error(), "clientid", t.Tunnel.ID())
-			}
+		if !t.closing.Load() {
+			t.setLastError(err)
 		}
+		err = errAcceptFailed{Inner: err}
+		t.log(func(l *slog.Logger) { l.Info(err.Error(), "clientid", t.Tunnel.ID()) })
+		t.tunnelClosed()
 		return nil, err
 	}
-	return &connImpl{
-		Conn:  conn.Conn,
-		Proxy: conn,
-	}, nil
+	if !conn.Received.IsZero() {
+		latency := time.Since(conn.Received)
+		t.stats.recordAccept(latency)
+		if latency > slowAcceptThreshold {
+			t.log(func(l *slog.Logger) {
+				l.Warn("application is slow to accept connections", "clientid", t.Tunnel.ID(), "latency", latency)
+			})
+		}
+	}
+	c := &connImpl{
+		Conn:   conn.Conn,
+		Proxy:  conn,
+		tun:    t,
+		seq:    t.connSeq.Add(1),
+		opened: time.Now(),
+	}
+	c.ctx, c.cancel = context.WithCancel(t.context())
+	if t.agentTLS != nil {
+		c.tlsConn = tls.Server(conn.Conn, t.agentTLS)
+		c.Conn = c.tlsConn
+	}
+	t.stats.connOpened()
+	t.acct.addConn()
+	c.tracked = t.connOpened(c)
+	return c, nil
+}
+
+// Closed once the tunnel closes.
+func (t *tunnelImpl) stopping() <-chan struct{} {
+	return t.stopped.context(context.Background()).Done()
+}
+
+// Runs the provided function with the session logger, if one is available.
+func (t *tunnelImpl) log(fn func(*slog.Logger)) {
+	if s, ok := t.Sess.(*sessionImpl); ok {
+		if si := s.inner(); si != nil && si.Logger != nil {
+			fn(si.Logger)
+		}
+	}
+}
+
+func (t *tunnelImpl) Stats() TunnelStats {
+	return t.stats.snapshot()
 }
 
 func (t *tunnelImpl) Close() error {
@@ -169,14 +310,55 @@ func (t *tunnelImpl) Close() error {
 	return t.CloseWithContext(ctx)
 }
 
-func (t *tunnelImpl) CloseWithContext(_ context.Context) error {
-	if t.server != nil {
-		err := t.server.Close()
-		if err != nil {
-			return err
+// The tunnel as the listener of its own server. The server closes it while
+// shutting down, which mustn't shut the server down again.
+type serverListener struct {
+	*tunnelImpl
+}
+
+func (l serverListener) Close() error {
+	l.serverClosing.Store(true)
+	return l.tunnelImpl.Close()
+}
+
+// The listener for a server that serves tun.
+func listenerFor(tun Tunnel) net.Listener {
+	if impl, ok := tun.(*tunnelImpl); ok {
+		return serverListener{impl}
+	}
+	return tun
+}
+
+func (t *tunnelImpl) CloseWithContext(ctx context.Context) error {
+	// The server closes its listener, which is this tunnel, while shutting
+	// down, so guard against re-entering this.
+	var shutdownErr error
+	if t.server != nil && t.serverClosing.CompareAndSwap(false, true) {
+		shutdownErr = t.server.Shutdown(ctx)
+		if shutdownErr != nil {
+			// Out of time to wait for active requests, so cut them off.
+			_ = t.server.Close()
+			if ctx.Err() == nil {
+				return shutdownErr
+			}
 		}
 	}
-	err := t.Tunnel.Close()
+
+	t.closing.Store(true)
+	// The unbind request can't be canceled, but we can stop waiting for it.
+	done := make(chan error, 1)
+	go func() { done <- t.Tunnel.Close() }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	t.tunnelClosed()
+	if err == nil {
+		err = shutdownErr
+	}
 	return err
 }
 
@@ -208,6 +390,10 @@ func (t *tunnelImpl) ID() string {
 	return t.Tunnel.ID()
 }
 
+func (t *tunnelImpl) Identity() EndpointIdentity {
+	return EndpointIdentity{ID: t.Tunnel.ID(), Token: t.Tunnel.RemoteBindConfig().Token}
+}
+
 func (t *tunnelImpl) Labels() map[string]string {
 	return t.Tunnel.RemoteBindConfig().Labels
 }
@@ -227,6 +413,9 @@ func (t *tunnelImpl) Session() Session {
 // conn, _ := tun.Accept()
 // ngrokConn := conn.(ngrok.Conn)
 // ```
+//
+// Once the ngrok edge resets a connection, its reads and writes fail with a
+// [*ConnResetError] describing why.
 type Conn interface {
 	net.Conn
 	// Proto returns the tunnel protocol (http, https, tls, or tcp) for this connection.
@@ -236,6 +425,15 @@ type Conn interface {
 	// PassthroughTLS returns whether this connection contains an end-to-end tls
 	// connection.
 	PassthroughTLS() bool
+	// Context returns a context that is canceled when the connection, its
+	// tunnel, or its session is closed. Connections forwarded by a
+	// [Forwarder] outlive the tunnel until they're drained.
+	Context() context.Context
+	// TLSConnectionState returns the state of the TLS connection terminated
+	// by the agent, as configured by [config.WithAgentTLSTermination],
+	// completing the handshake first if it hasn't been. It returns nil if TLS
+	// isn't terminated by the agent.
+	TLSConnectionState() (*tls.ConnectionState, error)
 }
 
 // EdgeType is the type of the edge (https, tls, or tcp) for this tunnel.
@@ -252,11 +450,82 @@ const (
 type connImpl struct {
 	net.Conn
 	Proxy *tunnel_client.ProxyConn
+
+	// The tunnel the connection was accepted from.
+	tun *tunnelImpl
+	seq uint64
+	// Whether the tunnel should be notified when the connection closes.
+	tracked      bool
+	opened       time.Time
+	bytesRead    atomic.Int64
+	bytesWritten atomic.Int64
+	closeOnce    sync.Once
+	closeReason  atomic.Pointer[ConnCloseReason]
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// The TLS connection terminated by the agent, if any. It wraps the
+	// stream, and is read from and written to in its place.
+	tlsConn *tls.Conn
 }
 
 // compile-time check that we're implementing the proper interface
 var _ Conn = &connImpl{}
 
+func (c *connImpl) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.bytesRead.Add(int64(n))
+	if c.tun != nil {
+		c.tun.acct.addRead(n)
+		if c.tun.tap != nil && n > 0 {
+			c.tun.tap(DirectionInbound, c.tun.Tunnel.ID(), p[:n])
+		}
+	}
+	return n, c.observeErr(err)
+}
+
+func (c *connImpl) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.bytesWritten.Add(int64(n))
+	if c.tun != nil {
+		c.tun.acct.addWritten(n)
+		if c.tun.tap != nil && n > 0 {
+			c.tun.tap(DirectionOutbound, c.tun.Tunnel.ID(), p[:n])
+		}
+	}
+	return n, c.observeErr(err)
+}
+
+func (c *connImpl) Close() error {
+	c.closeReason.CompareAndSwap(nil, &localCloseReason)
+	err := c.Conn.Close()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	if c.tun != nil {
+		c.closeOnce.Do(func() { c.tun.connDone(c) })
+	}
+	return err
+}
+
+// Record that an accepted connection was closed.
+func (t *tunnelImpl) connDone(conn *connImpl) {
+	t.stats.connClosed()
+	t.acct.closeConn()
+	if conn.tracked {
+		t.connClosed(conn)
+	}
+}
+
+// ID returns an identifier for the connection, unique within the session.
+func (c *connImpl) ID() string {
+	if c.tun == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s-%d", c.tun.ID(), c.seq)
+}
+
 func (c *connImpl) ProxyConn() *tunnel_client.ProxyConn {
 	return c.Proxy
 }
@@ -266,10 +535,16 @@ func (c *connImpl) Proto() string {
 }
 
 func (c *connImpl) EdgeType() EdgeType {
-	et, _ := proto.ParseEdgeType(c.Proxy.Header.EdgeType)
+	return edgeType(c.Proxy.Header.EdgeType)
+}
+
+func edgeType(raw string) EdgeType {
+	et, _ := proto.ParseEdgeType(raw)
 	return EdgeType(et)
 }
 
 func (c *connImpl) PassthroughTLS() bool {
-	return c.Proxy.Header.PassthroughTLS
+	// Connections terminated by the agent are plaintext by the time the
+	// application reads them.
+	return c.Proxy.Header.PassthroughTLS && c.tlsConn == nil
 }
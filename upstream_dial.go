@@ -0,0 +1,67 @@
+package ngrok
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+const (
+	// How long to wait before the first retry when retries are configured
+	// without a backoff.
+	defaultUpstreamRetryBackoff = 100 * time.Millisecond
+	// The longest wait between retries.
+	maxUpstreamRetryBackoff = 5 * time.Second
+)
+
+// EventUpstreamDialFailed is emitted when a forwarded connection can't be
+// established because the upstream service couldn't be reached, after any
+// retries configured with [config.WithUpstreamRetry].
+type EventUpstreamDialFailed struct {
+	baseEvent
+	Tunnel Tunnel
+	// The URL of the upstream service.
+	URL *url.URL
+	// The number of attempts made.
+	Attempts int
+	// The error from the last attempt.
+	Error error
+}
+
+// The function used to connect to the upstream service, limiting each attempt
+// to the dial timeout and retrying failures as configured.
+func upstreamDial(opts upstream.Options) func(ctx context.Context, network, address string) (net.Conn, error) {
+	dial := baseUpstreamDial(opts)
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		backoff := opts.RetryBackoff
+		if backoff <= 0 {
+			backoff = defaultUpstreamRetryBackoff
+		}
+		for attempt := 1; ; attempt++ {
+			dialCtx, cancel := ctx, context.CancelFunc(func() {})
+			if opts.DialTimeout > 0 {
+				dialCtx, cancel = context.WithTimeout(ctx, opts.DialTimeout)
+			}
+			conn, err := dial(dialCtx, network, address)
+			cancel()
+			if err == nil {
+				return conn, nil
+			}
+			if attempt > opts.Retries || ctx.Err() != nil {
+				return nil, errUpstreamDial{Address: address, Attempts: attempt, Inner: err}
+			}
+
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, errUpstreamDial{Address: address, Attempts: attempt, Inner: err}
+			}
+			backoff = min(2*backoff, maxUpstreamRetryBackoff)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package ngrok
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func TestUpstreamDialRetry(t *testing.T) {
+	refused := errors.New("connection refused")
+	var attempts atomic.Int32
+	opts := upstream.Options{
+		Retries:      3,
+		RetryBackoff: time.Millisecond,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if attempts.Add(1) < 3 {
+				return nil, refused
+			}
+			client, server := net.Pipe()
+			server.Close()
+			return client, nil
+		},
+	}
+
+	conn, err := upstreamDial(opts)(context.Background(), "tcp", "upstream:80")
+	require.NoError(t, err)
+	conn.Close()
+	require.EqualValues(t, 3, attempts.Load())
+
+	attempts.Store(-10)
+	_, err = upstreamDial(opts)(context.Background(), "tcp", "upstream:80")
+	require.ErrorIs(t, err, ErrUpstreamDial)
+	require.ErrorIs(t, err, refused)
+	require.Equal(t, 4, err.(errUpstreamDial).Attempts)
+}
+
+func TestUpstreamDialTimeout(t *testing.T) {
+	opts := upstream.Options{
+		DialTimeout: 10 * time.Millisecond,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	}
+	_, err := upstreamDial(opts)(context.Background(), "tcp", "upstream:80")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestUpstreamDialRestart(t *testing.T) {
+	// Reserve an address for an upstream that only starts once the first
+	// attempts to reach it have failed.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	var dials atomic.Int32
+	dialer := func(ctx context.Context, network, address string) (net.Conn, error) {
+		if dials.Add(1) == 2 {
+			l, err := net.Listen("tcp", addr)
+			require.NoError(t, err)
+			t.Cleanup(func() { l.Close() })
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				_, _ = io.WriteString(conn, "hello")
+			}()
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+	fwd, err := sess.ListenAndForward(ctx, &url.URL{Scheme: "tcp", Host: addr}, config.TCPEndpoint(
+		config.WithUpstreamDialer(dialer),
+		config.WithUpstreamRetry(3, 10*time.Millisecond),
+	))
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	conn := dialTunnel(t, fwd)
+	greeting, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(greeting))
+	require.EqualValues(t, 2, dials.Load())
+}
+
+func TestUpstreamDialFailedEvent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	target := &url.URL{Scheme: "tcp", Host: l.Addr().String()}
+	require.NoError(t, l.Close())
+
+	events := make(chan *EventUpstreamDialFailed, 1)
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"), WithEventHandler(func(ev Event) {
+		if failed, ok := ev.(*EventUpstreamDialFailed); ok {
+			events <- failed
+		}
+	}))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	fwd, err := sess.ListenAndForward(ctx, target, config.TCPEndpoint(
+		config.WithUpstreamRetry(2, time.Millisecond),
+	))
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	dialTunnel(t, fwd)
+	failed := <-events
+	require.Equal(t, target, failed.URL)
+	require.Equal(t, fwd.ID(), failed.Tunnel.ID())
+	require.Equal(t, 3, failed.Attempts)
+	require.Error(t, failed.Error)
+}
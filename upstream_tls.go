@@ -0,0 +1,96 @@
+package ngrok
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/url"
+	"os"
+
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+// EventUpstreamTLSFailed is emitted when a forwarded connection can't be
+// established because the TLS handshake with the upstream service failed,
+// such as when its certificate can't be verified. Match Error against
+// [*tls.CertificateVerificationError] with [errors.As] to tell certificate
+// problems apart. See [config.WithUpstreamCAs].
+type EventUpstreamTLSFailed struct {
+	baseEvent
+	Tunnel Tunnel
+	// The URL of the upstream service.
+	URL *url.URL
+	// The handshake error.
+	Error error
+}
+
+// Load the CA file configured for the upstream into the options' root CAs.
+func loadUpstreamCAs(opts *upstream.Options) error {
+	if opts.CAFile == "" {
+		return nil
+	}
+	pem, err := os.ReadFile(opts.CAFile)
+	if err != nil {
+		return errUpstreamCAFile{opts.CAFile, err}
+	}
+	pool := x509.NewCertPool()
+	if opts.RootCAs != nil {
+		pool = opts.RootCAs.Clone()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return errUpstreamCAFile{opts.CAFile, errors.New("no certificates found")}
+	}
+	opts.RootCAs = pool
+	return nil
+}
+
+// The TLS config to connect to an upstream with.
+func upstreamTLSConfig(opts upstream.Options, serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName:         serverName,
+		RootCAs:            opts.RootCAs,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+		Renegotiation:      tls.RenegotiateOnceAsClient,
+	}
+}
+
+// Returns a function that reports failures to connect to the upstreams of a
+// tunnel with the event for their kind.
+func (s *sessionImpl) upstreamFailed(tun Tunnel) func(*url.URL, error) {
+	return func(target *url.URL, err error) {
+		var (
+			dialErr errUpstreamDial
+			tlsErr  errUpstreamTLS
+		)
+		switch {
+		case errors.As(err, &dialErr):
+			s.events.emit(&EventUpstreamDialFailed{
+				baseEvent: newBaseEvent(EventTypeUpstreamDialFailed),
+				Tunnel:    tun,
+				URL:       target,
+				Attempts:  dialErr.Attempts,
+				Error:     dialErr.Inner,
+			})
+		case errors.As(err, &tlsErr):
+			s.upstreamTLSFailed(tun, target, tlsErr.Inner)
+		case isCertificateError(err):
+			s.upstreamTLSFailed(tun, target, err)
+		}
+	}
+}
+
+func (s *sessionImpl) upstreamTLSFailed(tun Tunnel, target *url.URL, err error) {
+	s.events.emit(&EventUpstreamTLSFailed{
+		baseEvent: newBaseEvent(EventTypeUpstreamTLSFailed),
+		Tunnel:    tun,
+		URL:       target,
+		Error:     err,
+	})
+}
+
+// Whether an error from connecting to an upstream came from verifying its
+// certificate.
+func isCertificateError(err error) bool {
+	var verifyErr *tls.CertificateVerificationError
+	return errors.As(err, &verifyErr)
+}
@@ -0,0 +1,98 @@
+package ngrok
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+	"golang.ngrok.com/ngrok/internal/upstream"
+)
+
+func TestOpenBackendUpstreamCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	target := &url.URL{Scheme: "https", Host: srv.Listener.Addr().String()}
+	logger := slog.New(discardHandler{})
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: srv.Certificate().Raw,
+	}), 0o600))
+
+	for name, opts := range map[string]upstream.Options{
+		"pool":     {RootCAs: pool},
+		"file":     {CAFile: caFile},
+		"insecure": {InsecureSkipVerify: true},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, loadUpstreamCAs(&opts))
+			tunnelConn, _ := testTunnelConn(t, "tcp")
+			backend, err := openBackend(context.Background(), logger, nil, tunnelConn, target, opts)
+			require.NoError(t, err)
+			backend.Close()
+		})
+	}
+
+	// The handshake fails up front with a typed error, rather than once data
+	// is forwarded.
+	tunnelConn, _ := testTunnelConn(t, "tcp")
+	_, err := openBackend(context.Background(), logger, nil, tunnelConn, target, upstream.Options{})
+	require.ErrorIs(t, err, errUpstreamTLS{})
+	require.True(t, isCertificateError(err))
+}
+
+func TestLoadUpstreamCAsErrors(t *testing.T) {
+	opts := upstream.Options{CAFile: filepath.Join(t.TempDir(), "missing.pem")}
+	require.ErrorIs(t, loadUpstreamCAs(&opts), errUpstreamCAFile{})
+	require.ErrorIs(t, loadUpstreamCAs(&opts), os.ErrNotExist)
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	require.NoError(t, os.WriteFile(empty, nil, 0o600))
+	opts = upstream.Options{CAFile: empty}
+	require.ErrorIs(t, loadUpstreamCAs(&opts), errUpstreamCAFile{})
+}
+
+func TestUpstreamTLSFailedEvent(t *testing.T) {
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	defer srv.Close()
+	target, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	events := make(chan *EventUpstreamTLSFailed, 1)
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"), WithEventHandler(func(ev Event) {
+		if failed, ok := ev.(*EventUpstreamTLSFailed); ok {
+			events <- failed
+		}
+	}))
+	require.NoError(t, err)
+	defer sess.Close()
+
+	fwd, err := sess.ListenAndForward(ctx, target, config.HTTPEndpoint())
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	resp, err := client.Get(fwd.URL())
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadGateway, resp.StatusCode)
+
+	failed := <-events
+	require.Equal(t, target, failed.URL)
+	require.Equal(t, fwd.ID(), failed.Tunnel.ID())
+	require.True(t, isCertificateError(failed.Error))
+}
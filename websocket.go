@@ -0,0 +1,308 @@
+package ngrok
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The close reason reported for WebSockets closed because the client stopped
+// answering pings.
+var keepaliveCloseReason = ConnCloseReason{ClosedBy: CloseInitiatorLocal, Kind: CloseKindKeepalive, Reason: "keepalive"}
+
+// The payload of the pings sent to keep WebSockets alive, which tells their
+// pongs apart from those the upstream asked for.
+var keepalivePayload = []byte("ngrok-keepalive")
+
+const (
+	wsOpPing = 0x9
+	wsOpPong = 0xA
+	// The most bytes of the response head inspected for an upgrade.
+	maxResponseHead = 8 << 10
+)
+
+// A forwarded HTTP connection that watches for the upstream to accept a
+// WebSocket upgrade, and then pings the client to keep the socket alive.
+// Writes carry frames from the upstream to the client, and reads carry frames
+// from the client to the upstream, minus the pongs to its pings.
+type wsKeepaliveConn struct {
+	Conn
+	// The connection pings are written to. It bypasses any idle timer, so
+	// that only the client's pongs count as activity.
+	raw      Conn
+	interval time.Duration
+	onStale  func()
+
+	// Held for each write, so pings only go out between frames.
+	writeMu sync.Mutex
+	// The response head collected so far, until it has been inspected.
+	head     []byte
+	inspect  bool
+	upgraded atomic.Bool
+	out      frameTracker
+
+	// Only used by Read.
+	in      pongFilter
+	ready   []byte
+	readBuf []byte
+	readErr error
+
+	// When the oldest unanswered ping was sent, in nanoseconds since the Unix
+	// epoch, or zero if they have all been answered.
+	pinged   atomic.Int64
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// Keep the WebSocket forwarded over conn alive, if the upstream upgrades it to
+// one, by pinging the client every interval. onStale is called if a ping is
+// still unanswered when the next one is due. Only the first response on the
+// connection is inspected for an upgrade. Returns the conn to forward instead,
+// and a function that stops the pings.
+func keepWebSocketAlive(conn, raw Conn, interval time.Duration, onStale func()) (Conn, func()) {
+	c := &wsKeepaliveConn{
+		Conn:     conn,
+		raw:      raw,
+		interval: interval,
+		onStale:  onStale,
+		inspect:  true,
+		done:     make(chan struct{}),
+	}
+	return c, func() {
+		c.stopOnce.Do(func() { close(c.done) })
+	}
+}
+
+func (c *wsKeepaliveConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.upgraded.Load() {
+		c.out.advance(p)
+	} else if c.inspect {
+		prev := len(c.head)
+		c.head = append(c.head, p[:min(len(p), maxResponseHead-prev)]...)
+		if end := bytes.Index(c.head, []byte("\r\n\r\n")); end >= 0 {
+			c.inspect = false
+			if isWebSocketUpgrade(c.head[:end]) {
+				// Frames may follow the head in the same write.
+				c.out.advance(p[end+4-prev:])
+				c.upgraded.Store(true)
+				go c.keepalive()
+			}
+			c.head = nil
+		} else if len(c.head) >= maxResponseHead {
+			c.inspect = false
+			c.head = nil
+		}
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *wsKeepaliveConn) Read(p []byte) (int, error) {
+	for {
+		if len(c.ready) > 0 {
+			n := copy(p, c.ready)
+			c.ready = c.ready[n:]
+			return n, nil
+		}
+		if c.readErr != nil {
+			return 0, c.readErr
+		}
+		if c.readBuf == nil {
+			c.readBuf = make([]byte, 32<<10)
+		}
+		n, err := c.Conn.Read(c.readBuf)
+		c.readErr = err
+		// The upgrade response can't be written before the request read here
+		// is passed on, and the client only sends frames once it has the
+		// response, so bytes read after the upgrade are all frames.
+		if c.upgraded.Load() {
+			c.ready = c.in.filter(c.readBuf[:n], func() { c.pinged.Store(0) })
+		} else {
+			c.ready = c.readBuf[:n]
+		}
+	}
+}
+
+func (c *wsKeepaliveConn) keepalive() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+		}
+		if pinged := c.pinged.Load(); pinged != 0 && time.Since(time.Unix(0, pinged)) >= c.interval {
+			if impl, ok := c.raw.(*connImpl); ok {
+				impl.closeReason.CompareAndSwap(nil, &keepaliveCloseReason)
+			}
+			c.onStale()
+			return
+		}
+		c.ping()
+	}
+}
+
+// Send a ping, unless the upstream is partway through writing a frame, in
+// which case it waits for the next interval.
+func (c *wsKeepaliveConn) ping() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if !c.out.atBoundary() {
+		return
+	}
+	// Recorded before the write, since the pong can arrive before it returns.
+	c.pinged.CompareAndSwap(0, time.Now().UnixNano())
+	frame := append([]byte{0x80 | wsOpPing, byte(len(keepalivePayload))}, keepalivePayload...)
+	_, _ = c.raw.Write(frame)
+}
+
+// Whether a response head accepts an upgrade to a WebSocket.
+func isWebSocketUpgrade(head []byte) bool {
+	statusLine, headers, _ := strings.Cut(string(head), "\r\n")
+	if _, status, _ := strings.Cut(statusLine, " "); !strings.HasPrefix(status, "101") {
+		return false
+	}
+	for _, line := range strings.Split(headers, "\r\n") {
+		name, value, _ := strings.Cut(line, ":")
+		if http.CanonicalHeaderKey(strings.TrimSpace(name)) == "Upgrade" &&
+			strings.EqualFold(strings.TrimSpace(value), "websocket") {
+			return true
+		}
+	}
+	return false
+}
+
+// The length of a frame header, given at least its first two bytes.
+func wsHeaderLen(header []byte) int {
+	if len(header) < 2 {
+		return 2
+	}
+	n := 2
+	switch header[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if header[1]&0x80 != 0 {
+		n += 4 // masking key
+	}
+	return n
+}
+
+// The payload length of a frame, given its whole header.
+func wsPayloadLen(header []byte) uint64 {
+	switch n := header[1] & 0x7f; n {
+	case 126:
+		return uint64(binary.BigEndian.Uint16(header[2:]))
+	case 127:
+		return binary.BigEndian.Uint64(header[2:])
+	default:
+		return uint64(n)
+	}
+}
+
+// Tracks the frame boundaries in a stream of WebSocket frames.
+type frameTracker struct {
+	// The header of the current frame, while it's incomplete.
+	header []byte
+	// The payload bytes left in the current frame.
+	remaining uint64
+}
+
+// Consume bytes from the stream.
+func (t *frameTracker) advance(p []byte) {
+	t.consume(p, nil, nil)
+}
+
+// Consume bytes from the stream, calling onHeader with each header as it
+// completes, and onPayload with the bytes of payloads.
+func (t *frameTracker) consume(p []byte, onHeader func(header []byte), onPayload func(payload []byte)) {
+	for len(p) > 0 {
+		if t.remaining > 0 {
+			n := min(uint64(len(p)), t.remaining)
+			if onPayload != nil {
+				onPayload(p[:n])
+			}
+			t.remaining -= n
+			p = p[n:]
+			continue
+		}
+		take := min(wsHeaderLen(t.header)-len(t.header), len(p))
+		t.header = append(t.header, p[:take]...)
+		p = p[take:]
+		if len(t.header) == wsHeaderLen(t.header) {
+			t.remaining = wsPayloadLen(t.header)
+			if onHeader != nil {
+				onHeader(t.header)
+			}
+			t.header = t.header[:0]
+		}
+	}
+}
+
+func (t *frameTracker) atBoundary() bool {
+	return len(t.header) == 0 && t.remaining == 0
+}
+
+// Removes the pongs answering keepalive pings from a stream of frames sent by
+// a client.
+type pongFilter struct {
+	frames frameTracker
+	// A frame held back until it's complete, since it may be a pong to drop.
+	held    []byte
+	holding bool
+}
+
+// Filter bytes from the stream, returning those to pass on. onPong is called
+// for each keepalive pong dropped.
+func (f *pongFilter) filter(p []byte, onPong func()) []byte {
+	var out []byte
+	release := func() {
+		if isKeepalivePong(f.held) {
+			onPong()
+		} else {
+			out = append(out, f.held...)
+		}
+		f.held = f.held[:0]
+		f.holding = false
+	}
+	f.frames.consume(p, func(header []byte) {
+		if header[0]&0x0f == wsOpPong && f.frames.remaining == uint64(len(keepalivePayload)) {
+			f.holding = true
+			f.held = append(f.held[:0], header...)
+			return
+		}
+		out = append(out, header...)
+	}, func(payload []byte) {
+		if !f.holding {
+			out = append(out, payload...)
+			return
+		}
+		f.held = append(f.held, payload...)
+		if f.frames.remaining == uint64(len(payload)) {
+			release()
+		}
+	})
+	return out
+}
+
+// Whether a whole frame is a pong answering a keepalive ping.
+func isKeepalivePong(frame []byte) bool {
+	n := wsHeaderLen(frame)
+	payload := append([]byte(nil), frame[n:]...)
+	if frame[1]&0x80 != 0 {
+		mask := frame[n-4 : n]
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return bytes.Equal(payload, keepalivePayload)
+}
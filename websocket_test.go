@@ -0,0 +1,117 @@
+package ngrok
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"golang.ngrok.com/ngrok/config"
+)
+
+// A frame from a client, which masks its payloads.
+func maskedFrame(op byte, payload []byte) []byte {
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | op, 0x80 | byte(len(payload))}, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestWebSocketKeepalive(t *testing.T) {
+	// An upstream that accepts the upgrade, and passes on what it receives
+	// after.
+	received := make(chan []byte, 16)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+		buf := make([]byte, 1024)
+		for {
+			n, err := rw.Read(buf)
+			if err != nil {
+				close(received)
+				return
+			}
+			received <- append([]byte(nil), buf[:n]...)
+		}
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	sess, err := Connect(ctx, WithOfflineFallback("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer sess.Close()
+	fwd, err := sess.ListenAndForward(ctx, target, config.HTTPEndpoint(
+		config.WithWebSocketKeepalive(50*time.Millisecond),
+		config.WithConnectionIdleTimeout(150*time.Millisecond),
+	))
+	require.NoError(t, err)
+	defer fwd.Close()
+
+	conn := dialTunnel(t, fwd)
+	_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	require.NoError(t, err)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// Answering the pings keeps the socket open past the idle timeout.
+	ping := append([]byte{0x89, byte(len(keepalivePayload))}, keepalivePayload...)
+	for i := 0; i < 6; i++ {
+		frame := make([]byte, len(ping))
+		_, err := io.ReadFull(r, frame)
+		require.NoError(t, err)
+		require.Equal(t, ping, frame)
+		_, err = conn.Write(maskedFrame(wsOpPong, keepalivePayload))
+		require.NoError(t, err)
+	}
+
+	// The pongs aren't passed on to the upstream, unlike other frames.
+	text := maskedFrame(0x1, []byte("hello"))
+	_, err = conn.Write(text)
+	require.NoError(t, err)
+	require.Equal(t, text, <-received)
+
+	// A client that stops answering is disconnected.
+	_, err = io.Copy(io.Discard, r)
+	require.NoError(t, err)
+	for range received {
+	}
+}
+
+func TestPongFilter(t *testing.T) {
+	pong := maskedFrame(wsOpPong, keepalivePayload)
+	otherPong := maskedFrame(wsOpPong, []byte("something-else!"))
+	text := maskedFrame(0x1, bytes.Repeat([]byte("x"), 100))
+	stream := bytes.Join([][]byte{text, pong, otherPong, pong, text}, nil)
+
+	// Frames split across reads at any point are filtered the same.
+	for _, size := range []int{1, 3, 7, len(stream)} {
+		var (
+			f     pongFilter
+			out   []byte
+			pongs int
+		)
+		for p := stream; len(p) > 0; p = p[min(size, len(p)):] {
+			out = append(out, f.filter(p[:min(size, len(p))], func() { pongs++ })...)
+		}
+		require.Equal(t, 2, pongs)
+		require.Equal(t, bytes.Join([][]byte{text, otherPong, text}, nil), out)
+	}
+}